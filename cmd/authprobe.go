@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// printAuthProbes writes the --registry-auth-probe results, one line per image,
+// and returns how many failed. vmServiceAccount is the build VM's service
+// account in remote mode, whose access the probe, made with this machine's
+// credentials, cannot check.
+func printAuthProbes(probes []image.AuthProbe, pullAuth, vmServiceAccount string) int {
+	fmt.Printf("Image pull authentication: %s\n", pullAuth)
+	if vmServiceAccount != "" && pullAuth == "ServiceAccountToken" {
+		fmt.Printf("Probed with this machine's credentials; the build VM pulls as service account '%s'\n", vmServiceAccount)
+	}
+	failed := 0
+	for _, probe := range probes {
+		if probe.Err != nil {
+			failed++
+			fmt.Printf("❌ %s: %v\n", probe.Image, probe.Err)
+			continue
+		}
+		fmt.Printf("✅ %s (%s: %s)\n", probe.Image, probe.Registry, probe.Mechanism)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d images cannot be read with these credentials\n", failed, len(probes))
+	} else {
+		fmt.Printf("All %d images can be read\n", len(probes))
+	}
+	return failed
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+	"strings"
+)
+
+// runCleanup implements "cleanup": it deletes the temporary resources earlier
+// builds left behind, as listed in last-build.json, and the boot disks of build
+// VMs that outlived them. On a GCP VM it also unmounts, detaches and deletes the
+// disks crashed local-mode runs left attached to it. It returns the process
+// exit code.
+func runCleanup(args []string) int {
+	cfg := config.NewConfig()
+	cfg.Timeout = 10 * time.Minute
+
+	fs := newCleanupFlagSet(cfg)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if cfg.ProjectName == "" {
+		// The disks were attached to this VM, in its project unless the build said otherwise
+		cfg.ProjectName, _ = gcp.QueryMetadata("project/project-id")
+	}
+	if cfg.ProjectName == "" {
+		// Off GCP, what is left to clean up is the last build's
+		if record, _ := builder.LastBuild(); record != nil {
+			cfg.ProjectName = record.Project
+		}
+	}
+	if err := cfg.ValidateCleanup(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Run '%s cleanup -h' for the options.\n", ui.GetToolInfo().ExecutableName)
+		return 1
+	}
+
+	b, err := builder.NewBuilder(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create builder: %v\n", err)
+		return 1
+	}
+	defer b.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	exitCode := 0
+	leaked, err := b.CleanupLeaked(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to delete resources earlier builds left behind: %v\n", err)
+		exitCode = 1
+	}
+	if leaked != nil {
+		if len(leaked.Deleted) > 0 {
+			fmt.Printf("✅ Deleted %d resources earlier builds left behind:\n  %s\n", len(leaked.Deleted), strings.Join(leaked.Deleted, "\n  "))
+		} else if len(leaked.Pending) == 0 {
+			fmt.Println("✅ No resource is left behind by an earlier build")
+		}
+		if len(leaked.Pending) > 0 {
+			fmt.Fprintf(os.Stderr, "⚠️  %d resources are still left behind; delete them with:\n  %s\n", len(leaked.Pending), strings.Join(leaked.Pending, "\n  "))
+		}
+	}
+
+	bootDisks, err := b.CleanupBootDisks(ctx)
+	if len(bootDisks) > 0 {
+		fmt.Printf("✅ Deleted %d boot disks left behind by build VMs:\n  %s\n", len(bootDisks), strings.Join(bootDisks, "\n  "))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to delete boot disks left behind by build VMs: %v\n", err)
+		exitCode = 1
+	}
+
+	// Only local-mode runs attach disks, to the VM they run on
+	if _, err := gcp.QueryMetadata("instance/name"); err != nil {
+		return exitCode
+	}
+	recovered, err := b.CleanupAttachments(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Cleanup failed: %v\n", err)
+		return 1
+	}
+	if len(recovered) == 0 {
+		fmt.Println("✅ No disk is left attached to this VM by an earlier run")
+		return exitCode
+	}
+	fmt.Printf("✅ Detached and deleted %d disks left attached by earlier runs\n", len(recovered))
+	return exitCode
+}
+
+// newCleanupFlagSet registers the cleanup flags
+func newCleanupFlagSet(cfg *config.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	fs.StringVar(&cfg.ProjectName, "project-name", "", "GCP project of the resources (default: this VM's project, off GCP the last build's)")
+	fs.StringVar(&cfg.GCPOAuth, "gcp-oauth", "", "Path to GCP service account credential file")
+	fs.StringVar(&cfg.APIEndpoint, "api-endpoint", "", "Compute Engine API endpoint, e.g. https://compute.restricted.googleapis.com")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "How long the cleanup may take")
+	fs.BoolVar(&cfg.Verbose, "v", false, "Enable verbose logging")
+	fs.BoolVar(&cfg.Verbose, "verbose", false, "Enable verbose logging")
+	fs.BoolVar(&cfg.NoColor, "no-color", false, "Disable colored log output")
+	return fs
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+)
+
+// runControl implements "control": it talks to builds running on this machine
+// and returns the process exit code
+func runControl(args []string) int {
+	if len(args) == 0 {
+		controlUsageError(fmt.Errorf("missing command: status or extend"))
+		return 1
+	}
+
+	switch args[0] {
+	case "status":
+		return runControlStatus()
+	case "extend":
+		return runControlExtend(args[1:])
+	default:
+		controlUsageError(fmt.Errorf("unknown command '%s': supported commands: status, extend", args[0]))
+		return 1
+	}
+}
+
+// runControlStatus lists the running builds and their deadlines
+func runControlStatus() int {
+	builds, err := builder.RunningBuilds()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(builds) == 0 {
+		fmt.Println("No running builds accept control commands on this machine.")
+		return 0
+	}
+	for _, build := range builds {
+		printControlStatus(build)
+	}
+	return 0
+}
+
+// runControlExtend pushes back the deadline of a running build
+func runControlExtend(args []string) int {
+	fs, pid := newControlExtendFlagSet()
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		controlUsageError(fmt.Errorf("expected one duration to extend by, e.g. 'extend 30m'"))
+		return 1
+	}
+	by, err := time.ParseDuration(fs.Arg(0))
+	if err != nil || by <= 0 {
+		controlUsageError(fmt.Errorf("invalid duration '%s': expected a positive duration such as 30m or 1h", fs.Arg(0)))
+		return 1
+	}
+
+	if *pid == 0 {
+		builds, err := builder.RunningBuilds()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		switch len(builds) {
+		case 0:
+			fmt.Fprintln(os.Stderr, "Error: no running build accepts control commands on this machine")
+			return 1
+		case 1:
+			*pid = builds[0].PID
+		default:
+			fmt.Fprintln(os.Stderr, "Error: several builds are running, choose one with --pid:")
+			for _, build := range builds {
+				printControlStatus(build)
+			}
+			return 1
+		}
+	}
+
+	status, err := builder.ExtendBuild(*pid, by)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Could not extend build %d: %v\n", *pid, err)
+		return 1
+	}
+	fmt.Printf("✅ Extended build %d by %s\n", *pid, by)
+	printControlStatus(status)
+	return 0
+}
+
+// newControlExtendFlagSet registers the "control extend" flags
+func newControlExtendFlagSet() (*flag.FlagSet, *int) {
+	fs := flag.NewFlagSet("control extend", flag.ContinueOnError)
+	pid := fs.Int("pid", 0, "Process ID of the build to extend (required when several builds run)")
+	return fs, pid
+}
+
+// controlUsageError reports an invalid control invocation
+func controlUsageError(err error) {
+	exe := ui.GetToolInfo().ExecutableName
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	fmt.Fprintf(os.Stderr, "Usage: %s control status\n", exe)
+	fmt.Fprintf(os.Stderr, "       %s control extend [--pid <PID>] <DURATION>\n", exe)
+}
+
+func printControlStatus(status *builder.ControlStatus) {
+	fmt.Printf("PID %d: image %s, deadline %s (extended %s, %s more allowed)\n",
+		status.PID, status.DiskImage, status.Deadline.Local().Format(time.RFC3339), status.Extended, status.Remaining)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+)
+
+// doctorTimeout bounds all doctor checks; each network check fails well before
+const doctorTimeout = 2 * time.Minute
+
+// runDoctor implements "doctor": it checks whether this machine is set up to
+// run builds and prints a checklist. It returns 1 if any check failed.
+func runDoctor(args []string) int {
+	cfg := config.NewConfig()
+	fs, opts := newDoctorFlagSet(cfg)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if opts.localMode || opts.remoteMode {
+		mode, err := validateExecutionMode(opts.localMode, opts.remoteMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Run '%s doctor -h' for the options.\n", ui.GetToolInfo().ExecutableName)
+			return 1
+		}
+		cfg.SetMode(mode, executionModeFlag(mode))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	return printDoctorChecks(builder.Diagnose(ctx, cfg))
+}
+
+// doctorFlags holds the doctor options that are not Config fields
+type doctorFlags struct {
+	localMode, remoteMode bool
+}
+
+// newDoctorFlagSet registers the doctor flags
+func newDoctorFlagSet(cfg *config.Config) (*flag.FlagSet, *doctorFlags) {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	f := &doctorFlags{}
+	fs.StringVar(&cfg.ProjectName, "project-name", "", "GCP project to check (default: the credentials' or this VM's project)")
+	fs.StringVar(&cfg.GCPOAuth, "gcp-oauth", "", "Path to GCP service account credential file")
+	fs.StringVar(&cfg.APIEndpoint, "api-endpoint", "", "Compute Engine API endpoint, e.g. https://compute.restricted.googleapis.com")
+	fs.BoolVar(&f.localMode, "L", false, "Fail the checks local mode needs (GCP VM, containerd) instead of warning")
+	fs.BoolVar(&f.localMode, "local-mode", false, "Fail the checks local mode needs (GCP VM, containerd) instead of warning")
+	fs.BoolVar(&f.remoteMode, "R", false, "Skip the checks only local mode needs")
+	fs.BoolVar(&f.remoteMode, "remote-mode", false, "Skip the checks only local mode needs")
+	return fs, f
+}
+
+// printDoctorChecks writes the doctor checklist and returns the exit code
+func printDoctorChecks(checks []builder.DoctorCheck) int {
+	failed, warned := 0, 0
+	for _, check := range checks {
+		switch check.Status {
+		case builder.DoctorPassed:
+			fmt.Printf("✅ %s: %s\n", check.Name, check.Detail)
+		case builder.DoctorWarning:
+			warned++
+			fmt.Printf("⚠️  %s: %s\n", check.Name, check.Detail)
+		case builder.DoctorFailed:
+			failed++
+			fmt.Printf("❌ %s: %s\n", check.Name, check.Detail)
+		case builder.DoctorSkipped:
+			fmt.Printf("➖ %s: skipped, %s\n", check.Name, check.Hint)
+			continue
+		}
+		if check.Hint != "" {
+			fmt.Printf("   %s\n", check.Hint)
+		}
+	}
+
+	switch {
+	case failed > 0:
+		fmt.Printf("\n%d checks failed, %d warnings: builds are likely to fail until the failures are fixed\n", failed, warned)
+		return 1
+	case warned > 0:
+		fmt.Printf("\nNo check failed, %d warnings\n", warned)
+	default:
+		fmt.Println("\nAll checks passed")
+	}
+	return 0
+}
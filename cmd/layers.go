@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// printLayerReport writes the --report-layers tables: each image's size and
+// what it adds to the others, then the largest layers and who shares them
+func printLayerReport(report *image.LayerReport, diskSizeGB int) {
+	width := len("IMAGE")
+	for _, img := range report.Images {
+		width = max(width, len(img.Image))
+	}
+
+	fmt.Printf("%-*s  %6s  %10s  %10s\n", width, "IMAGE", "LAYERS", "SIZE", "UNIQUE")
+	for _, img := range report.Images {
+		fmt.Printf("%-*s  %6d  %10s  %10s\n", width, img.Image, img.Layers, formatBytes(img.Bytes), formatBytes(img.UniqueBytes))
+	}
+	fmt.Println()
+
+	fmt.Printf("Sum of image sizes:     %s\n", formatBytes(report.TotalBytes))
+	fmt.Printf("Distinct layers:        %s\n", formatBytes(report.UniqueBytes))
+	saved := 0.0
+	if report.TotalBytes > 0 {
+		saved = float64(report.SavedBytes()) / float64(report.TotalBytes) * 100
+	}
+	fmt.Printf("Saved by shared layers: %s (%.0f%%)\n", formatBytes(report.SavedBytes()), saved)
+	fmt.Println()
+
+	fmt.Printf("Largest layers:\n")
+	fmt.Printf("%-19s  %10s  %s\n", "DIGEST", "SIZE", "USED BY")
+	for _, layer := range report.Largest {
+		fmt.Printf("%-19s  %10s  %s\n", shortDigest(layer.Digest), formatBytes(layer.Size), strings.Join(layer.Images, ", "))
+	}
+	fmt.Println()
+
+	fmt.Printf("Sizes are compressed, as downloaded. The cache disk (--disk-size %d GB) holds\n", diskSizeGB)
+	fmt.Printf("the distinct layers plus their unpacked contents, which are usually larger.\n")
+}
+
+// formatBytes renders a size in MB or GB (10^6 and 10^9 bytes)
+func formatBytes(bytes int64) string {
+	if bytes >= 1e9 {
+		return fmt.Sprintf("%.2f GB", float64(bytes)/1e9)
+	}
+	return fmt.Sprintf("%.1f MB", float64(bytes)/1e6)
+}
+
+// shortDigest abbreviates sha256:<hex> to its first 12 hex characters
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 && len(digest) > i+13 {
+		return digest[:i+13]
+	}
+	return digest
+}
@@ -5,10 +5,15 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
 )
 
@@ -25,177 +30,462 @@ func main() {
 		os.Exit(1)
 	}
 
-	cfg := config.NewConfig()
-	errorHandler := ui.NewErrorHandler()
-
-	// Configuration file support
-	configFile := flag.String("config", "", "Path to YAML configuration file")
-	flag.StringVar(configFile, "c", "", "Path to YAML configuration file (short form)")
-
-	// Config generation and validation
-	generateConfig := flag.String("generate-config", "", "Generate configuration template (basic|advanced|ci-cd|ml)")
-	generateOutput := flag.String("output", "", "Output path for generated config (default: stdout)")
-	validateConfig := flag.String("validate-config", "", "Validate YAML configuration file")
-
-	// Define execution mode flags (mutually exclusive)
-	localMode := flag.Bool("L", false, "Execute on current GCP VM (local mode)")
-	flag.BoolVar(localMode, "local-mode", false, "Execute on current GCP VM (local mode)")
-
-	remoteMode := flag.Bool("R", false, "Create temporary GCP VM for execution (remote mode)")
-	flag.BoolVar(remoteMode, "remote-mode", false, "Create temporary GCP VM for execution (remote mode)")
-
-	// Required parameters
-	flag.StringVar(&cfg.ProjectName, "project-name", "", "GCP project name")
-	flag.StringVar(&cfg.DiskImageName, "disk-image-name", "", "Name for the disk image")
+	// Before any HTTP request: the proxy settings are read once
+	gcp.BypassProxyForMetadata()
 
-	// Container images (repeatable)
-	var containerImages stringSlice
-	flag.Var(&containerImages, "container-image", "Container image to cache (repeatable)")
-
-	// Zone and location
-	flag.StringVar(&cfg.Zone, "z", "", "GCP zone (required for -R mode)")
-	flag.StringVar(&cfg.Zone, "zone", "", "GCP zone (required for -R mode)")
-	flag.StringVar(&cfg.Network, "n", cfg.Network, "VPC network for build VM (remote mode only)")
-	flag.StringVar(&cfg.Network, "network", cfg.Network, "VPC network for build VM (remote mode only)")
-	flag.StringVar(&cfg.Subnet, "u", cfg.Subnet, "Subnet for build VM (remote mode only)")
-	flag.StringVar(&cfg.Subnet, "subnet", cfg.Subnet, "Subnet for build VM (remote mode only)")
+	builder.ToolVersion = version
 
-	// Cache configuration
-	flag.IntVar(&cfg.DiskSizeGB, "s", cfg.DiskSizeGB, "Disk size in GB")         // 改为 DiskSizeGB
-	flag.IntVar(&cfg.DiskSizeGB, "disk-size", cfg.DiskSizeGB, "Disk size in GB") // 改为 DiskSizeGB
-	flag.DurationVar(&cfg.Timeout, "t", cfg.Timeout, "Build timeout")
-	flag.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Build timeout")
-
-	// Image management
-	flag.StringVar(&cfg.DiskFamilyName, "disk-family", cfg.DiskFamilyName, "Image family name") // 改为 DiskFamilyName
-	var diskLabels stringMap                                                                    // 改为 diskLabels
-	flag.Var(&diskLabels, "disk-labels", "Disk labels (key=value, repeatable)")                 // 改为 disk-labels
-
-	// Authentication
-	flag.StringVar(&cfg.GCPOAuth, "gcp-oauth", "", "Path to GCP service account credential file")
-	flag.StringVar(&cfg.ServiceAccount, "service-account", cfg.ServiceAccount, "Service account email")
-	flag.StringVar(&cfg.ImagePullAuth, "image-pull-auth", cfg.ImagePullAuth, "Image pull authentication")
-
-	// Logging (console only, no GCS)
-	verbose := flag.Bool("v", false, "Enable verbose logging")
-	flag.BoolVar(verbose, "verbose", false, "Enable verbose logging")
-	quiet := flag.Bool("q", false, "Suppress non-error output")
-	flag.BoolVar(quiet, "quiet", false, "Suppress non-error output")
+	// Subcommands take their own flags
+	if os.Args[1] == "verify-image" {
+		os.Exit(runVerifyImage(os.Args[2:]))
+	}
+	if os.Args[1] == "control" {
+		os.Exit(runControl(os.Args[2:]))
+	}
+	if os.Args[1] == "watch" {
+		os.Exit(runWatch(os.Args[2:]))
+	}
+	if os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:]))
+	}
+	if os.Args[1] == "cleanup" {
+		os.Exit(runCleanup(os.Args[2:]))
+	}
+	if os.Args[1] == "selftest" {
+		os.Exit(runSelftest(os.Args[2:]))
+	}
 
-	// Advanced options
-	flag.StringVar(&cfg.JobName, "job-name", cfg.JobName, "Build job name")
-	machineType := flag.String("machine-type", "e2-standard-2", "VM machine type for -R mode")
-	preemptible := flag.Bool("preemptible", false, "Use preemptible VM for -R mode")
-	diskType := flag.String("disk-type", "pd-standard", "Cache disk type")
+	cfg := config.NewConfig()
+	errorHandler := ui.NewErrorHandler()
 
-	// Help options
-	helpFull := flag.Bool("help-full", false, "Show complete help")
-	helpExamples := flag.Bool("help-examples", false, "Show usage examples")
-	helpConfig := flag.Bool("help-config", false, "Show configuration file help")
-	showVersion := flag.Bool("version", false, "Show version information")
+	fs, opts := newFlagSet(cfg)
+	fs.Parse(os.Args[1:])
 
-	flag.Parse()
+	if opts.lang != "" {
+		if err := ui.SetLocale(opts.lang); err != nil {
+			log.NewConsoleLogger(opts.verbose, opts.quiet, opts.noColor).Warnf("%v", err)
+		}
+	}
 
 	// Handle special commands first
-	if *generateConfig != "" {
-		if err := handleGenerateConfig(*generateConfig, *generateOutput); err != nil {
+	if opts.generateConfig != "" {
+		if err := handleGenerateConfig(opts.generateConfig, opts.generateOutput); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to generate config: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	if *validateConfig != "" {
-		if err := config.ValidateYAMLFile(*validateConfig); err != nil {
+	if opts.validateConfig != "" {
+		skipped, err := config.ValidateYAMLFile(opts.validateConfig, opts.offline)
+		printSkippedChecks(skipped)
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Configuration validation failed: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("✅ Configuration file '%s' is valid\n", *validateConfig)
+		fmt.Printf("✅ Configuration file '%s' is valid\n", opts.validateConfig)
 		return
 	}
 
 	// Handle help and version flags
-	if *showVersion {
+	if opts.showVersion {
 		ui.ShowVersionInfo(version, buildTime, gitCommit)
 		return
 	}
 
-	if *helpFull {
+	if opts.helpFull {
 		ui.ShowHelp("full", version)
 		return
 	}
 
-	if *helpExamples {
+	if opts.helpExamples {
 		ui.ShowHelp("examples", version)
 		return
 	}
 
-	if *helpConfig {
+	if opts.helpConfig {
 		ui.ShowHelp("config", version)
 		return
 	}
 
-	// Load configuration from YAML file first (if specified)
-	if *configFile != "" {
-		if err := cfg.LoadFromYAML(*configFile); err != nil {
+	// A build, and loading the configuration of one, needs the network
+	if opts.offline && (opts.showConfig == "" || opts.reproduceFrom != "") {
+		errorHandler.HandleConfigError(fmt.Errorf("--offline only applies to --validate-config and --show-config, and not with --reproduce-from"))
+		os.Exit(1)
+	}
+	cfg.Offline = opts.offline
+
+	// Resolve the execution mode before loading any configuration: the command
+	// line takes precedence, and a mode set here is kept by the config file
+	if opts.localMode || opts.remoteMode {
+		mode, err := validateExecutionMode(opts.localMode, opts.remoteMode)
+		if err != nil {
 			errorHandler.HandleConfigError(err)
 			os.Exit(1)
 		}
+		cfg.SetMode(mode, executionModeFlag(mode))
 	}
 
-	// Validate execution mode (command line takes precedence)
-	if *localMode || *remoteMode {
-		mode, err := validateExecutionMode(*localMode, *remoteMode)
-		if err != nil {
+	// Load the configuration of a previous build instead of a config file
+	if opts.reproduceFrom != "" {
+		if err := loadReproduceConfig(cfg, opts.configFile, opts.reproduceFrom); err != nil {
+			cfg.RemoveSecrets()
+			errorHandler.HandleConfigError(err)
+			os.Exit(1)
+		}
+	}
+
+	// Load configuration from YAML file first (if specified)
+	if opts.configFile != "" && opts.reproduceFrom == "" {
+		if err := cfg.LoadFromYAML(opts.configFile); err != nil {
 			errorHandler.HandleConfigError(err)
 			os.Exit(1)
 		}
-		cfg.Mode = mode
 	}
 
 	// Set parsed values (command line takes precedence over config file)
-	if len(containerImages) > 0 {
-		cfg.ContainerImages = []string(containerImages)
+	if len(opts.containerImages) > 0 {
+		cfg.ContainerImages = []string(opts.containerImages)
+	}
+	if len(opts.pullArgs) > 0 {
+		cfg.PullArgs = []string(opts.pullArgs)
+	}
+	if len(opts.additionalStartupScripts) > 0 {
+		cfg.AdditionalStartupScripts = []string(opts.additionalStartupScripts)
+	}
+	if len(opts.familyAliases) > 0 {
+		cfg.FamilyAliases = []string(opts.familyAliases)
 	}
-	if len(diskLabels) > 0 { // 改为 diskLabels
+	if len(opts.imageLicenses) > 0 {
+		cfg.ImageLicenses = []string(opts.imageLicenses)
+	}
+	if len(opts.diskLabels) > 0 { // 改为 diskLabels
 		if cfg.DiskLabels == nil { // 改为 DiskLabels
 			cfg.DiskLabels = make(map[string]string) // 改为 DiskLabels
 		}
-		for k, v := range diskLabels { // 改为 diskLabels
+		for k, v := range opts.diskLabels { // 改为 diskLabels
 			cfg.DiskLabels[k] = v // Command line labels override config file labels  // 改为 DiskLabels
 		}
 	}
+	if len(opts.vmLabels) > 0 {
+		if cfg.VMLabels == nil {
+			cfg.VMLabels = make(map[string]string)
+		}
+		for k, v := range opts.vmLabels {
+			cfg.VMLabels[k] = v
+		}
+	}
+	if len(opts.credentialProviders) > 0 {
+		if cfg.CredentialProviders == nil {
+			cfg.CredentialProviders = make(map[string]string)
+		}
+		for pattern, provider := range opts.credentialProviders {
+			cfg.CredentialProviders[pattern] = provider
+		}
+	}
+
+	cfg.Verbose = opts.verbose
+	cfg.Quiet = opts.quiet
+	cfg.NoColor = opts.noColor
+
+	// The spec's images, name and labels are defaults below the flags and config file
+	if err := cfg.LoadSpec(); err != nil {
+		cfg.RemoveSecrets()
+		errorHandler.HandleConfigError(err)
+		os.Exit(1)
+	}
+
+	if opts.showConfig != "" {
+		// Nothing is built, so secrets resolved for --reproduce-from are not needed
+		cfg.RemoveSecrets()
 
-	cfg.Verbose = *verbose
-	cfg.Quiet = *quiet
-	cfg.MachineType = *machineType
-	cfg.Preemptible = *preemptible
-	cfg.DiskType = *diskType
+		// Validation resolves defaults such as the zone in local mode, so run it first
+		validationErr := cfg.Validate()
+		if err := cfg.Show(os.Stdout, string(opts.showConfig)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to show config: %v\n", err)
+			os.Exit(1)
+		}
+		printSkippedChecks(cfg.SkippedChecks())
+		printImageListNotes(cfg)
+		if validationErr != nil {
+			fmt.Fprintf(os.Stderr, "\n❌ Configuration is not valid: %v\n", validationErr)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
+		cfg.RemoveSecrets()
 		errorHandler.HandleConfigError(err)
 		os.Exit(1)
 	}
 
+	os.Exit(runBuild(cfg, opts, errorHandler))
+}
+
+// runBuild builds the cache, or reports on its images with --report-layers or
+// --registry-auth-probe, and returns the process exit code. An interrupt
+// cancels the build, whose temporary resources are still cleaned up, and the
+// secrets resolved for it are removed on every path.
+func runBuild(cfg *config.Config, opts *cliFlags, errorHandler *ui.ErrorHandler) int {
+	defer cfg.RemoveSecrets()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Read secret:// values from Secret Manager
+	if err := resolveSecrets(ctx, cfg); err != nil {
+		errorHandler.HandleConfigError(err)
+		return 1
+	}
+
 	// Create and run builder
-	builder, err := builder.NewBuilder(cfg)
+	b, err := builder.NewBuilder(cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create builder: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
+	defer b.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
-	defer cancel()
+	if opts.reportLayers {
+		report, err := b.ReportLayers(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to report layers: %v\n", err)
+			return 1
+		}
+		printLayerReport(report, cfg.DiskSizeGB)
+		return 0
+	}
 
-	if err := builder.BuildImageCache(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "Build failed: %v\n", err)
-		os.Exit(1)
+	if opts.registryAuthProbe {
+		probes := b.ProbeRegistryAuth(ctx)
+		vmServiceAccount := ""
+		if cfg.IsRemoteMode() {
+			vmServiceAccount = cfg.ServiceAccount
+		}
+		if printAuthProbes(probes, cfg.ImagePullAuth, vmServiceAccount) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// The builder applies the timeout itself so that it can be extended
+	result, err := b.BuildImageCache(ctx)
+	if err != nil {
+		errorHandler.HandleBuildError(err)
+		return 1
+	}
+
+	if cfg.LogFormat == config.LogFormatJSON {
+		// Log collectors read stderr; stdout gets the result as one JSON object
+		printResultJSON(result)
+		return 0
 	}
 
 	toolInfo := ui.GetToolInfo()
 	fmt.Printf("✅ %s completed successfully!\n", toolInfo.ShortDesc)
-	fmt.Printf("Disk image '%s' is ready for use with GKE nodes.\n", cfg.DiskImageName)
+	if cfg.OutputsImage() {
+		fmt.Printf("Disk image '%s' is ready for use with GKE nodes.\n", cfg.DiskImageName)
+	}
+	for _, disk := range result.Disks {
+		fmt.Printf("Cache disk: %s\n", disk)
+	}
+	if !cfg.Quiet {
+		printNextSteps(result)
+	}
+	return 0
+}
+
+// cliFlags holds the command line options that are not Config fields
+type cliFlags struct {
+	configFile, reproduceFrom                      string
+	generateConfig, generateOutput, validateConfig string
+	showConfig                                     optionalFormat
+	offline, reportLayers, registryAuthProbe       bool
+	localMode, remoteMode                          bool
+
+	containerImages, pullArgs, imageLicenses, familyAliases, additionalStartupScripts stringSlice
+	diskLabels, vmLabels, credentialProviders                                         stringMap
+
+	verbose, quiet, noColor                         bool
+	helpFull, helpExamples, helpConfig, showVersion bool
+	lang                                            string
+}
+
+// newFlagSet registers the build's command line flags: options of the build
+// itself set cfg directly, the others are returned in cliFlags. The help
+// messages document these flags, which "selftest" checks.
+func newFlagSet(cfg *config.Config) (*flag.FlagSet, *cliFlags) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	f := &cliFlags{}
+
+	// Configuration file support
+	fs.StringVar(&f.configFile, "config", "", "Path to YAML configuration file")
+	fs.StringVar(&f.configFile, "c", "", "Path to YAML configuration file (short form)")
+	fs.StringVar(&f.reproduceFrom, "reproduce-from", "", "Rebuild with the configuration recorded on an existing cache image (flags override it)")
+
+	// Config generation and validation
+	fs.StringVar(&f.generateConfig, "generate-config", "", "Generate configuration template (basic|advanced|ci-cd|ml)")
+	fs.StringVar(&f.generateOutput, "output", "", "Output path for generated config (default: stdout)")
+	fs.StringVar(&f.validateConfig, "validate-config", "", "Validate YAML configuration file")
+	fs.Var(&f.showConfig, "show-config", "Print the effective configuration and exit (--show-config or --show-config=json)")
+	fs.BoolVar(&f.offline, "offline", false, "Skip validation checks that need the network (with --validate-config or --show-config)")
+	fs.BoolVar(&f.reportLayers, "report-layers", false, "Report the images' sizes and what shared layers save, from their registry manifests, and exit")
+	fs.BoolVar(&f.registryAuthProbe, "registry-auth-probe", false, "Check that the image pull authentication can read every image's manifest, and exit")
+
+	// Define execution mode flags (mutually exclusive)
+	fs.BoolVar(&f.localMode, "L", false, "Execute on current GCP VM (local mode)")
+	fs.BoolVar(&f.localMode, "local-mode", false, "Execute on current GCP VM (local mode)")
+
+	fs.BoolVar(&f.remoteMode, "R", false, "Create temporary GCP VM for execution (remote mode)")
+	fs.BoolVar(&f.remoteMode, "remote-mode", false, "Create temporary GCP VM for execution (remote mode)")
+
+	// Required parameters
+	fs.StringVar(&cfg.ProjectName, "project-name", "", "GCP project name")
+	fs.StringVar(&cfg.ExpectedProjectNumber, "expected-project-number", "", "Refuse to build unless --project-name is the project of this number")
+	fs.StringVar(&cfg.DiskImageName, "disk-image-name", "", "Name for the disk image")
+
+	// Container images (repeatable)
+	fs.Var(&f.containerImages, "container-image", "Container image to cache (repeatable)")
+	fs.Var(&f.pullArgs, "pull-arg", "Extra argument appended to ctr image pull (repeatable)")
+	fs.StringVar(&cfg.Lockfile, "lockfile", "", "JSON lockfile of image digests to cache exactly (overrides container images)")
+	fs.StringVar(&cfg.FromSpec, "from-spec", "", "Also cache the images of an ImageCacheSpec YAML document, named and labeled after it by default")
+	fs.IntVar(&cfg.MaxImages, "max-images", cfg.MaxImages, "Fail validation if more container images than this are listed")
+	fs.BoolVar(&cfg.ForbidMultipleTags, "forbid-multiple-tags", false, "Fail validation if several tags of the same repository are listed, instead of warning")
+	fs.BoolVar(&cfg.SkipIfExists, "skip-if-exists", false, "Skip the build if an image of the same resolved image set already exists in the family")
+	fs.BoolVar(&cfg.VerifyNoLayersMissing, "verify-no-layers-missing", false, "Check that every layer blob of the pulled images is present with the correct size")
+	fs.BoolVar(&cfg.VerifyLayerDigests, "verify-layer-digests", false, "Like --verify-no-layers-missing, also recomputing every blob digest")
+	fs.IntVar(&cfg.ParallelVerify, "parallel-verify", cfg.ParallelVerify, "Verify up to N images at a time with --verify-no-layers-missing or --verify-layer-digests")
+	fs.IntVar(&cfg.PullConcurrency, "pull-concurrency", 0, "Pull and unpack up to N images at a time (default: auto from this machine's memory in local mode, all at once in remote mode)")
+	fs.StringVar(&cfg.WriteLockfile, "write-lockfile", "", "Write the resolved image digests to a JSON lockfile after a successful build")
+	fs.StringVar(&cfg.ApprovedDigests, "approved-digests", "", "File of approved sha256 image digests; fail if any image resolves to another")
+	fs.StringVar(&cfg.WriteImageRef, "write-image-ref", "", "Write the created image's reference to this file after a successful build")
+	fs.StringVar(&cfg.ImageRefFormat, "image-ref-format", cfg.ImageRefFormat, "Reference written by --write-image-ref: self-link or name")
+	fs.StringVar(&cfg.NodePoolConfig, "node-pool-config", "", "Write the configuration of a node pool attaching the created image to this file after a successful build")
+	fs.StringVar(&cfg.NodePoolConfigFormat, "node-pool-config-format", cfg.NodePoolConfigFormat, "Configuration written by --node-pool-config: gcloud or terraform")
+	fs.StringVar(&cfg.OutputType, "output-type", cfg.OutputType, "What the build produces: image, disk-only (keep the populated cache disk, no image) or both")
+	fs.BoolVar(&cfg.ImageForceCreate, "image-force-create", false, "Create the image from the still-attached cache disk once its writes are flushed, detaching it afterwards (-R mode)")
+	fs.BoolVar(&cfg.SmokeTest, "smoke-test", false, "Boot a small node VM with the created image attached and check that containerd resolves every image from it")
+	fs.DurationVar(&cfg.SmokeTestTimeout, "smoke-test-timeout", cfg.SmokeTestTimeout, "Time allowed for --smoke-test, added to --timeout")
+	fs.BoolVar(&cfg.CloudLogging, "cloud-logging", false, "Ship the build VM's log to Cloud Logging through the Ops Agent, labeled with the run ID (-R mode)")
+	fs.BoolVar(&cfg.AssumeNoStreaming, "assume-no-streaming", false, "Acknowledge that the nodes do not use GKE Image Streaming, silencing the warning that the cache may be redundant")
+
+	// Zone and location
+	fs.StringVar(&cfg.Zone, "z", "", "GCP zone (required for -R mode)")
+	fs.StringVar(&cfg.Zone, "zone", "", "GCP zone (required for -R mode)")
+	fs.StringVar(&cfg.Network, "n", cfg.Network, "VPC network for build VM (remote mode only)")
+	fs.StringVar(&cfg.Network, "network", cfg.Network, "VPC network for build VM (remote mode only)")
+	fs.StringVar(&cfg.Subnet, "u", cfg.Subnet, "Subnet for build VM (remote mode only)")
+	fs.StringVar(&cfg.Subnet, "subnet", cfg.Subnet, "Subnet for build VM (remote mode only)")
+	fs.BoolVar(&cfg.NoExternalIP, "no-external-ip", false, "Create the build VM without an external IP (remote mode only, needs Cloud NAT)")
+	fs.BoolVar(&cfg.ConnectivityCheck, "connectivity-check", false, "Run Network Management connectivity tests from the build VM before pulling (remote mode only)")
+
+	// Cache configuration
+	fs.IntVar(&cfg.DiskSizeGB, "s", cfg.DiskSizeGB, "Disk size in GB")         // 改为 DiskSizeGB
+	fs.IntVar(&cfg.DiskSizeGB, "disk-size", cfg.DiskSizeGB, "Disk size in GB") // 改为 DiskSizeGB
+	fs.DurationVar(&cfg.Timeout, "t", cfg.Timeout, "Build timeout")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Build timeout")
+	fs.DurationVar(&cfg.PullTimeout, "pull-timeout", 0, "Separate timeout for pulling images; --timeout then covers the other phases")
+	fs.DurationVar(&cfg.MaxTimeoutExtension, "max-timeout-extension", cfg.MaxTimeoutExtension, "Most 'control extend' can add to the running build's timeout (0 disables it)")
+	fs.DurationVar(&cfg.CleanupTimeout, "cleanup-timeout", cfg.CleanupTimeout, "Give up deleting the build's temporary resources after this long, listing what was left behind")
+	fs.IntVar(&cfg.SerialPort, "serial-port", cfg.SerialPort, "Build VM serial port (1-4) the setup script reports status and logs to")
+	fs.Float64Var(&cfg.MinPullThroughput, "min-pull-throughput", 0, "Warn about registries pulled from slower than this many MB/s (0 disables it)")
+
+	// Image management
+	fs.StringVar(&cfg.DiskFamilyName, "disk-family", cfg.DiskFamilyName, "Image family name") // 改为 DiskFamilyName
+	fs.Var(&f.diskLabels, "disk-labels", "Disk labels (key=value, repeatable)")               // 改为 disk-labels
+	fs.Var(&f.vmLabels, "vm-labels", "Build VM labels, added to the disk labels (key=value, repeatable)")
+	fs.BoolVar(&cfg.StrictFamilyArch, "strict-family-arch", false, "Fail if the image family holds images of another architecture, instead of warning")
+	fs.Var(&f.familyAliases, "family-alias", "Secondary image family, recorded as a family-alias-<n> label (repeatable)")
+	fs.Var(&f.imageLicenses, "image-license", "License attached to the cache image: projects/<project>/global/licenses/<name> (repeatable)")
+
+	// Authentication
+	fs.StringVar(&cfg.GCPOAuth, "gcp-oauth", "", "Path to GCP service account credential file")
+	fs.StringVar(&cfg.ServiceAccount, "service-account", cfg.ServiceAccount, "Service account email")
+	fs.BoolVar(&cfg.SSHInsecure, "ssh-insecure", false, "Skip SSH host key verification for the build VM")
+	fs.StringVar(&cfg.SSHAddressType, "ssh-address-type", cfg.SSHAddressType, "Build VM address for SSH: auto, internal or external")
+	fs.StringVar(&cfg.SSHProxyJump, "ssh-proxy-jump", "", "Reach the build VM through a bastion: [user@]host[:port]")
+	fs.StringVar(&cfg.SSHProxyJumpKeyFile, "ssh-proxy-jump-key-file", "", "Private key for the bastion (default: --ssh-key-file)")
+	fs.StringVar(&cfg.SSHKeyFile, "ssh-key-file", "", "Private key for SSH to the build VM (default: ephemeral per-build key)")
+	fs.StringVar(&cfg.ImagePullAuth, "image-pull-auth", cfg.ImagePullAuth, "Image pull authentication")
+	fs.Var(&f.credentialProviders, "credential-provider", "Kubelet image credential provider the nodes run for a registry pattern: <pattern>=<provider> (repeatable)")
+
+	// Logging (console only, no GCS)
+	fs.BoolVar(&f.verbose, "v", false, "Enable verbose logging")
+	fs.BoolVar(&f.verbose, "verbose", false, "Enable verbose logging")
+	fs.BoolVar(&f.quiet, "q", false, "Suppress non-error output")
+	fs.BoolVar(&f.quiet, "quiet", false, "Suppress non-error output")
+	fs.BoolVar(&f.noColor, "no-color", false, "Disable colored log output")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format: text or json (JSON lines on stderr)")
+	fs.StringVar(&cfg.LogFile, "log-file", "", "Also append the log, in --log-format, to this file")
+	fs.BoolVar(&cfg.DebugHTTP, "debug-http", false, "Log the metadata, registry and token requests (method, URL, status, latency) at trace level")
+	fs.StringVar(&cfg.TraceFile, "trace", "", "Write a timeline of the build's phases, GCP operations and image pulls to this JSON file, for chrome://tracing")
+
+	// Advanced options
+	fs.StringVar(&cfg.JobName, "job-name", cfg.JobName, "Build job name")
+	fs.StringVar(&cfg.MachineType, "machine-type", cfg.MachineType, "VM machine type for -R mode, or auto to size it from the images (default for arm64: t2a-standard-2)")
+	fs.BoolVar(&cfg.Preemptible, "preemptible", false, "Use preemptible VM for -R mode")
+	fs.BoolVar(&cfg.ShieldedVM, "shielded-vm", false, "Create the build VM as a Shielded VM (-R mode)")
+	fs.StringVar(&cfg.BuildVMImage, "build-vm-image", "", "Boot image of the build VM: projects/<project>/global/images/[family/]<name> (-R mode)")
+	fs.StringVar(&cfg.PostPullCommand, "post-pull-command", "", "Bash script run as root on the build VM after pulling, before the image is created")
+	fs.BoolVar(&cfg.AllowHooks, "allow-hooks", false, "Run the scripts of the config file: pre_pull_commands, post_pull_commands, post_pull_command and additional_startup_scripts")
+	fs.Var(&f.additionalStartupScripts, "additional-startup-script", "Bash script file the build VM's startup script also runs, e.g. an org-mandated agent install (repeatable, -R mode)")
+	fs.StringVar(&cfg.AdditionalStartupScriptsOrder, "additional-startup-scripts-order", cfg.AdditionalStartupScriptsOrder, "Run additional startup scripts before or after the build VM's own bootstrap steps")
+	fs.StringVar(&cfg.BuildVMImageVersion, "build-vm-image-version", "", "Pin the build VM to this image of the --build-vm-image family or the default one (-R mode)")
+	fs.StringVar(&cfg.DiskType, "disk-type", cfg.DiskType, "Cache disk type")
+	fs.StringVar(&cfg.Arch, "disk-architecture", cfg.Arch, "CPU architecture the cache is built for: x86_64 or arm64")
+	fs.StringVar(&cfg.Snapshotter, "snapshotter", cfg.Snapshotter, "containerd snapshotter matching the nodes: overlayfs, native or stargz")
+	fs.StringVar(&cfg.NodeImageType, "node-image-type", "", "Node image of the target node pools: COS or UBUNTU; recorded on the image and checked against the build VM")
+	fs.StringVar(&cfg.COSVersion, "cos-version", "", "COS milestone or version of the target nodes, e.g. 113; warns about images their node image already holds")
+	fs.StringVar(&cfg.OSType, "os-type", cfg.OSType, "Node OS the cache is built for: linux or windows (-R mode)")
+	fs.IntVar(&cfg.Partitions, "partitions", cfg.Partitions, "Split the images across N cache disks built in parallel (-R mode)")
+	fs.StringVar(&cfg.ContainerdVersion, "containerd-version", "", "Install this containerd release on the build VM, e.g. 1.7.13 (-R mode)")
+	fs.StringVar(&cfg.GKEVersion, "gke-version", "", "GKE version of the target nodes, e.g. 1.29; warns when the build's containerd differs")
+	fs.StringVar(&cfg.IncludeGKESystemImages, "include-gke-system-images", "", "Add the upstream registry.k8s.io images of the system images GKE nodes of this version run (pause, kube-dns, metrics-server; the nodes pull gke.gcr.io mirrors), e.g. 1.29")
+	fs.StringVar(&cfg.SystemImagesManifest, "system-images-manifest", "", "Read the system images per GKE version from this file instead of the built-in table")
+	fs.StringVar(&cfg.PreinstalledImagesFile, "preinstalled-images-file", "", "Read the preinstalled images per COS milestone from this file instead of the built-in table")
+	fs.StringVar(&cfg.ConfigSnapshotBucket, "config-snapshot-bucket", "", "Cloud Storage bucket to store the effective configuration in, for --reproduce-from")
+	fs.StringVar(&cfg.AttestationBucket, "attestation-bucket", "", "Cloud Storage bucket to store the SLSA provenance of each cache image in")
+	fs.StringVar(&cfg.AttestationKMSKey, "attestation-kms-key", "", "Cloud KMS key version that signs the provenance")
+	fs.StringVar(&cfg.AttestationKeyFile, "attestation-key-file", "", "Private key (PEM) that signs the provenance, instead of a KMS key")
+	fs.BoolVar(&cfg.AutoRecover, "auto-recover", false, "Unmount, detach and delete disks a crashed local-mode run left attached to this VM")
+	fs.BoolVar(&cfg.AbortOnWarning, "abort-on-warning", false, "Fail the build if any warning is logged (strict CI gating)")
+	fs.IntVar(&cfg.RetryBudget, "retry-budget", cfg.RetryBudget, "Retried failures allowed across the whole build before it stops")
+	fs.StringVar(&cfg.APIEndpoint, "api-endpoint", "", "Compute Engine API endpoint, e.g. https://compute.restricted.googleapis.com")
+
+	// Help options
+	fs.BoolVar(&f.helpFull, "help-full", false, "Show complete help")
+	fs.BoolVar(&f.helpExamples, "help-examples", false, "Show usage examples")
+	fs.BoolVar(&f.helpConfig, "help-config", false, "Show configuration file help")
+	fs.BoolVar(&f.showVersion, "version", false, "Show version information")
+	fs.StringVar(&f.lang, "lang", "", "Language of help and error guidance, e.g. ja (default: from LC_ALL, LC_MESSAGES or LANG)")
+
+	return fs, f
+}
+
+// printSkippedChecks lists the validation checks --offline left out
+func printSkippedChecks(skipped []string) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "⚠️  Skipped with --offline (checked when the build runs):")
+	for _, check := range skipped {
+		fmt.Fprintf(os.Stderr, "    - %s\n", check)
+	}
+}
+
+// printImageListNotes reports what validation changed or noticed in the image list
+func printImageListNotes(cfg *config.Config) {
+	if duplicates := cfg.DuplicateImages(); len(duplicates) > 0 {
+		fmt.Fprintf(os.Stderr, "ℹ️  Removed %d duplicate images: %s\n", len(duplicates), strings.Join(duplicates, ", "))
+	}
+	for _, note := range cfg.SpecNotes() {
+		fmt.Fprintf(os.Stderr, "ℹ️  From %s: %s\n", cfg.FromSpec, note)
+	}
+	for _, tags := range cfg.MultipleTags() {
+		fmt.Fprintf(os.Stderr, "⚠️  %s is listed with %d tags, each cached in full: %s\n", tags.Repository, len(tags.Images), strings.Join(tags.Images, ", "))
+	}
 }
 
 // handleGenerateConfig handles configuration template generation
@@ -213,6 +503,53 @@ func handleGenerateConfig(templateType, outputPath string) error {
 	return nil
 }
 
+// loadReproduceConfig loads the configuration recorded on a cache image. Flags
+// given on the command line take precedence, like over a config file.
+func loadReproduceConfig(cfg *config.Config, configFile, imageName string) error {
+	if configFile != "" {
+		return fmt.Errorf("--reproduce-from and --config cannot be combined: override the recorded configuration with flags")
+	}
+
+	// Credentials given as secret:// are needed to read the image
+	if err := resolveSecrets(context.Background(), cfg); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	recorded, err := builder.FetchConfigSnapshot(ctx, cfg.ProjectName, cfg.GCPOAuth, cfg.APIEndpoint, imageName)
+	if err != nil {
+		return err
+	}
+	if err := cfg.LoadFromYAMLData(recorded.Snapshot, "recorded on image "+imageName); err != nil {
+		return err
+	}
+
+	// Boot the build VM from the image the recorded build used, even if its family has moved on
+	if recorded.BuildVMImage != "" && cfg.BuildVMImageVersion == "" && cfg.IsRemoteMode() {
+		if path, err := gcp.ParseImagePath(cfg.BuildVMImage); cfg.BuildVMImage == "" || (err == nil && path.Family) {
+			cfg.BuildVMImageVersion = recorded.BuildVMImage
+		}
+	}
+
+	source := imageName
+	if path, err := gcp.ParseImagePath(imageName); err == nil {
+		source = path.Name
+	}
+	if cfg.DiskImageName == source {
+		return fmt.Errorf("image %s already exists: give the rebuilt image a new name with --disk-image-name", source)
+	}
+	return nil
+}
+
+// resolveSecrets reads the options given as secret:// URIs from Secret Manager
+func resolveSecrets(ctx context.Context, cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	return cfg.ResolveSecrets(ctx)
+}
+
 // validateExecutionMode ensures exactly one execution mode is specified
 func validateExecutionMode(local, remote bool) (config.ExecutionMode, error) {
 	if local && remote {
@@ -227,6 +564,14 @@ func validateExecutionMode(local, remote bool) (config.ExecutionMode, error) {
 	return config.ModeRemote, nil
 }
 
+// executionModeFlag returns the flag that selects mode, to tell where it was set
+func executionModeFlag(mode config.ExecutionMode) string {
+	if mode == config.ModeLocal {
+		return "-L"
+	}
+	return "-R"
+}
+
 // stringSlice implements flag.Value for multiple string values
 type stringSlice []string
 
@@ -239,6 +584,29 @@ func (s *stringSlice) Set(value string) error {
 	return nil
 }
 
+// optionalFormat is a flag that may be given bare (yaml) or with a format
+type optionalFormat string
+
+func (f *optionalFormat) String() string {
+	return string(*f)
+}
+
+func (f *optionalFormat) Set(value string) error {
+	switch value {
+	case "true":
+		value = "yaml"
+	case "false":
+		value = ""
+	}
+	*f = optionalFormat(value)
+	return nil
+}
+
+// IsBoolFlag lets the flag be passed without a value
+func (f *optionalFormat) IsBoolFlag() bool {
+	return true
+}
+
 // stringMap implements flag.Value for key=value pairs
 type stringMap map[string]string
 
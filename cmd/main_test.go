@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// mainArgsEnv carries the arguments main runs with in a child test process,
+// separated by newlines
+const mainArgsEnv = "GKE_IMAGE_CACHE_BUILDER_TEST_MAIN_ARGS"
+
+// networkIOMarker is printed by the child process for each request it tried
+const networkIOMarker = "NETWORK I/O:"
+
+// blockingTransport fails every request, reporting it to the parent process
+type blockingTransport struct{}
+
+func (blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fmt.Fprintln(os.Stderr, networkIOMarker, req.Method, req.URL)
+	return nil, errors.New("network I/O is not allowed here")
+}
+
+// TestMain runs main in the child processes started by runMain, with every
+// request through the default transport blocked
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(mainArgsEnv); ok {
+		http.DefaultTransport = blockingTransport{}
+		os.Args = append([]string{"gke-image-cache-builder"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs main with args in a child process whose HTTP requests fail,
+// and whose connections to the metadata server or a proxy go to a listener
+// counting them. It returns the child's output and the connections made.
+func runMain(t *testing.T, args ...string) (string, int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().String()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(),
+		mainArgsEnv+"="+strings.Join(args, "\n"),
+		"GCE_METADATA_HOST="+addr,
+		"HTTP_PROXY=http://"+addr, "HTTPS_PROXY=http://"+addr, "NO_PROXY=",
+		"XDG_CONFIG_HOME="+t.TempDir(),
+	)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("failed to run %v: %v", args, err)
+	}
+	return string(output), connections.Load()
+}
+
+func TestUXPathsDoNoNetworkIO(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "build.yaml")
+	tests := [][]string{
+		{},
+		{"-h"},
+		{"--help"},
+		{"--help-full"},
+		{"--help-examples"},
+		{"--help-config"},
+		{"--version"},
+		{"--generate-config", "basic", "--output", output},
+		{"--generate-config", "ml", "--output", output},
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(append([]string{"gke-image-cache-builder"}, args...), " "), func(t *testing.T) {
+			out, connections := runMain(t, args...)
+			if strings.Contains(out, networkIOMarker) || connections > 0 {
+				t.Errorf("made %d connections and the requests:\n%s", connections, out)
+			}
+			if strings.TrimSpace(out) == "" {
+				t.Error("printed nothing")
+			}
+		})
+	}
+}
+
+// TestOfflineValidation validates a local-mode config file, which probes the
+// metadata server unless --offline skips the probes
+func TestOfflineValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.yaml")
+	yaml := "execution:\n  mode: local\n  zone: us-central1-a\nproject:\n  name: p\ncache:\n  name: cache\nimages:\n  - nginx:latest\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, connections := runMain(t, "--validate-config", path); connections == 0 {
+		t.Error("validation without --offline made no connection: the probes are not detected")
+	}
+	out, connections := runMain(t, "--validate-config", path, "--offline")
+	if strings.Contains(out, networkIOMarker) || connections > 0 {
+		t.Errorf("validation with --offline made %d connections and the requests:\n%s", connections, out)
+	}
+	if !strings.Contains(out, "GCP VM detection for local mode") {
+		t.Errorf("validation with --offline did not report the skipped checks:\n%s", out)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"encoding/json"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+)
+
+// resultJSON is the result of a successful build with --log-format=json, in
+// place of the success message and next steps
+type resultJSON struct {
+	Status  string   `json:"status"`
+	Project string   `json:"project"`
+	Zone    string   `json:"zone"`
+	Images  []string `json:"images,omitempty"`
+	Disks   []string `json:"disks,omitempty"`
+
+	// NodePoolCommand creates a node pool attaching the images, as in the
+	// next steps of text output
+	NodePoolCommand string `json:"node_pool_command,omitempty"`
+}
+
+// printResultJSON prints the result of a successful build as one JSON line
+func printResultJSON(result *builder.BuildResult) {
+	out := resultJSON{
+		Status:  "succeeded",
+		Project: result.Project,
+		Zone:    result.Zone,
+		Images:  result.Images,
+		Disks:   result.Disks,
+	}
+	if len(result.Images) > 0 {
+		out.NodePoolCommand = result.NodePoolCommand()
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// printNextSteps tells how to use the images of a successful build: the node
+// pool flags that attach them, the grant clusters in other projects need, and
+// how to check them again later; and how to attach the disks it kept
+func printNextSteps(result *builder.BuildResult) {
+	fmt.Println()
+	fmt.Println("Next steps:")
+	if len(result.Images) > 0 {
+		printImageNextSteps(result)
+	}
+	if len(result.Disks) > 0 {
+		fmt.Println("  Attach a cache disk to a VM in the same zone, read-only:")
+		for _, disk := range result.Disks {
+			fmt.Printf("    gcloud compute instances attach-disk <VM> --disk=%s --zone=%s --project=%s --mode=ro\n",
+				gcp.ResourceName(disk), result.Zone, result.Project)
+		}
+		fmt.Println("  Delete it when done, it is not cleaned up:")
+		for _, disk := range result.Disks {
+			fmt.Printf("    gcloud compute disks delete %s --zone=%s --project=%s\n", gcp.ResourceName(disk), result.Zone, result.Project)
+		}
+	}
+}
+
+// printImageNextSteps tells how to use the images of a successful build
+func printImageNextSteps(result *builder.BuildResult) {
+	exe := ui.GetToolInfo().ExecutableName
+
+	if len(result.Images) > 1 {
+		fmt.Println("  Create a node pool that attaches the images, one secondary boot disk per partition:")
+	} else {
+		fmt.Println("  Create a node pool that attaches the image as a secondary boot disk:")
+	}
+	fmt.Printf("    %s\n", strings.ReplaceAll(result.NodePoolCommand(), "\n", "\n    "))
+
+	fmt.Printf("  A cluster in a project other than %s needs its GKE service agent to be\n", result.Project)
+	fmt.Printf("  allowed to use images of %s:\n", result.Project)
+	fmt.Printf("    gcloud projects add-iam-policy-binding %s --role=roles/compute.imageUser \\\n", result.Project)
+	fmt.Println("      --member=serviceAccount:service-<CLUSTER_PROJECT_NUMBER>@container-engine-robot.iam.gserviceaccount.com")
+
+	mode := "-L"
+	if result.Mode == config.ModeRemote {
+		mode = fmt.Sprintf("-R --zone=%s", result.Zone)
+	}
+	fmt.Println("  Check the cached content again at any time:")
+	for _, name := range result.Images {
+		fmt.Printf("    %s verify-image %s --project-name=%s --image=%s\n", exe, mode, result.Project, name)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+)
+
+// TestPrintResultJSON prints the result of a build with --log-format=json as
+// a single JSON line, free of the text output's emoji and next steps
+func TestPrintResultJSON(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	printResultJSON(&builder.BuildResult{Project: "p", Zone: "z", Images: []string{"web-cache"}})
+	os.Stdout = stdout
+	writer.Close()
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(output), "\n"); lines != 1 {
+		t.Fatalf("printResultJSON() wrote %d lines, want 1:\n%s", lines, output)
+	}
+	var result resultJSON
+	if err := json.Unmarshal(output, &result); err != nil {
+		t.Fatalf("printResultJSON() wrote invalid JSON: %v\n%s", err, output)
+	}
+	if result.Status != "succeeded" || result.Project != "p" || result.Zone != "z" || len(result.Images) != 1 || result.Images[0] != "web-cache" {
+		t.Errorf("printResultJSON() = %+v", result)
+	}
+	if !strings.Contains(result.NodePoolCommand, "disk-image=projects/p/global/images/web-cache") {
+		t.Errorf("node_pool_command = %q, want the image attached", result.NodePoolCommand)
+	}
+}
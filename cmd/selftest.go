@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+)
+
+// helpFlagPattern finds the long flags a message mentions, e.g. --disk-size
+// in "--disk-size=30" or "[--deep]"
+var helpFlagPattern = regexp.MustCompile(`(?:^|[^\w-])--([a-z][a-z0-9-]*)`)
+
+// proseFlags are --tokens of the help messages that are not flags of this
+// tool: the flag package's own --help, and ctr options shown as --pull-arg
+// values
+var proseFlags = map[string]bool{
+	"help":          true,
+	"all-platforms": true,
+}
+
+// runSelftest implements "selftest": it checks that every message catalog is
+// valid and that every flag the help and error messages of every locale
+// mention is registered, so that renaming or removing a flag cannot leave the
+// documentation behind. It is meant for CI and returns the process exit code.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	if err := ui.CheckCatalogs(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	known := registeredFlags()
+	drift := 0
+	checked := 0
+	for _, locale := range ui.Locales() {
+		catalog := ui.Catalog(locale)
+		ids := make([]string, 0, len(catalog))
+		for id := range catalog {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			reported := make(map[string]bool)
+			for _, match := range helpFlagPattern.FindAllStringSubmatch(catalog[id], -1) {
+				name := match[1]
+				checked++
+				if known[name] || proseFlags[name] || reported[name] {
+					continue
+				}
+				reported[name] = true
+				fmt.Fprintf(os.Stderr, "❌ %s (%s): --%s is not a flag\n", id, locale, name)
+				drift++
+			}
+		}
+	}
+
+	if drift > 0 {
+		fmt.Fprintf(os.Stderr, "%d flags mentioned in the messages do not exist; register them, fix the messages, or add prose examples to proseFlags\n", drift)
+		return 1
+	}
+	fmt.Printf("✅ All %d flag mentions in the messages of %d locales are registered\n", checked, len(ui.Locales()))
+	return 0
+}
+
+// registeredFlags returns the names of the flags of the build and of every
+// subcommand
+func registeredFlags() map[string]bool {
+	buildFlags, _ := newFlagSet(config.NewConfig())
+	verifyFlags, _ := newVerifyImageFlagSet(config.NewConfig())
+	extendFlags, _ := newControlExtendFlagSet()
+	doctorFlags, _ := newDoctorFlagSet(config.NewConfig())
+	watchFlags, _ := newWatchFlagSet(config.NewConfig())
+	cleanupFlags := newCleanupFlagSet(config.NewConfig())
+
+	known := make(map[string]bool)
+	for _, fs := range []*flag.FlagSet{buildFlags, verifyFlags, extendFlags, doctorFlags, watchFlags, cleanupFlags} {
+		fs.VisitAll(func(f *flag.Flag) {
+			known[f.Name] = true
+		})
+	}
+	return known
+}
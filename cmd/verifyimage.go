@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+)
+
+// verify-image exit codes
+const (
+	verifyPassed      = 0
+	verifyFailed      = 1 // the image has content problems
+	verifyNotRunnable = 2 // the check could not run
+)
+
+// runVerifyImage implements "verify-image": it checks the containerd content
+// store of an existing cache image and returns the process exit code
+func runVerifyImage(args []string) int {
+	cfg := config.NewConfig()
+	cfg.Timeout = 15 * time.Minute
+
+	fs, opts := newVerifyImageFlagSet(cfg)
+	if err := fs.Parse(args); err != nil {
+		return verifyNotRunnable
+	}
+	cfg.AdditionalStartupScripts = []string(opts.additionalStartupScripts)
+
+	if opts.format != "text" && opts.format != "json" {
+		verifyUsageError(fmt.Errorf("unsupported format '%s', supported formats: text, json", opts.format))
+		return verifyNotRunnable
+	}
+	// Progress is logged to stdout, which JSON output needs for itself
+	cfg.Quiet = opts.format == "json"
+
+	if opts.localMode || opts.remoteMode {
+		mode, err := validateExecutionMode(opts.localMode, opts.remoteMode)
+		if err != nil {
+			verifyUsageError(err)
+			return verifyNotRunnable
+		}
+		cfg.SetMode(mode, executionModeFlag(mode))
+	}
+
+	if err := cfg.ValidateVerify(); err != nil {
+		verifyUsageError(err)
+		return verifyNotRunnable
+	}
+
+	// An interrupt stops the check, whose temporary resources are still cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := resolveSecrets(ctx, cfg); err != nil {
+		cfg.RemoveSecrets()
+		verifyUsageError(err)
+		return verifyNotRunnable
+	}
+	defer cfg.RemoveSecrets()
+
+	b, err := builder.NewBuilder(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create builder: %v\n", err)
+		return verifyNotRunnable
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	result, err := b.VerifyImage(ctx, opts.deep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Could not verify image '%s': %v\n", cfg.DiskImageName, err)
+		return verifyNotRunnable
+	}
+
+	if opts.format == "json" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode result: %v\n", err)
+			return verifyNotRunnable
+		}
+		fmt.Println(string(data))
+	} else {
+		printVerification(result)
+	}
+
+	if !result.Passed {
+		return verifyFailed
+	}
+	return verifyPassed
+}
+
+// verifyImageFlags holds the verify-image options that are not Config fields
+type verifyImageFlags struct {
+	localMode, remoteMode    bool
+	additionalStartupScripts stringSlice
+	deep                     bool
+	format                   string
+}
+
+// newVerifyImageFlagSet registers the verify-image flags
+func newVerifyImageFlagSet(cfg *config.Config) (*flag.FlagSet, *verifyImageFlags) {
+	fs := flag.NewFlagSet("verify-image", flag.ContinueOnError)
+	f := &verifyImageFlags{}
+	fs.StringVar(&cfg.DiskImageName, "image", "", "Cache image to verify")
+	fs.StringVar(&cfg.ProjectName, "project-name", "", "GCP project holding the image")
+	fs.BoolVar(&f.localMode, "L", false, "Attach the image to the current GCP VM (local mode)")
+	fs.BoolVar(&f.localMode, "local-mode", false, "Attach the image to the current GCP VM (local mode)")
+	fs.BoolVar(&f.remoteMode, "R", false, "Attach the image to a temporary GCP VM (remote mode)")
+	fs.BoolVar(&f.remoteMode, "remote-mode", false, "Attach the image to a temporary GCP VM (remote mode)")
+	fs.StringVar(&cfg.Zone, "z", "", "GCP zone (required for -R mode)")
+	fs.StringVar(&cfg.Zone, "zone", "", "GCP zone (required for -R mode)")
+	fs.StringVar(&cfg.Network, "n", cfg.Network, "VPC network for the verification VM (remote mode only)")
+	fs.StringVar(&cfg.Network, "network", cfg.Network, "VPC network for the verification VM (remote mode only)")
+	fs.StringVar(&cfg.Subnet, "u", cfg.Subnet, "Subnet for the verification VM (remote mode only)")
+	fs.StringVar(&cfg.Subnet, "subnet", cfg.Subnet, "Subnet for the verification VM (remote mode only)")
+	fs.BoolVar(&cfg.NoExternalIP, "no-external-ip", false, "Create the verification VM without an external IP (remote mode only)")
+	fs.BoolVar(&cfg.ShieldedVM, "shielded-vm", false, "Create the verification VM as a Shielded VM (-R mode)")
+	fs.StringVar(&cfg.BuildVMImage, "build-vm-image", "", "Boot image of the verification VM (-R mode)")
+	fs.Var(&f.additionalStartupScripts, "additional-startup-script", "Bash script file the verification VM's startup script also runs (repeatable, -R mode)")
+	fs.StringVar(&cfg.AdditionalStartupScriptsOrder, "additional-startup-scripts-order", cfg.AdditionalStartupScriptsOrder, "Run additional startup scripts before or after the VM's own bootstrap steps")
+	fs.StringVar(&cfg.GCPOAuth, "gcp-oauth", "", "Path to GCP service account credential file")
+	fs.StringVar(&cfg.APIEndpoint, "api-endpoint", "", "Compute Engine API endpoint, e.g. https://compute.restricted.googleapis.com")
+	fs.StringVar(&cfg.ServiceAccount, "service-account", cfg.ServiceAccount, "Service account email")
+	fs.StringVar(&cfg.SSHKeyFile, "ssh-key-file", "", "Private key for SSH to the verification VM (default: ephemeral key)")
+	fs.BoolVar(&cfg.SSHInsecure, "ssh-insecure", false, "Skip SSH host key verification for the verification VM")
+	fs.StringVar(&cfg.SSHAddressType, "ssh-address-type", cfg.SSHAddressType, "Verification VM address for SSH: auto, internal or external")
+	fs.StringVar(&cfg.SSHProxyJump, "ssh-proxy-jump", "", "Reach the verification VM through a bastion: [user@]host[:port]")
+	fs.StringVar(&cfg.SSHProxyJumpKeyFile, "ssh-proxy-jump-key-file", "", "Private key for the bastion (default: --ssh-key-file)")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Verification timeout")
+	fs.BoolVar(&cfg.AutoRecover, "auto-recover", false, "Unmount, detach and delete disks a crashed local-mode run left attached to this VM")
+	fs.BoolVar(&f.deep, "deep", false, "Also rehash every blob and compare it to its digest")
+	fs.IntVar(&cfg.ParallelVerify, "parallel-verify", cfg.ParallelVerify, "Check blobs with up to N concurrent commands on the VM")
+	fs.StringVar(&f.format, "format", "text", "Output format: text or json")
+	fs.BoolVar(&cfg.Verbose, "v", false, "Enable verbose logging")
+	fs.BoolVar(&cfg.Verbose, "verbose", false, "Enable verbose logging")
+	fs.BoolVar(&cfg.NoColor, "no-color", false, "Disable colored log output")
+	return fs, f
+}
+
+// verifyUsageError reports an invalid verify-image invocation; the build's
+// configuration help does not apply to it
+func verifyUsageError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	fmt.Fprintf(os.Stderr, "Run '%s verify-image -h' for the options.\n", ui.GetToolInfo().ExecutableName)
+}
+
+// printVerification writes the text summary of a verify-image run
+func printVerification(result *builder.ImageVerification) {
+	check := "size check"
+	if result.Deep {
+		check = "size and digest check"
+	}
+	fmt.Printf("Image:      %s (project %s)\n", result.Image, result.Project)
+	if result.Family != "" {
+		fmt.Printf("Family:     %s\n", result.Family)
+	}
+	if len(result.Aliases) > 0 {
+		fmt.Printf("Aliases:    %s\n", strings.Join(result.Aliases, ", "))
+	}
+	if result.Filesystem != "" {
+		fmt.Printf("Filesystem: %s\n", result.Filesystem)
+	}
+	fmt.Printf("Content:    %d images, %d manifests, %d blobs (%.1f GiB), %s\n",
+		result.Images, result.Manifests, result.Blobs, float64(result.Bytes)/(1<<30), check)
+	fmt.Printf("Duration:   %s\n", result.Duration)
+
+	if result.Passed {
+		fmt.Printf("✅ Image '%s' passed verification\n", result.Image)
+		return
+	}
+	fmt.Printf("❌ Image '%s' failed verification with %d problems:\n", result.Image, len(result.Problems))
+	for _, problem := range result.Problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+}
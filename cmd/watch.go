@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/builder"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ui"
+)
+
+// runWatch implements "watch": it finishes a remote build whose invocation is
+// gone and returns the process exit code
+func runWatch(args []string) int {
+	cfg := config.NewConfig()
+	cfg.Timeout = 2 * time.Hour
+
+	fs, opts := newWatchFlagSet(cfg)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	// Without --build-id, the last build on this machine, e.g. from an earlier session
+	if opts.buildID == "" {
+		record, err := builder.LastBuild()
+		if err != nil || record == nil || record.BuildID == "" {
+			watchUsageError(fmt.Errorf("build-id is required (use --build-id): no remote build is recorded on this machine"))
+			return 1
+		}
+		opts.buildID = record.BuildID
+		if cfg.ProjectName == "" {
+			cfg.ProjectName = record.Project
+		}
+		if cfg.Zone == "" {
+			cfg.Zone = record.Zone
+		}
+		fmt.Printf("Watching the last build on this machine: %s (%s)\n", record.BuildID, record.DiskImage)
+	}
+
+	if err := cfg.ValidateWatch(); err != nil {
+		watchUsageError(err)
+		return 1
+	}
+
+	b, err := builder.NewBuilder(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create builder: %v\n", err)
+		return 1
+	}
+	defer b.Close()
+
+	// An interrupt stops watch, which can be run again, after its cleanup
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	result, err := b.Watch(ctx, opts.buildID, opts.abandon, opts.force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Build %s not finished: %v\n", opts.buildID, err)
+		return 1
+	}
+	if len(result.Abandoned) > 0 {
+		fmt.Printf("✅ Deleted the resources of build %s\n", opts.buildID)
+		return 0
+	}
+	fmt.Printf("✅ Build %s finished\n", opts.buildID)
+	printNextSteps(&result.BuildResult)
+	return 0
+}
+
+// watchFlags holds the watch options that are not Config fields
+type watchFlags struct {
+	buildID string
+	abandon bool
+	force   bool
+}
+
+// newWatchFlagSet registers the watch flags
+func newWatchFlagSet(cfg *config.Config) (*flag.FlagSet, *watchFlags) {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	f := &watchFlags{}
+	fs.StringVar(&f.buildID, "build-id", "", "Build to finish, as logged when it started (default: the last build on this machine)")
+	fs.StringVar(&cfg.ProjectName, "project-name", "", "GCP project of the build")
+	fs.StringVar(&cfg.Zone, "z", "", "Zone of the build VM (default: search every zone)")
+	fs.StringVar(&cfg.Zone, "zone", "", "Zone of the build VM (default: search every zone)")
+	fs.BoolVar(&f.abandon, "abandon", false, "Delete the build's VM and cache disk without creating the image")
+	fs.BoolVar(&f.force, "force", false, "Take over the build even though the invocation that started it recorded a heartbeat recently")
+	fs.StringVar(&cfg.GCPOAuth, "gcp-oauth", "", "Path to GCP service account credential file")
+	fs.StringVar(&cfg.APIEndpoint, "api-endpoint", "", "Compute Engine API endpoint, e.g. https://compute.restricted.googleapis.com")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "How long to wait for the build VM and finish the build")
+	fs.DurationVar(&cfg.CleanupTimeout, "cleanup-timeout", cfg.CleanupTimeout, "How long cleanup may take")
+	fs.BoolVar(&cfg.Verbose, "v", false, "Enable verbose logging")
+	fs.BoolVar(&cfg.Verbose, "verbose", false, "Enable verbose logging")
+	fs.BoolVar(&cfg.NoColor, "no-color", false, "Disable colored log output")
+	return fs, f
+}
+
+// watchUsageError reports an invalid watch invocation
+func watchUsageError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	fmt.Fprintf(os.Stderr, "Run '%s watch -h' for the options.\n", ui.GetToolInfo().ExecutableName)
+}
@@ -3,7 +3,10 @@ module github.com/0x00fafa/gke-image-cache-builder
 go 1.21
 
 require (
+	github.com/pkg/sftp v1.13.6
+	golang.org/x/crypto v0.16.0
 	golang.org/x/oauth2 v0.15.0
+	golang.org/x/term v0.15.0
 	google.golang.org/api v0.153.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -17,8 +20,8 @@ require (
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.2 // indirect
 	github.com/googleapis/gax-go/v2 v2.12.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	go.opencensus.io v0.24.0 // indirect
-	golang.org/x/crypto v0.16.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
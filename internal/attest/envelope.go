@@ -0,0 +1,66 @@
+package attest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// PayloadType is the DSSE payload type of in-toto statements
+const PayloadType = "application/vnd.in-toto+json"
+
+// Envelope is a DSSE envelope holding a statement and its signatures
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is a signature of an envelope's payload
+type Signature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// Signer signs the pre-authentication encoding of a DSSE envelope
+type Signer interface {
+	// KeyID identifies the key to verifiers
+	KeyID() string
+	// Sign signs message, hashing it as the key requires
+	Sign(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// Seal encodes the statement in an envelope and signs it; a nil signer leaves
+// the envelope unsigned
+func Seal(ctx context.Context, statement *Statement, signer Signer) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode statement: %w", err)
+	}
+
+	envelope := &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{},
+	}
+	if signer == nil {
+		return envelope, nil
+	}
+
+	sig, err := signer.Sign(ctx, preAuthEncoding(PayloadType, payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign statement with %s: %w", signer.KeyID(), err)
+	}
+	envelope.Signatures = append(envelope.Signatures, Signature{
+		KeyID: signer.KeyID(),
+		Sig:   base64.StdEncoding.EncodeToString(sig),
+	})
+	return envelope, nil
+}
+
+// preAuthEncoding is the DSSE v1 PAE: what is actually signed
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	header := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	return append([]byte(header), payload...)
+}
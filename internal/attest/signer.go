@@ -0,0 +1,112 @@
+package attest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// keySigner signs with a private key read from a file
+type keySigner struct {
+	key   crypto.Signer
+	keyID string
+}
+
+// LoadKeyFile returns a signer for the PEM-encoded ECDSA, RSA or Ed25519 private
+// key in path (PKCS#8, SEC 1 or PKCS#1). Its key ID is the SHA-256 of the
+// DER-encoded public key, in hex.
+func LoadKeyFile(path string) (Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("attestation key %s is not PEM-encoded", path)
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation key %s: %w", path, err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation key %s: %w", path, err)
+	}
+	sum := sha256.Sum256(public)
+	return &keySigner{key: key, keyID: hex.EncodeToString(sum[:])}, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			return key, nil
+		case *rsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("expected an ECDSA, RSA or Ed25519 private key")
+}
+
+func (s *keySigner) KeyID() string {
+	return s.keyID
+}
+
+// Sign signs message as is with Ed25519 keys and its SHA-256 digest with the
+// others (ECDSA ASN.1, RSA PKCS#1 v1.5)
+func (s *keySigner) Sign(_ context.Context, message []byte) ([]byte, error) {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return s.key.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(message)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// KMSClient signs digests with Cloud KMS asymmetric keys
+type KMSClient interface {
+	AsymmetricSign(ctx context.Context, keyVersion string, digest []byte) ([]byte, error)
+}
+
+// kmsSigner signs with a Cloud KMS key version
+type kmsSigner struct {
+	client     KMSClient
+	keyVersion string
+}
+
+// NewKMSSigner returns a signer for a Cloud KMS key version
+// (projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v>).
+// The key must sign SHA-256 digests, e.g. EC_SIGN_P256_SHA256 or
+// RSA_SIGN_PKCS1_2048_SHA256. Its key ID is gcpkms://<key version>, as used
+// by Sigstore tooling.
+func NewKMSSigner(client KMSClient, keyVersion string) Signer {
+	return &kmsSigner{client: client, keyVersion: keyVersion}
+}
+
+func (s *kmsSigner) KeyID() string {
+	return "gcpkms://" + s.keyVersion
+}
+
+func (s *kmsSigner) Sign(ctx context.Context, message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	return s.client.AsymmetricSign(ctx, s.keyVersion, digest[:])
+}
@@ -0,0 +1,202 @@
+package attest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testStatement is a provenance statement about one image
+func testStatement() *Statement {
+	return NewProvenance(
+		[]Subject{{Name: "projects/p/global/images/cache", Digest: map[string]string{"sha256": "abc"}}},
+		BuildDefinition{BuildType: "https://example.com/build", ExternalParameters: map[string]any{"disk_image": "cache"}},
+		RunDetails{Builder: Builder{ID: "https://example.com/builder"}},
+	)
+}
+
+// writeKey writes key PEM-encoded as blockType into a file
+func writeKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// verify checks a signature of message made as the signers do
+func verify(t *testing.T, public crypto.PublicKey, message, sig []byte) bool {
+	t.Helper()
+	digest := sha256.Sum256(message)
+	switch public := public.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(public, message, sig)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(public, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], sig) == nil
+	default:
+		t.Fatalf("unexpected key type %T", public)
+		return false
+	}
+}
+
+// openEnvelope checks that an envelope signed by keyID holds the statement and
+// returns its payload and signature
+func openEnvelope(t *testing.T, envelope *Envelope, keyID string) (payload, sig []byte) {
+	t.Helper()
+	if envelope.PayloadType != PayloadType || len(envelope.Signatures) != 1 || envelope.Signatures[0].KeyID != keyID {
+		t.Fatalf("envelope = %+v, want one signature by %s", envelope, keyID)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statement Statement
+	if err := json.Unmarshal(payload, &statement); err != nil || statement.Subject[0].Name != "projects/p/global/images/cache" {
+		t.Fatalf("payload = %s, %v, want the statement", payload, err)
+	}
+	sig, err = base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload, sig
+}
+
+func TestSealWithKeyFile(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		blockType string
+		der       []byte
+		key       crypto.Signer
+	}{
+		{name: "Ed25519 PKCS#8", blockType: "PRIVATE KEY", der: pkcs8, key: edKey},
+		{name: "ECDSA SEC 1", blockType: "EC PRIVATE KEY", der: sec1, key: ecKey},
+		{name: "RSA PKCS#1", blockType: "RSA PRIVATE KEY", der: x509.MarshalPKCS1PrivateKey(rsaKey), key: rsaKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := LoadKeyFile(writeKey(t, tt.blockType, tt.der))
+			if err != nil {
+				t.Fatalf("LoadKeyFile() error = %v", err)
+			}
+			public, err := x509.MarshalPKIXPublicKey(tt.key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(public)
+
+			envelope, err := Seal(context.Background(), testStatement(), signer)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			payload, sig := openEnvelope(t, envelope, hex.EncodeToString(sum[:]))
+			if !verify(t, tt.key.Public(), preAuthEncoding(PayloadType, payload), sig) {
+				t.Error("signature does not verify against the DSSE pre-authentication encoding")
+			}
+		})
+	}
+}
+
+func TestLoadKeyFileRejectsInvalidKeys(t *testing.T) {
+	if _, err := LoadKeyFile(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("LoadKeyFile() of a missing file succeeded")
+	}
+	if _, err := LoadKeyFile(writeKey(t, "PRIVATE KEY", []byte("not a key"))); err == nil {
+		t.Error("LoadKeyFile() of a corrupt key succeeded")
+	}
+	path := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(path, []byte("not PEM"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFile(path); err == nil {
+		t.Error("LoadKeyFile() of a file that is not PEM-encoded succeeded")
+	}
+}
+
+// fakeKMS signs digests with a local ECDSA key, as an EC_SIGN_P256_SHA256 key would
+type fakeKMS struct {
+	key        *ecdsa.PrivateKey
+	keyVersion string
+	err        error
+}
+
+func (f *fakeKMS) AsymmetricSign(_ context.Context, keyVersion string, digest []byte) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.keyVersion = keyVersion
+	return ecdsa.SignASN1(rand.Reader, f.key, digest)
+}
+
+func TestSealWithKMS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const keyVersion = "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	kms := &fakeKMS{key: key}
+
+	envelope, err := Seal(context.Background(), testStatement(), NewKMSSigner(kms, keyVersion))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if kms.keyVersion != keyVersion {
+		t.Errorf("signed with %q, want %q", kms.keyVersion, keyVersion)
+	}
+	payload, sig := openEnvelope(t, envelope, "gcpkms://"+keyVersion)
+	if !verify(t, &key.PublicKey, preAuthEncoding(PayloadType, payload), sig) {
+		t.Error("signature does not verify against the DSSE pre-authentication encoding")
+	}
+
+	kms.err = errors.New("permission denied")
+	if _, err := Seal(context.Background(), testStatement(), NewKMSSigner(kms, keyVersion)); !errors.Is(err, kms.err) {
+		t.Errorf("Seal() error = %v, want %v", err, kms.err)
+	}
+}
+
+func TestSealUnsigned(t *testing.T) {
+	envelope, err := Seal(context.Background(), testStatement(), nil)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if envelope.Signatures == nil || len(envelope.Signatures) != 0 {
+		t.Errorf("Signatures = %#v, want an empty list", envelope.Signatures)
+	}
+}
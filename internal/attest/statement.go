@@ -0,0 +1,80 @@
+// Package attest builds in-toto statements with SLSA provenance and seals them
+// in DSSE envelopes, signed or not, so consumers can verify them with standard
+// tooling.
+package attest
+
+import "time"
+
+const (
+	// StatementType is the in-toto statement version written
+	StatementType = "https://in-toto.io/Statement/v1"
+
+	// ProvenancePredicateType is the SLSA provenance version written
+	ProvenancePredicateType = "https://slsa.dev/provenance/v1"
+)
+
+// Statement is an in-toto statement about the subjects
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is an artifact the statement is about, identified by its digests
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is a SLSA provenance predicate: how the subjects were built
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition holds the inputs of the build
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]any       `json:"externalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ResourceDescriptor is an input of the build, such as a pulled image
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"` // none for a dependency identified by URI only
+}
+
+// RunDetails identifies what ran the build and when
+type RunDetails struct {
+	Builder  Builder        `json:"builder"`
+	Metadata *BuildMetadata `json:"metadata,omitempty"`
+}
+
+// Builder identifies the tool that ran the build
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// BuildMetadata describes one run of the build
+type BuildMetadata struct {
+	InvocationID string     `json:"invocationId,omitempty"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// NewProvenance returns a statement with a SLSA provenance predicate about subjects
+func NewProvenance(subjects []Subject, definition BuildDefinition, run RunDetails) *Statement {
+	return &Statement{
+		Type:          StatementType,
+		Subject:       subjects,
+		PredicateType: ProvenancePredicateType,
+		Predicate: Provenance{
+			BuildDefinition: definition,
+			RunDetails:      run,
+		},
+	}
+}
@@ -3,15 +3,24 @@ package auth
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"sync"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
 )
 
-// GCPAuth handles Google Cloud Platform authentication
+// GCPAuth handles Google Cloud Platform authentication. The credentials are
+// looked up once and shared by every API client and registry token of the run.
 type GCPAuth struct {
 	credentialsPath string
+
+	mu    sync.Mutex
+	creds *google.Credentials
 }
 
 // NewGCPAuth creates a new GCP authentication handler
@@ -21,15 +30,29 @@ func NewGCPAuth(credentialsPath string) *GCPAuth {
 	}
 }
 
-// GetCredentials returns GCP credentials for API access
+// GetCredentials returns GCP credentials for API access, looking them up on
+// first use. A failed lookup is not remembered, so a later call tries again.
 func (g *GCPAuth) GetCredentials(ctx context.Context) (*google.Credentials, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.creds != nil {
+		return g.creds, nil
+	}
+
 	var creds *google.Credentials
 	var err error
 
+	// The token exchanges of the credentials go through the counted transport
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: httputil.Transport("oauth2", nil)})
+
 	if g.credentialsPath != "" {
 		// Use service account file
-		creds, err = google.CredentialsFromJSON(ctx, g.readCredentialsFile(),
-			"https://www.googleapis.com/auth/cloud-platform")
+		var data []byte
+		data, err = os.ReadFile(g.credentialsPath)
+		if err == nil {
+			creds, err = google.CredentialsFromJSON(ctx, data,
+				"https://www.googleapis.com/auth/cloud-platform")
+		}
 	} else {
 		// Use default credentials (metadata server, gcloud, etc.)
 		creds, err = google.FindDefaultCredentials(ctx,
@@ -40,16 +63,13 @@ func (g *GCPAuth) GetCredentials(ctx context.Context) (*google.Credentials, erro
 		return nil, fmt.Errorf("failed to get GCP credentials: %w", err)
 	}
 
+	g.creds = creds
 	return creds, nil
 }
 
-// GetClientOption returns a client option for GCP services
+// GetClientOption returns a client option for GCP services carrying the shared
+// credentials, so creating a service does not look them up again
 func (g *GCPAuth) GetClientOption(ctx context.Context) (option.ClientOption, error) {
-	if g.credentialsPath != "" {
-		return option.WithCredentialsFile(g.credentialsPath), nil
-	}
-
-	// Use default credentials
 	creds, err := g.GetCredentials(ctx)
 	if err != nil {
 		return nil, err
@@ -58,14 +78,6 @@ func (g *GCPAuth) GetClientOption(ctx context.Context) (option.ClientOption, err
 	return option.WithCredentials(creds), nil
 }
 
-func (g *GCPAuth) readCredentialsFile() []byte {
-	data, err := os.ReadFile(g.credentialsPath)
-	if err != nil {
-		return nil
-	}
-	return data
-}
-
 // ValidateCredentials checks if the credentials are valid
 func (g *GCPAuth) ValidateCredentials(ctx context.Context) error {
 	_, err := g.GetCredentials(ctx)
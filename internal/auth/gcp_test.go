@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeCredentials writes user credentials that parse without network access
+func writeCredentials(t testing.TB) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	data := `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestCredentialsLookedUpOnce looks the credentials up from many goroutines,
+// then removes their file: every later lookup still gets the same credentials
+// without reading it again
+func TestCredentialsLookedUpOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		auth func(t *testing.T, path string) *GCPAuth
+	}{
+		{name: "credentials file", auth: func(t *testing.T, path string) *GCPAuth {
+			return NewGCPAuth(path)
+		}},
+		{name: "default credentials", auth: func(t *testing.T, path string) *GCPAuth {
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+			return NewGCPAuth("")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeCredentials(t)
+			g := tt.auth(t, path)
+			ctx := context.Background()
+
+			first, err := g.GetCredentials(ctx)
+			if err != nil {
+				t.Fatalf("GetCredentials() error = %v", err)
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if creds, err := g.GetCredentials(ctx); err != nil || creds != first {
+						t.Errorf("GetCredentials() = %p, %v, want the first credentials %p", creds, err, first)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			if creds, err := g.GetCredentials(ctx); err != nil || creds != first {
+				t.Errorf("GetCredentials() after removing the file = %p, %v, want the first credentials %p", creds, err, first)
+			}
+			if _, err := g.GetClientOption(ctx); err != nil {
+				t.Errorf("GetClientOption() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestFailedLookupIsRetried(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	g := NewGCPAuth(path)
+	if _, err := g.GetCredentials(context.Background()); err == nil {
+		t.Fatal("GetCredentials() succeeded without a credentials file")
+	}
+
+	data, err := os.ReadFile(writeCredentials(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetCredentials(context.Background()); err != nil {
+		t.Errorf("GetCredentials() once the file exists error = %v", err)
+	}
+}
+
+// BenchmarkSharedCredentials measures the lookups of many builds sharing one
+// GCPAuth, against BenchmarkCredentialLookup where each looks them up anew
+func BenchmarkSharedCredentials(b *testing.B) {
+	g := NewGCPAuth(writeCredentials(b))
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.GetClientOption(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCredentialLookup(b *testing.B) {
+	path := writeCredentials(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewGCPAuth(path).GetClientOption(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -34,7 +34,7 @@ func (r *RegistryAuth) GetAuthConfig(ctx context.Context, registry string) (*Aut
 
 func (r *RegistryAuth) getServiceAccountAuth(ctx context.Context, registry string) (*AuthConfig, error) {
 	// Only apply service account auth for GCP registries
-	if !isGCPRegistry(registry) {
+	if !IsGCPRegistry(registry) {
 		return &AuthConfig{Type: "none"}, nil
 	}
 
@@ -57,7 +57,9 @@ func (r *RegistryAuth) getServiceAccountAuth(ctx context.Context, registry strin
 	}, nil
 }
 
-func isGCPRegistry(registry string) bool {
+// IsGCPRegistry reports whether registry is a Container Registry or Artifact
+// Registry host, which ServiceAccountToken pulls authenticate to
+func IsGCPRegistry(registry string) bool {
 	gcpRegistries := []string{
 		"gcr.io",
 		"us.gcr.io",
@@ -0,0 +1,250 @@
+// Package deadline provides build deadlines that can be pushed back while the
+// build runs. Go contexts cannot change their deadline, so a Controller hands
+// out contexts of its own whose deadline it moves on Extend; contexts derived
+// from them with a later or no deadline of their own follow along.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Controller extends the deadlines it created, by at most maxExtension in total
+type Controller struct {
+	mu           sync.Mutex
+	maxExtension time.Duration
+	extended     time.Duration
+	active       []*extendable // oldest first: the build's deadline, then step deadlines
+	listeners    []*listener
+}
+
+// listener is a function registered with OnExtend
+type listener struct {
+	notify func(deadline time.Time)
+}
+
+// NewController returns a controller allowing maxExtension of extensions in total
+func NewController(maxExtension time.Duration) *Controller {
+	return &Controller{maxExtension: maxExtension}
+}
+
+type controllerKey struct{}
+
+// NewContext returns a context carrying the controller
+func NewContext(ctx context.Context, c *Controller) context.Context {
+	return context.WithValue(ctx, controllerKey{}, c)
+}
+
+// FromContext returns the controller carried by ctx, or nil
+func FromContext(ctx context.Context) *Controller {
+	c, _ := ctx.Value(controllerKey{}).(*Controller)
+	return c
+}
+
+// WithTimeout is context.WithTimeout, except that the deadline is extendable when
+// ctx carries a controller
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if c := FromContext(ctx); c != nil {
+		return c.WithTimeout(ctx, timeout)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// WithTimeout returns a context whose deadline, timeout from now, is moved by Extend
+// until the context is done or cancel is called
+func (c *Controller) WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	e := &extendable{
+		parent:   parent,
+		deadline: time.Now().Add(timeout),
+		done:     make(chan struct{}),
+	}
+	e.mu.Lock() // expire must not see the timer unset
+	e.timer = time.AfterFunc(timeout, e.expire)
+	e.mu.Unlock()
+	stop := context.AfterFunc(parent, func() { e.cancel(parent.Err()) })
+
+	c.mu.Lock()
+	c.active = append(c.active, e)
+	c.mu.Unlock()
+
+	return e, func() {
+		stop()
+		e.cancel(context.Canceled)
+		c.remove(e)
+	}
+}
+
+// Extend pushes every active deadline back by the duration and returns the new
+// deadline of the oldest one, the build's. It fails if that would exceed the
+// maximum extension or no deadline is active.
+func (c *Controller) Extend(by time.Duration) (time.Time, error) {
+	if by <= 0 {
+		return time.Time{}, fmt.Errorf("extension must be positive, got %s", by)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.extended+by > c.maxExtension {
+		return time.Time{}, fmt.Errorf("extending by %s would exceed the maximum extension of %s (%s left)",
+			by, c.maxExtension, c.maxExtension-c.extended)
+	}
+
+	var deadline time.Time
+	for _, e := range c.active {
+		if d, ok := e.extend(by); ok && deadline.IsZero() {
+			deadline = d
+		}
+	}
+	if deadline.IsZero() {
+		return time.Time{}, fmt.Errorf("no deadline left to extend: the build is finishing")
+	}
+	c.extended += by
+	for _, l := range c.listeners {
+		go l.notify(deadline)
+	}
+	return deadline, nil
+}
+
+// Hold pushes every active deadline back by d, without counting it as an
+// extension, for a phase with a budget of its own. The returned function ends
+// the phase and takes back the part of d not used, so that the time in between
+// does not count against the deadlines held.
+func (c *Controller) Hold(d time.Duration) (release func()) {
+	start := time.Now()
+	c.shift(d)
+	return func() {
+		if unused := d - time.Since(start); unused > 0 {
+			c.shift(-unused)
+		}
+	}
+}
+
+// shift moves every active deadline by the duration
+func (c *Controller) shift(by time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.active {
+		e.extend(by)
+	}
+}
+
+// OnExtend calls notify with the build's new deadline after each extension,
+// on a goroutine of its own, until the returned function is called
+func (c *Controller) OnExtend(notify func(deadline time.Time)) (stop func()) {
+	l := &listener{notify: notify}
+	c.mu.Lock()
+	c.listeners = append(c.listeners, l)
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.listeners = slices.DeleteFunc(c.listeners, func(other *listener) bool { return other == l })
+	}
+}
+
+// Deadline returns the deadline of the oldest active context, the build's
+func (c *Controller) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.active) == 0 {
+		return time.Time{}, false
+	}
+	return c.active[0].Deadline()
+}
+
+// Extended returns the total extension granted so far
+func (c *Controller) Extended() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.extended
+}
+
+// Remaining returns how much more extension can be granted
+func (c *Controller) Remaining() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxExtension - c.extended
+}
+
+func (c *Controller) remove(e *extendable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, active := range c.active {
+		if active == e {
+			c.active = append(c.active[:i], c.active[i+1:]...)
+			return
+		}
+	}
+}
+
+// extendable is a context whose deadline can move
+type extendable struct {
+	parent context.Context
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	done     chan struct{}
+	err      error
+}
+
+func (e *extendable) Deadline() (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deadline, true
+}
+
+func (e *extendable) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *extendable) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+func (e *extendable) Value(key any) any {
+	return e.parent.Value(key)
+}
+
+// expire ends the context at its deadline, unless the deadline moved meanwhile
+func (e *extendable) expire() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !time.Now().Before(e.deadline) {
+		e.cancelLocked(context.DeadlineExceeded)
+	}
+}
+
+func (e *extendable) cancel(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancelLocked(err)
+}
+
+func (e *extendable) cancelLocked(err error) {
+	if e.err != nil {
+		return
+	}
+	e.err = err
+	e.timer.Stop()
+	close(e.done)
+}
+
+// extend moves the deadline back, or forward for a negative duration, unless
+// the context is already done
+func (e *extendable) extend(by time.Duration) (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return time.Time{}, false
+	}
+	e.timer.Stop()
+	e.deadline = e.deadline.Add(by)
+	e.timer = time.AfterFunc(time.Until(e.deadline), e.expire)
+	return e.deadline, true
+}
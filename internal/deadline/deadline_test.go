@@ -0,0 +1,130 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExtendMovesDeadlines(t *testing.T) {
+	c := NewController(time.Hour)
+	ctx, cancel := c.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	step, cancelStep := WithTimeout(NewContext(ctx, c), 30*time.Second)
+	defer cancelStep()
+
+	before, _ := ctx.Deadline()
+	stepBefore, _ := step.Deadline()
+	got, err := c.Extend(10 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := ctx.Deadline()
+	if !after.Equal(before.Add(10*time.Minute)) || !got.Equal(after) {
+		t.Errorf("build deadline = %s (returned %s), want %s", after, got, before.Add(10*time.Minute))
+	}
+	if stepAfter, _ := step.Deadline(); !stepAfter.Equal(stepBefore.Add(10 * time.Minute)) {
+		t.Errorf("step deadline = %s, want %s", stepAfter, stepBefore.Add(10*time.Minute))
+	}
+	if c.Extended() != 10*time.Minute || c.Remaining() != 50*time.Minute {
+		t.Errorf("Extended() = %s, Remaining() = %s", c.Extended(), c.Remaining())
+	}
+}
+
+func TestExtendBeyondMaximum(t *testing.T) {
+	c := NewController(time.Hour)
+	_, cancel := c.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if _, err := c.Extend(45 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Extend(30 * time.Minute); err == nil {
+		t.Error("extension past the maximum granted")
+	}
+	if _, err := c.Extend(-time.Minute); err == nil {
+		t.Error("negative extension granted")
+	}
+}
+
+func TestExtendWithoutDeadline(t *testing.T) {
+	c := NewController(time.Hour)
+	_, cancel := c.WithTimeout(context.Background(), time.Minute)
+	cancel()
+	if _, err := c.Extend(time.Minute); err == nil {
+		t.Error("extension granted with no active deadline")
+	}
+	if c.Extended() != 0 {
+		t.Errorf("Extended() = %s after a refused extension", c.Extended())
+	}
+}
+
+func TestOnExtend(t *testing.T) {
+	c := NewController(time.Hour)
+	_, cancel := c.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	notified := make(chan time.Time, 1)
+	stop := c.OnExtend(func(deadline time.Time) { notified <- deadline })
+	want, err := c.Extend(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-notified:
+		if !got.Equal(want) {
+			t.Errorf("notified of %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not notified of the extension")
+	}
+
+	stop()
+	if _, err := c.Extend(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-notified:
+		t.Error("notified after stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := NewController(time.Hour)
+	ctx, cancel := c.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			t.Errorf("Err() = %v, want %v", ctx.Err(), context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadline did not expire")
+	}
+}
+
+func TestHoldReturnsUnusedTime(t *testing.T) {
+	c := NewController(time.Hour)
+	ctx, cancel := c.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	before, _ := ctx.Deadline()
+
+	release := c.Hold(time.Hour)
+	if held, _ := ctx.Deadline(); !held.Equal(before.Add(time.Hour)) {
+		t.Errorf("deadline while held = %s, want %s", held, before.Add(time.Hour))
+	}
+	time.Sleep(20 * time.Millisecond)
+	release()
+
+	after, _ := ctx.Deadline()
+	if elapsed := after.Sub(before); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("deadline moved by %s after the hold, want the time held", elapsed)
+	}
+	if c.Extended() != 0 {
+		t.Errorf("Extended() = %s after a hold", c.Extended())
+	}
+	if ctx.Err() != nil {
+		t.Errorf("context ended by the hold: %v", ctx.Err())
+	}
+}
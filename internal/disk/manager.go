@@ -2,11 +2,42 @@ package disk
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
 
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/deadline"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
 )
 
+const (
+	// deleteDiskAttempts bounds the retries of deleting a disk that is still attached
+	deleteDiskAttempts = 5
+
+	// imageVisibleTimeout bounds the wait for a new image to be returned by
+	// images.get once its insert operation completed
+	imageVisibleTimeout = 2 * time.Minute
+
+	// imageProgressInterval is how often the progress of creating an image is
+	// logged; past imageReassuranceAfter, the log also says how long images of
+	// the disk's size typically take
+	imageProgressInterval = 45 * time.Second
+	imageReassuranceAfter = 10 * time.Minute
+)
+
+// deleteDiskRetryDelay is the wait before the first retry of deleting a disk
+// that is still attached, growing with each attempt; a variable for tests
+var deleteDiskRetryDelay = 5 * time.Second
+
 // Manager handles disk operations
 type Manager struct {
 	gcpClient *gcp.Client
@@ -25,59 +56,593 @@ func NewManager(gcpClient *gcp.Client, logger *log.Logger) *Manager {
 func (m *Manager) CreateDisk(ctx context.Context, config *Config) (*Disk, error) {
 	m.logger.Infof("Creating disk: %s", config.Name)
 
-	// Implementation would create actual GCP disk
-	disk := &Disk{
-		Name: config.Name,
-		Zone: config.Zone,
+	project := m.gcpClient.ProjectName()
+	disk := &compute.Disk{
+		Name:         config.Name,
+		SizeGb:       int64(config.SizeGB),
+		Type:         fmt.Sprintf("zones/%s/diskTypes/%s", config.Zone, config.Type),
+		Labels:       maps.Clone(config.Labels), // read while encoding the request; the caller keeps its map
+		Architecture: config.Architecture,
+		SourceImage:  config.SourceImage,
+	}
+
+	op, err := m.gcpClient.Compute().Disks.Insert(project, config.Zone, disk).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForZoneOperation(ctx, config.Zone, op)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk %s: %w", config.Name, m.gcpClient.ExplainPolicyError(ctx, m.logger, err, "creating disk "+config.Name))
+	}
+
+	created, err := m.GetDisk(ctx, config.Name, config.Zone)
+	if err != nil {
+		// The disk exists: hand it to the caller, who cleans it up, instead of
+		// leaking it. The insert operation completing means it is ready.
+		m.logger.Debugf("Using the requested state of disk %s: %v", config.Name, err)
+		return &Disk{
+			Name:         config.Name,
+			Zone:         config.Zone,
+			SizeGB:       int64(config.SizeGB),
+			Type:         config.Type,
+			Status:       StatusReady,
+			Architecture: config.Architecture,
+			Labels:       maps.Clone(config.Labels),
+			selfLink:     fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s/disks/%s", project, config.Zone, config.Name),
+		}, nil
+	}
+	return created, nil
+}
+
+// GetDisk fetches the current state of a persistent disk
+func (m *Manager) GetDisk(ctx context.Context, name, zone string) (*Disk, error) {
+	disk, err := m.gcpClient.Compute().Disks.Get(m.gcpClient.ProjectName(), zone, name).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk %s: %w", name, err)
+	}
+	return newDisk(disk), nil
+}
+
+// ImageExists reports whether an image of the project exists
+func (m *Manager) ImageExists(ctx context.Context, name string) (bool, error) {
+	_, err := m.gcpClient.Compute().Images.Get(m.gcpClient.ProjectName(), name).Fields("name").Context(ctx).Do()
+	if gcp.IsNotFound(err) {
+		return false, nil
 	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get image %s: %w", name, err)
+	}
+	return true, nil
+}
 
-	return disk, nil
+// SetLabels replaces the labels of a disk
+func (m *Manager) SetLabels(ctx context.Context, name, zone string, labels map[string]string) error {
+	project := m.gcpClient.ProjectName()
+	disk, err := m.gcpClient.Compute().Disks.Get(project, zone, name).Fields("labelFingerprint").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get disk %s: %w", name, err)
+	}
+
+	op, err := m.gcpClient.Compute().Disks.SetLabels(project, zone, name, &compute.ZoneSetLabelsRequest{
+		Labels:           maps.Clone(labels),
+		LabelFingerprint: disk.LabelFingerprint,
+	}).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForZoneOperation(ctx, zone, op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to label disk %s: %w", name, err)
+	}
+	return nil
 }
 
-// DeleteDisk deletes a persistent disk
+// DeleteDisk deletes a persistent disk. A disk that is still attached, e.g. because
+// the deletion of its VM or a detach has not fully propagated, is detached from
+// its remaining users and the deletion retried a bounded number of times.
 func (m *Manager) DeleteDisk(ctx context.Context, name, zone string) error {
 	m.logger.Infof("Deleting disk: %s", name)
 
-	// Implementation would delete actual GCP disk
+	// A disk still attached cannot be deleted: detach it from the instances it
+	// lists as users first, e.g. when the build's own detach did not happen
+	if err := m.detachFromUsers(ctx, name, zone); err != nil {
+		m.logger.Debugf("Cannot detach disk %s before deleting it: %v", name, err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.deleteDisk(ctx, name, zone)
+		if err == nil {
+			return nil
+		}
+		if !isResourceInUse(err) || attempt == deleteDiskAttempts {
+			return fmt.Errorf("failed to delete disk %s: %w", name, err)
+		}
+		if err := retry.FromContext(ctx).Retry("deletion of disk "+name, err); err != nil {
+			return fmt.Errorf("failed to delete disk %s: %w", name, err)
+		}
+
+		// A detach may still be in progress, or the disk was attached again since
+		m.logger.Warnf("Disk %s is still attached, detaching before retrying (attempt %d/%d)", name, attempt, deleteDiskAttempts)
+		if err := m.detachFromUsers(ctx, name, zone); err != nil {
+			m.logger.Warnf("Failed to detach disk %s: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to delete disk %s: %w", name, ctx.Err())
+		case <-time.After(time.Duration(attempt) * deleteDiskRetryDelay):
+		}
+	}
+}
+
+// FindUnusedDisks returns the ready disks in every zone of the project that
+// carry label key, with any value, and are attached to no instance
+func (m *Manager) FindUnusedDisks(ctx context.Context, key string) ([]*Disk, error) {
+	var found []*Disk
+	err := m.gcpClient.Compute().Disks.AggregatedList(m.gcpClient.ProjectName()).Filter(fmt.Sprintf("labels.%s:*", key)).
+		Pages(ctx, func(list *compute.DiskAggregatedList) error {
+			for _, scoped := range list.Items {
+				for _, disk := range scoped.Disks {
+					if disk.Status == StatusReady && len(disk.Users) == 0 {
+						found = append(found, newDisk(disk))
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks labeled %s: %w", key, err)
+	}
+	return found, nil
+}
+
+// DeleteDiskIfExists deletes a disk unless it is already gone, and reports
+// whether it still existed
+func (m *Manager) DeleteDiskIfExists(ctx context.Context, name, zone string) (bool, error) {
+	if _, err := m.gcpClient.Compute().Disks.Get(m.gcpClient.ProjectName(), zone, name).Context(ctx).Do(); err != nil {
+		if gcp.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get disk %s: %w", name, err)
+	}
+	return true, m.DeleteDisk(ctx, name, zone)
+}
+
+func (m *Manager) deleteDisk(ctx context.Context, name, zone string) error {
+	op, err := m.gcpClient.Compute().Disks.Delete(m.gcpClient.ProjectName(), zone, name).Context(ctx).Do()
+	if err != nil {
+		if gcp.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return m.gcpClient.WaitForZoneOperation(ctx, zone, op)
+}
+
+// detachFromUsers detaches a disk from every instance it is still attached
+// to, under the device name each instance has it as, and waits for the
+// detaches to complete. A disk that no longer exists has nothing to detach.
+func (m *Manager) detachFromUsers(ctx context.Context, name, zone string) error {
+	project := m.gcpClient.ProjectName()
+	disk, err := m.gcpClient.Compute().Disks.Get(project, zone, name).Fields("users").Context(ctx).Do()
+	if err != nil {
+		if gcp.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get disk %s: %w", name, err)
+	}
+
+	for _, user := range disk.Users {
+		instanceName := gcp.ResourceName(user)
+		instance, err := m.gcpClient.Compute().Instances.Get(project, zone, instanceName).Context(ctx).Do()
+		if err != nil {
+			if gcp.IsNotFound(err) {
+				// Deleted since the disk was read
+				continue
+			}
+			return fmt.Errorf("failed to get instance %s: %w", instanceName, err)
+		}
+
+		for _, attached := range instance.Disks {
+			if gcp.ResourceName(attached.Source) != name {
+				continue
+			}
+			m.logger.Infof("Detaching disk %s from instance %s", name, instanceName)
+			op, err := m.gcpClient.Compute().Instances.DetachDisk(project, zone, instanceName, attached.DeviceName).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("failed to detach disk %s from %s: %w", name, instanceName, err)
+			}
+			if err := m.gcpClient.WaitForZoneOperation(ctx, zone, op); err != nil {
+				return fmt.Errorf("failed to detach disk %s from %s: %w", name, instanceName, err)
+			}
+		}
+	}
 	return nil
 }
 
 // CreateImage creates a disk image
 func (m *Manager) CreateImage(ctx context.Context, config *ImageConfig) error {
-	m.logger.Infof("Creating image: %s", config.Name)
+	if config.ForceCreate {
+		m.logger.Infof("Creating image: %s (from the attached disk)", config.Name)
+	} else {
+		m.logger.Infof("Creating image: %s", config.Name)
+	}
+
+	project := m.gcpClient.ProjectName()
+	image := &compute.Image{
+		Name:         config.Name,
+		SourceDisk:   fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, config.Zone, config.SourceDisk),
+		Family:       config.Family,
+		Labels:       maps.Clone(config.Labels),
+		Licenses:     config.Licenses,
+		Description:  config.Description,
+		Architecture: config.Architecture,
+	}
+	for _, feature := range config.GuestOSFeatures {
+		image.GuestOsFeatures = append(image.GuestOsFeatures, &compute.GuestOsFeature{Type: feature})
+	}
+
+	op, err := m.gcpClient.Compute().Images.Insert(project, image).ForceCreate(config.ForceCreate).Context(ctx).Do()
+	if err == nil {
+		m.logger.Debugf("Image creation operation: %s", op.SelfLink)
+		err = m.gcpClient.WaitForGlobalOperationWithProgress(ctx, op, imageProgressInterval, m.imageProgress(ctx, config, op))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create image %s: %w", config.Name, m.gcpClient.ExplainPolicyError(ctx, m.logger, err, "creating image "+config.Name))
+	}
+	return nil
+}
+
+// AddImageLabels sets labels on an image of the client's project, keeping its others
+func (m *Manager) AddImageLabels(ctx context.Context, name string, labels map[string]string) error {
+	project := m.gcpClient.ProjectName()
+	image, err := m.gcpClient.Compute().Images.Get(project, name).Fields("labels", "labelFingerprint").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get image %s: %w", name, err)
+	}
+
+	merged := maps.Clone(image.Labels)
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+	maps.Copy(merged, labels)
+	if err := gcp.CheckLabelCount("image "+name, merged); err != nil {
+		return err
+	}
+	op, err := m.gcpClient.Compute().Images.SetLabels(project, name, &compute.GlobalSetLabelsRequest{
+		Labels:           merged,
+		LabelFingerprint: image.LabelFingerprint,
+	}).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForGlobalOperation(ctx, op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to label image %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteImage deletes an image of the client's project unless it is already gone
+func (m *Manager) DeleteImage(ctx context.Context, name string) error {
+	m.logger.Infof("Deleting image: %s", name)
+	op, err := m.gcpClient.Compute().Images.Delete(m.gcpClient.ProjectName(), name).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForGlobalOperation(ctx, op)
+	}
+	if err != nil && !gcp.IsNotFound(err) {
+		return fmt.Errorf("failed to delete image %s: %w", name, err)
+	}
+	return nil
+}
 
-	// Implementation would create actual GCP image
+// imageProgress logs the progress of creating an image and the time left before
+// the build's deadline. Once creating it takes longer than imageReassuranceAfter,
+// it also says how long it typically takes and how to inspect the operation.
+func (m *Manager) imageProgress(ctx context.Context, config *ImageConfig, op *compute.Operation) gcp.OperationProgress {
+	reassured := false
+	return func(current *compute.Operation, elapsed time.Duration) {
+		status := "still running"
+		if current.Progress > 0 {
+			status = fmt.Sprintf("%d%% done", current.Progress)
+		}
+		expires, hasDeadline := ctx.Deadline()
+		left := ""
+		if hasDeadline {
+			left = fmt.Sprintf(", %s left before the build timeout", time.Until(expires).Round(time.Second))
+		}
+		m.logger.Infof("Creating image %s: %s after %s%s", config.Name, status, elapsed.Round(time.Second), left)
+
+		if reassured || elapsed < imageReassuranceAfter {
+			return
+		}
+		reassured = true
+		low, high := typicalImageMinutes(config.SourceDiskSizeGB)
+		m.logger.Infof("Creating an image from a %d GB disk typically takes %d-%d minutes. The operation is still running: gcloud compute operations describe %s --global --project=%s",
+			config.SourceDiskSizeGB, low, high, op.Name, m.gcpClient.ProjectName())
+		if hasDeadline && time.Until(expires) < time.Duration(high)*time.Minute-elapsed {
+			if deadline.FromContext(ctx) != nil {
+				m.logger.Infof("The build timeout may expire first; 'control extend' can push it back")
+			} else {
+				m.logger.Infof("The build timeout may expire first")
+			}
+		}
+	}
+}
+
+// typicalImageMinutes is a rough range of how long creating an image from a
+// disk of sizeGB takes: a couple of minutes, plus about a minute per 15 GB
+func typicalImageMinutes(sizeGB int64) (low, high int64) {
+	low = 2 + sizeGB/15
+	return low, 2 * low
+}
+
+// VerifyImage checks that an image is READY and was created by this build: from
+// the source disk, in the family and with the labels and architecture requested
+func (m *Manager) VerifyImage(ctx context.Context, expected *ImageConfig, source *Disk) error {
+	m.logger.Infof("Verifying image: %s", expected.Name)
+
+	image, err := m.gcpClient.WaitForImageVisible(ctx, expected.Name, imageVisibleTimeout)
+	if err != nil {
+		return err
+	}
+	if image.Status != StatusReady {
+		return fmt.Errorf("image %s is in status %s, expected READY", expected.Name, image.Status)
+	}
+
+	var mismatches []string
+	check := func(field string, want, got interface{}) {
+		if want != got {
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected %v, got %v", field, want, got))
+		}
+	}
+
+	// .../projects/<project>/zones/<zone>/disks/<name>
+	check("source disk", source.Zone+"/"+source.Name, sourceDiskZoneAndName(image.SourceDisk))
+	check("family", expected.Family, image.Family)
+	if expected.Architecture != "" {
+		check("architecture", expected.Architecture, image.Architecture)
+	}
+	for key, want := range expected.Labels {
+		got, ok := image.Labels[key]
+		if !ok {
+			got = "<missing>"
+		}
+		check("label "+key, want, got)
+	}
+	for _, license := range expected.Licenses {
+		if !hasLicense(image.Licenses, license) {
+			mismatches = append(mismatches, fmt.Sprintf("license %s: missing", license))
+		}
+	}
+	check("disk size (GB)", source.SizeGB, image.DiskSizeGb)
+
+	// The archive is the compressed disk content: empty means nothing was
+	// captured, larger than the disk is implausible
+	if image.ArchiveSizeBytes <= 0 || image.ArchiveSizeBytes > source.SizeGB<<30 {
+		mismatches = append(mismatches, fmt.Sprintf("archive size: expected between 1 byte and %d GB, got %d bytes",
+			source.SizeGB, image.ArchiveSizeBytes))
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("image %s does not match this build:\n  %s", expected.Name, strings.Join(mismatches, "\n  "))
+	}
+
+	m.logger.Debugf("Image %s: source %s, archive %d bytes", expected.Name, image.SourceDisk, image.ArchiveSizeBytes)
 	return nil
 }
 
-// VerifyImage verifies a disk image
-func (m *Manager) VerifyImage(ctx context.Context, imageName string) error {
-	m.logger.Infof("Verifying image: %s", imageName)
+// hasLicense reports whether the license URLs of an image include license, a
+// projects/<project>/global/licenses/<name> path
+func hasLicense(urls []string, license string) bool {
+	for _, url := range urls {
+		if strings.HasSuffix(url, "/"+license) || url == license {
+			return true
+		}
+	}
+	return false
+}
 
-	// Implementation would verify actual GCP image
+// ValidateLicenses checks that each license exists and is readable with the
+// build's credentials, so that a typo fails the build before any VM is created
+func (m *Manager) ValidateLicenses(ctx context.Context, licenses []string) error {
+	for _, license := range licenses {
+		path, err := gcp.ParseLicensePath(license)
+		if err != nil {
+			return fmt.Errorf("invalid image license: %w", err)
+		}
+		if _, err := m.gcpClient.Compute().Licenses.Get(path.Project, path.Name).Context(ctx).Do(); err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+				return fmt.Errorf("no read access to image license %s: the credentials used by this tool need compute.licenses.get on project %s",
+					path, path.Project)
+			}
+			if gcp.IsNotFound(err) {
+				return fmt.Errorf("image license %s not found", path)
+			}
+			return fmt.Errorf("failed to get image license %s: %w", path, err)
+		}
+	}
 	return nil
 }
 
+// sourceDiskZoneAndName returns "<zone>/<name>" of a disk URL
+func sourceDiskZoneAndName(url string) string {
+	parts := strings.Split(url, "/")
+	if len(parts) < 4 || parts[len(parts)-2] != "disks" || parts[len(parts)-4] != "zones" {
+		return url
+	}
+	return parts[len(parts)-3] + "/" + parts[len(parts)-1]
+}
+
+// FindImageByLabels returns the name of a READY image in the family carrying all the given
+// labels, or an empty string if there is none
+func (m *Manager) FindImageByLabels(ctx context.Context, family string, labels map[string]string) (string, error) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	filter := fmt.Sprintf(`(family = "%s")`, family)
+	for _, key := range keys {
+		filter += fmt.Sprintf(` AND (labels.%s = "%s")`, key, labels[key])
+	}
+
+	var found string
+	err := m.gcpClient.Compute().Images.List(m.gcpClient.ProjectName()).Filter(filter).Pages(ctx, func(list *compute.ImageList) error {
+		for _, image := range list.Items {
+			if image.Status == "READY" && found == "" {
+				found = image.Name
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list images in family %s: %w", family, err)
+	}
+
+	return found, nil
+}
+
+// FamilyImage is an image of a family, as listed by ListFamily
+type FamilyImage struct {
+	Name         string
+	Architecture string // X86_64 or ARM64; empty for images created without one
+	Labels       map[string]string
+}
+
+// ListFamily returns the images of a family in the project that the family can
+// resolve to: deprecated, obsolete and deleted images are skipped
+func (m *Manager) ListFamily(ctx context.Context, family string) ([]FamilyImage, error) {
+	var images []FamilyImage
+	err := m.gcpClient.Compute().Images.List(m.gcpClient.ProjectName()).Filter(fmt.Sprintf(`family = "%s"`, family)).
+		Fields("items(name,architecture,labels,deprecated/state)", "nextPageToken").Pages(ctx, func(list *compute.ImageList) error {
+		for _, image := range list.Items {
+			if image.Deprecated != nil && image.Deprecated.State != "" && image.Deprecated.State != "ACTIVE" {
+				continue
+			}
+			images = append(images, FamilyImage{Name: image.Name, Architecture: image.Architecture, Labels: image.Labels})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images in family %s: %w", family, err)
+	}
+	return images, nil
+}
+
+// GuestOSFeatures returns the guest OS features a cache image needs for the given node OS
+func GuestOSFeatures(osType string) []string {
+	if osType == "windows" {
+		return []string{"WINDOWS", "MULTI_IP_SUBNET", "UEFI_COMPATIBLE", "VIRTIO_SCSI_MULTIQUEUE", "GVNIC"}
+	}
+	return nil
+}
+
+// Architecture returns the GCP disk and image architecture for a node architecture
+func Architecture(arch string) string {
+	if arch == "arm64" {
+		return "ARM64"
+	}
+	return "X86_64"
+}
+
+// isResourceInUse reports whether a disk operation failed because the disk is attached
+func isResourceInUse(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		for _, item := range apiErr.Errors {
+			if item.Reason == "resourceInUseByAnotherResource" {
+				return true
+			}
+		}
+	}
+	var opErr *gcp.OperationError
+	return errors.As(err, &opErr) && opErr.HasCode("RESOURCE_IN_USE_BY_ANOTHER_RESOURCE")
+}
+
 // Config holds disk configuration
 type Config struct {
 	Name   string
 	Zone   string
 	SizeGB int
 	Type   string
+	Labels map[string]string
+
+	// Architecture is X86_64 or ARM64; empty leaves it unset
+	Architecture string
+
+	// SourceImage creates the disk from an image (projects/<project>/global/images/<name>);
+	// a zero SizeGB then takes the image's size
+	SourceImage string
 }
 
 // ImageConfig holds image configuration
 type ImageConfig struct {
-	Name        string
-	SourceDisk  string
-	Zone        string
-	Family      string
-	Labels      map[string]string
-	Description string
+	Name            string
+	SourceDisk      string
+	Zone            string
+	Family          string
+	Labels          map[string]string
+	Licenses        []string
+	Description     string
+	GuestOSFeatures []string
+	Architecture    string
+
+	// SourceDiskSizeGB is the size of the source disk, for progress estimates
+	SourceDiskSizeGB int64
+
+	// ForceCreate creates the image even though the source disk is attached to an instance
+	ForceCreate bool
 }
 
-// Disk represents a persistent disk
+// Disk status values reported by the Compute API
+const (
+	StatusReady = "READY"
+)
+
+// Disk represents a persistent disk as returned by the Compute API
 type Disk struct {
-	Name string
-	Zone string
+	Name         string
+	Zone         string
+	SizeGB       int64
+	Type         string // e.g. pd-standard
+	Status       string // CREATING, RESTORING, READY, FAILED, DELETING
+	Architecture string
+	Labels       map[string]string
+	Users        []string // URLs of the instances the disk is attached to
+
+	selfLink string
+}
+
+func newDisk(disk *compute.Disk) *Disk {
+	return &Disk{
+		Name:         disk.Name,
+		Zone:         gcp.ResourceName(disk.Zone),
+		SizeGB:       disk.SizeGb,
+		Type:         gcp.ResourceName(disk.Type),
+		Status:       disk.Status,
+		Architecture: disk.Architecture,
+		Labels:       disk.Labels,
+		Users:        disk.Users,
+		selfLink:     disk.SelfLink,
+	}
+}
+
+// SelfLink returns the disk's resource URL
+func (d *Disk) SelfLink() string {
+	return d.selfLink
+}
+
+// Region returns the region of the disk's zone
+func (d *Disk) Region() string {
+	return gcp.RegionFromZone(d.Zone)
+}
+
+// IsReady reports whether the disk was ready when it was last fetched
+func (d *Disk) IsReady() bool {
+	return d.Status == StatusReady
+}
+
+// IsAttached reports whether the disk was attached to any instance when it was last fetched
+func (d *Disk) IsAttached() bool {
+	return len(d.Users) > 0
 }
@@ -0,0 +1,220 @@
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/gcptest"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// newTestManager returns a manager of project p whose requests go to a fake Compute Engine API
+func newTestManager(t *testing.T) (*Manager, *gcptest.Server) {
+	t.Helper()
+	server := gcptest.NewServer(t)
+	client, err := gcp.NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(client, log.NewLogger(false, true, log.NewTextImpl(io.Discard))), server
+}
+
+func TestListFamilySkipsDeprecatedImages(t *testing.T) {
+	m, server := newTestManager(t)
+	server.Handle(http.MethodGet, "projects/p/global/images", gcptest.Respond(map[string]any{
+		"items": []map[string]any{
+			{"name": "cache-3", "architecture": "X86_64"},
+			{"name": "cache-2", "architecture": "ARM64", "deprecated": map[string]string{"state": "DEPRECATED"}},
+			{"name": "cache-1", "architecture": "ARM64", "deprecated": map[string]string{"state": "OBSOLETE"}},
+			{"name": "cache-0", "architecture": "X86_64", "deprecated": map[string]string{"state": "ACTIVE"}},
+		},
+	}))
+
+	images, err := m.ListFamily(context.Background(), "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	if len(names) != 2 || names[0] != "cache-3" || names[1] != "cache-0" {
+		t.Errorf("ListFamily() = %v, want [cache-3 cache-0]", names)
+	}
+}
+
+// TestConcurrentLabelUse creates disks side by side from one label map, as
+// partitions do, and changes the labels of the disks returned while the map
+// is still being read
+func TestConcurrentLabelUse(t *testing.T) {
+	m, server := newTestManager(t)
+	server.Handle(http.MethodPost, "projects/p/zones/z/disks", gcptest.Operation("insert"))
+
+	labels := map[string]string{"team": "web", "env": "prod"}
+	const disks = 8
+	var wg sync.WaitGroup
+	for i := 0; i < disks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, err := m.CreateDisk(context.Background(), &Config{Name: fmt.Sprintf("cache-%d", i), Zone: "z", SizeGB: 10, Type: "pd-ssd", Labels: labels})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			d.Labels["cache-partition"] = strconv.Itoa(i)
+		}(i)
+	}
+	wg.Wait()
+
+	if len(labels) != 2 {
+		t.Errorf("labels = %v, changed through a created disk", labels)
+	}
+	for _, r := range server.Requests(http.MethodPost, "projects/p/zones/z/disks") {
+		var disk compute.Disk
+		if err := json.Unmarshal(r.Body, &disk); err != nil {
+			t.Fatal(err)
+		}
+		if disk.Labels["team"] != "web" || disk.Labels["env"] != "prod" {
+			t.Errorf("disk %s labels = %v", disk.Name, disk.Labels)
+		}
+	}
+}
+
+func TestCreateDiskPopulatesFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		get      http.HandlerFunc
+		want     Disk
+		selfLink string
+	}{
+		{
+			name: "fetched",
+			get: gcptest.Respond(map[string]any{
+				"name":         "cache",
+				"zone":         "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a",
+				"sizeGb":       "20",
+				"type":         "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/diskTypes/pd-ssd",
+				"status":       "READY",
+				"architecture": "ARM64",
+				"labels":       map[string]string{"team": "web"},
+				"selfLink":     "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/disks/cache",
+			}),
+			want:     Disk{Name: "cache", Zone: "us-central1-a", SizeGB: 20, Type: "pd-ssd", Status: StatusReady, Architecture: "ARM64"},
+			selfLink: "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/disks/cache",
+		},
+		{
+			name:     "requested state when the disk cannot be fetched",
+			get:      gcptest.Fail(http.StatusServiceUnavailable, "backend error"),
+			want:     Disk{Name: "cache", Zone: "us-central1-a", SizeGB: 20, Type: "pd-ssd", Status: StatusReady, Architecture: "ARM64"},
+			selfLink: "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/disks/cache",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, server := newTestManager(t)
+			server.Handle(http.MethodPost, "projects/p/zones/us-central1-a/disks", gcptest.Operation("insert"))
+			server.Handle(http.MethodGet, "projects/p/zones/us-central1-a/disks/cache", tt.get)
+
+			d, err := m.CreateDisk(context.Background(), &Config{
+				Name: "cache", Zone: "us-central1-a", SizeGB: 20, Type: "pd-ssd", Architecture: "ARM64",
+				Labels: map[string]string{"team": "web"},
+			})
+			if err != nil {
+				t.Fatalf("CreateDisk() error = %v", err)
+			}
+			if d.Name != tt.want.Name || d.Zone != tt.want.Zone || d.SizeGB != tt.want.SizeGB || d.Type != tt.want.Type ||
+				d.Status != tt.want.Status || d.Architecture != tt.want.Architecture {
+				t.Errorf("CreateDisk() = %+v, want %+v", *d, tt.want)
+			}
+			if d.Labels["team"] != "web" {
+				t.Errorf("Labels = %v, want team=web", d.Labels)
+			}
+			if d.SelfLink() != tt.selfLink {
+				t.Errorf("SelfLink() = %q, want %q", d.SelfLink(), tt.selfLink)
+			}
+			if d.Region() != "us-central1" {
+				t.Errorf("Region() = %q, want us-central1", d.Region())
+			}
+			if !d.IsReady() || d.IsAttached() {
+				t.Errorf("IsReady() = %v, IsAttached() = %v, want ready and detached", d.IsReady(), d.IsAttached())
+			}
+		})
+	}
+}
+
+// TestDeleteAttachedDisk deletes a disk whose detach failed: the deletion is
+// refused while the disk is attached, and succeeds once it is detached before
+// the retry
+func TestDeleteAttachedDisk(t *testing.T) {
+	deleteDiskRetryDelay = time.Millisecond
+	t.Cleanup(func() { deleteDiskRetryDelay = 5 * time.Second })
+
+	m, server := newTestManager(t)
+	var mu sync.Mutex
+	attached, detaches := true, 0
+
+	server.Handle(http.MethodGet, "projects/p/zones/z/disks/cache", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		disk := map[string]any{"name": "cache"}
+		if attached {
+			disk["users"] = []string{"https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/vm"}
+		}
+		gcptest.WriteJSON(w, disk)
+	})
+	server.Handle(http.MethodGet, "projects/p/zones/z/instances/vm", gcptest.Respond(map[string]any{
+		"name":  "vm",
+		"disks": []map[string]string{{"source": "https://www.googleapis.com/compute/v1/projects/p/zones/z/disks/cache", "deviceName": "cache-dev"}},
+	}))
+	server.Handle(http.MethodPost, "projects/p/zones/z/instances/vm/detachDisk", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if device := r.URL.Query().Get("deviceName"); device != "cache-dev" {
+			t.Errorf("detached device %q, want cache-dev", device)
+		}
+		detaches++
+		if detaches == 1 {
+			gcptest.WriteError(w, http.StatusServiceUnavailable, "backend error")
+			return
+		}
+		attached = false
+		gcptest.WriteJSON(w, map[string]string{"name": "detach", "status": "DONE"})
+	})
+	server.Handle(http.MethodDelete, "projects/p/zones/z/disks/cache", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if attached {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+				"code":    http.StatusBadRequest,
+				"message": "The disk resource is already being used by 'vm'",
+				"errors":  []map[string]string{{"reason": "resourceInUseByAnotherResource"}},
+			}})
+			return
+		}
+		gcptest.WriteJSON(w, map[string]string{"name": "delete", "status": "DONE"})
+	})
+
+	if err := m.DeleteDisk(context.Background(), "cache", "z"); err != nil {
+		t.Fatalf("DeleteDisk() error = %v", err)
+	}
+	if n := len(server.Requests(http.MethodDelete, "projects/p/zones/z/disks/cache")); n != 2 {
+		t.Errorf("disk deleted %d times, want 2", n)
+	}
+	if detaches != 2 {
+		t.Errorf("disk detached %d times, want 2", detaches)
+	}
+}
@@ -0,0 +1,136 @@
+// Package gcptest serves a fake Compute Engine API for tests of the code that
+// calls it. Tests register handlers for the requests they expect; operations
+// complete at once, and any other request fails with 404 like a missing
+// resource.
+package gcptest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Request is a request the server received
+type Request struct {
+	Method string
+	Path   string // relative to the API's base path, e.g. "projects/p/zones/z/disks"
+	Body   []byte
+}
+
+// Server is a fake Compute Engine API. Its URL is the endpoint to pass to
+// gcp.NewClient along with option.WithHTTPClient(server.Client()).
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	routes   []route
+	requests []Request
+}
+
+type route struct {
+	method  string
+	pattern string
+	handler http.HandlerFunc
+}
+
+// basePath is where the Compute Engine API is served
+const basePath = "/compute/v1/"
+
+// NewServer starts a fake Compute Engine API, closed when the test ends
+func NewServer(t testing.TB) *Server {
+	s := &Server{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Handle serves the requests whose method and path match, the path being
+// relative to the API's base path and matched with path.Match, e.g.
+// "projects/*/zones/*/disks/*". Routes added later take precedence.
+func (s *Server) Handle(method, pattern string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, route{method: method, pattern: pattern, handler: handler})
+}
+
+// Requests returns the requests received so far whose method and path match,
+// as for Handle; an empty method matches any
+func (s *Server) Requests(method, pattern string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []Request
+	for _, r := range s.requests {
+		if ok, _ := path.Match(pattern, r.Path); ok && (method == "" || method == r.Method) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p := strings.TrimPrefix(r.URL.Path, basePath)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: p, Body: body})
+	var handler http.HandlerFunc
+	for i := len(s.routes) - 1; i >= 0; i-- {
+		route := s.routes[i]
+		if ok, _ := path.Match(route.pattern, p); ok && route.method == r.Method {
+			handler = route.handler
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+	switch {
+	case handler != nil:
+		handler(w, r)
+	case r.Method == http.MethodPost && strings.Contains(p, "/operations/") && strings.HasSuffix(p, "/wait"):
+		WriteJSON(w, map[string]string{"name": path.Base(path.Dir(p)), "status": "DONE"})
+	default:
+		WriteError(w, http.StatusNotFound, "The resource '"+p+"' was not found")
+	}
+}
+
+// WriteJSON writes v as the response
+func WriteJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteError writes a Google API error response
+func WriteError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": message},
+	})
+}
+
+// Operation answers a request with a finished operation named name
+func Operation(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, map[string]string{"name": name, "status": "DONE"})
+	}
+}
+
+// Respond answers a request with v
+func Respond(v any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, v)
+	}
+}
+
+// Fail answers a request with a Google API error
+func Fail(code int, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, code, message)
+	}
+}
@@ -0,0 +1,147 @@
+// Package httputil counts and, with --debug-http, logs the requests of the
+// HTTP clients the builder makes itself: the metadata server, registry
+// validation and token exchanges. The Google API clients are not wrapped;
+// their calls are logged by the operations using them. The pulls ctr makes
+// are not wrapped either, but their retries are counted.
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// Stats counts the requests of the wrapped clients since the process started
+type Stats struct {
+	Requests     int64 // requests sent, including retries
+	Retries      int64 // requests repeating an earlier one, e.g. after an authentication challenge
+	ClientErrors int64 // 4xx responses
+	ServerErrors int64 // 5xx responses
+	Failures     int64 // requests that got no response
+	PullRetries  int64 // image pulls repeated after a rate limit or an interruption
+}
+
+var (
+	requests, retries, clientErrors, serverErrors, failures, pullRetries atomic.Int64
+
+	// debugLogger is set by EnableDebug
+	debugLogger atomic.Pointer[log.Logger]
+)
+
+// EnableDebug logs every request of the wrapped clients to logger at trace level
+func EnableDebug(logger *log.Logger) {
+	logger.SetTrace(true)
+	debugLogger.Store(logger)
+}
+
+// Snapshot returns the counters so far
+func Snapshot() Stats {
+	return Stats{
+		Requests:     requests.Load(),
+		Retries:      retries.Load(),
+		ClientErrors: clientErrors.Load(),
+		ServerErrors: serverErrors.Load(),
+		Failures:     failures.Load(),
+		PullRetries:  pullRetries.Load(),
+	}
+}
+
+// CountPullRetry counts an image pull that is repeated
+func CountPullRetry() {
+	pullRetries.Add(1)
+}
+
+type retryKey struct{}
+
+// Retrying returns a context marking the requests made with it as retries
+func Retrying(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+// Transport wraps base, http.DefaultTransport if nil, so that its requests
+// are counted and logged; name tells the clients apart in the log
+func Transport(name string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{name: name, base: base}
+}
+
+type transport struct {
+	name string
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request through the base transport. Only the method,
+// URL, status and latency are logged: never headers, which carry the
+// Authorization values, nor bodies, which carry token exchange secrets.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requests.Add(1)
+	retry, _ := req.Context().Value(retryKey{}).(bool)
+	if retry {
+		retries.Add(1)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start).Round(time.Millisecond)
+
+	status := ""
+	switch {
+	case err != nil:
+		failures.Add(1)
+		status = "failed: " + err.Error()
+	default:
+		if resp.StatusCode >= 500 {
+			serverErrors.Add(1)
+		} else if resp.StatusCode >= 400 {
+			clientErrors.Add(1)
+		}
+		status = resp.Status
+	}
+
+	if logger := debugLogger.Load(); logger != nil {
+		suffix := ""
+		if retry {
+			suffix = " (retry)"
+		}
+		logger.Tracef("HTTP %s: %s %s -> %s in %s%s", t.name, req.Method, RedactURL(req.URL), status, latency, suffix)
+	}
+	return resp, err
+}
+
+// sensitiveParams are fragments of query parameter names whose values are
+// credentials, e.g. access_token or X-Goog-Signature
+var sensitiveParams = []string{"token", "secret", "password", "signature", "credential", "assertion", "key"}
+
+// RedactURL returns u without its user info and with the values of query
+// parameters that may hold credentials replaced
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	redacted.User = nil
+
+	query := redacted.Query()
+	changed := false
+	for name := range query {
+		lower := strings.ToLower(name)
+		for _, fragment := range sensitiveParams {
+			if strings.Contains(lower, fragment) {
+				query[name] = []string{"REDACTED"}
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
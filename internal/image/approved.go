@@ -0,0 +1,43 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ApprovedDigests is the set of image digests a security review allowed into a cache
+type ApprovedDigests map[string]bool
+
+// LoadApprovedDigests reads a file of approved digests: one sha256:<hex> per
+// line, optionally followed by a note such as the image it belongs to. Blank
+// lines and lines starting with # are ignored.
+func LoadApprovedDigests(path string) (ApprovedDigests, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approved digests: %w", err)
+	}
+	defer file.Close()
+
+	approved := make(ApprovedDigests)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		digest := strings.ToLower(fields[0])
+		if !digestPattern.MatchString(digest) {
+			return nil, fmt.Errorf("approved digests %s, line %d: '%s' is not a sha256:<64 hex digits> digest", path, line, fields[0])
+		}
+		approved[digest] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read approved digests %s: %w", path, err)
+	}
+	if len(approved) == 0 {
+		return nil, fmt.Errorf("approved digests %s does not list any digests", path)
+	}
+	return approved, nil
+}
@@ -0,0 +1,92 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Authentication mechanisms reported by ProbeAuth
+const (
+	AuthNone           = "none required"
+	AuthAnonymousToken = "anonymous token"
+	AuthGCPToken       = "GCP access token"
+)
+
+// AuthProbe is the outcome of requesting one image's manifest the way a pull would
+type AuthProbe struct {
+	Image    string
+	Registry string
+
+	// Mechanism is how the request was authenticated, one of the Auth constants;
+	// empty if the probe failed before authenticating
+	Mechanism string
+
+	// Err is why the manifest could not be read, nil if it could
+	Err error
+}
+
+// ProbeAuth checks that the configured registry credentials can read an image's
+// manifest, with an authenticated HEAD request. Nothing is pulled.
+func (c *Cache) ProbeAuth(ctx context.Context, image string) AuthProbe {
+	probe := AuthProbe{Image: image}
+	ref, err := ParseReference(image)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	probe.Registry = ref.Registry
+
+	probe.Mechanism, probe.Err = c.registry.probeAuth(ctx, ref)
+	if probe.Err == nil {
+		c.logger.Debugf("Read the manifest of %s (%s)", image, probe.Mechanism)
+	}
+	return probe
+}
+
+// probeAuth requests a manifest, performing the token handshake if challenged,
+// and returns the mechanism that authenticated the request
+func (c *registryClient) probeAuth(ctx context.Context, ref *Reference) (string, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.APIHost(), ref.Repository, ref.manifestRef())
+
+	mechanism := AuthNone
+	resp, err := c.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		mechanism = AuthAnonymousToken
+		if c.registryAuth != nil {
+			authConfig, err := c.registryAuth.GetAuthConfig(ctx, ref.Registry)
+			if err != nil {
+				return "", fmt.Errorf("cannot resolve credentials: %w", err)
+			}
+			if authConfig.Username != "" {
+				mechanism = AuthGCPToken
+			}
+		}
+
+		token, err := c.fetchToken(ctx, ref, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return mechanism, err
+		}
+		resp, err = c.headManifest(ctx, manifestURL, token)
+		if err != nil {
+			return mechanism, err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return mechanism, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return mechanism, fmt.Errorf("registry %s denied access to %s: %s", ref.Registry, ref.Name(), resp.Status)
+	case http.StatusNotFound:
+		// Some registries answer 404 rather than 403 for repositories the
+		// credentials cannot see
+		return mechanism, fmt.Errorf("%w: %s (or the credentials cannot see it)", ErrManifestNotFound, ref.String())
+	default:
+		return mechanism, fmt.Errorf("registry %s returned %s for %s", ref.Registry, resp.Status, ref.String())
+	}
+}
@@ -2,20 +2,31 @@ package image
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
-	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/auth"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
 )
 
 // Cache handles container image caching operations
 type Cache struct {
-	logger *log.Logger
+	logger   *log.Logger
+	registry *registryClient
+
+	// dockerHub paces pulls from Docker Hub, which rate limits pulls per IP
+	dockerHub *pacer
 }
 
 // NewCache creates a new image cache handler
-func NewCache(logger *log.Logger) *Cache {
+func NewCache(logger *log.Logger, registryAuth *auth.RegistryAuth) *Cache {
 	return &Cache{
-		logger: logger,
+		logger:    logger,
+		registry:  newRegistryClient(registryAuth),
+		dockerHub: newPacer(dockerHubDomain, dockerHubConcurrency),
 	}
 }
 
@@ -27,14 +38,162 @@ func (c *Cache) ValidateImageAccess(ctx context.Context, image string) error {
 	return nil
 }
 
-// PullAndCache pulls and caches a container image
-func (c *Cache) PullAndCache(ctx context.Context, image string, cacheDisk *disk.Disk) error {
-	c.logger.Infof("Pulling and caching image: %s", image)
+// ResolveDigest returns the registry content digest for an image reference
+func (c *Cache) ResolveDigest(ctx context.Context, image string) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := c.registry.resolveDigest(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve digest for %s: %w", image, err)
+	}
+
+	c.logger.Debugf("Resolved %s to %s", image, digest)
+	return digest, nil
+}
+
+// VerifyDigestAvailable confirms that a digest-pinned image can still be pulled
+func (c *Cache) VerifyDigestAvailable(ctx context.Context, pinnedImage string) error {
+	ref, err := ParseReference(pinnedImage)
+	if err != nil {
+		return err
+	}
 
-	// Implementation would:
-	// 1. Pull the container image
-	// 2. Cache it to the disk using containerd
-	// 3. Optimize for GKE compatibility
+	if _, err := c.registry.resolveDigest(ctx, ref); err != nil {
+		if errors.Is(err, ErrManifestNotFound) {
+			return fmt.Errorf("digest %s is no longer available in %s", ref.Digest, ref.Name())
+		}
+		return fmt.Errorf("failed to check digest %s in %s: %w", ref.Digest, ref.Name(), err)
+	}
 
 	return nil
 }
+
+// PlatformOS returns the operating systems (e.g. linux, windows) an image is published for
+func (c *Cache) PlatformOS(ctx context.Context, image string) ([]string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	systems, err := c.registry.platformOS(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read platforms of %s: %w", image, err)
+	}
+
+	c.logger.Debugf("Image %s is published for %v", image, systems)
+	return systems, nil
+}
+
+// CompressedSize returns the download size of an image's variant for platform
+// (e.g. linux/amd64): its config plus compressed layers, as listed in the registry
+func (c *Cache) CompressedSize(ctx context.Context, image, platform string) (int64, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := c.registry.compressedSize(ctx, ref, platform)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read size of %s: %w", image, err)
+	}
+
+	c.logger.Debugf("Image %s (%s) is %d bytes compressed", image, platform, size)
+	return size, nil
+}
+
+// PullOptions tunes how images are pulled
+type PullOptions struct {
+	// Platform selects the image variant, e.g. linux/arm64; empty uses the machine's own
+	Platform string
+
+	// Snapshotter unpacks the layers; empty uses containerd's default (overlayfs)
+	Snapshotter string
+
+	// MaxDownloads bounds the layers each pull downloads at a time; 0 leaves it to ctr
+	MaxDownloads int
+
+	// Args are appended to ctr images pull; validated by config to contain no shell metacharacters
+	Args []string
+
+	// Env holds NAME=value assignments for ctr, such as the proxy settings
+	// sudo would otherwise drop
+	Env []string
+
+	// Detach runs each ctr pull as a transient systemd service, so that a pull
+	// under way finishes even if the runner's session is lost
+	Detach bool
+}
+
+// PullAndCache pulls a container image into containerd's k8s.io namespace on the
+// runner's machine and measures the pull
+func (c *Cache) PullAndCache(ctx context.Context, runner Runner, image string, opts PullOptions) (PullStats, error) {
+	c.logger.Infof("Pulling and caching image: %s", image)
+
+	args := "images pull"
+	if opts.Platform != "" {
+		args += " --platform " + scripts.ShellQuote(opts.Platform)
+	}
+	if opts.Snapshotter != "" {
+		args += " --snapshotter " + scripts.ShellQuote(opts.Snapshotter)
+	}
+	if opts.MaxDownloads > 0 {
+		args += " --max-concurrent-downloads " + strconv.Itoa(opts.MaxDownloads)
+	}
+	for _, arg := range opts.Args {
+		args += " " + scripts.ShellQuote(arg)
+	}
+	// ctr only accepts fully qualified references (docker.io/library/nginx:latest)
+	ref, err := ParseReference(image)
+	if err != nil {
+		return PullStats{}, err
+	}
+	args += " " + scripts.ShellQuote(ref.String())
+
+	stats := PullStats{Image: image, Registry: ref.Registry}
+	if opts.Platform != "" {
+		// The pull rate is only reported, so an unknown size is not an error
+		size, err := c.registry.compressedSize(ctx, ref, opts.Platform)
+		if err != nil {
+			c.logger.Debugf("Cannot measure pull rate of %s: failed to read its size: %v", image, err)
+		} else {
+			stats.Bytes = size
+		}
+	}
+
+	pull := func() error {
+		command := ctrCommand(opts.Env, args)
+		if opts.Detach {
+			command = detachedCtrCommand(opts.Env, args, image)
+		}
+		start := time.Now()
+		output, err := runner.Run(ctx, command)
+		stats.Duration = time.Since(start)
+		if err != nil {
+			c.logger.Debugf("ctr output for %s:\n%s", image, output)
+			if isRateLimited(output) {
+				return fmt.Errorf("failed to pull %s: %w", image, ErrRateLimited)
+			}
+			if isInterrupted(output) {
+				return fmt.Errorf("failed to pull %s: %w: %v", image, ErrPullInterrupted, err)
+			}
+			return fmt.Errorf("failed to pull %s: %w", image, err)
+		}
+		return nil
+	}
+
+	attempt := pull
+	if ref.Registry == dockerHubDomain {
+		attempt = func() error { return c.dockerHub.do(ctx, c.logger, image, pull) }
+	}
+
+	// Pulls the network interrupts are retried, keeping what they downloaded
+	pins := newLayerPins(runner, opts.Env, image)
+	err = c.retryInterrupted(ctx, pins, ref, opts.Platform, attempt)
+	if releaseErr := pins.release(ctx); releaseErr != nil {
+		err = errors.Join(err, releaseErr)
+	}
+	return stats, err
+}
@@ -0,0 +1,45 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ImageSetHashLabel is the disk image label holding the hash of the cached image set
+const ImageSetHashLabel = "image-set-hash"
+
+// imageSetHashLength keeps the hash well within the 63 character label value limit
+const imageSetHashLength = 32
+
+// ImageSetHash returns a deterministic hash of a set of digest-pinned references.
+// References are canonicalized to registry/repository@digest and sorted, so tags,
+// Docker Hub shorthand and list order do not affect the result.
+func ImageSetHash(pinnedImages []string) (string, error) {
+	digest, err := ImageSetDigest(pinnedImages)
+	if err != nil {
+		return "", err
+	}
+	return digest[:imageSetHashLength], nil
+}
+
+// ImageSetDigest is the full hex SHA-256 that ImageSetHash is shortened from
+func ImageSetDigest(pinnedImages []string) (string, error) {
+	entries := make([]string, 0, len(pinnedImages))
+	for _, img := range pinnedImages {
+		ref, err := ParseReference(img)
+		if err != nil {
+			return "", err
+		}
+		if ref.Digest == "" {
+			return "", fmt.Errorf("image %s is not pinned to a digest", img)
+		}
+		entries = append(entries, ref.WithDigest(ref.Digest))
+	}
+	sort.Strings(entries)
+
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,140 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+// maxManifestSize bounds the blobs read as candidate manifests (4 MiB, as in
+// listBlobsScript); manifests and indexes are small JSON documents, layers are not
+const maxManifestSize = 4 << 20
+
+// listBlobsScript prints "<hex> <size>" for every sha256 blob under $BLOBS, and
+// "JSON <hex> <base64>" for the small ones that look like JSON documents
+const listBlobsScript = `cd "$BLOBS/sha256" || exit 1
+for f in *; do
+  [ -f "$f" ] || continue
+  size=$(stat -c %s "$f")
+  echo "$f $size"
+  if [ "$size" -le 4194304 ] && [ "$(head -c 1 "$f")" = "{" ]; then
+    echo "JSON $f $(base64 -w0 < "$f")"
+  fi
+done`
+
+// StoreReport describes a containerd content store inspected without containerd
+type StoreReport struct {
+	Images    int      `json:"images"`    // manifests and indexes not referenced by an index
+	Manifests int      `json:"manifests"` // image manifests, including platform manifests of indexes
+	Blobs     int      `json:"blobs"`     // blobs referenced by the manifests
+	Bytes     int64    `json:"bytes"`     // size of the referenced blobs
+	Problems  []string `json:"problems"`
+}
+
+// InspectContentStore checks a content store directory (…/io.containerd.content.v1.content)
+// directly, e.g. on a mounted cache disk: every manifest and index found in it is
+// parsed, and each blob they reference must be present with its recorded size.
+// With deep, blob contents are also hashed and compared to their digest, by up
+// to parallel concurrent commands. Nothing is written to the store.
+func InspectContentStore(ctx context.Context, runner Runner, contentDir string, deep bool, parallel int) (*StoreReport, error) {
+	blobDir := strings.TrimSuffix(contentDir, "/") + "/blobs"
+
+	output, err := runner.Run(ctx, "sudo BLOBS="+scripts.ShellQuote(blobDir)+" sh -c "+scripts.ShellQuote(listBlobsScript))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list content blobs in %s: %w", blobDir, err)
+	}
+
+	sizes := make(map[string]int64)
+	documents := make(map[string][]byte)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*maxManifestSize)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 3 && fields[0] == "JSON":
+			data, err := base64.StdEncoding.DecodeString(fields[2])
+			if err == nil {
+				documents["sha256:"+fields[1]] = data
+			}
+		case len(fields) == 2:
+			if size, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				sizes["sha256:"+fields[0]] = size
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read content blob list: %w", err)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("content store %s holds no blobs", contentDir)
+	}
+
+	report := &StoreReport{}
+	referenced := make(map[string]descriptor)
+	children := make(map[string]bool) // manifests referenced by an index
+	var roots []string
+
+	for digest, data := range documents {
+		var m manifest
+		// Image configs have a "config" object too, but without a digest
+		if json.Unmarshal(data, &m) != nil || (len(m.Manifests) == 0 && (m.Config == nil || m.Config.Digest == "")) {
+			continue // a config or other JSON blob
+		}
+		roots = append(roots, digest)
+		referenced[digest] = descriptor{Digest: digest, Size: int64(len(data))}
+
+		if len(m.Manifests) > 0 {
+			// Only the platforms that were pulled are present, as on a node
+			for _, child := range m.Manifests {
+				if _, ok := sizes[child.Digest]; ok {
+					children[child.Digest] = true
+				}
+			}
+			continue
+		}
+
+		report.Manifests++
+		if m.Config != nil {
+			referenced[m.Config.Digest] = *m.Config
+		}
+		for _, layer := range m.Layers {
+			referenced[layer.Digest] = layer
+		}
+	}
+	for _, digest := range roots {
+		if !children[digest] {
+			report.Images++
+		}
+	}
+	if report.Manifests == 0 {
+		report.Problems = append(report.Problems, "no image manifests found in the content store")
+	}
+
+	blobs := make([]descriptor, 0, len(referenced))
+	for _, blob := range referenced {
+		if !digestPattern.MatchString(blob.Digest) {
+			report.Problems = append(report.Problems, fmt.Sprintf("unsupported blob digest %q", blob.Digest))
+			continue
+		}
+		blobs = append(blobs, blob)
+		report.Bytes += blob.Size
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Digest < blobs[j].Digest })
+	report.Blobs = len(blobs)
+
+	results, err := checkBlobs(ctx, runner, blobDir, blobs, deep, parallel)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		report.Problems = append(report.Problems, fmt.Sprintf("blob %s %s", result.digest, result.problem))
+	}
+	return report, nil
+}
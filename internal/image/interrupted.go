@@ -0,0 +1,163 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+const (
+	// pullAttempts bounds the pulls of an image interrupted by the network
+	pullAttempts     = 3
+	pullRetryBackoff = 15 * time.Second
+
+	// gcRootLabel marks content containerd's garbage collector keeps
+	gcRootLabel = "containerd.io/gc.root"
+
+	// releaseTimeout bounds releasing the pinned layers once the pull is over
+	releaseTimeout = time.Minute
+)
+
+// ErrPullInterrupted is returned when the network cut a pull short, as opposed
+// to the registry refusing it
+var ErrPullInterrupted = errors.New("pull interrupted by a network failure")
+
+// interruptedMarkers are lowercase fragments of ctr's output for transfers the
+// network broke off
+var interruptedMarkers = []string{
+	"connection reset by peer",
+	"unexpected eof",
+	"i/o timeout",
+	"tls handshake timeout",
+	"broken pipe",
+	"http2: server sent goaway",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// isInterrupted recognizes a pull the network broke off in ctr's output
+func isInterrupted(output string) bool {
+	lower := strings.ToLower(output)
+	for _, marker := range interruptedMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// layerPins keeps the layers an interrupted pull already stored until the pull
+// is retried. ctr pulls under a lease of its own, which it deletes when it
+// exits, failed or not, leaving the downloaded layers to containerd's garbage
+// collector and the retry to download them again. ctr cannot pull under a
+// lease it is given, so the image's layers already in the content store are
+// instead labeled as GC roots, and the labels removed once the pulled image
+// references the layers.
+type layerPins struct {
+	runner Runner
+	env    []string
+	image  string
+
+	digests []string        // the image's layers, read from the registry
+	pinned  map[string]bool // layers marked as GC roots
+}
+
+// newLayerPins returns the pins of the pulls of image on the runner's machine
+func newLayerPins(runner Runner, env []string, image string) *layerPins {
+	return &layerPins{runner: runner, env: env, image: image, pinned: make(map[string]bool)}
+}
+
+// pin marks the layers stored so far as GC roots and returns how many are
+func (l *layerPins) pin(ctx context.Context) (int, error) {
+	var script strings.Builder
+	for _, digest := range l.digests {
+		if l.pinned[digest] {
+			continue
+		}
+		// Layers not downloaded yet are not in the content store, and ctr fails
+		script.WriteString("ctr -n k8s.io content label " + scripts.ShellQuote(digest) + " " +
+			scripts.ShellQuote(gcRootLabel+"="+l.image) + " >/dev/null 2>&1 && echo " + scripts.ShellQuote(digest) + "\n")
+	}
+	if script.Len() > 0 {
+		output, err := RunAsRoot(ctx, l.runner, script.String()+"true", l.env)
+		if err != nil {
+			return len(l.pinned), err
+		}
+		for _, digest := range strings.Fields(output) {
+			l.pinned[digest] = true
+		}
+	}
+	return len(l.pinned), nil
+}
+
+// release removes the GC root label from the pinned layers, also after the
+// build's context ended. Labels left behind would be captured with the cache
+// disk and keep the layers from ever being collected on the nodes, so a
+// failed release is an error.
+func (l *layerPins) release(ctx context.Context) error {
+	if len(l.pinned) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+
+	// A label given without a value is removed
+	var script strings.Builder
+	for digest := range l.pinned {
+		script.WriteString("ctr -n k8s.io content label " + scripts.ShellQuote(digest) + " " + scripts.ShellQuote(gcRootLabel) + "\n")
+	}
+	if output, err := RunAsRoot(ctx, l.runner, script.String(), l.env); err != nil {
+		return fmt.Errorf("failed to remove the %s label from the layers of %s kept for its retried pull: %w: %s",
+			gcRootLabel, l.image, err, strings.TrimSpace(output))
+	}
+	l.pinned = make(map[string]bool)
+	return nil
+}
+
+// retryInterrupted runs attempt until it succeeds, fails for a reason other
+// than an interrupted transfer, or pullAttempts are spent. Between attempts
+// the pins keep what was downloaded, so the retry only fetches the rest.
+func (c *Cache) retryInterrupted(ctx context.Context, pins *layerPins, ref *Reference, platform string, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || !errors.Is(err, ErrPullInterrupted) || n == pullAttempts {
+			return err
+		}
+		if err := retry.FromContext(ctx).Retry("pull of "+pins.image, err); err != nil {
+			return err
+		}
+
+		// The layers are read once; without them the retry starts over
+		if pins.digests == nil && platform != "" {
+			if _, descriptors, err := c.registry.platformManifest(ctx, ref, platform); err != nil {
+				c.logger.Debugf("Cannot keep the layers of %s for its retry: failed to read its manifest: %v", pins.image, err)
+			} else {
+				for _, d := range descriptors {
+					pins.digests = append(pins.digests, d.Digest)
+				}
+			}
+		}
+		kept, pinErr := pins.pin(ctx)
+		if pinErr != nil {
+			c.logger.Debugf("Cannot keep the layers of %s for its retry: %v", pins.image, pinErr)
+		}
+		c.logger.Warnf("Pull of %s was interrupted, retrying in %s with %d of %d layers kept (attempt %d/%d): %v",
+			pins.image, pullRetryBackoff, kept, len(pins.digests), n+1, pullAttempts, err)
+		httputil.CountPullRetry()
+
+		timer := time.NewTimer(pullRetryBackoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Layer is a compressed layer of an image as listed in its registry manifest
+type Layer struct {
+	Digest string
+	Size   int64
+}
+
+// Layers returns the compressed layers of an image's variant for platform
+// (e.g. linux/amd64), read from its registry manifest
+func (c *Cache) Layers(ctx context.Context, image, platform string) ([]Layer, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	_, descriptors, err := c.registry.platformManifest(ctx, ref, platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layers of %s: %w", image, err)
+	}
+
+	layers := make([]Layer, 0, len(descriptors))
+	for _, d := range descriptors {
+		layers = append(layers, Layer{Digest: d.Digest, Size: d.Size})
+	}
+	return layers, nil
+}
+
+// ImageLayers summarizes one image in a LayerReport
+type ImageLayers struct {
+	Image  string
+	Layers int
+	Bytes  int64
+
+	// UniqueBytes is the size of the layers no other image in the report
+	// shares: what adding this image to the others costs on the cache disk
+	UniqueBytes int64
+}
+
+// SharedLayer is a layer in a LayerReport with the images that use it
+type SharedLayer struct {
+	Layer
+	Images []string
+}
+
+// LayerReport compares the naive size of a set of images, each counted in full,
+// with the size of their distinct layers, which is what containerd stores
+type LayerReport struct {
+	Images []ImageLayers
+
+	// TotalBytes sums every image's layers; UniqueBytes counts a layer shared
+	// by several images once
+	TotalBytes  int64
+	UniqueBytes int64
+
+	// Largest lists the largest distinct layers, largest first
+	Largest []SharedLayer
+}
+
+// SavedBytes is what sharing layers saves over storing each image in full
+func (r *LayerReport) SavedBytes() int64 {
+	return r.TotalBytes - r.UniqueBytes
+}
+
+// NewLayerReport builds a report from the layers of each image, listing up to
+// largest of the largest layers. Images keep the order of images.
+func NewLayerReport(images []string, layers map[string][]Layer, largest int) *LayerReport {
+	report := &LayerReport{}
+	shared := make(map[string]*SharedLayer)
+	var order []string
+	for _, img := range images {
+		seen := make(map[string]bool)
+		for _, layer := range layers[img] {
+			report.TotalBytes += layer.Size
+			if seen[layer.Digest] {
+				continue
+			}
+			seen[layer.Digest] = true
+			s, ok := shared[layer.Digest]
+			if !ok {
+				s = &SharedLayer{Layer: layer}
+				shared[layer.Digest] = s
+				order = append(order, layer.Digest)
+				report.UniqueBytes += layer.Size
+			}
+			s.Images = append(s.Images, img)
+		}
+	}
+
+	for _, img := range images {
+		summary := ImageLayers{Image: img, Layers: len(layers[img])}
+		for _, layer := range layers[img] {
+			summary.Bytes += layer.Size
+			if len(shared[layer.Digest].Images) == 1 {
+				summary.UniqueBytes += layer.Size
+			}
+		}
+		report.Images = append(report.Images, summary)
+	}
+
+	for _, digest := range order {
+		report.Largest = append(report.Largest, *shared[digest])
+	}
+	sort.SliceStable(report.Largest, func(i, j int) bool { return report.Largest[i].Size > report.Largest[j].Size })
+	if len(report.Largest) > largest {
+		report.Largest = report.Largest[:largest]
+	}
+	return report
+}
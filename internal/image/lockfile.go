@@ -0,0 +1,99 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lockfileVersion is the schema version written to generated lockfiles
+const lockfileVersion = 1
+
+// Lockfile maps container image references to the exact digests that should be cached
+type Lockfile struct {
+	Version   int               `json:"version"`
+	Generated string            `json:"generated,omitempty"`
+	Images    map[string]string `json:"images"`
+}
+
+// LoadLockfile reads and validates a JSON lockfile
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile %s: %w", path, err)
+	}
+
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile %s: %w", path, err)
+	}
+
+	if len(lock.Images) == 0 {
+		return nil, fmt.Errorf("lockfile %s does not list any images", path)
+	}
+
+	for image, digest := range lock.Images {
+		if !strings.HasPrefix(digest, "sha256:") || len(digest) != len("sha256:")+64 {
+			return nil, fmt.Errorf("lockfile %s has invalid digest '%s' for image %s", path, digest, image)
+		}
+	}
+
+	return &lock, nil
+}
+
+// NewLockfile creates an empty lockfile stamped with the current time
+func NewLockfile() *Lockfile {
+	return &Lockfile{
+		Version:   lockfileVersion,
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		Images:    make(map[string]string),
+	}
+}
+
+// ImageNames returns the locked image references in a stable order
+func (l *Lockfile) ImageNames() []string {
+	names := make([]string, 0, len(l.Images))
+	for image := range l.Images {
+		names = append(names, image)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PinnedReference returns the digest-pinned reference for a locked image
+func (l *Lockfile) PinnedReference(image string) (string, error) {
+	digest, ok := l.Images[image]
+	if !ok {
+		return "", fmt.Errorf("image %s is not in the lockfile", image)
+	}
+
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	return ref.WithDigest(digest), nil
+}
+
+// Write saves the lockfile as indented JSON
+func (l *Lockfile) Write(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write lockfile %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+const (
+	// dockerHubConcurrency limits parallel pulls from Docker Hub, which limits pulls per IP
+	dockerHubConcurrency = 2
+
+	rateLimitAttempts   = 4
+	rateLimitBackoff    = 30 * time.Second
+	rateLimitMaxBackoff = 5 * time.Minute
+)
+
+// ErrRateLimited is returned when a registry refused a pull with HTTP 429
+var ErrRateLimited = errors.New("registry rate limit exceeded (429 Too Many Requests)")
+
+// isRateLimited recognizes a 429 in ctr's output, e.g. "unexpected status code ...: 429 Too Many Requests"
+func isRateLimited(output string) bool {
+	lower := strings.ToLower(output)
+	return strings.Contains(lower, "429 too many requests") || strings.Contains(lower, "toomanyrequests")
+}
+
+// pacer limits concurrent pulls from one registry and, once the registry
+// answers 429, holds back every pull from it with a growing shared backoff
+type pacer struct {
+	registry string
+	slots    chan struct{}
+
+	mu        sync.Mutex
+	notBefore time.Time
+	backoff   time.Duration
+	warned    bool
+}
+
+func newPacer(registry string, concurrency int) *pacer {
+	return &pacer{registry: registry, slots: make(chan struct{}, concurrency)}
+}
+
+// do runs pull, retrying it a bounded number of times while it is rate limited
+func (p *pacer) do(ctx context.Context, logger *log.Logger, image string, pull func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err := pull()
+		<-p.slots
+
+		if !errors.Is(err, ErrRateLimited) {
+			if err == nil {
+				p.succeeded()
+			}
+			return err
+		}
+
+		delay, first := p.limited()
+		if first {
+			logger.Warnf("%s is rate limiting pulls from this IP. Authenticate pulls with a Docker Hub account "+
+				"or copy the images to Artifact Registry to avoid the limit", p.registry)
+		}
+		if attempt == rateLimitAttempts {
+			return err
+		}
+		if err := retry.FromContext(ctx).Retry("pulls from "+p.registry, err); err != nil {
+			return err
+		}
+		logger.Warnf("Rate limited pulling %s, retrying in %s (attempt %d/%d)", image, delay, attempt, rateLimitAttempts)
+		httputil.CountPullRetry()
+	}
+}
+
+// wait blocks until the shared backoff has passed
+func (p *pacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	delay := time.Until(p.notBefore)
+	p.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limited extends the shared backoff; first is true on the first 429 of the build
+func (p *pacer) limited() (delay time.Duration, first bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.backoff == 0:
+		p.backoff = rateLimitBackoff
+	case p.backoff < rateLimitMaxBackoff:
+		p.backoff = min(2*p.backoff, rateLimitMaxBackoff)
+	}
+	if until := time.Now().Add(p.backoff); until.After(p.notBefore) {
+		p.notBefore = until
+	}
+
+	first = !p.warned
+	p.warned = true
+	return p.backoff, first
+}
+
+// succeeded resets the backoff once the registry accepts pulls again
+func (p *pacer) succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backoff = 0
+}
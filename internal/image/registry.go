@@ -0,0 +1,480 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/auth"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+	dockerHubDomain   = "docker.io"
+)
+
+// manifestMediaTypes lists the manifest formats accepted when resolving digests
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ErrManifestNotFound is returned when a registry has no manifest for a reference
+var ErrManifestNotFound = errors.New("manifest not found in registry")
+
+// Reference is a parsed container image reference
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image reference into registry, repository, tag and digest.
+// Docker Hub shorthand (e.g. nginx:latest) is normalized to its fully-qualified form.
+func ParseReference(image string) (*Reference, error) {
+	if image == "" {
+		return nil, fmt.Errorf("image reference cannot be empty")
+	}
+
+	ref := &Reference{}
+	remainder := image
+
+	if i := strings.Index(remainder, "@"); i >= 0 {
+		ref.Digest = remainder[i+1:]
+		remainder = remainder[:i]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return nil, fmt.Errorf("unsupported digest '%s', expected sha256:<hex>", ref.Digest)
+		}
+	}
+
+	// A colon after the last slash separates the tag; earlier colons belong to a registry port
+	if i := strings.LastIndex(remainder, ":"); i > strings.LastIndex(remainder, "/") {
+		ref.Tag = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+
+	parts := strings.SplitN(remainder, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		ref.Repository = parts[1]
+	} else {
+		ref.Registry = dockerHubDomain
+		ref.Repository = remainder
+	}
+
+	if ref.Registry == dockerHubDomain && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	if ref.Repository == "" {
+		return nil, fmt.Errorf("image reference '%s' has no repository", image)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	return ref, nil
+}
+
+// Name returns the reference without tag or digest (registry/repository)
+func (r *Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// WithDigest returns the fully-qualified reference pinned to the given digest
+func (r *Reference) WithDigest(digest string) string {
+	return r.Name() + "@" + digest
+}
+
+// String returns the fully-qualified reference
+func (r *Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// APIHost returns the host serving the registry v2 API
+func (r *Reference) APIHost() string {
+	if r.Registry == dockerHubDomain {
+		return dockerHubRegistry
+	}
+	return r.Registry
+}
+
+// IsArtifactRegistry reports whether the image is in an Artifact Registry
+// Docker repository (<location>-docker.pkg.dev), which GKE Image Streaming serves
+func (r *Reference) IsArtifactRegistry() bool {
+	return strings.HasSuffix(r.Registry, "-docker.pkg.dev")
+}
+
+// manifestRef returns the tag or digest used to address the manifest
+func (r *Reference) manifestRef() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// registryClient talks to the OCI distribution (registry v2) API
+type registryClient struct {
+	httpClient   *http.Client
+	registryAuth *auth.RegistryAuth
+}
+
+func newRegistryClient(registryAuth *auth.RegistryAuth) *registryClient {
+	return &registryClient{
+		// The default transport honors HTTPS_PROXY and NO_PROXY
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: httputil.Transport("registry", nil),
+		},
+		registryAuth: registryAuth,
+	}
+}
+
+// Reachable checks that a registry answers on its API endpoint over HTTPS. Any
+// response does, including 401: only the network path is checked.
+func Reachable(ctx context.Context, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := newRegistryClient(nil).httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("registry request failed: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// resolveDigest returns the content digest of the manifest the reference points to
+func (c *registryClient) resolveDigest(ctx context.Context, ref *Reference) (string, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.APIHost(), ref.Repository, ref.manifestRef())
+
+	resp, err := c.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := c.fetchToken(ctx, ref, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		resp, err = c.headManifest(httputil.Retrying(ctx), manifestURL, token)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrManifestNotFound
+	default:
+		return "", fmt.Errorf("registry %s returned %s for %s", ref.Registry, resp.Status, ref.String())
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		if ref.Digest != "" {
+			return ref.Digest, nil
+		}
+		return "", fmt.Errorf("registry %s did not return a content digest for %s", ref.Registry, ref.String())
+	}
+
+	return digest, nil
+}
+
+func (c *registryClient) headManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// platformOS returns the operating systems an image provides, read from its
+// manifest list or, for single-platform images, from its config blob
+func (c *registryClient) platformOS(ctx context.Context, ref *Reference) ([]string, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.APIHost(), ref.Repository, ref.manifestRef())
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Platform struct {
+				OS string `json:"os"`
+			} `json:"platform"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := c.getJSON(ctx, ref, manifestURL, strings.Join(manifestMediaTypes, ", "), &manifest); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var systems []string
+	for _, m := range manifest.Manifests {
+		if name := m.Platform.OS; name != "" && name != "unknown" && !seen[name] {
+			seen[name] = true
+			systems = append(systems, name)
+		}
+	}
+	if len(manifest.Manifests) > 0 || manifest.Config.Digest == "" {
+		return systems, nil
+	}
+
+	var config struct {
+		OS string `json:"os"`
+	}
+	blobURL := fmt.Sprintf("https://%s/v2/%s/blobs/%s", ref.APIHost(), ref.Repository, manifest.Config.Digest)
+	if err := c.getJSON(ctx, ref, blobURL, "*/*", &config); err != nil {
+		return nil, err
+	}
+	if config.OS == "" {
+		return nil, nil
+	}
+
+	return []string{config.OS}, nil
+}
+
+// compressedSize returns the size of an image's config and compressed layers
+// for a platform ("os/arch"), read from its manifest
+func (c *registryClient) compressedSize(ctx context.Context, ref *Reference, platform string) (int64, error) {
+	config, layers, err := c.platformManifest(ctx, ref, platform)
+	if err != nil {
+		return 0, err
+	}
+
+	size := config.Size
+	for _, layer := range layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// platformManifest returns the config and compressed layers of an image's
+// manifest for a platform ("os/arch"), following an index if there is one
+func (c *registryClient) platformManifest(ctx context.Context, ref *Reference, platform string) (descriptor, []descriptor, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.APIHost(), ref.Repository, ref.manifestRef())
+
+	type platformDescriptor struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	}
+	var manifest struct {
+		Manifests []platformDescriptor `json:"manifests"`
+		Config    descriptor           `json:"config"`
+		Layers    []descriptor         `json:"layers"`
+	}
+	if err := c.getJSON(ctx, ref, manifestURL, strings.Join(manifestMediaTypes, ", "), &manifest); err != nil {
+		return descriptor{}, nil, err
+	}
+
+	// Follow an index to the manifest of the requested platform
+	if len(manifest.Manifests) > 0 {
+		digest := ""
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS+"/"+m.Platform.Architecture == platform {
+				digest = m.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return descriptor{}, nil, fmt.Errorf("%s has no %s variant", ref.String(), platform)
+		}
+		manifestURL = fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.APIHost(), ref.Repository, digest)
+		manifest.Manifests, manifest.Layers = nil, nil
+		if err := c.getJSON(ctx, ref, manifestURL, strings.Join(manifestMediaTypes, ", "), &manifest); err != nil {
+			return descriptor{}, nil, err
+		}
+	}
+
+	return manifest.Config, manifest.Layers, nil
+}
+
+// getJSON fetches a registry document, performing the token handshake if challenged
+func (c *registryClient) getJSON(ctx context.Context, ref *Reference, docURL, accept string, v interface{}) error {
+	resp, err := c.get(ctx, docURL, accept, "")
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		token, err := c.fetchToken(ctx, ref, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return err
+		}
+		resp, err = c.get(httputil.Retrying(ctx), docURL, accept, token)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrManifestNotFound
+	default:
+		return fmt.Errorf("registry %s returned %s for %s", ref.Registry, resp.Status, ref.String())
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode registry response for %s: %w", ref.String(), err)
+	}
+	return nil
+}
+
+func (c *registryClient) get(ctx context.Context, docURL, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// fetchToken performs the registry token handshake described by a Bearer challenge
+func (c *registryClient) fetchToken(ctx context.Context, ref *Reference, challenge string) (string, error) {
+	params := parseBearerChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry %s requires authentication but sent no token realm", ref.Registry)
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm '%s': %w", realm, err)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if c.registryAuth != nil {
+		authConfig, err := c.registryAuth.GetAuthConfig(ctx, ref.Registry)
+		if err != nil {
+			return "", err
+		}
+		if authConfig.Username != "" {
+			req.SetBasicAuth(authConfig.Username, authConfig.Password)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("registry token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token request to %s returned %s", tokenURL.Host, resp.Status)
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("failed to decode registry token response: %w", err)
+	}
+
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// parseBearerChallenge extracts key="value" pairs from a WWW-Authenticate Bearer header
+func parseBearerChallenge(header string) map[string]string {
+	params := make(map[string]string)
+
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(strings.ToLower(header), "bearer ") {
+		return params
+	}
+	header = header[len("bearer "):]
+
+	for header != "" {
+		eq := strings.Index(header, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(header[:eq])
+		header = header[eq+1:]
+
+		var value string
+		if strings.HasPrefix(header, `"`) {
+			end := strings.Index(header[1:], `"`)
+			if end < 0 {
+				value = header[1:]
+				header = ""
+			} else {
+				value = header[1 : end+1]
+				header = header[end+2:]
+			}
+		} else if comma := strings.Index(header, ","); comma >= 0 {
+			value = header[:comma]
+			header = header[comma:]
+		} else {
+			value = header
+			header = ""
+		}
+
+		params[strings.ToLower(key)] = value
+		header = strings.TrimLeft(header, ", ")
+	}
+
+	return params
+}
@@ -0,0 +1,37 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+// Runner executes shell commands on the machine whose containerd holds the cache
+type Runner interface {
+	Run(ctx context.Context, command string) (string, error)
+}
+
+// LocalRunner runs commands on the current machine (local mode)
+type LocalRunner struct{}
+
+// Run executes a command with bash and returns its combined output
+func (LocalRunner) Run(ctx context.Context, command string) (string, error) {
+	output, err := exec.CommandContext(ctx, "/bin/bash", "-c", command).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("command failed: %w", err)
+	}
+	return string(output), nil
+}
+
+// RunAsRoot runs script with bash as root on the runner's machine, with env
+// (NAME=value assignments) set, and returns its combined output. The script is
+// passed as a single quoted word, so only bash itself interprets it.
+func RunAsRoot(ctx context.Context, runner Runner, script string, env []string) (string, error) {
+	command := "sudo"
+	for _, assignment := range env {
+		command += " " + scripts.ShellQuote(assignment)
+	}
+	return runner.Run(ctx, command+" bash -c "+scripts.ShellQuote(script))
+}
@@ -0,0 +1,73 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+// detachedPulls numbers the detached pulls of this process
+var detachedPulls atomic.Uint64
+
+// DefaultRoot is containerd's root directory, where build VMs mount the cache disk
+const DefaultRoot = "/var/lib/containerd"
+
+// Store locates the containerd whose root directory is the cache disk
+type Store struct {
+	// Root is containerd's root directory, the cache disk's mount point
+	Root string
+
+	// Address is containerd's socket; empty for the default one
+	Address string
+}
+
+// Env returns the NAME=value assignments that point ctr at the store's containerd
+func (s Store) Env() []string {
+	if s.Address == "" {
+		return nil
+	}
+	return []string{"CONTAINERD_ADDRESS=" + s.Address}
+}
+
+// blobDir returns the blob directory of the store's content store
+func (s Store) blobDir() string {
+	root := s.Root
+	if root == "" {
+		root = DefaultRoot
+	}
+	return path.Join(root, "io.containerd.content.v1.content", "blobs")
+}
+
+// ctrCommand returns a ctr command in the k8s.io namespace run as root, with env set
+func ctrCommand(env []string, args string) string {
+	command := "sudo"
+	for _, assignment := range env {
+		command += " " + scripts.ShellQuote(assignment)
+	}
+	return command + " ctr -n k8s.io " + args
+}
+
+// detachedCtrCommand returns a command that runs ctr like ctrCommand, but as a
+// transient systemd service of its own that outlives the command: its output
+// goes to a file, printed once the service exits, whose exit status the
+// command returns. The unit is named after image, the time and a sequence
+// number, so retries of the same pull do not clash.
+func detachedCtrCommand(env []string, args, image string) string {
+	sum := sha256.Sum256([]byte(image))
+	unit := "gke-image-cache-pull-" + hex.EncodeToString(sum[:6]) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36) +
+		"-" + strconv.FormatUint(detachedPulls.Add(1), 10)
+	output := "/run/" + unit + ".log"
+
+	command := "sudo systemd-run --quiet --collect --wait --service-type=exec --unit=" + unit +
+		" -p StandardOutput=append:" + output + " -p StandardError=append:" + output
+	for _, assignment := range env {
+		command += " " + scripts.ShellQuote("--setenv="+assignment)
+	}
+	command += " ctr -n k8s.io " + args
+	return command + "; status=$?; sudo cat " + output + " 2>/dev/null; sudo rm -f " + output + "; exit $status"
+}
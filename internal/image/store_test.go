@@ -0,0 +1,42 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCtrCommand(t *testing.T) {
+	got := ctrCommand([]string{"CONTAINERD_ADDRESS=/run/x/containerd.sock"}, "images pull 'docker.io/library/nginx:latest'")
+	want := "sudo 'CONTAINERD_ADDRESS=/run/x/containerd.sock' ctr -n k8s.io images pull 'docker.io/library/nginx:latest'"
+	if got != want {
+		t.Errorf("ctrCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestDetachedCtrCommand(t *testing.T) {
+	env := []string{"HTTPS_PROXY=http://proxy:3128"}
+	first := detachedCtrCommand(env, "images pull 'docker.io/library/nginx:latest'", "nginx")
+	for _, want := range []string{
+		"sudo systemd-run --quiet --collect --wait",
+		"--unit=gke-image-cache-pull-",
+		"'--setenv=HTTPS_PROXY=http://proxy:3128'",
+		" ctr -n k8s.io images pull 'docker.io/library/nginx:latest';",
+		"exit $status",
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("detachedCtrCommand() = %q, missing %q", first, want)
+		}
+	}
+
+	// A retry of the same pull runs as a unit of its own
+	if second := detachedCtrCommand(env, "images pull 'docker.io/library/nginx:latest'", "nginx"); unitName(second) == unitName(first) {
+		t.Errorf("retries share unit %s", unitName(first))
+	}
+}
+
+// unitName returns the --unit of a detached command
+func unitName(command string) string {
+	_, unit, _ := strings.Cut(command, "--unit=")
+	unit, _, _ = strings.Cut(unit, " ")
+	return unit
+}
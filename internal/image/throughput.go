@@ -0,0 +1,70 @@
+package image
+
+import (
+	"sort"
+	"time"
+)
+
+// PullStats measures one image pull
+type PullStats struct {
+	Image    string
+	Registry string
+
+	// Bytes is the image's compressed size for the pulled platform as listed in
+	// the registry, 0 if unknown. Layers already in containerd are not
+	// downloaded again, so it can overstate what went over the wire.
+	Bytes int64
+
+	// Duration covers the successful ctr pull only, not waits for Docker Hub's
+	// rate limit or failed attempts
+	Duration time.Duration
+}
+
+// MBps returns the pull rate in MB/s (10^6 bytes), or 0 if it is unknown
+func (s PullStats) MBps() float64 {
+	return mbps(s.Bytes, s.Duration)
+}
+
+// RegistryThroughput is the combined pull rate of the images from one registry
+type RegistryThroughput struct {
+	Registry string
+	Images   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// MBps returns the registry's pull rate in MB/s (10^6 bytes), or 0 if it is unknown
+func (t RegistryThroughput) MBps() float64 {
+	return mbps(t.Bytes, t.Duration)
+}
+
+// ByRegistry combines the pulls of known size per registry, sorted by registry.
+// Pulls run in parallel, so a registry's rate is its bytes over the summed
+// pull durations: what a single pull from it achieved on average.
+func ByRegistry(stats []PullStats) []RegistryThroughput {
+	index := make(map[string]int)
+	var registries []RegistryThroughput
+	for _, s := range stats {
+		if s.Bytes == 0 || s.Duration <= 0 {
+			continue
+		}
+		i, ok := index[s.Registry]
+		if !ok {
+			i = len(registries)
+			index[s.Registry] = i
+			registries = append(registries, RegistryThroughput{Registry: s.Registry})
+		}
+		registries[i].Images++
+		registries[i].Bytes += s.Bytes
+		registries[i].Duration += s.Duration
+	}
+	sort.Slice(registries, func(i, j int) bool { return registries[i].Registry < registries[j].Registry })
+	return registries
+}
+
+func mbps(bytes int64, duration time.Duration) float64 {
+	if bytes == 0 || duration <= 0 {
+		return 0
+	}
+	return float64(bytes) / 1e6 / duration.Seconds()
+}
@@ -0,0 +1,317 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+// blobCheckScript reads "<algorithm>:<hex> <size>" lines and reports blobs under
+// $BLOBS that are missing, have the wrong size or, with DEEP=1, do not hash to their digest
+const blobCheckScript = `cd "$BLOBS" || exit 1
+while read -r digest size; do
+  file="${digest%%:*}/${digest#*:}"
+  if [ ! -f "$file" ]; then
+    echo "MISSING $digest"
+  elif [ "$(stat -c %s "$file")" != "$size" ]; then
+    echo "SIZE $digest $(stat -c %s "$file")"
+  elif [ "$DEEP" = 1 ] && [ "$(sha256sum < "$file" | cut -d' ' -f1)" != "${digest#*:}" ]; then
+    echo "CORRUPT $digest"
+  fi
+done`
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// descriptor references a blob in the content store
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// manifest covers both image manifests and indexes (manifest lists)
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    *descriptor  `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// VerifyLayers walks each cached image's manifest and confirms that every referenced
+// blob is present in the store's content store, on the cache disk, with the expected size. With
+// recomputeDigests, blob contents are also hashed and compared to their digest.
+// Up to parallel images are walked, and blob batches checked, at a time; the
+// problems of all images are reported together.
+func (c *Cache) VerifyLayers(ctx context.Context, runner Runner, store Store, images []string, recomputeDigests bool, parallel int) error {
+	if parallel > 1 {
+		c.logger.Infof("Verifying content blobs of %d images, %d at a time...", len(images), parallel)
+	} else {
+		c.logger.Infof("Verifying content blobs of %d images...", len(images))
+	}
+
+	refs := make([]string, len(images))
+	for i, img := range images {
+		ref, err := ParseReference(img)
+		if err != nil {
+			return err
+		}
+		refs[i] = ref.String()
+	}
+
+	targets, err := listImageTargets(ctx, runner, store.Env())
+	if err != nil {
+		return err
+	}
+
+	// Each image's manifests are read with a command of their own
+	imageBlobs := make([][]descriptor, len(images))
+	imageProblems := make([]string, len(images))
+	forEachParallel(len(images), parallel, func(i int) {
+		target, ok := targets[refs[i]]
+		if !ok {
+			imageProblems[i] = fmt.Sprintf("%s: image not found in containerd", images[i])
+			return
+		}
+		blobs, err := c.collectBlobs(ctx, runner, store.Env(), target)
+		if err != nil {
+			imageProblems[i] = fmt.Sprintf("%s: %v", images[i], err)
+			return
+		}
+		imageBlobs[i] = blobs
+	})
+
+	var problems []string
+	owners := make(map[string][]string) // blob digest -> images referencing it
+	var blobs []descriptor
+	for i, img := range images {
+		if imageProblems[i] != "" {
+			problems = append(problems, imageProblems[i])
+			continue
+		}
+		for _, blob := range imageBlobs[i] {
+			if _, seen := owners[blob.Digest]; !seen {
+				blobs = append(blobs, blob)
+			}
+			owners[blob.Digest] = append(owners[blob.Digest], img)
+		}
+	}
+
+	results, err := checkBlobs(ctx, runner, store.blobDir(), blobs, recomputeDigests, parallel)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		for _, img := range owners[result.digest] {
+			problems = append(problems, fmt.Sprintf("%s: blob %s %s", img, result.digest, result.problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d content problems found:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+
+	c.logger.Infof("Verified %d content blobs", len(blobs))
+	return nil
+}
+
+// listImageTargets maps each image reference in containerd to its target digest
+func listImageTargets(ctx context.Context, runner Runner, env []string) (map[string]string, error) {
+	output, err := runner.Run(ctx, ctrCommand(env, "images ls"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	targets := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		// REF TYPE DIGEST SIZE PLATFORMS LABELS
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && digestPattern.MatchString(fields[2]) {
+			targets[fields[0]] = fields[2]
+		}
+	}
+	return targets, nil
+}
+
+// collectBlobs returns the manifest, config and layer blobs an image target references.
+// For an index, only the platform manifests present locally are walked, since a
+// pull fetches just the platform it needs.
+func (c *Cache) collectBlobs(ctx context.Context, runner Runner, env []string, target string) ([]descriptor, error) {
+	m, size, err := readManifest(ctx, runner, env, target)
+	if err != nil {
+		return nil, err
+	}
+	blobs := []descriptor{{Digest: target, Size: size}}
+
+	manifests := []manifest{*m}
+	if len(m.Manifests) > 0 {
+		manifests = nil
+		for _, child := range m.Manifests {
+			childManifest, _, err := readManifest(ctx, runner, env, child.Digest)
+			if err != nil {
+				continue
+			}
+			blobs = append(blobs, child)
+			manifests = append(manifests, *childManifest)
+		}
+		if len(manifests) == 0 {
+			return nil, fmt.Errorf("no platform manifest of index %s is present", target)
+		}
+	}
+
+	for _, platform := range manifests {
+		if platform.Config != nil {
+			blobs = append(blobs, *platform.Config)
+		}
+		blobs = append(blobs, platform.Layers...)
+	}
+
+	for _, blob := range blobs {
+		if !digestPattern.MatchString(blob.Digest) {
+			return nil, fmt.Errorf("unsupported blob digest %q", blob.Digest)
+		}
+	}
+	return blobs, nil
+}
+
+func readManifest(ctx context.Context, runner Runner, env []string, digest string) (*manifest, int64, error) {
+	if !digestPattern.MatchString(digest) {
+		return nil, 0, fmt.Errorf("unsupported manifest digest %q", digest)
+	}
+
+	output, err := runner.Run(ctx, ctrCommand(env, "content get "+digest))
+	if err != nil {
+		return nil, 0, fmt.Errorf("manifest %s missing from content store", digest)
+	}
+
+	var m manifest
+	if err := json.Unmarshal([]byte(output), &m); err != nil {
+		return nil, 0, fmt.Errorf("manifest %s is corrupt: %w", digest, err)
+	}
+	return &m, int64(len(output)), nil
+}
+
+type blobResult struct {
+	digest  string
+	problem string
+}
+
+// checkBlobs runs blobCheckScript over the blobs in blobDir, split into up to
+// parallel batches checked by concurrent commands. The results of every batch
+// are returned, in the order of blobs, unless a batch could not be checked.
+func checkBlobs(ctx context.Context, runner Runner, blobDir string, blobs []descriptor, deep bool, parallel int) ([]blobResult, error) {
+	if len(blobs) == 0 {
+		return nil, nil
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	if parallel > len(blobs) {
+		parallel = len(blobs)
+	}
+
+	// Consecutive batches, so that the results keep the order of blobs
+	batchSize := (len(blobs) + parallel - 1) / parallel
+	var batches [][]descriptor
+	for start := 0; start < len(blobs); start += batchSize {
+		end := start + batchSize
+		if end > len(blobs) {
+			end = len(blobs)
+		}
+		batches = append(batches, blobs[start:end])
+	}
+
+	batchResults := make([][]blobResult, len(batches))
+	batchErrs := make([]error, len(batches))
+	forEachParallel(len(batches), len(batches), func(i int) {
+		batchResults[i], batchErrs[i] = checkBlobBatch(ctx, runner, blobDir, batches[i], deep)
+	})
+
+	var results []blobResult
+	for i := range batches {
+		if batchErrs[i] != nil {
+			return nil, batchErrs[i]
+		}
+		results = append(results, batchResults[i]...)
+	}
+	return results, nil
+}
+
+// checkBlobBatch runs blobCheckScript over blobs in a single command
+func checkBlobBatch(ctx context.Context, runner Runner, blobDir string, blobs []descriptor, deep bool) ([]blobResult, error) {
+	lines := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		lines = append(lines, fmt.Sprintf("%s %d", blob.Digest, blob.Size))
+	}
+
+	deepFlag := "0"
+	if deep {
+		deepFlag = "1"
+	}
+	command := fmt.Sprintf("printf '%%s\\n' %s | sudo BLOBS=%s DEEP=%s sh -c %s",
+		scripts.ShellQuote(strings.Join(lines, "\n")), scripts.ShellQuote(blobDir), deepFlag, scripts.ShellQuote(blobCheckScript))
+
+	output, err := runner.Run(ctx, command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check content blobs: %w", err)
+	}
+
+	var results []blobResult
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MISSING":
+			results = append(results, blobResult{fields[1], "is missing"})
+		case "SIZE":
+			actual := "?"
+			if len(fields) > 2 {
+				actual = fields[2]
+			}
+			results = append(results, blobResult{fields[1], "has size " + actual + " (expected " + expectedSize(blobs, fields[1]) + ")"})
+		case "CORRUPT":
+			results = append(results, blobResult{fields[1], "content does not match its digest"})
+		}
+	}
+	return results, nil
+}
+
+// forEachParallel calls fn for 0..n-1 with at most parallel calls running at a
+// time, and returns when all of them have
+func forEachParallel(n, parallel int, fn func(i int)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(index int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			fn(index)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func expectedSize(blobs []descriptor, digest string) string {
+	for _, blob := range blobs {
+		if blob.Digest == digest {
+			return fmt.Sprintf("%d", blob.Size)
+		}
+	}
+	return "?"
+}
@@ -0,0 +1,134 @@
+// Package retry bounds the retries of a whole build. Individual operations keep
+// their own retry loops, but every retried failure is charged to one Budget
+// shared through the context, so a systemically broken environment (wrong
+// project, missing permissions, no network) fails fast with a diagnosis
+// instead of retrying each call until the build times out.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// breakerThreshold is the number of consecutive permission failures of one
+// operation that trips the circuit breaker. It leaves room for failures that
+// are expected briefly, such as SSH before the guest agent installed the key.
+const breakerThreshold = 8
+
+// ErrPermission marks a failure as an authentication or authorization error
+var ErrPermission = errors.New("permission denied")
+
+// Permission marks err as an authentication or authorization failure, for
+// errors the Budget cannot recognize itself (Google API 401 and 403 it can)
+func Permission(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermission, err)
+}
+
+// Budget counts the retried failures of a build. It is safe for concurrent use,
+// and all methods are no-ops on a nil Budget.
+type Budget struct {
+	mu          sync.Mutex
+	limit       int
+	used        int
+	consecutive map[string]int // operation -> consecutive permission failures
+	stopped     error          // set once the budget is spent or the breaker tripped
+}
+
+// NewBudget returns a budget allowing limit retried failures
+func NewBudget(limit int) *Budget {
+	return &Budget{limit: limit, consecutive: make(map[string]int)}
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context carrying the budget
+func WithBudget(ctx context.Context, budget *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// FromContext returns the budget carried by ctx, or nil
+func FromContext(ctx context.Context) *Budget {
+	budget, _ := ctx.Value(budgetKey{}).(*Budget)
+	return budget
+}
+
+// Retry charges a failed attempt of operation that is about to be retried.
+// It returns nil to go ahead, or an error explaining why the build should stop
+// instead: the budget is spent, or operation keeps failing with permission
+// errors. Once stopped, every later Retry returns the same error.
+func (b *Budget) Retry(operation string, err error) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped != nil {
+		return b.stopped
+	}
+
+	b.used++
+	if isPermission(err) {
+		b.consecutive[operation]++
+	} else {
+		delete(b.consecutive, operation)
+	}
+
+	switch {
+	case b.consecutive[operation] >= breakerThreshold:
+		b.stopped = fmt.Errorf("stopping after %d consecutive authentication or permission errors from %s: %w. "+
+			"Retrying does not clear this up: check the project, the credentials in use and their permissions",
+			b.consecutive[operation], operation, err)
+	case b.used > b.limit:
+		b.stopped = fmt.Errorf("retry budget exhausted: more than %d failures needed retrying in this build, the last from %s: %w. "+
+			"Failures this widespread point to a problem with the environment (project, network, quota) "+
+			"rather than a transient one; raise --retry-budget if it is only slow", b.limit, operation, err)
+	}
+	return b.stopped
+}
+
+// Succeeded resets the consecutive permission failures of operation
+func (b *Budget) Succeeded(operation string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.consecutive, operation)
+}
+
+// Used returns the number of retried failures charged so far
+func (b *Budget) Used() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// isPermission recognizes authentication and authorization failures: marked
+// errors and Google API 401/403 responses other than rate limiting
+func isPermission(err error) bool {
+	if errors.Is(err, ErrPermission) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code != http.StatusUnauthorized && apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestBudgetConcurrentRetries(t *testing.T) {
+	const limit, workers, attempts = 50, 8, 10
+	budget := NewBudget(limit)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			operation := fmt.Sprintf("operation-%d", worker)
+			for j := 0; j < attempts; j++ {
+				if err := budget.Retry(operation, errors.New("unavailable")); err != nil {
+					return
+				}
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+				budget.Succeeded(operation)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Errorf("%d retries allowed, want %d", allowed, limit)
+	}
+	if err := budget.Retry("late", errors.New("unavailable")); err == nil {
+		t.Error("Retry() after the budget is spent = nil, want an error")
+	}
+}
+
+func TestBudgetBreakerTrips(t *testing.T) {
+	budget := NewBudget(100)
+	denied := &googleapi.Error{Code: http.StatusForbidden}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, breakerThreshold)
+	for i := 0; i < breakerThreshold; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- budget.Retry("disks.insert", denied)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	tripped := 0
+	for err := range errs {
+		if err != nil {
+			tripped++
+		}
+	}
+	if tripped != 1 {
+		t.Errorf("%d retries stopped by the breaker, want the last one", tripped)
+	}
+	if budget.Used() != breakerThreshold {
+		t.Errorf("Used() = %d, want %d", budget.Used(), breakerThreshold)
+	}
+}
+
+func TestBudgetRateLimitIsNotPermission(t *testing.T) {
+	budget := NewBudget(100)
+	limited := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}
+	for i := 0; i < 2*breakerThreshold; i++ {
+		if err := budget.Retry("disks.insert", limited); err != nil {
+			t.Fatalf("Retry() of a rate limited request = %v, want nil", err)
+		}
+	}
+}
+
+func TestNilBudget(t *testing.T) {
+	var budget *Budget
+	if err := budget.Retry("op", errors.New("x")); err != nil || budget.Used() != 0 {
+		t.Errorf("nil budget: Retry() = %v, Used() = %d", err, budget.Used())
+	}
+}
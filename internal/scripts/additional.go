@@ -0,0 +1,59 @@
+package scripts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Markers in bootstrap.sh where additional startup scripts are inserted
+const (
+	additionalBeforeMarker = "# @additional-startup-scripts:before"
+	additionalAfterMarker  = "# @additional-startup-scripts:after"
+)
+
+// AdditionalScript is a user script the Linux build VM's startup script runs
+// besides its own steps, e.g. to install an agent an organization requires
+type AdditionalScript struct {
+	Name    string // shown in logs, e.g. the file's base name
+	Content string
+}
+
+// GetBootstrapScriptWith returns the bootstrap script running additional scripts
+// in order, before its own steps or, with after, once they are done but before
+// the VM reports that it is bootstrapped. Each script runs in its own bash
+// process from a delimited section; one that fails is logged and recorded, and
+// the bootstrap continues.
+func GetBootstrapScriptWith(additional []AdditionalScript, after bool) string {
+	if len(additional) == 0 {
+		return bootstrapScript
+	}
+
+	var section strings.Builder
+	for i, script := range additional {
+		label := fmt.Sprintf("%d/%d: %s", i+1, len(additional), script.Name)
+		// A delimiter derived from the content cannot occur in it
+		sum := sha256.Sum256([]byte(script.Content))
+		delimiter := "ADDITIONAL_SCRIPT_" + hex.EncodeToString(sum[:8])
+		content := script.Content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+
+		fmt.Fprintf(&section, "# ===== BEGIN additional startup script %s =====\n", label)
+		fmt.Fprintf(&section, "log_info %s\n", ShellQuote("Running additional startup script "+label))
+		// Passed with -c rather than on stdin, which the script may read itself
+		fmt.Fprintf(&section, "if ! /bin/bash -c \"$(cat <<'%s'\n%s%s\n)\"\nthen\n", delimiter, content, delimiter)
+		fmt.Fprintf(&section, "    log_error %s\n", ShellQuote("Additional startup script "+label+" failed, continuing"))
+		fmt.Fprintf(&section, "    ADDITIONAL_SCRIPT_FAILURES=\"$ADDITIONAL_SCRIPT_FAILURES \"%s\n", ShellQuote(script.Name))
+		fmt.Fprintf(&section, "fi\n")
+		fmt.Fprintf(&section, "# ===== END additional startup script %s =====\n", label)
+	}
+
+	marker := additionalBeforeMarker
+	if after {
+		marker = additionalAfterMarker
+	}
+	return strings.Replace(bootstrapScript, marker, strings.TrimSuffix(section.String(), "\n"), 1)
+}
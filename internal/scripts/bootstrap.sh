@@ -0,0 +1,198 @@
+#!/bin/bash
+# GKE Image Cache Builder - Build VM Bootstrap Script
+# Runs as the startup-script. It only prepares what the builder needs before it
+# can connect over SSH; the full setup script is then uploaded and run over SSH.
+
+set -e
+
+METADATA_URL="http://metadata.google.internal/computeMetadata/v1"
+GUEST_ATTR_NAMESPACE="gke-image-cache-builder"
+
+log_info() {
+    echo "[INFO] $(date '+%Y-%m-%d %H:%M:%S') - $1"
+}
+
+log_error() {
+    echo "[ERROR] $(date '+%Y-%m-%d %H:%M:%S') - $1" >&2
+}
+
+# Report progress to the builder through guest attributes
+publish_status() {
+    curl -s -X PUT --data "$2" -H "Metadata-Flavor: Google" \
+        "${METADATA_URL}/instance/guest-attributes/${GUEST_ATTR_NAMESPACE}/$1" >/dev/null 2>&1 || true
+}
+
+# Publish SSH host keys where guest agents do, so the builder can pin them
+publish_host_keys() {
+    local pub type key
+    for pub in /etc/ssh/ssh_host_*_key.pub; do
+        [ -f "$pub" ] || continue
+        read -r type key _ < "$pub"
+        curl -s -X PUT --data "$type $key" -H "Metadata-Flavor: Google" \
+            "${METADATA_URL}/instance/guest-attributes/hostkeys/${type}" >/dev/null 2>&1 || true
+    done
+}
+
+get_metadata_attribute() {
+    curl -sf -H "Metadata-Flavor: Google" "${METADATA_URL}/instance/attributes/$1" 2>/dev/null || true
+}
+
+# Check that an HTTPS endpoint answers at all (any HTTP status counts as reachable)
+check_endpoint() {
+    local code
+    code=$(curl -s -o /dev/null -I --max-time 10 -w '%{http_code}' "$1" || true)
+    [ -n "$code" ] && [ "$code" != "000" ]
+}
+
+# Verify egress to the metadata server, Cloud Storage, every image registry and,
+# with --cloud-logging, Cloud Logging
+check_connectivity() {
+    log_info "Checking network connectivity to required endpoints..."
+
+    local failures=""
+
+    if ! curl -sf --max-time 5 -H "Metadata-Flavor: Google" "${METADATA_URL}/instance/id" >/dev/null 2>&1; then
+        failures="$failures metadata.google.internal"
+    fi
+
+    if ! check_endpoint "https://storage.googleapis.com"; then
+        failures="$failures storage.googleapis.com"
+    fi
+
+    if [ -n "$(get_metadata_attribute "cloud-logging-run-id")" ] && ! check_endpoint "https://logging.googleapis.com"; then
+        failures="$failures logging.googleapis.com"
+    fi
+
+    for registry in $(get_metadata_attribute "registries"); do
+        if ! check_endpoint "https://${registry}/v2/"; then
+            failures="$failures $registry"
+        fi
+    done
+
+    if [ -n "$failures" ]; then
+        publish_status "connectivity-failures" "${failures# }"
+        publish_status "connectivity" "failed"
+        log_error "Cannot reach:${failures}"
+        return 1
+    fi
+
+    publish_status "connectivity" "ok"
+}
+
+# Ship the build log to Cloud Logging through the Ops Agent when the builder
+# asked for it (--cloud-logging): entries of the build's run carry its run ID.
+# An agent configuration of the boot image (e.g. a hardened one) is kept: the
+# build log's receiver, processor and pipeline are merged into it.
+BUILD_LOG_DIR="/var/log/gke-image-cache-builder"
+CLOUD_LOG_NAME="gke_image_cache_builder"
+OPS_AGENT_CONFIG="/etc/google-cloud-ops-agent/config.yaml"
+setup_cloud_logging() {
+    local run_id
+    run_id=$(get_metadata_attribute "cloud-logging-run-id")
+    [ -n "$run_id" ] || return 0
+
+    log_info "Shipping the build log to Cloud Logging (run ID $run_id)..."
+    # Boot images with the Ops Agent preinstalled only need the configuration
+    if ! [ -d /etc/google-cloud-ops-agent ]; then
+        if ! (cd /tmp && curl -sSfO https://dl.google.com/cloudagents/add-google-cloud-ops-agent-repo.sh &&
+            bash add-google-cloud-ops-agent-repo.sh --also-install); then
+            log_error "Failed to install the Ops Agent, the build log stays on the VM"
+            publish_status "cloud-logging" "failed"
+            return 0
+        fi
+    fi
+
+    if [ -s "$OPS_AGENT_CONFIG" ] && grep -qvE '^[[:space:]]*(#|$)' "$OPS_AGENT_CONFIG"; then
+        if ! merge_ops_agent_config "$run_id"; then
+            log_error "Failed to add the build log to the Ops Agent configuration of the boot image, the build log stays on the VM"
+            publish_status "cloud-logging" "failed"
+            return 0
+        fi
+    else
+        cat > "$OPS_AGENT_CONFIG" << EOF
+logging:
+  receivers:
+    ${CLOUD_LOG_NAME}:
+      type: files
+      include_paths: [${BUILD_LOG_DIR}/*.log]
+  processors:
+    ${CLOUD_LOG_NAME}_run:
+      type: modify_fields
+      fields:
+        labels."run_id":
+          static_value: "${run_id}"
+  service:
+    pipelines:
+      ${CLOUD_LOG_NAME}:
+        receivers: [${CLOUD_LOG_NAME}]
+        processors: [${CLOUD_LOG_NAME}_run]
+EOF
+    fi
+    if ! systemctl restart google-cloud-ops-agent; then
+        log_error "Failed to start the Ops Agent, the build log stays on the VM"
+        publish_status "cloud-logging" "failed"
+        return 0
+    fi
+    publish_status "cloud-logging" "ok"
+}
+
+# Add the build log's receiver, processor and pipeline to an existing Ops Agent
+# configuration, leaving the rest of it alone
+merge_ops_agent_config() {
+    python3 - "$OPS_AGENT_CONFIG" "$CLOUD_LOG_NAME" "$BUILD_LOG_DIR" "$1" << 'EOF'
+import sys
+import yaml
+
+path, name, log_dir, run_id = sys.argv[1:]
+with open(path) as f:
+    config = yaml.safe_load(f) or {}
+
+
+def section(parent, key):
+    parent[key] = parent.get(key) or {}
+    return parent[key]
+
+
+logging = section(config, "logging")
+section(logging, "receivers")[name] = {"type": "files", "include_paths": [log_dir + "/*.log"]}
+section(logging, "processors")[name + "_run"] = {
+    "type": "modify_fields",
+    "fields": {'labels."run_id"': {"static_value": run_id}},
+}
+section(section(logging, "service"), "pipelines")[name] = {"receivers": [name], "processors": [name + "_run"]}
+with open(path, "w") as f:
+    yaml.safe_dump(config, f, default_flow_style=False, sort_keys=False)
+EOF
+}
+
+# Mirror the log to the serial port the builder was told to read, if not port 1
+serial_port=$(get_metadata_attribute "serial-port")
+if [ -n "$serial_port" ] && [ "$serial_port" -gt 1 ] && [ -w "/dev/ttyS$((serial_port - 1))" ]; then
+    exec > >(tee -a "/dev/ttyS$((serial_port - 1))") 2>&1
+fi
+
+# With --cloud-logging the bootstrap log, additional startup scripts included,
+# is the first part of the build log
+if [ -n "$(get_metadata_attribute "cloud-logging-run-id")" ]; then
+    mkdir -p "$BUILD_LOG_DIR"
+    exec > >(tee -a "${BUILD_LOG_DIR}/build.log") 2>&1
+fi
+
+trap 'publish_status "bootstrap" "failed"; exit 1' ERR
+
+# Additional startup scripts (--additional-startup-script) are inserted at one
+# of these markers; a failing one is reported in additional-script-failures
+ADDITIONAL_SCRIPT_FAILURES=""
+# @additional-startup-scripts:before
+
+log_info "Bootstrapping GKE Image Cache Builder VM"
+publish_host_keys
+check_connectivity
+setup_cloud_logging
+
+# @additional-startup-scripts:after
+
+if [ -n "$ADDITIONAL_SCRIPT_FAILURES" ]; then
+    publish_status "additional-script-failures" "${ADDITIONAL_SCRIPT_FAILURES# }"
+fi
+publish_status "bootstrap" "done"
@@ -1,36 +1,64 @@
 package scripts
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 )
 
 //go:embed setup-and-verify.sh
 var setupScript string
 
-// ExecuteSetupScript writes the embedded script to a temporary file and executes it
+//go:embed bootstrap.sh
+var bootstrapScript string
+
+//go:embed windows-setup.ps1
+var windowsSetupScript string
+
+//go:embed smoke-test.sh
+var smokeTestScript string
+
+// ExecuteSetupScript writes the embedded script to a temporary file and executes it.
+// Each call uses its own file, readable only by the current user, so concurrent
+// runs cannot replace or remove each other's script.
 func ExecuteSetupScript() error {
-	// Create temporary file
-	tmpDir := os.TempDir()
-	scriptPath := filepath.Join(tmpDir, "gke-setup-and-verify.sh")
+	file, err := os.CreateTemp("", "gke-setup-and-verify-*.sh")
+	if err != nil {
+		return fmt.Errorf("failed to create setup script: %w", err)
+	}
+	scriptPath := file.Name()
+	defer os.Remove(scriptPath)
 
 	// Write embedded script to temporary file
-	if err := os.WriteFile(scriptPath, []byte(setupScript), 0755); err != nil {
+	if err := file.Chmod(0700); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write setup script: %w", err)
+	}
+	if _, err := file.WriteString(setupScript); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write setup script: %w", err)
+	}
+	if err := file.Close(); err != nil {
 		return fmt.Errorf("failed to write setup script: %w", err)
 	}
 
-	// Ensure cleanup
-	defer os.Remove(scriptPath)
+	// An interrupt stops the script instead of the process, so the file is still removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Execute the script
-	cmd := exec.Command("/bin/bash", scriptPath)
+	cmd := exec.CommandContext(ctx, "/bin/bash", scriptPath)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("setup script interrupted: %w", err)
+		}
 		return fmt.Errorf("setup script execution failed: %w", err)
 	}
 
@@ -42,6 +70,21 @@ func GetSetupScript() string {
 	return setupScript
 }
 
+// GetBootstrapScript returns the startup script that readies Linux build VMs for SSH
+func GetBootstrapScript() string {
+	return bootstrapScript
+}
+
+// GetWindowsSetupScript returns the embedded PowerShell setup script for Windows build VMs
+func GetWindowsSetupScript() string {
+	return windowsSetupScript
+}
+
+// GetSmokeTestScript returns the startup script that checks a cache image the way a node would
+func GetSmokeTestScript() string {
+	return smokeTestScript
+}
+
 // WriteSetupScriptToFile writes the embedded script to a specified file path
 func WriteSetupScriptToFile(filePath string) error {
 	return os.WriteFile(filePath, []byte(setupScript), 0755)
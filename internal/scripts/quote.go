@@ -0,0 +1,8 @@
+package scripts
+
+import "strings"
+
+// ShellQuote quotes a value for safe use as a single shell word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -1,20 +1,34 @@
 #!/bin/bash
 # GKE Image Cache Builder - VM Setup and Verification Script
-# This script is embedded in the binary and executed at runtime
+# This script is embedded in the binary. On build VMs it is uploaded over SSH
+# and run as root once bootstrap.sh has published the host keys and checked
+# network connectivity.
 
 set -e
 
 # Configuration
-CONTAINERD_VERSION="1.6.6"
+CONTAINERD_PINNED="${CONTAINERD_VERSION:+true}"  # set when the builder pins a release
+CONTAINERD_VERSION="${CONTAINERD_VERSION:-1.6.6}"
 RUNC_VERSION="1.1.4"
 CNI_VERSION="1.1.1"
+STARGZ_VERSION="0.15.1"
+SNAPSHOTTER="${SNAPSHOTTER:-overlayfs}"  # overlayfs, native or stargz; must match the nodes
+CACHE_DEVICE="${CACHE_DEVICE:-}"  # device name of the cache disk, mounted as containerd's root
+CONTAINERD_ROOT="/var/lib/containerd"
+ARCH="$(dpkg --print-architecture)"  # amd64 or arm64, as used in release asset names
+METADATA_URL="http://metadata.google.internal/computeMetadata/v1"
+GUEST_ATTR_NAMESPACE="gke-image-cache-builder"
 
-# Colors for output
-RED='\033[0;31m'
-GREEN='\033[0;32m'
-YELLOW='\033[1;33m'
-BLUE='\033[0;34m'
-NC='\033[0m' # No Color
+# Colors for output, left out when NO_COLOR is set or stdout is not a terminal
+if [[ -z "${NO_COLOR:-}" && -t 1 ]]; then
+    RED='\033[0;31m'
+    GREEN='\033[0;32m'
+    YELLOW='\033[1;33m'
+    BLUE='\033[0;34m'
+    NC='\033[0m' # No Color
+else
+    RED='' GREEN='' YELLOW='' BLUE='' NC=''
+fi
 
 # Logging functions
 log_info() {
@@ -33,25 +47,45 @@ log_error() {
     echo -e "${RED}[ERROR]${NC} $(date '+%Y-%m-%d %H:%M:%S') - $1" >&2
 }
 
+# Report progress to the builder through guest attributes
+publish_status() {
+    curl -s -X PUT --data "$2" -H "Metadata-Flavor: Google" \
+        "${METADATA_URL}/instance/guest-attributes/${GUEST_ATTR_NAMESPACE}/$1" >/dev/null 2>&1 || true
+}
+
 # Error handling
 cleanup_on_error() {
     log_error "Script failed. Performing cleanup..."
+    publish_status "setup" "failed"
     exit 1
 }
 
 trap cleanup_on_error ERR
 
+# BUILD_LOG appends the output to the build log shipped to Cloud Logging (--cloud-logging)
+if [ -n "${BUILD_LOG:-}" ]; then
+    mkdir -p "$(dirname "$BUILD_LOG")"
+    exec > >(tee -a "$BUILD_LOG") 2>&1
+fi
+
 # Main execution
 main() {
     log_info "Starting GKE Image Cache Builder VM setup and verification"
+    publish_status "setup" "running"
     
     # Step 1: System preparation
     prepare_system
     
+    # Step 1a: Put containerd's root on the cache disk, so the images land on it
+    prepare_cache_disk
+    
     # Step 2: Install containerd if not present
     install_containerd
     
     # Step 3: Configure containerd for image caching
+    if [ "$SNAPSHOTTER" = "stargz" ]; then
+        install_stargz
+    fi
     configure_containerd
     
     # Step 4: Verify installation
@@ -61,6 +95,7 @@ main() {
     setup_cache_environment
     
     log_success "VM setup and verification completed successfully"
+    publish_status "setup" "done"
 }
 
 # System preparation
@@ -88,6 +123,46 @@ prepare_system() {
     log_success "System preparation completed"
 }
 
+# Format the cache disk unless it holds a filesystem already and mount it as
+# containerd's root, before containerd writes anything there. The image is
+# captured from this filesystem, so nodes find the content store at its root.
+prepare_cache_disk() {
+    if [ -z "$CACHE_DEVICE" ]; then
+        log_error "No cache disk given (CACHE_DEVICE)"
+        return 1
+    fi
+    local dev="/dev/disk/by-id/google-${CACHE_DEVICE}"
+    log_info "Mounting cache disk $dev at $CONTAINERD_ROOT..."
+    
+    local i
+    for i in $(seq 1 30); do [ -e "$dev" ] && break; sleep 2; done
+    if [ ! -e "$dev" ]; then
+        log_error "Cache disk $dev did not appear"
+        return 1
+    fi
+    
+    if mountpoint -q "$CONTAINERD_ROOT"; then
+        log_info "$CONTAINERD_ROOT is already mounted"
+        return 0
+    fi
+    
+    # A containerd shipped with the image must not keep writing to the boot disk
+    if systemctl is-active --quiet containerd; then
+        systemctl stop containerd
+    fi
+    
+    if [ -z "$(blkid -o value -s TYPE "$dev" || true)" ]; then
+        log_info "Formatting cache disk as ext4..."
+        mkfs.ext4 -q -F -m 0 -E lazy_itable_init=0,lazy_journal_init=0,discard "$dev"
+    fi
+    
+    mkdir -p "$CONTAINERD_ROOT"
+    mount -o discard,defaults "$dev" "$CONTAINERD_ROOT"
+    echo "UUID=$(blkid -o value -s UUID "$dev") $CONTAINERD_ROOT ext4 discard,defaults,nofail 0 2" >> /etc/fstab
+    
+    log_success "Cache disk mounted at $CONTAINERD_ROOT"
+}
+
 # Install containerd if not already installed
 install_containerd() {
     log_info "Checking containerd installation..."
@@ -96,8 +171,16 @@ install_containerd() {
         local current_version=$(containerd --version | awk '{print $3}' | sed 's/v//')
         log_info "containerd is already installed (version: $current_version)"
         
-        # Check if version is acceptable
-        if version_ge "$current_version" "$CONTAINERD_VERSION"; then
+        # A pinned release must match exactly; otherwise any newer version will do
+        if [ "$CONTAINERD_PINNED" = "true" ]; then
+            if [ "$current_version" = "$CONTAINERD_VERSION" ]; then
+                log_success "containerd version matches the pinned release"
+                return 0
+            fi
+            log_warn "containerd $current_version differs from the pinned release, replacing it..."
+            systemctl stop containerd || true
+            remove_distro_containerd
+        elif version_ge "$current_version" "$CONTAINERD_VERSION"; then
             log_success "containerd version is acceptable"
             return 0
         else
@@ -108,19 +191,20 @@ install_containerd() {
     log_info "Installing containerd $CONTAINERD_VERSION..."
     
     # Download and install containerd
-    wget -q "https://github.com/containerd/containerd/releases/download/v${CONTAINERD_VERSION}/containerd-${CONTAINERD_VERSION}-linux-amd64.tar.gz"
-    tar Cxzvf /usr/local "containerd-${CONTAINERD_VERSION}-linux-amd64.tar.gz"
-    rm "containerd-${CONTAINERD_VERSION}-linux-amd64.tar.gz"
+    download "https://github.com/containerd/containerd/releases/download/v${CONTAINERD_VERSION}/containerd-${CONTAINERD_VERSION}-linux-${ARCH}.tar.gz" \
+        "containerd ${CONTAINERD_VERSION} has no linux-${ARCH} release"
+    tar Cxzvf /usr/local "containerd-${CONTAINERD_VERSION}-linux-${ARCH}.tar.gz"
+    rm "containerd-${CONTAINERD_VERSION}-linux-${ARCH}.tar.gz"
     
     # Install runc
-    wget -q "https://github.com/opencontainers/runc/releases/download/v${RUNC_VERSION}/runc.amd64"
-    install -m 755 runc.amd64 /usr/local/sbin/runc
-    rm runc.amd64
+    download "https://github.com/opencontainers/runc/releases/download/v${RUNC_VERSION}/runc.${ARCH}"
+    install -m 755 runc.${ARCH} /usr/local/sbin/runc
+    rm runc.${ARCH}
     
     # Install CNI plugins
-    wget -q "https://github.com/containernetworking/plugins/releases/download/v${CNI_VERSION}/cni-plugins-linux-amd64-v${CNI_VERSION}.tgz"
-    tar Cxzvf /opt/cni/bin "cni-plugins-linux-amd64-v${CNI_VERSION}.tgz"
-    rm "cni-plugins-linux-amd64-v${CNI_VERSION}.tgz"
+    download "https://github.com/containernetworking/plugins/releases/download/v${CNI_VERSION}/cni-plugins-linux-${ARCH}-v${CNI_VERSION}.tgz"
+    tar Cxzvf /opt/cni/bin "cni-plugins-linux-${ARCH}-v${CNI_VERSION}.tgz"
+    rm "cni-plugins-linux-${ARCH}-v${CNI_VERSION}.tgz"
     
     # Create systemd service
     cat > /etc/systemd/system/containerd.service << 'EOF'
@@ -155,19 +239,61 @@ EOF
     log_success "containerd installation completed"
 }
 
+# Remove containerd installed from distro packages, so the pinned binaries in
+# /usr/local are the only ones on the PATH
+remove_distro_containerd() {
+    local pkg
+    for pkg in containerd containerd.io; do
+        if dpkg -s "$pkg" >/dev/null 2>&1; then
+            log_info "Removing distro package $pkg..."
+            apt-get remove -y "$pkg"
+        fi
+    done
+}
+
+# Download a release asset into the current directory, failing with a clear
+# message (the optional second argument) when it cannot be fetched
+download() {
+    if ! wget -q "$1"; then
+        log_error "Failed to download $1${2:+: $2}"
+        return 1
+    fi
+}
+
+# Install the stargz snapshotter, which containerd reaches as a proxy plugin
+install_stargz() {
+    if command -v containerd-stargz-grpc >/dev/null 2>&1; then
+        log_info "stargz snapshotter is already installed"
+        return 0
+    fi
+
+    log_info "Installing stargz snapshotter $STARGZ_VERSION..."
+    local release="https://github.com/containerd/stargz-snapshotter/releases/download/v${STARGZ_VERSION}"
+    wget -q "${release}/stargz-snapshotter-v${STARGZ_VERSION}-linux-${ARCH}.tar.gz"
+    tar -C /usr/local/bin -xzf "stargz-snapshotter-v${STARGZ_VERSION}-linux-${ARCH}.tar.gz" containerd-stargz-grpc ctr-remote
+    rm "stargz-snapshotter-v${STARGZ_VERSION}-linux-${ARCH}.tar.gz"
+
+    wget -q -O /etc/systemd/system/stargz-snapshotter.service \
+        "https://raw.githubusercontent.com/containerd/stargz-snapshotter/v${STARGZ_VERSION}/script/config/etc/systemd/system/stargz-snapshotter.service"
+    systemctl daemon-reload
+    systemctl enable --now stargz-snapshotter
+
+    log_success "stargz snapshotter installation completed"
+}
+
 # Configure containerd for optimal image caching
 configure_containerd() {
-    log_info "Configuring containerd for image caching..."
-    
-    # Generate default config
-    containerd config default > /etc/containerd/config.toml
+    log_info "Configuring containerd for image caching (snapshotter: $SNAPSHOTTER)..."
     
-    # Optimize for image caching
-    cat >> /etc/containerd/config.toml << 'EOF'
+    # Only the settings that differ from the defaults are written: containerd
+    # fills in the rest, and a table may appear only once in a TOML file, so
+    # appending to "containerd config default" would leave it unparsable
+    cat > /etc/containerd/config.toml << EOF
+version = 2
 
 # GKE Image Cache Builder optimizations
 [plugins."io.containerd.grpc.v1.cri".containerd]
-  snapshotter = "overlayfs"
+  snapshotter = "${SNAPSHOTTER}"
   default_runtime_name = "runc"
 
 [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc]
@@ -176,16 +302,20 @@ configure_containerd() {
 [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc.options]
   SystemdCgroup = true
 
-# Image cache optimizations
+# Image cache optimizations; containerd refuses mirrors next to config_path,
+# and docker.io and gcr.io resolve to their own registries by default
 [plugins."io.containerd.grpc.v1.cri".registry]
   config_path = "/etc/containerd/certs.d"
+EOF
+
+    if [ "$SNAPSHOTTER" = "stargz" ]; then
+        cat >> /etc/containerd/config.toml << 'EOF'
 
-[plugins."io.containerd.grpc.v1.cri".registry.mirrors]
-  [plugins."io.containerd.grpc.v1.cri".registry.mirrors."docker.io"]
-    endpoint = ["https://registry-1.docker.io"]
-  [plugins."io.containerd.grpc.v1.cri".registry.mirrors."gcr.io"]
-    endpoint = ["https://gcr.io"]
+[proxy_plugins.stargz]
+  type = "snapshot"
+  address = "/run/containerd-stargz-grpc/containerd-stargz-grpc.sock"
 EOF
+    fi
     
     # Restart containerd to apply configuration
     systemctl restart containerd
@@ -235,12 +365,14 @@ setup_cache_environment() {
     log_info "Setting up image cache environment..."
     
     # Create cache directories
-    mkdir -p /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs
-    mkdir -p /var/lib/containerd/io.containerd.content.v1.content
+    if [ "$SNAPSHOTTER" != "stargz" ]; then
+        mkdir -p "${CONTAINERD_ROOT}/io.containerd.snapshotter.v1.${SNAPSHOTTER}"
+    fi
+    mkdir -p "${CONTAINERD_ROOT}/io.containerd.content.v1.content"
     
     # Set appropriate permissions
-    chown -R root:root /var/lib/containerd
-    chmod -R 755 /var/lib/containerd
+    chown -R root:root "$CONTAINERD_ROOT"
+    chmod -R 755 "$CONTAINERD_ROOT"
     
     # Create cache management script
     cat > /usr/local/bin/cache-manager.sh << 'EOF'
@@ -0,0 +1,126 @@
+#!/bin/bash
+# GKE Image Cache Builder - Smoke Test Script
+# Runs as the startup-script of a throwaway Container-Optimized OS VM booted with
+# a new cache image attached read-only, as a node sees its secondary boot disk.
+# A private containerd is started on the disk's content, through an overlay so
+# nothing is written to the disk, and asked which expected images resolve with
+# all of their content. Results are published as guest attributes:
+#   smoke-test          running, then passed or failed
+#   smoke-test-missing  the expected references that did not resolve
+#   smoke-test-error    why the check could not run at all
+
+set -u
+
+METADATA_URL="http://metadata.google.internal/computeMetadata/v1"
+GUEST_ATTR_NAMESPACE="gke-image-cache-builder"
+WORK_DIR="/run/gke-image-cache-smoke-test"
+SOCKET="${WORK_DIR}/containerd.sock"
+
+log_info() {
+    echo "[INFO] $(date '+%Y-%m-%d %H:%M:%S') - $1"
+}
+
+log_error() {
+    echo "[ERROR] $(date '+%Y-%m-%d %H:%M:%S') - $1" >&2
+}
+
+# Report progress to the builder through guest attributes
+publish_status() {
+    curl -s -X PUT --data "$2" -H "Metadata-Flavor: Google" \
+        "${METADATA_URL}/instance/guest-attributes/${GUEST_ATTR_NAMESPACE}/$1" >/dev/null 2>&1 || true
+}
+
+get_metadata_attribute() {
+    curl -sf -H "Metadata-Flavor: Google" "${METADATA_URL}/instance/attributes/$1" 2>/dev/null || true
+}
+
+fail() {
+    log_error "$1"
+    publish_status "smoke-test-error" "$1"
+    publish_status "smoke-test" "failed"
+    exit 1
+}
+
+# Mount the first filesystem on the cache disk or its partitions read-only,
+# without replaying its journal
+mount_cache_disk() {
+    local dev="/dev/disk/by-id/google-$1" part fs
+    for _ in $(seq 1 30); do [ -e "$dev" ] && break; sleep 2; done
+    [ -e "$dev" ] || fail "device $dev did not appear"
+
+    mkdir -p "${WORK_DIR}/disk"
+    for part in "$dev" "$dev"-part*; do
+        [ -e "$part" ] || continue
+        fs=$(blkid -o value -s TYPE "$part" || true)
+        case "$fs" in
+            ext2|ext3|ext4) mount -o ro,noload "$part" "${WORK_DIR}/disk" || continue ;;
+            xfs) mount -o ro,norecovery "$part" "${WORK_DIR}/disk" || continue ;;
+            *) continue ;;
+        esac
+        log_info "Mounted $part ($fs) read-only"
+        return 0
+    done
+    fail "no supported filesystem on $dev"
+}
+
+# Start containerd on the disk's content. The metadata database must be
+# writable, so the disk is the lower layer of an overlay on tmpfs.
+start_containerd() {
+    [ -f "${WORK_DIR}/disk/io.containerd.metadata.v1.bolt/meta.db" ] ||
+        fail "no containerd metadata database (io.containerd.metadata.v1.bolt/meta.db) on the disk"
+
+    mkdir -p "${WORK_DIR}/upper" "${WORK_DIR}/work" "${WORK_DIR}/root" "${WORK_DIR}/state"
+    mount -t overlay overlay \
+        -o "lowerdir=${WORK_DIR}/disk,upperdir=${WORK_DIR}/upper,workdir=${WORK_DIR}/work" "${WORK_DIR}/root" ||
+        fail "failed to mount an overlay over the disk"
+
+    # Images are only resolved, never run
+    cat > "${WORK_DIR}/config.toml" << 'EOF'
+version = 2
+disabled_plugins = ["io.containerd.grpc.v1.cri"]
+EOF
+
+    containerd --config "${WORK_DIR}/config.toml" --root "${WORK_DIR}/root" --state "${WORK_DIR}/state" \
+        --address "$SOCKET" > "${WORK_DIR}/containerd.log" 2>&1 &
+    for _ in $(seq 1 30); do
+        ctr --address "$SOCKET" version >/dev/null 2>&1 && return 0
+        sleep 2
+    done
+    tail -n 20 "${WORK_DIR}/containerd.log" >&2
+    fail "containerd did not start on the disk's content"
+}
+
+log_info "Smoke testing GKE image cache"
+publish_status "smoke-test" "running"
+
+command -v containerd >/dev/null && command -v ctr >/dev/null || fail "containerd and ctr are not installed on the boot image"
+
+device=$(get_metadata_attribute "smoke-test-device")
+[ -n "$device" ] || fail "no smoke-test-device in the instance metadata"
+mount_cache_disk "$device"
+start_containerd
+
+# REF TYPE DIGEST STATUS SIZE UNPACKED; STATUS is "complete (n/n)" once every
+# blob of the platform's image is present
+ctr --address "$SOCKET" -n k8s.io images check > "${WORK_DIR}/check" 2>&1 ||
+    fail "ctr images check failed: $(tail -n 1 "${WORK_DIR}/check")"
+awk 'NR > 1 && $4 == "complete" { print $1 }' "${WORK_DIR}/check" > "${WORK_DIR}/complete"
+
+expected=0
+missing=""
+while read -r ref; do
+    [ -n "$ref" ] || continue
+    expected=$((expected + 1))
+    if ! grep -qxF "$ref" "${WORK_DIR}/complete"; then
+        log_error "Image does not resolve: $ref"
+        missing="$missing $ref"
+    fi
+done <<< "$(get_metadata_attribute "smoke-test-images")"
+
+if [ -n "$missing" ]; then
+    publish_status "smoke-test-missing" "${missing# }"
+    publish_status "smoke-test" "failed"
+    exit 1
+fi
+log_info "All $expected images resolve"
+publish_status "smoke-test" "passed"
@@ -0,0 +1,191 @@
+# GKE Image Cache Builder - Windows VM Setup Script
+# This script is embedded in the binary and runs as windows-startup-script-ps1.
+# Progress is reported on serial port 1 as "GKE-IMAGE-CACHE-STATUS key=value" lines,
+# or on the port given in the serial-port metadata attribute.
+
+$ErrorActionPreference = "Stop"
+
+# Configuration
+$ContainerdVersion = "1.7.13"
+$MetadataUrl = "http://metadata.google.internal/computeMetadata/v1"
+$CacheDriveLetter = "D"
+$ContainerdRoot = "${CacheDriveLetter}:\ProgramData\containerd\root"
+$Platform = "windows/amd64"
+$SerialPort = 1
+
+# Write a line to the startup script log (serial port 1) and, when another port
+# was chosen, to that port, which is the one the builder reads
+function Write-Serial($Line) {
+    Write-Host $Line
+    if ($SerialPort -gt 1) {
+        try {
+            $port = New-Object System.IO.Ports.SerialPort "COM$SerialPort", 115200
+            $port.Open()
+            $port.WriteLine($Line)
+            $port.Close()
+        } catch {
+            Write-Host "[ERROR] Cannot write to COM${SerialPort}: $($_.Exception.Message)"
+        }
+    }
+}
+
+# Logging functions
+function Log-Info($Message) {
+    Write-Serial "[INFO] $(Get-Date -Format 'yyyy-MM-dd HH:mm:ss') - $Message"
+}
+
+function Log-Error($Message) {
+    Write-Serial "[ERROR] $(Get-Date -Format 'yyyy-MM-dd HH:mm:ss') - $Message"
+}
+
+# Report progress to the builder through the serial port
+function Publish-Status($Key, $Value) {
+    Write-Serial "GKE-IMAGE-CACHE-STATUS $Key=$Value"
+}
+
+function Get-MetadataAttribute($Name) {
+    try {
+        return Invoke-RestMethod -Headers @{ "Metadata-Flavor" = "Google" } -Uri "$MetadataUrl/instance/attributes/$Name"
+    } catch {
+        return ""
+    }
+}
+
+$requestedPort = Get-MetadataAttribute "serial-port"
+if ($requestedPort) {
+    $SerialPort = [int]$requestedPort
+}
+
+# Check that an HTTPS endpoint answers at all (any HTTP status counts as reachable)
+function Test-Endpoint($Url) {
+    try {
+        Invoke-WebRequest -Uri $Url -Method Head -UseBasicParsing -TimeoutSec 10 | Out-Null
+        return $true
+    } catch [System.Net.WebException] {
+        return $null -ne $_.Exception.Response
+    } catch {
+        return $false
+    }
+}
+
+# Verify egress to the metadata server, Cloud Storage and every image registry
+function Test-Connectivity {
+    Log-Info "Checking network connectivity to required endpoints..."
+
+    $failures = @()
+
+    try {
+        Invoke-RestMethod -Headers @{ "Metadata-Flavor" = "Google" } -Uri "$MetadataUrl/instance/id" -TimeoutSec 5 | Out-Null
+    } catch {
+        $failures += "metadata.google.internal"
+    }
+
+    if (-not (Test-Endpoint "https://storage.googleapis.com")) {
+        $failures += "storage.googleapis.com"
+    }
+
+    foreach ($registry in ((Get-MetadataAttribute "registries") -split " " | Where-Object { $_ })) {
+        if (-not (Test-Endpoint "https://$registry/v2/")) {
+            $failures += $registry
+        }
+    }
+
+    if ($failures.Count -gt 0) {
+        Publish-Status "connectivity-failures" ($failures -join " ")
+        Publish-Status "connectivity" "failed"
+        throw "Cannot reach: $($failures -join ', ')"
+    }
+
+    Publish-Status "connectivity" "ok"
+}
+
+# The Containers feature needs a reboot; the startup script runs again afterwards
+function Install-ContainersFeature {
+    if ((Get-WindowsFeature -Name Containers).Installed) {
+        return
+    }
+
+    Log-Info "Installing Windows Containers feature (reboot required)..."
+    Install-WindowsFeature -Name Containers | Out-Null
+    Restart-Computer -Force
+    exit 0
+}
+
+# Initialize the attached cache disk with a single NTFS volume
+function Initialize-CacheDisk {
+    if (Get-Volume -DriveLetter $CacheDriveLetter -ErrorAction SilentlyContinue) {
+        return
+    }
+
+    Log-Info "Formatting cache disk as NTFS..."
+    $disk = Get-Disk | Where-Object { $_.PartitionStyle -eq "RAW" } | Select-Object -First 1
+    if (-not $disk) {
+        throw "No uninitialized cache disk attached"
+    }
+
+    Initialize-Disk -Number $disk.Number -PartitionStyle GPT
+    New-Partition -DiskNumber $disk.Number -UseMaximumSize -DriveLetter $CacheDriveLetter |
+        Format-Volume -FileSystem NTFS -NewFileSystemLabel "image-cache" -Confirm:$false | Out-Null
+}
+
+# Install containerd with its root on the cache disk
+function Install-Containerd {
+    $installDir = "$env:ProgramFiles\containerd"
+    if (Test-Path "$installDir\containerd.exe") {
+        return
+    }
+
+    Log-Info "Installing containerd $ContainerdVersion..."
+    $archive = "$env:TEMP\containerd.tar.gz"
+    Invoke-WebRequest -UseBasicParsing -OutFile $archive `
+        -Uri "https://github.com/containerd/containerd/releases/download/v$ContainerdVersion/containerd-$ContainerdVersion-windows-amd64.tar.gz"
+    New-Item -ItemType Directory -Force -Path $installDir | Out-Null
+    tar.exe xf $archive -C $installDir --strip-components 1
+    Remove-Item $archive
+
+    New-Item -ItemType Directory -Force -Path $ContainerdRoot | Out-Null
+    & "$installDir\containerd.exe" config default |
+        ForEach-Object { $_ -replace '^root = .*', "root = '$($ContainerdRoot -replace '\\', '\\')'" } |
+        Out-File -Encoding ascii "$installDir\config.toml"
+
+    & "$installDir\containerd.exe" --register-service --config "$installDir\config.toml"
+    Start-Service containerd
+}
+
+# Pull every image for the Windows platform into the k8s.io namespace
+function Pull-Images {
+    $ctr = "$env:ProgramFiles\containerd\ctr.exe"
+    $images = (Get-MetadataAttribute "images") -split " " | Where-Object { $_ }
+    # Validated by the builder to contain no whitespace or shell metacharacters
+    $pullArgs = @((Get-MetadataAttribute "pull-args") -split " " | Where-Object { $_ })
+
+    foreach ($image in $images) {
+        Log-Info "Pulling $image ($Platform)..."
+        & $ctr -n k8s.io images pull --platform $Platform @pullArgs $image
+        if ($LASTEXITCODE -ne 0) {
+            Publish-Status "pull-failed" $image
+            throw "Failed to pull $image"
+        }
+    }
+
+    Publish-Status "pull" "done"
+}
+
+try {
+    Log-Info "Starting GKE Image Cache Builder Windows VM setup"
+    Publish-Status "setup" "running"
+
+    Test-Connectivity
+    Install-ContainersFeature
+    Initialize-CacheDisk
+    Install-Containerd
+
+    Publish-Status "setup" "done"
+
+    Pull-Images
+} catch {
+    Log-Error $_.Exception.Message
+    Publish-Status "setup" "failed"
+    Publish-Status "pull" "failed"
+    exit 1
+}
@@ -0,0 +1,235 @@
+// Package trace records a timeline of a build: a span per workflow phase,
+// GCP operation and image pull, nested by the context they were started
+// from. The timeline is written in the Trace Event Format, which
+// chrome://tracing and Perfetto open and OpenTelemetry tooling can convert,
+// so phases running side by side show as overlapping bars.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Span categories
+const (
+	CategoryPhase = "phase" // a step of the workflow
+	CategoryGCP   = "gcp"   // a Compute Engine operation
+	CategoryImage = "image" // work on one container image
+)
+
+// Recorder collects the spans of a build. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	traceID string
+	spans   []*Span
+}
+
+// NewRecorder returns an empty recorder with a random trace ID
+func NewRecorder() *Recorder {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &Recorder{traceID: hex.EncodeToString(id)}
+}
+
+type recorderKey struct{}
+type spanKey struct{}
+
+// WithRecorder returns a context whose spans ctx's descendants record
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// FromContext returns the recorder carried by ctx, or nil
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(recorderKey{}).(*Recorder)
+	return r
+}
+
+// Span is a timed piece of work. All methods are no-ops on a nil Span, which
+// Start returns when the context carries no recorder.
+type Span struct {
+	recorder *Recorder
+	id       int
+	parent   int // 0 for a root span
+	category string
+	name     string
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs map[string]string
+}
+
+// Start begins a span, a child of the span ctx carries, and returns a context
+// carrying it for the spans of the work it covers. attrs are key, value pairs,
+// e.g. "image", "nginx:1.25".
+func Start(ctx context.Context, category, name string, attrs ...string) (context.Context, *Span) {
+	r := FromContext(ctx)
+	if r == nil {
+		return ctx, nil
+	}
+
+	span := &Span{recorder: r, category: category, name: name, start: time.Now(), attrs: make(map[string]string, len(attrs)/2)}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.parent = parent.id
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.attrs[attrs[i]] = attrs[i+1]
+	}
+
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	span.id = len(r.spans)
+	r.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SetAttr adds an attribute to the span
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// End ends the span, recording err if the work failed. Later calls do nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.attrs["error"] = err.Error()
+	}
+}
+
+// event is a complete ("X") event of the Trace Event Format, in microseconds
+type event struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat"`
+	Phase    string            `json:"ph"`
+	Start    int64             `json:"ts"`
+	Duration int64             `json:"dur"`
+	PID      int               `json:"pid"`
+	TID      int               `json:"tid"`
+	Args     map[string]string `json:"args"`
+}
+
+// traceFile is the JSON object format of the Trace Event Format
+type traceFile struct {
+	TraceEvents     []event           `json:"traceEvents"`
+	DisplayTimeUnit string            `json:"displayTimeUnit"`
+	OtherData       map[string]string `json:"otherData"`
+}
+
+// Len returns the number of spans recorded
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.spans)
+}
+
+// WriteFile writes the spans to path. Spans still running, such as those of
+// a build that was interrupted, end now and are marked unfinished.
+func (r *Recorder) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r.traceFile(time.Now()), "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) traceFile(now time.Time) traceFile {
+	r.mu.Lock()
+	spans := append([]*Span(nil), r.spans...)
+	r.mu.Unlock()
+
+	events := make([]event, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		end := s.end
+		args := make(map[string]string, len(s.attrs)+4)
+		for k, v := range s.attrs {
+			args[k] = v
+		}
+		s.mu.Unlock()
+
+		if end.IsZero() {
+			end = now
+			args["unfinished"] = "true"
+		}
+		args["trace_id"] = r.traceID
+		args["span_id"] = strconv.Itoa(s.id)
+		if s.parent != 0 {
+			args["parent_span_id"] = strconv.Itoa(s.parent)
+		}
+		events = append(events, event{
+			Name:     s.name,
+			Category: s.category,
+			Phase:    "X",
+			Start:    s.start.UnixMicro(),
+			Duration: end.Sub(s.start).Microseconds(),
+			PID:      1,
+			Args:     args,
+		})
+	}
+	assignLanes(events)
+
+	return traceFile{
+		TraceEvents:     events,
+		DisplayTimeUnit: "ms",
+		OtherData:       map[string]string{"trace_id": r.traceID},
+	}
+}
+
+// assignLanes puts each event on a lane (tid) where it nests within the
+// events before it: viewers draw the events of a lane as a call stack, so
+// overlapping spans that do not nest, like parallel pulls, go to separate lanes
+func assignLanes(events []event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Start != events[j].Start {
+			return events[i].Start < events[j].Start
+		}
+		return events[i].Duration > events[j].Duration // parents before children
+	})
+
+	var lanes [][]int64 // per lane, the ends of the events still open
+	for i := range events {
+		start, end := events[i].Start, events[i].Start+events[i].Duration
+		lane := -1
+		for l, open := range lanes {
+			for len(open) > 0 && open[len(open)-1] <= start {
+				open = open[:len(open)-1]
+			}
+			lanes[l] = open
+			if len(open) == 0 || open[len(open)-1] >= end {
+				lane = l
+				break
+			}
+		}
+		if lane < 0 {
+			lanes = append(lanes, nil)
+			lane = len(lanes) - 1
+		}
+		lanes[lane] = append(lanes[lane], end)
+		events[i].TID = lane + 1
+	}
+}
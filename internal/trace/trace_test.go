@@ -0,0 +1,83 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorderConcurrentSpans(t *testing.T) {
+	recorder := NewRecorder()
+	ctx, root := Start(WithRecorder(context.Background(), recorder), CategoryPhase, "pull images")
+
+	const pulls = 16
+	var wg sync.WaitGroup
+	for i := 0; i < pulls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pullCtx, span := Start(ctx, CategoryImage, fmt.Sprintf("pull image-%d", i), "image", strconv.Itoa(i))
+			_, op := Start(pullCtx, CategoryGCP, "get")
+			op.SetAttr("attempt", "1")
+			op.End(nil)
+			if i%2 == 0 {
+				span.End(errors.New("failed"))
+			}
+			span.End(nil)
+		}(i)
+	}
+	// The timeline is written while the spans are recorded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		recorder.traceFile(time.Now())
+	}()
+	wg.Wait()
+	<-done
+	root.End(nil)
+
+	file := recorder.traceFile(time.Now())
+	if len(file.TraceEvents) != 1+2*pulls {
+		t.Fatalf("%d events, want %d", len(file.TraceEvents), 1+2*pulls)
+	}
+	ids := make(map[string]event)
+	for _, e := range file.TraceEvents {
+		ids[e.Args["span_id"]] = e
+	}
+	failed := 0
+	for _, e := range file.TraceEvents {
+		if e.Args["unfinished"] != "" {
+			t.Errorf("span %s is unfinished", e.Name)
+		}
+		if e.Args["error"] != "" {
+			failed++
+		}
+		switch e.Category {
+		case CategoryImage:
+			if parent := ids[e.Args["parent_span_id"]]; parent.Name != "pull images" {
+				t.Errorf("span %s has parent %q, want the pull phase", e.Name, parent.Name)
+			}
+		case CategoryGCP:
+			if parent := ids[e.Args["parent_span_id"]]; parent.Category != CategoryImage {
+				t.Errorf("span %s has parent %q, want its pull", e.Name, parent.Name)
+			}
+		}
+	}
+	// A span records the error of its first End only
+	if failed != pulls/2 {
+		t.Errorf("%d failed spans, want %d", failed, pulls/2)
+	}
+}
+
+func TestStartWithoutRecorder(t *testing.T) {
+	ctx, span := Start(context.Background(), CategoryPhase, "build")
+	if span != nil || FromContext(ctx) != nil {
+		t.Errorf("Start() without a recorder = %v, want a nil span", span)
+	}
+	span.SetAttr("k", "v")
+	span.End(nil)
+}
@@ -0,0 +1,133 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/api/networkmanagement/v1"
+)
+
+// connectivityAdvice is appended to every reachability failure
+const connectivityAdvice = "check Cloud NAT / Private Google Access / firewall egress"
+
+// CheckConnectivity waits for the setup script's endpoint probes and fails if any endpoint was unreachable
+func (m *Manager) CheckConnectivity(ctx context.Context, instance *Instance) error {
+	m.logger.Info("Waiting for build VM connectivity check...")
+
+	// Covers the VM's boot too, which is slow in some regions
+	status, err := m.WaitForStatus(ctx, instance, "connectivity", m.tuning.BootTimeout)
+	if err != nil {
+		return fmt.Errorf("connectivity check did not complete (raise 'advanced.tuning.vm_boot_timeout' if the VM boots slowly): %w", err)
+	}
+
+	if status != "ok" {
+		attrs, err := m.GetStatus(ctx, instance)
+		if err != nil {
+			return err
+		}
+		failures := strings.Fields(attrs["connectivity-failures"])
+		if len(failures) == 0 {
+			return fmt.Errorf("the build VM connectivity check failed — %s", connectivityAdvice)
+		}
+		return fmt.Errorf("the build VM cannot reach %s — %s", strings.Join(failures, ", "), connectivityAdvice)
+	}
+
+	m.logger.Info("Build VM connectivity check passed")
+	return nil
+}
+
+// RunConnectivityTests uses the Network Management API to confirm the VM can reach each host on port 443
+func (m *Manager) RunConnectivityTests(ctx context.Context, instance *Instance, hosts []string) error {
+	m.logger.Info("Running Network Management connectivity tests...")
+
+	service, err := m.gcpClient.NetworkManagement(ctx)
+	if err != nil {
+		return err
+	}
+
+	project := m.gcpClient.ProjectName()
+	parent := fmt.Sprintf("projects/%s/locations/global", project)
+	source := fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, instance.Zone, instance.Name)
+
+	var unreachable []string
+	for i, host := range hosts {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			m.logger.Warnf("Skipping connectivity test for %s: cannot resolve address", host)
+			continue
+		}
+
+		test := &networkmanagement.ConnectivityTest{
+			Source:      &networkmanagement.Endpoint{Instance: source},
+			Destination: &networkmanagement.Endpoint{IpAddress: addrs[0], Port: 443},
+			Protocol:    "TCP",
+		}
+
+		result, err := m.runConnectivityTest(ctx, service, parent, fmt.Sprintf("%s-egress-%d", instance.Name, i), test)
+		if err != nil {
+			m.logger.Warnf("Connectivity test for %s could not run: %v", host, err)
+			continue
+		}
+
+		m.logger.Debugf("Connectivity test %s -> %s (%s): %s", instance.Name, host, addrs[0], result)
+		if result == "UNREACHABLE" {
+			unreachable = append(unreachable, host)
+		}
+	}
+
+	if len(unreachable) > 0 {
+		return fmt.Errorf("the build VM cannot reach %s — %s", strings.Join(unreachable, ", "), connectivityAdvice)
+	}
+
+	m.logger.Info("Network Management connectivity tests passed")
+	return nil
+}
+
+// runConnectivityTest creates a test, waits for its analysis, deletes it and returns the reachability result
+func (m *Manager) runConnectivityTest(ctx context.Context, service *networkmanagement.Service, parent, testID string, test *networkmanagement.ConnectivityTest) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.tuning.ConnectivityTestTimeout)
+	defer cancel()
+
+	tests := service.Projects.Locations.Global.ConnectivityTests
+	op, err := tests.Create(parent, test).TestId(testID).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+
+	name := parent + "/connectivityTests/" + testID
+	defer func() {
+		if _, err := tests.Delete(name).Context(context.Background()).Do(); err != nil {
+			m.logger.Debugf("Failed to delete connectivity test %s: %v", name, err)
+		}
+	}()
+
+	err = m.poll(ctx, func() (bool, error) {
+		if op.Done {
+			return true, nil
+		}
+		var err error
+		op, err = service.Projects.Locations.Global.Operations.Get(op.Name).Context(ctx).Do()
+		return op != nil && op.Done, err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out waiting for connectivity test %s", testID)
+		}
+		return "", err
+	}
+	if op.Error != nil {
+		return "", fmt.Errorf("connectivity test %s failed: %s", testID, op.Error.Message)
+	}
+
+	result, err := tests.Get(name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	if result.ReachabilityDetails == nil {
+		return "UNDETERMINED", nil
+	}
+
+	return result.ReachabilityDetails.Result, nil
+}
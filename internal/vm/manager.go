@@ -2,61 +2,490 @@ package vm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 
 	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/trace"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
 )
 
+const (
+	// GuestAttributeNamespace is the guest attribute namespace the setup script reports into
+	GuestAttributeNamespace = "gke-image-cache-builder"
+
+	// hostKeysNamespace is where guest agents publish the VM's SSH host keys
+	hostKeysNamespace = "hostkeys"
+
+	// OSWindows selects a Windows Server build VM
+	OSWindows = "windows"
+
+	// ArchARM64 selects an Arm boot image; the machine type must be an Arm one
+	ArchARM64 = "arm64"
+
+	defaultBootImage = "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts"
+	armBootImage     = "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts-arm64"
+	nodeBootImage    = "projects/cos-cloud/global/images/family/cos-stable"
+	nodeArmBootImage = "projects/cos-cloud/global/images/family/cos-arm64-stable"
+	bootDiskSizeGB   = 20
+	statusRunning    = "running"
+
+	// instanceGoneTimeout bounds the wait for a deleted instance to drop out of
+	// instances.list once its delete operation completed
+	instanceGoneTimeout = 2 * time.Minute
+
+	// unfetchedDeleteTimeout bounds the deletion of an instance that was
+	// created but could not be fetched, also after the caller's context ended
+	unfetchedDeleteTimeout = 5 * time.Minute
+
+	// Windows Server 2022 matches the ltsc2022 node image used by GKE Windows node pools
+	windowsBootImage      = "projects/windows-cloud/global/images/family/windows-2022-core"
+	windowsBootDiskSizeGB = 64
+)
+
 // Manager handles VM lifecycle operations
 type Manager struct {
 	gcpClient *gcp.Client
 	logger    *log.Logger
+	tuning    Tuning
+	clock     clock
 }
 
 // NewManager creates a new VM manager
-func NewManager(gcpClient *gcp.Client, logger *log.Logger) *Manager {
+func NewManager(gcpClient *gcp.Client, logger *log.Logger, tuning Tuning) *Manager {
 	return &Manager{
 		gcpClient: gcpClient,
 		logger:    logger,
+		tuning:    tuning,
+		clock:     realClock{},
 	}
 }
 
-// CreateVM creates a new VM instance
+// CreateVM creates a new VM instance running the embedded setup script at boot
 func (m *Manager) CreateVM(ctx context.Context, config *Config) (*Instance, error) {
 	m.logger.Infof("Creating VM: %s", config.Name)
 
-	// Implementation would create actual GCP VM
-	instance := &Instance{
-		Name: config.Name,
-		Zone: config.Zone,
+	project := m.gcpClient.ProjectName()
+	region := gcp.RegionFromZone(config.Zone)
+
+	bootImage, bootDiskSize := DefaultBootImage(config.OSType, config.Arch), int64(bootDiskSizeGB)
+	if config.OSType == OSWindows {
+		bootDiskSize = windowsBootDiskSizeGB
+	}
+	if config.BootImage != "" {
+		bootImage = config.BootImage
+	}
+	metadata := []*compute.MetadataItems{
+		metadataItem("enable-guest-attributes", "TRUE"),
+	}
+	if config.OSType == OSWindows {
+		// Windows VMs report progress on the serial port instead of guest attributes
+		metadata = append(metadata, metadataItem("windows-startup-script-ps1", scripts.GetWindowsSetupScript()))
+	} else if config.StartupScript != "" {
+		metadata = append(metadata, metadataItem("startup-script", config.StartupScript))
+	} else {
+		// The full setup script is uploaded over SSH once the VM is bootstrapped
+		metadata = append(metadata, metadataItem("startup-script", scripts.GetBootstrapScriptWith(config.AdditionalScripts, config.AdditionalScriptsAfter)))
+	}
+	if config.SerialPort > 1 {
+		metadata = append(metadata, metadataItem("serial-port", strconv.Itoa(config.SerialPort)))
+	}
+	for key, value := range config.Metadata {
+		metadata = append(metadata, metadataItem(key, value))
 	}
 
-	return instance, nil
+	disks := []*compute.AttachedDisk{
+		{
+			Boot:       true,
+			AutoDelete: true,
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				SourceImage: bootImage,
+				DiskSizeGb:  bootDiskSize,
+				// Labeled like the VM, so a boot disk left behind can be attributed
+				Labels: maps.Clone(config.Labels),
+			},
+		},
+	}
+	for _, name := range config.DataDisks {
+		attached := &compute.AttachedDisk{
+			Source:     fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, config.Zone, name),
+			DeviceName: name,
+		}
+		if config.ReadOnlyDisks {
+			attached.Mode = "READ_ONLY"
+		}
+		disks = append(disks, attached)
+	}
+
+	instance := &compute.Instance{
+		Name:        config.Name,
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", config.Zone, config.MachineType),
+		Disks:       disks,
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network:    fmt.Sprintf("projects/%s/global/networks/%s", project, config.Network),
+				Subnetwork: fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", project, region, config.Subnet),
+				AccessConfigs: []*compute.AccessConfig{
+					{Name: "External NAT", Type: "ONE_TO_ONE_NAT"},
+				},
+			},
+		},
+		ServiceAccounts: []*compute.ServiceAccount{
+			{
+				Email:  config.ServiceAccount,
+				Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
+			},
+		},
+		Scheduling: &compute.Scheduling{
+			Preemptible:       config.Preemptible,
+			AutomaticRestart:  googleapi.Bool(!config.Preemptible),
+			OnHostMaintenance: "TERMINATE",
+		},
+		Metadata: &compute.Metadata{Items: metadata},
+		Labels:   maps.Clone(config.Labels), // read while encoding the request; the caller keeps its map
+	}
+
+	if config.NoExternalIP {
+		instance.NetworkInterfaces[0].AccessConfigs = nil
+	}
+	if config.ShieldedVM {
+		instance.ShieldedInstanceConfig = &compute.ShieldedInstanceConfig{
+			EnableSecureBoot:          true,
+			EnableVtpm:                true,
+			EnableIntegrityMonitoring: true,
+		}
+	}
+
+	op, err := m.gcpClient.Compute().Instances.Insert(project, config.Zone, instance).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForZoneOperation(ctx, config.Zone, op)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instance %s: %w", config.Name, m.gcpClient.ExplainPolicyError(ctx, m.logger, err, "creating instance "+config.Name))
+	}
+
+	created, err := m.gcpClient.Compute().Instances.Get(project, config.Zone, config.Name).Context(ctx).Do()
+	if err != nil {
+		// The caller gets no instance to clean up, and one without its addresses
+		// could not be used: delete it rather than leak it
+		deleteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), unfetchedDeleteTimeout)
+		defer cancel()
+		if deleteErr := m.DeleteVM(deleteCtx, config.Name, config.Zone); deleteErr != nil {
+			m.logger.Warnf("Failed to delete instance %s, which could not be fetched after its creation: %v", config.Name, deleteErr)
+		}
+		return nil, fmt.Errorf("failed to get instance %s: %w", config.Name, err)
+	}
+
+	result := newInstance(created, config.OSType)
+	result.BootImage = bootImage
+	result.SerialPort = max(config.SerialPort, 1)
+	return result, nil
+}
+
+// AttachedDevices returns the source disk URL of each disk attached to a running
+// instance, by device name
+func (m *Manager) AttachedDevices(ctx context.Context, instanceName, zone string) (map[string]string, error) {
+	instance, err := m.gcpClient.Compute().Instances.Get(m.gcpClient.ProjectName(), zone, instanceName).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance %s: %w", instanceName, err)
+	}
+	devices := make(map[string]string, len(instance.Disks))
+	for _, attached := range instance.Disks {
+		devices[attached.DeviceName] = attached.Source
+	}
+	return devices, nil
+}
+
+// AttachDisk attaches an existing disk to a running instance under deviceName
+// (/dev/disk/by-id/google-<deviceName> on Linux), read-only or read-write
+func (m *Manager) AttachDisk(ctx context.Context, instanceName, zone, diskName, deviceName string, readOnly bool) error {
+	mode := "READ_WRITE"
+	if readOnly {
+		mode = "READ_ONLY"
+	}
+	m.logger.Infof("Attaching disk %s to %s as %s (%s)", diskName, instanceName, deviceName, strings.ToLower(strings.ReplaceAll(mode, "_", "-")))
+
+	project := m.gcpClient.ProjectName()
+	attached := &compute.AttachedDisk{
+		Source:     fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, diskName),
+		DeviceName: deviceName,
+		Mode:       mode,
+	}
+	op, err := m.gcpClient.Compute().Instances.AttachDisk(project, zone, instanceName, attached).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForZoneOperation(ctx, zone, op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to attach disk %s to %s: %w", diskName, instanceName, err)
+	}
+	return nil
+}
+
+// DetachDisk detaches the disk attached with AttachDisk under deviceName
+func (m *Manager) DetachDisk(ctx context.Context, instanceName, zone, deviceName string) error {
+	m.logger.Infof("Detaching device %s from %s", deviceName, instanceName)
+
+	op, err := m.gcpClient.Compute().Instances.DetachDisk(m.gcpClient.ProjectName(), zone, instanceName, deviceName).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForZoneOperation(ctx, zone, op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to detach device %s from %s: %w", deviceName, instanceName, err)
+	}
+	return nil
+}
+
+// DefaultBootImage returns the public image family build VMs boot from
+func DefaultBootImage(osType, arch string) string {
+	switch {
+	case osType == OSWindows:
+		return windowsBootImage
+	case arch == ArchARM64:
+		return armBootImage
+	}
+	return defaultBootImage
+}
+
+// NodeBootImage returns the Container-Optimized OS image family of GKE's
+// default Linux nodes for an architecture
+func NodeBootImage(arch string) string {
+	if arch == ArchARM64 {
+		return nodeArmBootImage
+	}
+	return nodeBootImage
+}
+
+// GetInstance fetches the current state of an instance, e.g. to check its status
+func (m *Manager) GetInstance(ctx context.Context, instance *Instance) (*Instance, error) {
+	current, err := m.gcpClient.Compute().Instances.Get(m.gcpClient.ProjectName(), instance.Zone, instance.Name).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance %s: %w", instance.Name, err)
+	}
+	return newInstance(current, instance.OSType), nil
+}
+
+// ResolveBootImage checks that a boot image exists, can be read with this
+// project's credentials and matches the build VM's architecture. A family is
+// resolved to version, an image that must belong to it, or else to its latest
+// image, so the returned path names a concrete image.
+func (m *Manager) ResolveBootImage(ctx context.Context, path, version, arch string) (string, error) {
+	image, err := gcp.ParseImagePath(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid build VM image: %w", err)
+	}
+
+	// The image looked up: the version within the family if one is pinned
+	target := image
+	if image.Family && version != "" {
+		target = &gcp.ImagePath{Project: image.Project, Name: version}
+	}
+
+	var found *compute.Image
+	switch {
+	case target != image:
+		found, err = m.gcpClient.Compute().Images.Get(target.Project, target.Name).Context(ctx).Do()
+		if err == nil && found.Family != image.Name {
+			return "", fmt.Errorf("build VM image version %s is not an image of family %s (its family is '%s')", version, image, found.Family)
+		}
+	case image.Family:
+		found, err = m.gcpClient.Compute().Images.GetFromFamily(image.Project, image.Name).Context(ctx).Do()
+	default:
+		found, err = m.gcpClient.Compute().Images.Get(image.Project, image.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+			return "", fmt.Errorf("no read access to build VM image %s: grant roles/compute.imageUser on project %s to the credentials used by this tool",
+				target, target.Project)
+		}
+		if gcp.IsNotFound(err) {
+			return "", fmt.Errorf("build VM image %s not found", target)
+		}
+		return "", fmt.Errorf("failed to get build VM image %s: %w", target, err)
+	}
+	if found.Deprecated != nil && found.Deprecated.State != "" && found.Deprecated.State != "ACTIVE" {
+		m.logger.Warnf("Build VM image %s is %s", found.Name, strings.ToLower(found.Deprecated.State))
+	}
+
+	if found.Status != "READY" {
+		return "", fmt.Errorf("build VM image %s is in status %s, expected READY", found.SelfLink, found.Status)
+	}
+	want := "X86_64"
+	if arch == ArchARM64 {
+		want = "ARM64"
+	}
+	if found.Architecture != "" && found.Architecture != want {
+		return "", fmt.Errorf("build VM image %s is for %s, but the build needs an %s image", found.Name, found.Architecture, want)
+	}
+
+	resolved := (&gcp.ImagePath{Project: image.Project, Name: found.Name}).String()
+	if image.Family && version != "" {
+		m.logger.Infof("Using build VM image %s (pinned version of family %s)", resolved, image)
+	} else if image.Family {
+		m.logger.Infof("Using build VM image %s (latest of family %s)", resolved, image)
+	} else {
+		m.logger.Infof("Using build VM image %s", resolved)
+	}
+	return resolved, nil
 }
 
 // DeleteVM deletes a VM instance
 func (m *Manager) DeleteVM(ctx context.Context, name, zone string) error {
 	m.logger.Infof("Deleting VM: %s", name)
 
-	// Implementation would delete actual GCP VM
+	op, err := m.gcpClient.Compute().Instances.Delete(m.gcpClient.ProjectName(), zone, name).Context(ctx).Do()
+	if err != nil {
+		if gcp.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete instance %s: %w", name, err)
+	}
+	if err := m.gcpClient.WaitForZoneOperation(ctx, zone, op); err != nil {
+		return err
+	}
+
+	// The instance is deleted; a lagging list only matters to later cleanup steps
+	if err := m.gcpClient.WaitForInstanceGone(ctx, name, zone, instanceGoneTimeout); err != nil {
+		m.logger.Warnf("%v", err)
+	}
 	return nil
 }
 
-// SetupVM executes the embedded setup script on the VM
-func (m *Manager) SetupVM(ctx context.Context, instance *Instance) error {
+// SetupVM waits for the script started at boot to finish on the VM: the bootstrap
+// script on Linux, after which the caller runs the setup script over SSH, or the
+// full setup script on Windows.
+// Network reachability is checked first so egress problems fail fast
+// instead of surfacing as hung image pulls much later.
+func (m *Manager) SetupVM(ctx context.Context, instance *Instance) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "set up VM", "instance", instance.Name)
+	defer func() { span.End(err) }()
+
 	m.logger.Infof("Setting up VM: %s", instance.Name)
 
-	// Execute the embedded setup script
-	if err := scripts.ExecuteSetupScript(); err != nil {
+	if err := m.CheckConnectivity(ctx, instance); err != nil {
+		return err
+	}
+
+	// Linux VMs only bootstrap at boot; the Windows script does the whole setup
+	key, timeout := "bootstrap", m.tuning.BootstrapTimeout
+	if instance.OSType == OSWindows {
+		key, timeout = "setup", m.tuning.WindowsSetupTimeout
+	}
+
+	status, err := m.WaitForStatus(ctx, instance, key, timeout)
+	if err != nil {
 		return fmt.Errorf("failed to setup VM: %w", err)
 	}
+	if status != "done" {
+		return fmt.Errorf("%s script on VM %s reported status '%s' (check serial console output)", key, instance.Name, status)
+	}
+	if instance.OSType != OSWindows {
+		if attrs, err := m.GetGuestAttributes(ctx, instance); err == nil {
+			if attrs["additional-script-failures"] != "" {
+				m.logger.Warnf("Additional startup scripts failed on %s: %s (check serial console output)", instance.Name, attrs["additional-script-failures"])
+			}
+			if attrs["cloud-logging"] == "failed" {
+				m.logger.Warnf("The Ops Agent could not be set up on %s; its build log is not shipped to Cloud Logging (check serial console output)", instance.Name)
+			}
+		}
+	}
 
 	m.logger.Infof("VM setup completed: %s", instance.Name)
 	return nil
 }
 
+// GetGuestAttributes returns the guest attributes the setup script published on the VM
+func (m *Manager) GetGuestAttributes(ctx context.Context, instance *Instance) (map[string]string, error) {
+	return m.guestAttributes(ctx, instance, GuestAttributeNamespace)
+}
+
+// GetHostKeys returns the SSH host keys the VM published in the hostkeys/ guest attribute
+// namespace, in authorized_keys format. It is empty if the VM published none.
+func (m *Manager) GetHostKeys(ctx context.Context, instance *Instance) ([]string, error) {
+	attrs, err := m.guestAttributes(ctx, instance, hostKeysNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for keyType, value := range attrs {
+		// The guest agent publishes "<type> <base64>"; tolerate a bare base64 value
+		if !strings.HasPrefix(value, keyType+" ") {
+			value = keyType + " " + value
+		}
+		keys = append(keys, value)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Manager) guestAttributes(ctx context.Context, instance *Instance, namespace string) (map[string]string, error) {
+	attrs, err := m.gcpClient.Compute().Instances.GetGuestAttributes(m.gcpClient.ProjectName(), instance.Zone, instance.Name).
+		QueryPath(namespace + "/").Context(ctx).Do()
+	if err != nil {
+		if gcp.IsNotFound(err) {
+			// Nothing has been published yet
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read guest attributes of %s: %w", instance.Name, err)
+	}
+
+	values := make(map[string]string)
+	if attrs.QueryValue != nil {
+		for _, item := range attrs.QueryValue.Items {
+			values[item.Key] = item.Value
+		}
+	}
+	return values, nil
+}
+
+// GetStatus returns the status keys reported by the VM's startup script
+func (m *Manager) GetStatus(ctx context.Context, instance *Instance) (map[string]string, error) {
+	if instance.OSType == OSWindows {
+		return m.GetSerialStatus(ctx, instance)
+	}
+	return m.GetGuestAttributes(ctx, instance)
+}
+
+// WaitForStatus polls until the startup script reports a final value for the given key.
+// The in-progress value "running" is not final. A timeout of 0 leaves the wait
+// bounded by ctx alone.
+func (m *Manager) WaitForStatus(ctx context.Context, instance *Instance, key string, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var value string
+	err := m.poll(ctx, func() (bool, error) {
+		attrs, err := m.GetStatus(ctx, instance)
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		value, ok = attrs[key]
+		return ok && value != statusRunning, nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out waiting for VM %s to report '%s'", instance.Name, key)
+		}
+		return "", err
+	}
+	return value, nil
+}
+
 // ValidatePermissions validates GCP permissions
 func (m *Manager) ValidatePermissions(ctx context.Context, projectName, zone string) error {
 	m.logger.Debug("Validating GCP permissions...")
@@ -65,6 +494,41 @@ func (m *Manager) ValidatePermissions(ctx context.Context, projectName, zone str
 	return nil
 }
 
+func metadataItem(key, value string) *compute.MetadataItems {
+	return &compute.MetadataItems{Key: key, Value: googleapi.String(value)}
+}
+
+// SharesNetworkWithController reports whether the machine running the builder is
+// on GCP with an interface in the instance's VPC network, so the instance's
+// internal IP is reachable. It is false off GCP.
+func (m *Manager) SharesNetworkWithController(ctx context.Context, instance *Instance) (bool, error) {
+	controllerNetworks, err := gcp.ControllerNetworks()
+	if err != nil {
+		return false, nil
+	}
+
+	// https://www.googleapis.com/compute/v1/projects/<project>/global/networks/<name>
+	parts := strings.Split(instance.Network, "/")
+	if len(parts) < 5 || parts[len(parts)-2] != "networks" || parts[len(parts)-5] != "projects" {
+		return false, fmt.Errorf("unexpected network URL %q for instance %s", instance.Network, instance.Name)
+	}
+	project, name := parts[len(parts)-4], parts[len(parts)-1]
+
+	// The metadata server names networks by project number rather than ID
+	number, err := m.gcpClient.ProjectNumber(ctx, project)
+	if err != nil {
+		return false, err
+	}
+	want := fmt.Sprintf("projects/%s/networks/%s", number, name)
+
+	for _, network := range controllerNetworks {
+		if network == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // Config holds VM configuration
 type Config struct {
 	Name           string
@@ -74,10 +538,118 @@ type Config struct {
 	Subnet         string
 	ServiceAccount string
 	Preemptible    bool
+	Metadata       map[string]string // Extra instance metadata read by the setup script
+	OSType         string            // "linux" (default) or "windows"
+	Arch           string            // "x86_64" (default) or "arm64"
+	DataDisks      []string          // Existing disks attached at creation, device name = disk name
+	ReadOnlyDisks  bool              // Attach DataDisks read-only
+	Labels         map[string]string
+	NoExternalIP   bool   // No access config; egress needs Cloud NAT
+	ShieldedVM     bool   // Secure Boot, vTPM and integrity monitoring
+	BootImage      string // projects/<project>/global/images/[family/]<name>; empty selects a public image for OSType and Arch
+	SerialPort     int    // Serial port (1-4) the setup script logs and reports status to; 0 means 1
+	StartupScript  string // Replaces the bootstrap script of a Linux VM nothing is built on
+
+	// AdditionalScripts run from the Linux startup script, before its own steps
+	// or, with AdditionalScriptsAfter, after them
+	AdditionalScripts      []scripts.AdditionalScript
+	AdditionalScriptsAfter bool
 }
 
-// Instance represents a VM instance
+// Instance status values reported by the Compute API
+const (
+	StatusRunning    = "RUNNING"
+	StatusStopping   = "STOPPING"
+	StatusTerminated = "TERMINATED"
+)
+
+// Instance represents a VM instance as returned by the Compute API
 type Instance struct {
-	Name string
-	Zone string
+	ID                uint64
+	Name              string
+	Zone              string
+	OSType            string
+	MachineType       string // e.g. e2-standard-2
+	Status            string // PROVISIONING, STAGING, RUNNING, STOPPING, TERMINATED, ...
+	Preemptible       bool
+	InternalIP        string
+	ExternalIP        string
+	Network           string // URL of the VPC network of the first interface
+	BootImage         string // Image the boot disk was created from, when created by this tool
+	BootDisk          string // Name of the boot disk
+	SerialPort        int    // Serial port the setup script logs to, when created by this tool; 0 means 1
+	Labels            map[string]string
+	CreationTimestamp string
+
+	selfLink string
+
+	// serial tracks serial console status across polls (Windows)
+	serial *serialStatusReader
+}
+
+func newInstance(instance *compute.Instance, osType string) *Instance {
+	result := &Instance{
+		ID:                instance.Id,
+		Name:              instance.Name,
+		Zone:              gcp.ResourceName(instance.Zone),
+		OSType:            osType,
+		MachineType:       gcp.ResourceName(instance.MachineType),
+		Status:            instance.Status,
+		Labels:            instance.Labels,
+		CreationTimestamp: instance.CreationTimestamp,
+		selfLink:          instance.SelfLink,
+	}
+	if instance.Scheduling != nil {
+		result.Preemptible = instance.Scheduling.Preemptible
+	}
+	for _, attached := range instance.Disks {
+		if attached.Boot {
+			result.BootDisk = gcp.ResourceName(attached.Source)
+		}
+	}
+	if len(instance.NetworkInterfaces) > 0 {
+		nic := instance.NetworkInterfaces[0]
+		result.InternalIP = nic.NetworkIP
+		result.Network = nic.Network
+		if len(nic.AccessConfigs) > 0 {
+			result.ExternalIP = nic.AccessConfigs[0].NatIP
+		}
+	}
+	return result
+}
+
+// SelfLink returns the instance's resource URL
+func (i *Instance) SelfLink() string {
+	return i.selfLink
+}
+
+// Region returns the region of the instance's zone
+func (i *Instance) Region() string {
+	return gcp.RegionFromZone(i.Zone)
+}
+
+// IsRunning reports whether the instance was running when it was last fetched
+func (i *Instance) IsRunning() bool {
+	return i.Status == StatusRunning
+}
+
+// IsStopped reports whether the instance was stopping or stopped (e.g. preempted)
+// when it was last fetched
+func (i *Instance) IsStopped() bool {
+	return i.Status == StatusStopping || i.Status == StatusTerminated
+}
+
+// SerialConsoleCommand returns the gcloud command that prints the instance's
+// serial console, on the port its setup script logs to
+func (i *Instance) SerialConsoleCommand(project string) string {
+	command := fmt.Sprintf("gcloud compute instances get-serial-port-output %s --zone=%s --project=%s", i.Name, i.Zone, project)
+	if i.SerialPort > 1 {
+		command += fmt.Sprintf(" --port=%d", i.SerialPort)
+	}
+	return command
+}
+
+// serialPort returns the serial port the setup script reports to
+func (i *Instance) serialPort() int64 {
+	return int64(max(i.SerialPort, 1))
 }
@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/gcptest"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// newTestManager returns a manager of project p whose requests go to a fake Compute Engine API
+func newTestManager(t *testing.T) (*Manager, *gcptest.Server) {
+	t.Helper()
+	server := gcptest.NewServer(t)
+	client, err := gcp.NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(client, log.NewLogger(false, true, log.NewTextImpl(io.Discard)), DefaultTuning()), server
+}
+
+const testInstanceURL = "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/instances/builder"
+
+func TestCreateVMPopulatesFields(t *testing.T) {
+	m, server := newTestManager(t)
+	server.Handle(http.MethodPost, "projects/p/zones/us-central1-a/instances", gcptest.Operation("insert"))
+	server.Handle(http.MethodGet, "projects/p/zones/us-central1-a/instances/builder", gcptest.Respond(map[string]any{
+		"id":                "1234",
+		"name":              "builder",
+		"zone":              "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a",
+		"machineType":       "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/machineTypes/e2-standard-2",
+		"status":            "RUNNING",
+		"creationTimestamp": "2026-10-17T10:00:00.000-07:00",
+		"labels":            map[string]string{"team": "web"},
+		"scheduling":        map[string]any{"preemptible": true},
+		"disks": []map[string]any{
+			{"boot": true, "source": "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/disks/builder"},
+		},
+		"networkInterfaces": []map[string]any{{
+			"network":       "https://www.googleapis.com/compute/v1/projects/p/global/networks/default",
+			"networkIP":     "10.0.0.2",
+			"accessConfigs": []map[string]string{{"natIP": "203.0.113.7"}},
+		}},
+		"selfLink": testInstanceURL,
+	}))
+
+	instance, err := m.CreateVM(context.Background(), &Config{
+		Name: "builder", Zone: "us-central1-a", MachineType: "e2-standard-2", Network: "default", Subnet: "default",
+		Preemptible: true, SerialPort: 2, Labels: map[string]string{"team": "web"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVM() error = %v", err)
+	}
+
+	want := Instance{
+		ID: 1234, Name: "builder", Zone: "us-central1-a", MachineType: "e2-standard-2", Status: StatusRunning,
+		Preemptible: true, InternalIP: "10.0.0.2", ExternalIP: "203.0.113.7",
+		Network:   "https://www.googleapis.com/compute/v1/projects/p/global/networks/default",
+		BootImage: defaultBootImage, BootDisk: "builder", SerialPort: 2,
+		Labels: map[string]string{"team": "web"}, CreationTimestamp: "2026-10-17T10:00:00.000-07:00",
+		selfLink: testInstanceURL,
+	}
+	if !reflect.DeepEqual(*instance, want) {
+		t.Errorf("CreateVM() = %+v, want %+v", *instance, want)
+	}
+	if instance.SelfLink() != testInstanceURL {
+		t.Errorf("SelfLink() = %q, want %q", instance.SelfLink(), testInstanceURL)
+	}
+	if instance.Region() != "us-central1" {
+		t.Errorf("Region() = %q, want us-central1", instance.Region())
+	}
+	if !instance.IsRunning() || instance.IsStopped() {
+		t.Errorf("IsRunning() = %v, IsStopped() = %v, want running", instance.IsRunning(), instance.IsStopped())
+	}
+}
+
+func TestCreateVMDeletesInstanceItCannotFetch(t *testing.T) {
+	m, server := newTestManager(t)
+	server.Handle(http.MethodPost, "projects/p/zones/us-central1-a/instances", gcptest.Operation("insert"))
+	server.Handle(http.MethodGet, "projects/p/zones/us-central1-a/instances/builder", gcptest.Fail(http.StatusServiceUnavailable, "backend error"))
+	server.Handle(http.MethodDelete, "projects/p/zones/us-central1-a/instances/builder", gcptest.Operation("delete"))
+	server.Handle(http.MethodGet, "projects/p/zones/us-central1-a/instances", gcptest.Respond(map[string]any{}))
+
+	instance, err := m.CreateVM(context.Background(), &Config{Name: "builder", Zone: "us-central1-a", MachineType: "e2-standard-2"})
+	if err == nil {
+		t.Fatalf("CreateVM() = %+v, want an error", instance)
+	}
+	if n := len(server.Requests(http.MethodDelete, "projects/p/zones/us-central1-a/instances/builder")); n != 1 {
+		t.Errorf("instance deleted %d times, want 1", n)
+	}
+}
@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// FindInstances returns the instances labeled key=value in zone, or in every
+// zone of the project if zone is empty. The instances' OS type is osType.
+func (m *Manager) FindInstances(ctx context.Context, zone, key, value, osType string) ([]*Instance, error) {
+	project := m.gcpClient.ProjectName()
+	filter := fmt.Sprintf("labels.%s = %q", key, value)
+
+	var found []*Instance
+	if zone != "" {
+		err := m.gcpClient.Compute().Instances.List(project, zone).Filter(filter).Pages(ctx, func(list *compute.InstanceList) error {
+			for _, instance := range list.Items {
+				found = append(found, newInstance(instance, osType))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances in %s: %w", zone, err)
+		}
+		return found, nil
+	}
+
+	err := m.gcpClient.Compute().Instances.AggregatedList(project).Filter(filter).Pages(ctx, func(list *compute.InstanceAggregatedList) error {
+		for _, scoped := range list.Items {
+			for _, instance := range scoped.Instances {
+				found = append(found, newInstance(instance, osType))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	return found, nil
+}
+
+// GetMetadata returns the value of an instance's metadata key, or "" if it is not set
+func (m *Manager) GetMetadata(ctx context.Context, instance *Instance, key string) (string, error) {
+	current, err := m.gcpClient.Compute().Instances.Get(m.gcpClient.ProjectName(), instance.Zone, instance.Name).
+		Fields("metadata").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to get metadata of %s: %w", instance.Name, err)
+	}
+	if current.Metadata != nil {
+		for _, item := range current.Metadata.Items {
+			if item.Key == key && item.Value != nil {
+				return *item.Value, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// SetMetadata sets one metadata key of a running instance, keeping the others
+func (m *Manager) SetMetadata(ctx context.Context, instance *Instance, key, value string) error {
+	project := m.gcpClient.ProjectName()
+	current, err := m.gcpClient.Compute().Instances.Get(project, instance.Zone, instance.Name).
+		Fields("metadata").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of %s: %w", instance.Name, err)
+	}
+
+	metadata := current.Metadata
+	if metadata == nil {
+		metadata = &compute.Metadata{}
+	}
+	replaced := false
+	for _, item := range metadata.Items {
+		if item.Key == key {
+			item.Value = &value
+			replaced = true
+		}
+	}
+	if !replaced {
+		metadata.Items = append(metadata.Items, metadataItem(key, value))
+	}
+
+	// The fingerprint read above makes a concurrent change fail instead of being lost
+	op, err := m.gcpClient.Compute().Instances.SetMetadata(project, instance.Zone, instance.Name, metadata).Context(ctx).Do()
+	if err == nil {
+		err = m.gcpClient.WaitForZoneOperation(ctx, instance.Zone, op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set metadata %s of %s: %w", key, instance.Name, err)
+	}
+	return nil
+}
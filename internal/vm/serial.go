@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// serialStatusPrefix marks status lines written to the serial port by the Windows setup script
+const serialStatusPrefix = "GKE-IMAGE-CACHE-STATUS "
+
+// serialStatusReader reads the serial port incrementally and remembers every status
+// seen. Re-reading the whole buffer on each poll is unreliable: GCE keeps only the
+// most recent output, so an early status can scroll out of it, and the buffer
+// starts over when the VM restarts.
+type serialStatusReader struct {
+	next    int64  // offset to continue reading from
+	partial string // unterminated last line of the previous read
+	values  map[string]string
+}
+
+// GetSerialStatus returns the latest value of each status key the Windows setup
+// script wrote to the instance's serial port (1 unless chosen otherwise),
+// including keys that have since left the buffer
+func (m *Manager) GetSerialStatus(ctx context.Context, instance *Instance) (map[string]string, error) {
+	if instance.serial == nil {
+		instance.serial = &serialStatusReader{values: make(map[string]string)}
+	}
+	r := instance.serial
+
+	output, err := m.gcpClient.Compute().Instances.GetSerialPortOutput(m.gcpClient.ProjectName(), instance.Zone, instance.Name).
+		Port(instance.serialPort()).Start(r.next).Context(ctx).Do()
+	if err != nil {
+		if gcp.IsNotFound(err) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read serial port output of %s: %w", instance.Name, err)
+	}
+
+	switch {
+	case output.Next < r.next:
+		// The buffer went backwards: the VM restarted and the script runs again,
+		// so statuses from the previous boot no longer apply
+		m.logger.Warnf("Serial console of %s was reset (VM restarted?), resyncing", instance.Name)
+		instance.serial = nil
+		return m.GetSerialStatus(ctx, instance)
+	case output.Start > r.next:
+		// Output was dropped from the buffer before we read it
+		m.logger.Debugf("Serial console of %s skipped %d bytes", instance.Name, output.Start-r.next)
+		r.partial = ""
+	}
+
+	r.consume(output.Contents)
+	r.next = output.Next
+
+	values := make(map[string]string, len(r.values))
+	for k, v := range r.values {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// consume parses complete status lines; a trailing partial line waits for the next read
+func (r *serialStatusReader) consume(contents string) {
+	lines := strings.Split(r.partial+contents, "\n")
+	r.partial = lines[len(lines)-1]
+
+	for _, line := range lines[:len(lines)-1] {
+		i := strings.Index(line, serialStatusPrefix)
+		if i < 0 {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(line[i+len(serialStatusPrefix):]), "=")
+		if !ok || key == "" {
+			continue
+		}
+		r.values[key] = value
+	}
+}
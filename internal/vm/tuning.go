@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"context"
+	"time"
+)
+
+// Tuning holds how long a build waits for its VMs and how often it polls them.
+// Only the config file sets it (advanced.tuning); the defaults suit most
+// regions, and slow ones may need longer waits.
+type Tuning struct {
+	// BootTimeout covers booting the VM until its startup script reported the
+	// connectivity probes
+	BootTimeout time.Duration
+
+	// BootstrapTimeout covers the Linux bootstrap script, WindowsSetupTimeout
+	// the whole Windows setup script
+	BootstrapTimeout    time.Duration
+	WindowsSetupTimeout time.Duration
+
+	// SSHReadyTimeout covers the guest agent provisioning the SSH user and key
+	SSHReadyTimeout time.Duration
+
+	// StatusPollInterval is how often the startup script's status, and the
+	// analysis of a connectivity test, are read
+	StatusPollInterval time.Duration
+
+	// ConnectivityTestTimeout bounds one Network Management connectivity test
+	ConnectivityTestTimeout time.Duration
+}
+
+// DefaultTuning returns the waits and polling intervals used unless configured
+func DefaultTuning() Tuning {
+	return Tuning{
+		BootTimeout:             10 * time.Minute,
+		BootstrapTimeout:        15 * time.Minute,
+		WindowsSetupTimeout:     45 * time.Minute,
+		SSHReadyTimeout:         10 * time.Minute,
+		StatusPollInterval:      5 * time.Second,
+		ConnectivityTestTimeout: 3 * time.Minute,
+	}
+}
+
+// clock is the time source of the polling loops, replaced in tests
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// poll calls check every StatusPollInterval until it reports done or fails,
+// and returns ctx's error once ctx is done
+func (m *Manager) poll(ctx context.Context, check func() (done bool, err error)) error {
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.clock.After(m.tuning.StatusPollInterval):
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock records the waits of a polling loop; each wait ends at once
+// unless the clock is stopped
+type fakeClock struct {
+	mu      sync.Mutex
+	waits   []time.Duration
+	stopped bool
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	if !c.stopped {
+		ch <- time.Time{}
+	}
+	return ch
+}
+
+func TestPollUsesConfiguredInterval(t *testing.T) {
+	clock := &fakeClock{}
+	m := &Manager{tuning: Tuning{StatusPollInterval: 7 * time.Second}, clock: clock}
+
+	checks := 0
+	err := m.poll(context.Background(), func() (bool, error) {
+		checks++
+		return checks == 3, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.waits) != 2 || clock.waits[0] != 7*time.Second || clock.waits[1] != 7*time.Second {
+		t.Errorf("waits = %v, want two of 7s", clock.waits)
+	}
+}
+
+func TestPollStopsOnCancel(t *testing.T) {
+	clock := &fakeClock{stopped: true}
+	m := &Manager{tuning: Tuning{StatusPollInterval: time.Hour}, clock: clock}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.poll(ctx, func() (bool, error) { return false, nil })
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("poll() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll did not return after its context was cancelled")
+	}
+}
+
+func TestPollReturnsCheckError(t *testing.T) {
+	m := &Manager{tuning: DefaultTuning(), clock: &fakeClock{}}
+	want := errors.New("status unavailable")
+	if err := m.poll(context.Background(), func() (bool, error) { return false, want }); !errors.Is(err, want) {
+		t.Errorf("poll() = %v, want %v", err, want)
+	}
+}
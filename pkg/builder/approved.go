@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// checkApprovedDigests resolves each image to pull to its digest and fails the
+// build unless every digest is on the approved list, naming all images that
+// are not. Digest-pinned images (lockfiles) are checked as pinned. The images
+// are then pulled by the checked digests, so a tag moved after the check
+// cannot bring in an unapproved image.
+func (w *Workflow) checkApprovedDigests(ctx context.Context) error {
+	approved, err := image.LoadApprovedDigests(w.config.ApprovedDigests)
+	if err != nil {
+		return err
+	}
+
+	var rejected []string
+	checked := make([]string, 0, len(w.images))
+	for _, img := range w.images {
+		ref, err := image.ParseReference(img)
+		if err != nil {
+			return err
+		}
+		digest := ref.Digest
+		if digest == "" {
+			if digest, err = w.imageCache.ResolveDigest(ctx, img); err != nil {
+				return fmt.Errorf("cannot check %s against the approved digests: %w", img, err)
+			}
+		}
+		if !approved[digest] {
+			rejected = append(rejected, fmt.Sprintf("%s (%s)", img, digest))
+		}
+		checked = append(checked, ref.WithDigest(digest))
+	}
+
+	if len(rejected) > 0 {
+		return fmt.Errorf("%d images are not on the approved digest list %s:\n  %s",
+			len(rejected), w.config.ApprovedDigests, strings.Join(rejected, "\n  "))
+	}
+	w.logger.Infof("All %d images are on the approved digest list %s", len(w.images), w.config.ApprovedDigests)
+	w.images = checked
+	return nil
+}
@@ -0,0 +1,326 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"google.golang.org/api/googleapi"
+)
+
+// AttachState records a disk attached to this machine in local mode, from the
+// attach until its clean detach, so a crashed run's disk can be recovered
+type AttachState struct {
+	Disk       string    `json:"disk"`
+	DeviceName string    `json:"device_name"`
+	Instance   string    `json:"instance"`
+	Project    string    `json:"project"`
+	Zone       string    `json:"zone"`
+	MountPoint string    `json:"mount_point,omitempty"`
+	PID        int       `json:"pid"` // process that attached the disk
+	AttachedAt time.Time `json:"attached_at"`
+
+	// StartTime is when PID started, in clock ticks since boot, which tells
+	// it from a later process given the same PID; 0 if unknown
+	StartTime uint64 `json:"start_time,omitempty"`
+
+	// ContainerdState is the state directory of the containerd started on
+	// the disk, a cache disk in local mode
+	ContainerdState string `json:"containerd_state,omitempty"`
+}
+
+// maxAttachedDisks is the most disks a Compute instance can have attached
+const maxAttachedDisks = 128
+
+// attachStateDir returns the directory of the attachment state files, next to last-build.json
+func attachStateDir() (string, error) {
+	lastBuild, err := DefaultLastBuildPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(lastBuild), "attachments"), nil
+}
+
+// saveAttachState persists the state of a newly attached disk
+func saveAttachState(state *AttachState) error {
+	dir, err := attachStateDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, state.Disk+".json"), append(data, '\n'), 0600)
+	}
+	return err
+}
+
+// removeAttachState forgets a disk after its clean detach
+func removeAttachState(disk string) error {
+	dir, err := attachStateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, disk+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// staleAttachStates returns the attachments of this machine left behind by
+// runs that are no longer alive
+func staleAttachStates(instance string) ([]*AttachState, error) {
+	dir, err := attachStateDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []*AttachState
+	for _, file := range files {
+		state, err := readAttachState(file)
+		if err != nil {
+			return nil, err
+		}
+		if state.Instance != instance || processAlive(state.PID, state.StartTime) {
+			continue
+		}
+		stale = append(stale, state)
+	}
+	return stale, nil
+}
+
+// liveAttachState returns the state of a disk attached to this machine by a
+// run that is still alive, or nil
+func liveAttachState(instance, disk string) (*AttachState, error) {
+	dir, err := attachStateDir()
+	if err != nil {
+		return nil, err
+	}
+	state, err := readAttachState(filepath.Join(dir, disk+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil || state.Instance != instance || !processAlive(state.PID, state.StartTime) {
+		return nil, err
+	}
+	return state, nil
+}
+
+func readAttachState(file string) (*AttachState, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var state AttachState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("corrupt attachment state %s: %w", file, err)
+	}
+	return &state, nil
+}
+
+// processAlive reports whether the process with the PID that started at
+// startTime exists; a startTime of 0 matches any process with the PID
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if err := process.Signal(syscall.Signal(0)); err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+	if startTime == 0 {
+		return true
+	}
+	// The PID was reused if the process started at another time
+	current := processStartTime(pid)
+	return current == 0 || current == startTime
+}
+
+// processStartTime returns when a process started, in clock ticks since boot,
+// or 0 if it cannot be read
+func processStartTime(pid int) uint64 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	return parseStartTime(string(data))
+}
+
+// parseStartTime returns field 22, starttime, of a /proc/<pid>/stat line. The
+// command name, field 2, is in parentheses and may hold spaces and
+// parentheses itself, so the fields are counted from the last ')'.
+func parseStartTime(stat string) uint64 {
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0
+	}
+	fields := strings.Fields(stat[i+1:]) // from field 3 on
+	if len(fields) < 20 {
+		return 0
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return start
+}
+
+// CleanupAttachments unmounts, detaches and deletes the disks crashed
+// local-mode runs left attached to this VM, like a build with --auto-recover,
+// and returns their names. Disks of runs that are still alive are left alone.
+func (b *Builder) CleanupAttachments(ctx context.Context) ([]string, error) {
+	instance, err := gcp.QueryMetadata("instance/name")
+	if err != nil {
+		return nil, fmt.Errorf("cleanup recovers the disks of local-mode runs on this VM, but this machine is not a GCP VM: %w", err)
+	}
+	stale, err := staleAttachStates(instance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment state: %w", err)
+	}
+
+	w := NewWorkflow(b.config, b.logger, b.vmManager, b.diskManager, b.imageCache)
+	if err := w.recoverAttachments(ctx, instance, true); err != nil {
+		return nil, err
+	}
+	disks := make([]string, 0, len(stale))
+	for _, state := range stale {
+		disks = append(disks, state.Disk)
+	}
+	return disks, nil
+}
+
+// recoverAttachments finds disks a crashed local-mode run left attached to this
+// VM (instance). Without autoRecover it fails, listing them; with it, each is
+// unmounted, detached and deleted, since such disks are only ever throwaway
+// copies the run created.
+func (w *Workflow) recoverAttachments(ctx context.Context, instance string, autoRecover bool) error {
+	stale, err := staleAttachStates(instance)
+	if err != nil {
+		return fmt.Errorf("failed to read attachment state: %w", err)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	if !autoRecover {
+		descriptions := make([]string, 0, len(stale))
+		for _, state := range stale {
+			descriptions = append(descriptions, fmt.Sprintf("%s (attached %s, mounted at %s)",
+				state.Disk, state.AttachedAt.Local().Format(time.RFC3339), orNone(state.MountPoint)))
+		}
+		return fmt.Errorf("an earlier run did not finish and left %d disks attached to this VM: %s. "+
+			"Rerun with --auto-recover to unmount, detach and delete them", len(stale), strings.Join(descriptions, ", "))
+	}
+
+	runner := image.LocalRunner{}
+	for _, state := range stale {
+		w.logger.Warnf("Recovering disk %s left attached by an earlier run", state.Disk)
+		if state.ContainerdState != "" {
+			env := []string{"MOUNT=" + state.MountPoint, "STATE=" + state.ContainerdState}
+			if output, err := image.RunAsRoot(ctx, runner, releaseCacheDiskScript, env); err != nil {
+				return fmt.Errorf("failed to unmount %s: %w: %s", state.MountPoint, err, strings.TrimSpace(output))
+			}
+		} else if state.MountPoint != "" {
+			// Not mounted any more after a reboot; only a failing umount of a mounted disk matters
+			command := fmt.Sprintf("if mountpoint -q %[1]s; then sudo umount %[1]s; fi; sudo rmdir %[1]s 2>/dev/null || true", state.MountPoint)
+			if output, err := runner.Run(ctx, command); err != nil {
+				return fmt.Errorf("failed to unmount %s: %w: %s", state.MountPoint, err, strings.TrimSpace(output))
+			}
+		}
+		if err := w.vmManager.DetachDisk(ctx, state.Instance, state.Zone, state.DeviceName); err != nil && !isNotAttached(err) {
+			return err
+		}
+		if err := w.diskManager.DeleteDisk(ctx, state.Disk, state.Zone); err != nil {
+			return err
+		}
+		if err := removeAttachState(state.Disk); err != nil {
+			return fmt.Errorf("failed to remove attachment state of %s: %w", state.Disk, err)
+		}
+		w.logger.Infof("Recovered disk %s", state.Disk)
+	}
+	return nil
+}
+
+// freeDeviceName returns a device name not in use on this VM (instance) for
+// attaching diskName: the disk name itself, or the disk name with the first free
+// numeric suffix. A name held by a disk an earlier, unfinished run of the same
+// image left behind without a state file is not skipped but reported, since
+// picking another name would leave that disk attached for good.
+func (w *Workflow) freeDeviceName(ctx context.Context, instance, diskName string) (string, error) {
+	devices, err := w.vmManager.AttachedDevices(ctx, instance, w.config.Zone)
+	if err != nil {
+		return "", err
+	}
+
+	name := diskName
+	for i := 2; i <= maxAttachedDisks+1; i++ {
+		source, taken := devices[name]
+		if !taken {
+			if name != diskName {
+				w.logger.Infof("Device name %s is in use on this VM, attaching disk %s as %s", diskName, diskName, name)
+			}
+			return name, nil
+		}
+		if err := w.checkDeviceOwner(ctx, instance, name, source); err != nil {
+			return "", err
+		}
+		name = verifyResourceName(diskName, strconv.Itoa(i))
+	}
+	return "", fmt.Errorf("no free device name for disk %s: this VM has %d disks attached", diskName, len(devices))
+}
+
+// checkDeviceOwner fails if the disk attached as device (source is its URL) was
+// left by an earlier run of this image that recorded no attachment state
+func (w *Workflow) checkDeviceOwner(ctx context.Context, instance, device, source string) error {
+	parts := strings.Split(source, "/")
+	if len(parts) < 4 || parts[len(parts)-2] != "disks" {
+		return nil
+	}
+	diskName, zone := parts[len(parts)-1], parts[len(parts)-3]
+
+	if state, err := liveAttachState(instance, diskName); err != nil || state != nil {
+		return err // attached by a run that is still going
+	}
+	attached, err := w.diskManager.GetDisk(ctx, diskName, zone)
+	if err != nil {
+		w.logger.Debugf("Cannot read disk %s attached as %s: %v", diskName, device, err)
+		return nil
+	}
+	if attached.Labels[vmImageLabel] != w.config.DiskImageName {
+		return nil
+	}
+	return fmt.Errorf("device name %s is in use by disk %s, left attached to this VM by an earlier run for image %s that recorded no attachment state. "+
+		"Detach and delete it, then rerun: gcloud compute instances detach-disk %s --disk=%s --zone=%s --project=%s && "+
+		"gcloud compute disks delete %s --zone=%s --project=%s",
+		device, diskName, w.config.DiskImageName, instance, diskName, zone, w.config.ProjectName, diskName, zone, w.config.ProjectName)
+}
+
+// isNotAttached recognizes a detach of a disk that is no longer attached, or
+// from an instance or of a disk that no longer exists
+func isNotAttached(err error) bool {
+	if gcp.IsNotFound(err) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Message, "deviceName")
+}
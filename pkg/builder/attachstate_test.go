@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestParseStartTime(t *testing.T) {
+	tests := []struct {
+		name string
+		stat string
+		want uint64
+	}{
+		{
+			name: "plain",
+			stat: "1234 (gke-image-cache) S 1 1234 1234 0 -1 4194560 1000 0 0 0 10 5 0 0 20 0 12 0 987654 123456789 2000",
+			want: 987654,
+		},
+		{
+			name: "command with spaces and parentheses",
+			stat: "1234 (a) b (c) S 1 1234 1234 0 -1 4194560 1000 0 0 0 10 5 0 0 20 0 12 0 42 123456789 2000",
+			want: 42,
+		},
+		{name: "truncated", stat: "1234 (x) S 1 2 3", want: 0},
+		{name: "garbage", stat: "not a stat line", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStartTime(tt.stat); got != tt.want {
+				t.Errorf("parseStartTime() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessAlive(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc")
+	}
+	pid := os.Getpid()
+	start := processStartTime(pid)
+	if start == 0 {
+		t.Fatal("start time of this process not read")
+	}
+	if !processAlive(pid, start) {
+		t.Error("this process is not alive")
+	}
+	if !processAlive(pid, 0) {
+		t.Error("this process is not alive without a start time")
+	}
+	if processAlive(pid, start+1) {
+		t.Error("a process with this PID that started at another time is alive")
+	}
+	if processAlive(0, 0) {
+		t.Error("PID 0 is alive")
+	}
+}
@@ -0,0 +1,25 @@
+package builder
+
+import (
+	"context"
+	"sync"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// ProbeRegistryAuth checks, for each configured image, that the configured
+// image pull authentication can read its manifest. The probes run in parallel
+// and are returned in the order of the images.
+func (b *Builder) ProbeRegistryAuth(ctx context.Context) []image.AuthProbe {
+	probes := make([]image.AuthProbe, len(b.config.ContainerImages))
+	var wg sync.WaitGroup
+	for i, img := range b.config.ContainerImages {
+		wg.Add(1)
+		go func(i int, img string) {
+			defer wg.Done()
+			probes[i] = b.imageCache.ProbeAuth(ctx, img)
+		}(i, img)
+	}
+	wg.Wait()
+	return probes
+}
@@ -2,11 +2,18 @@ package builder
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/0x00fafa/gke-image-cache-builder/internal/auth"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/deadline"
 	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
 	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/trace"
 	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
@@ -22,24 +29,41 @@ type Builder struct {
 	vmManager   *vm.Manager
 	diskManager *disk.Manager
 	imageCache  *image.Cache
+
+	// runID labels the build VMs' log in Cloud Logging; empty without --cloud-logging
+	runID string
+
+	// buildID labels the build VMs of a remote build, which "watch" finds them by
+	buildID string
 }
 
 // NewBuilder creates a new Builder instance
 func NewBuilder(cfg *config.Config) (*Builder, error) {
-	// Initialize logger (console only, no GCS)
-	logger := log.NewConsoleLogger(cfg.Verbose, cfg.Quiet)
+	// Credentials are looked up once; the GCP client and registry auth share them
+	authManager := auth.NewManager(cfg.GCPOAuth, cfg.ImagePullAuth)
+	credentials, err := authManager.GetGCPAuth().GetClientOption(context.Background())
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize GCP client
-	gcpClient, err := gcp.NewClient(cfg.ProjectName, cfg.GCPOAuth)
+	gcpClient, err := gcp.NewClient(cfg.ProjectName, credentials, cfg.APIEndpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCP client: %w", err)
 	}
 
+	logger, err := newLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DebugHTTP {
+		httputil.EnableDebug(logger)
+	}
+
 	// Initialize managers
-	authManager := auth.NewManager(cfg.GCPOAuth, cfg.ImagePullAuth)
-	vmManager := vm.NewManager(gcpClient, logger)
+	vmManager := vm.NewManager(gcpClient, logger, cfg.Tuning)
 	diskManager := disk.NewManager(gcpClient, logger)
-	imageCache := image.NewCache(logger)
+	imageCache := image.NewCache(logger, authManager.GetRegistryAuth())
 
 	return &Builder{
 		config:      cfg,
@@ -52,18 +76,274 @@ func NewBuilder(cfg *config.Config) (*Builder, error) {
 	}, nil
 }
 
-// BuildImageCache orchestrates the entire image cache creation process
-func (b *Builder) BuildImageCache(ctx context.Context) error {
+// newLogger creates the build's logger: on the console in the configured
+// format, and also in a log file if one is configured
+func newLogger(cfg *config.Config) (*log.Logger, error) {
+	var console log.LoggerImpl = log.NewConsoleImpl(log.ColorEnabled(cfg.NoColor))
+	if cfg.LogFormat == config.LogFormatJSON {
+		// stdout is left to the build result
+		console = log.NewJSONImpl(os.Stderr)
+	}
+	if cfg.LogFile == "" {
+		return log.NewLogger(cfg.Verbose, cfg.Quiet, console), nil
+	}
+
+	file, err := log.NewFileImpl(cfg.LogFile, cfg.LogFormat == config.LogFormatJSON)
+	if err != nil {
+		return nil, err
+	}
+	return log.NewLogger(cfg.Verbose, cfg.Quiet, log.MultiImpl{console, file}), nil
+}
+
+// Close releases what the builder holds open, such as the log file
+func (b *Builder) Close() error {
+	return b.logger.Close()
+}
+
+// BuildResult describes the images a successful build produced
+type BuildResult struct {
+	Project string
+	Zone    string
+	Mode    config.ExecutionMode
+	Images  []string // one per partition, including existing images found with --skip-if-exists
+
+	// Disks are the self-links of the cache disks kept with --output-type
+	// disk-only or both, one per partition
+	Disks []string
+
+	// ImageType is the GKE image type of the node pools the images are built
+	// for, or "" if not known; Arm is set for arm64 node pools
+	ImageType string
+	Arm       bool
+}
+
+// BuildImageCache orchestrates the entire image cache creation process. The
+// build is bounded by the configured timeout, which "control extend" can push back.
+func (b *Builder) BuildImageCache(ctx context.Context) (*BuildResult, error) {
 	b.logger.Info("Starting image cache build process")
-	b.logger.Infof("Disk image name: %s", b.config.DiskImageName)
+	if template := b.config.DiskImageNameTemplate(); template != "" {
+		b.logger.Infof("Disk image name: %s (from %s)", b.config.DiskImageName, template)
+	} else {
+		b.logger.Infof("Disk image name: %s", b.config.DiskImageName)
+	}
+	if system := b.config.SystemImages(); len(system) > 0 {
+		b.logger.Infof("GKE %s system images: %s", b.config.IncludeGKESystemImages, strings.Join(system, ", "))
+	}
+	for _, note := range b.config.SpecNotes() {
+		b.logger.Infof("From %s: %s", b.config.FromSpec, note)
+	}
+	if duplicates := b.config.DuplicateImages(); len(duplicates) > 0 {
+		b.logger.Infof("Removed %d duplicate container images: %s", len(duplicates), strings.Join(duplicates, ", "))
+	}
+	for _, tags := range b.config.MultipleTags() {
+		b.logger.Warnf("%s is listed with %d tags, each cached in full: %s (--forbid-multiple-tags fails such builds)",
+			tags.Repository, len(tags.Images), strings.Join(tags.Images, ", "))
+	}
+	// A lockfile's images are only known once it is read
+	if b.config.Lockfile == "" {
+		if preinstalled := b.config.PreinstalledImages(); len(preinstalled) > 0 {
+			b.logger.Warnf("COS %s nodes already hold %s: caching them again only takes disk space; remove them from the image list",
+				b.config.COSVersion, strings.Join(preinstalled, ", "))
+		}
+		for _, pattern := range b.config.UnusedCredentialProviders(b.config.ContainerImages) {
+			b.logger.Warnf("Credential provider pattern %s (%s) matches none of the container images; check it against their registries",
+				pattern, b.config.CredentialProviders[pattern])
+		}
+	}
 	b.logger.Infof("Container images: %v", b.config.ContainerImages)
 
-	workflow := NewWorkflow(b.config, b.logger, b.vmManager, b.diskManager, b.imageCache)
+	// Nothing is created until the project is known to be the intended one
+	if err := b.checkProject(ctx); err != nil {
+		return nil, err
+	}
+
+	// A cache disk may be redundant for images nodes can stream
+	b.checkImageStreaming(ctx)
+
+	// Validation kept the timeout within a preemptible VM's run time, but not its extensions
+	if b.config.Preemptible && b.config.TotalTimeout()+b.config.MaxTimeoutExtension > config.MaxPreemptibleRunTime {
+		b.logger.Warnf("The preemptible build VM is stopped after %s at most; extending the build's timeout with 'control extend' past that cannot keep it running",
+			config.MaxPreemptibleRunTime)
+	}
+
+	// A failed build must not leave the reference of an earlier one behind
+	if err := removePreviousOutput(b.config.WriteImageRef, "image reference"); err != nil {
+		return nil, err
+	}
+	if err := removePreviousOutput(b.config.NodePoolConfig, "node pool configuration"); err != nil {
+		return nil, err
+	}
+
+	// Every retry loop of the build draws on one budget
+	budget := retry.NewBudget(b.config.RetryBudget)
+	ctx = retry.WithBudget(ctx, budget)
+
+	attestor, err := b.newAttestor()
+	if err != nil {
+		return nil, err
+	}
+
+	if b.config.IsRemoteMode() {
+		b.buildID = newRunID()
+		b.logger.Infof("Build ID: %s; if this invocation is lost, finish the build from any machine with 'watch --build-id %s --project-name %s'",
+			b.buildID, b.buildID, b.config.ProjectName)
+	}
+	recorder := newBuildRecorder(b.config, b.buildID, b.logger)
+	if b.config.CloudLogging {
+		b.runID = newRunID()
+	}
 
-	if err := workflow.Execute(ctx); err != nil {
-		return fmt.Errorf("workflow execution failed: %w", err)
+	// The timeout, and the pull timeout derived from it, can be extended while the
+	// build runs. A pull timeout is added to the deadline only while pulling.
+	controller := deadline.NewController(b.config.MaxTimeoutExtension)
+	ctx, cancel := controller.WithTimeout(ctx, b.config.TotalTimeout()-b.config.PullTimeout)
+	defer cancel()
+	ctx = deadline.NewContext(ctx, controller)
+	if b.config.MaxTimeoutExtension > 0 {
+		stop, err := b.serveControl(controller, recorder)
+		if err != nil {
+			b.logger.Warnf("The build timeout cannot be extended with 'control extend': %v", err)
+		} else {
+			defer stop()
+		}
+	}
+
+	var tracer *trace.Recorder
+	if b.config.TraceFile != "" {
+		tracer = trace.NewRecorder()
+		ctx = trace.WithRecorder(ctx, tracer)
+	}
+	buildCtx, span := trace.Start(ctx, trace.CategoryPhase, "build", "image", b.config.DiskImageName, "project", b.config.ProjectName)
+	images, disks, err := b.build(buildCtx, recorder, attestor)
+	span.End(err)
+	record := recorder.finish(err)
+	if b.runID != "" {
+		b.logCloudLoggingLink()
+	}
+	b.logHTTPStats()
+	b.writeTrace(tracer)
+	if err != nil {
+		recorder.logVMs(record)
+		recorder.logLeaked(record)
+		recorder.logOutputs(record)
+		return nil, err
 	}
+	recorder.logLeaked(record)
 
+	recorder.logPullSummary(record)
+	if used := budget.Used(); used > 0 {
+		b.logger.Infof("%d failed attempts were retried (retry budget %d)", used, b.config.RetryBudget)
+	}
 	b.logger.Success("Image cache build completed successfully")
+	return b.newResult(images, disks), nil
+}
+
+// newResult returns the result of a build that created images and kept disks
+func (b *Builder) newResult(images, disks []string) *BuildResult {
+	return &BuildResult{
+		Project:   b.config.ProjectName,
+		Zone:      b.config.Zone,
+		Mode:      b.config.Mode,
+		Images:    images,
+		Disks:     disks,
+		ImageType: nodePoolImageType(b.config),
+		Arm:       b.config.IsARM64(),
+	}
+}
+
+// logHTTPStats logs the counters of the registry, token and metadata
+// requests, and of the retried pulls, which last-build.json records too
+func (b *Builder) logHTTPStats() {
+	stats := httputil.Snapshot()
+	if stats.Requests == 0 && stats.PullRetries == 0 {
+		return
+	}
+	b.logger.Infof("HTTP requests to registries, token endpoints and the metadata server: %d (%d retries, %d 4xx, %d 5xx, %d without response); pulls retried: %d",
+		stats.Requests, stats.Retries, stats.ClientErrors, stats.ServerErrors, stats.Failures, stats.PullRetries)
+}
+
+// writeTrace writes the timeline of the build to --trace, failed or not
+func (b *Builder) writeTrace(tracer *trace.Recorder) {
+	if tracer == nil {
+		return
+	}
+	if err := tracer.WriteFile(b.config.TraceFile); err != nil {
+		b.logger.Warnf("Build timeline not saved: %v", err)
+		return
+	}
+	b.logger.Infof("Wrote the build timeline (%d spans) to %s; open it in chrome://tracing or ui.perfetto.dev", tracer.Len(), b.config.TraceFile)
+}
+
+// build runs the workflows and returns the names of the images and the
+// self-links of the disks they produced
+func (b *Builder) build(ctx context.Context, recorder *buildRecorder, attestor *attestor) ([]string, []string, error) {
+	// Snapshot the configuration as given, before partitioning rewrites it
+	snapshot, err := b.publishConfigSnapshot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var images, disks []string
+	if b.config.Partitions > 1 {
+		images, disks, err = b.buildPartitioned(ctx, recorder, snapshot, attestor)
+		if err != nil {
+			return images, disks, err
+		}
+	} else {
+		workflow := NewWorkflow(b.config, b.logger, b.vmManager, b.diskManager, b.imageCache)
+		workflow.recorder = recorder
+		workflow.snapshot = snapshot
+		workflow.attestor = attestor
+		workflow.runID = b.runID
+		workflow.buildID = b.buildID
+		if err := workflow.Execute(ctx); err != nil {
+			return nil, nil, fmt.Errorf("workflow execution failed: %w", err)
+		}
+		if workflow.resultImage != "" {
+			images = []string{workflow.resultImage}
+		}
+		if workflow.resultDisk != "" {
+			disks = []string{workflow.resultDisk}
+		}
+	}
+
+	// A warning logged once the images were created, e.g. by cleanup, also
+	// keeps a strict build from publishing them
+	if err := checkWarnings(b.config, b.logger); err != nil {
+		return nil, nil, errors.Join(err, discardImages(ctx, b.config, b.diskManager, recorder, recorder.createdImages()))
+	}
+	if err := b.writeImageRef(images); err != nil {
+		return nil, nil, err
+	}
+	if err := b.writeNodePoolConfig(images); err != nil {
+		return nil, nil, err
+	}
+	return images, disks, nil
+}
+
+// checkWarnings fails a build run with --abort-on-warning once a warning was logged
+func checkWarnings(cfg *config.Config, logger *log.Logger) error {
+	if !cfg.AbortOnWarning {
+		return nil
+	}
+	if n := logger.Warnings(); n > 0 {
+		return fmt.Errorf("%d warnings were logged and --abort-on-warning is set", n)
+	}
 	return nil
 }
+
+// discardImages deletes images the build created, once checkWarnings failed
+// it, also after the build's context ended, within CleanupTimeout
+func discardImages(ctx context.Context, cfg *config.Config, diskManager *disk.Manager, recorder *buildRecorder, images []string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.CleanupTimeout)
+	defer cancel()
+	var errs []error
+	for _, name := range images {
+		if err := diskManager.DeleteImage(ctx, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		recorder.imageDeleted(name)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,192 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// localCacheDiskScript formats the cache disk $DEVICE attached to this machine
+// unless it holds a filesystem already, mounts it at $MOUNT, and starts a
+// containerd of its own with the disk as its root and its state, socket and
+// PID file in $STATE, leaving this machine's containerd alone. The CRI plugin
+// is disabled: images are only pulled, never run.
+const localCacheDiskScript = `set -e
+dev="/dev/disk/by-id/google-$DEVICE"
+for i in $(seq 1 30); do [ -e "$dev" ] && break; sleep 2; done
+[ -e "$dev" ] || { echo "device $dev did not appear" >&2; exit 1; }
+command -v containerd >/dev/null || { echo "containerd is not installed on this machine" >&2; exit 1; }
+if [ -z "$(blkid -o value -s TYPE "$dev" || true)" ]; then
+  mkfs.ext4 -q -F -m 0 -E lazy_itable_init=0,lazy_journal_init=0,discard "$dev"
+fi
+mkdir -p "$MOUNT" "$STATE"
+mountpoint -q "$MOUNT" || mount -o discard "$dev" "$MOUNT"
+cat > "$STATE/config.toml" << EOF
+version = 2
+root = "$MOUNT"
+state = "$STATE"
+disabled_plugins = ["io.containerd.grpc.v1.cri"]
+
+[grpc]
+  address = "$STATE/containerd.sock"
+
+[ttrpc]
+  address = "$STATE/containerd.sock.ttrpc"
+EOF
+if [ "$SNAPSHOTTER" = "stargz" ]; then
+  cat >> "$STATE/config.toml" << 'EOF'
+
+[proxy_plugins.stargz]
+  type = "snapshot"
+  address = "/run/containerd-stargz-grpc/containerd-stargz-grpc.sock"
+EOF
+fi
+setsid containerd --config "$STATE/config.toml" > "$STATE/containerd.log" 2>&1 < /dev/null &
+echo $! > "$STATE/containerd.pid"
+for i in $(seq 1 30); do
+  ctr --address "$STATE/containerd.sock" version >/dev/null 2>&1 && exit 0
+  sleep 1
+done
+tail -n 20 "$STATE/containerd.log" >&2
+echo "containerd did not start on the cache disk" >&2
+exit 1`
+
+// releaseCacheDiskScript stops the containerd whose root is the cache disk
+// mounted at $MOUNT, flushes the disk and unmounts it. The containerd is the
+// one started by localCacheDiskScript with its state in $STATE, or without
+// $STATE the build VM's service, whose fstab entry for the disk is removed.
+const releaseCacheDiskScript = `set -e
+if [ -n "${STATE:-}" ]; then
+  if [ -f "$STATE/containerd.pid" ]; then
+    pid=$(cat "$STATE/containerd.pid")
+    kill "$pid" 2>/dev/null || true
+    for i in $(seq 1 30); do kill -0 "$pid" 2>/dev/null || break; sleep 1; done
+  fi
+else
+  systemctl stop containerd
+  systemctl stop stargz-snapshotter 2>/dev/null || true
+  sed -i "\| $MOUNT |d" /etc/fstab
+fi
+sync
+if mountpoint -q "$MOUNT"; then umount "$MOUNT"; fi
+if [ -n "${STATE:-}" ]; then
+  rmdir "$MOUNT" 2>/dev/null || true
+  rm -rf "$STATE"
+fi`
+
+// localCachePaths returns where a cache disk attached to this machine is
+// mounted, and where the containerd on it keeps its state
+func localCachePaths(diskName string) (mountPoint, stateDir string) {
+	return "/mnt/gke-image-cache-" + diskName, "/run/gke-image-cache-" + diskName
+}
+
+// mountLocalCacheDisk attaches the cache disk read-write to this VM in local
+// mode, formats and mounts it, and starts a containerd with the disk as its
+// root that the images are pulled into
+func (w *Workflow) mountLocalCacheDisk(ctx context.Context, resources *WorkflowResources) error {
+	diskName := resources.CacheDisk.Name
+	mountPoint, stateDir := localCachePaths(diskName)
+	attachment, err := w.attachLocal(ctx, diskName, false, mountPoint, stateDir)
+	if err != nil {
+		return err
+	}
+	resources.Attachment = attachment
+	resources.CacheDevice = attachment.DeviceName
+
+	env := []string{"DEVICE=" + attachment.DeviceName, "MOUNT=" + mountPoint, "STATE=" + stateDir, "SNAPSHOTTER=" + w.config.Snapshotter}
+	if output, err := image.RunAsRoot(ctx, image.LocalRunner{}, localCacheDiskScript, env); err != nil {
+		return fmt.Errorf("failed to mount cache disk %s as containerd's root: %w: %s", diskName, err, strings.TrimSpace(output))
+	}
+	resources.Store = image.Store{Root: mountPoint, Address: stateDir + "/containerd.sock"}
+	w.logger.Infof("Mounted cache disk %s at %s, pulling into a containerd on it", diskName, mountPoint)
+	return nil
+}
+
+// releaseCacheDisk stops the containerd whose root is the cache disk and
+// unmounts the disk, so that it is consistent when it is captured or kept. In
+// local mode the disk is then detached from this VM. Windows build VMs are
+// left alone: their disk is not mounted over SSH.
+func (w *Workflow) releaseCacheDisk(ctx context.Context, resources *WorkflowResources) error {
+	switch {
+	case resources.Attachment != nil:
+		env := []string{"MOUNT=" + resources.Attachment.MountPoint, "STATE=" + resources.Attachment.ContainerdState}
+		if output, err := image.RunAsRoot(ctx, image.LocalRunner{}, releaseCacheDiskScript, env); err != nil {
+			return fmt.Errorf("failed to unmount cache disk %s: %w: %s", resources.CacheDisk.Name, err, strings.TrimSpace(output))
+		}
+		if err := w.detachLocal(ctx, resources.Attachment); err != nil {
+			return err
+		}
+		resources.Attachment = nil
+	case resources.SSHClient != nil && !w.config.IsWindows():
+		env := []string{"MOUNT=" + image.DefaultRoot}
+		if output, err := image.RunAsRoot(ctx, resources.SSHClient, releaseCacheDiskScript, env); err != nil {
+			return fmt.Errorf("failed to unmount cache disk %s on the build VM: %w: %s", resources.CacheDisk.Name, err, strings.TrimSpace(output))
+		}
+	default:
+		return nil
+	}
+	w.logger.Infof("Unmounted cache disk %s", resources.CacheDisk.Name)
+	return nil
+}
+
+// attachLocal attaches a disk to this VM in local mode, read-only or
+// read-write, under a free device name. The attachment is recorded in an
+// AttachState, with where the disk is mounted and the state directory of a
+// containerd on it, if any, until detachLocal.
+func (w *Workflow) attachLocal(ctx context.Context, diskName string, readOnly bool, mountPoint, containerdState string) (*AttachState, error) {
+	instance, err := gcp.QueryMetadata("instance/name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get this VM's name: %w", err)
+	}
+	if err := w.recoverAttachments(ctx, instance, w.config.AutoRecover); err != nil {
+		return nil, err
+	}
+
+	deviceName, err := w.freeDeviceName(ctx, instance, diskName)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &AttachState{
+		Disk:            diskName,
+		DeviceName:      deviceName,
+		Instance:        instance,
+		Project:         w.config.ProjectName,
+		Zone:            w.config.Zone,
+		MountPoint:      mountPoint,
+		ContainerdState: containerdState,
+		PID:             os.Getpid(),
+		StartTime:       processStartTime(os.Getpid()),
+		AttachedAt:      time.Now().UTC(),
+	}
+	// Recorded first: a crash during the attach leaves nothing unrecorded
+	if err := saveAttachState(state); err != nil {
+		return nil, fmt.Errorf("failed to record the attachment of disk %s: %w", diskName, err)
+	}
+	if err := w.vmManager.AttachDisk(ctx, instance, w.config.Zone, diskName, deviceName, readOnly); err != nil {
+		if removeErr := removeAttachState(diskName); removeErr != nil {
+			w.logger.Warnf("Failed to remove the attachment state of disk %s: %v", diskName, removeErr)
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+// detachLocal detaches a disk attached with attachLocal, also after the
+// build's context ended, within CleanupTimeout, and forgets its attachment
+func (w *Workflow) detachLocal(ctx context.Context, state *AttachState) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.config.CleanupTimeout)
+	defer cancel()
+	if err := w.vmManager.DetachDisk(ctx, state.Instance, state.Zone, state.DeviceName); err != nil {
+		return err
+	}
+	if err := removeAttachState(state.Disk); err != nil {
+		w.logger.Warnf("Failed to remove the attachment state of disk %s: %v", state.Disk, err)
+	}
+	return nil
+}
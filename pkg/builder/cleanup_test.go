@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/gcptest"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// TestCleanupBootDisk cleans up after a build VM whose boot disk auto-delete
+// removed, or did not
+func TestCleanupBootDisk(t *testing.T) {
+	tests := []struct {
+		name       string
+		bootDisk   http.HandlerFunc // get of the boot disk after the VM's deletion
+		deleteDisk http.HandlerFunc
+		deleted    bool   // whether the boot disk is deleted by cleanup
+		leaked     string // in the log, "" for nothing left behind
+	}{
+		{
+			name:     "auto-deleted",
+			bootDisk: gcptest.Fail(http.StatusNotFound, "not found"),
+		},
+		{
+			name:       "auto-delete failed",
+			bootDisk:   gcptest.Respond(map[string]any{"name": "builder"}),
+			deleteDisk: gcptest.Operation("delete-boot"),
+			deleted:    true,
+		},
+		{
+			name:       "boot disk cannot be deleted",
+			bootDisk:   gcptest.Respond(map[string]any{"name": "builder"}),
+			deleteDisk: gcptest.Fail(http.StatusForbidden, "denied"),
+			deleted:    true,
+			leaked:     "gcloud compute disks delete builder --zone=z --project=p",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gcptest.NewServer(t)
+			server.Handle(http.MethodDelete, "projects/p/zones/z/instances/builder", gcptest.Operation("delete-vm"))
+			server.Handle(http.MethodGet, "projects/p/zones/z/instances", gcptest.Respond(map[string]any{}))
+			server.Handle(http.MethodGet, "projects/p/zones/z/disks/builder", tt.bootDisk)
+			if tt.deleteDisk != nil {
+				server.Handle(http.MethodDelete, "projects/p/zones/z/disks/builder", tt.deleteDisk)
+			}
+			server.Handle(http.MethodGet, "projects/p/zones/z/disks/cache", gcptest.Respond(map[string]any{"name": "cache"}))
+			server.Handle(http.MethodDelete, "projects/p/zones/z/disks/cache", gcptest.Operation("delete-cache"))
+
+			client, err := gcp.NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output bytes.Buffer
+			logger := log.NewLogger(false, false, log.NewTextImpl(&output))
+			cfg := config.NewConfig()
+			cfg.ProjectName = "p"
+			cfg.Zone = "z"
+			w := NewWorkflow(cfg, logger, vm.NewManager(client, logger, vm.DefaultTuning()), disk.NewManager(client, logger), nil)
+
+			w.cleanupResources(context.Background(), &WorkflowResources{
+				VMInstance: &vm.Instance{Name: "builder", Zone: "z", BootDisk: "builder"},
+				CacheDisk:  &disk.Disk{Name: "cache", Zone: "z"},
+			})
+
+			if n := len(server.Requests(http.MethodDelete, "projects/p/zones/z/instances/builder")); n != 1 {
+				t.Errorf("VM deleted %d times, want 1", n)
+			}
+			if deleted := len(server.Requests(http.MethodDelete, "projects/p/zones/z/disks/builder")) > 0; deleted != tt.deleted {
+				t.Errorf("boot disk deleted = %v, want %v", deleted, tt.deleted)
+			}
+			if n := len(server.Requests(http.MethodDelete, "projects/p/zones/z/disks/cache")); n != 1 {
+				t.Errorf("cache disk deleted %d times, want 1", n)
+			}
+			logged := output.String()
+			if tt.leaked == "" && !strings.Contains(logged, "Resource cleanup completed") {
+				t.Errorf("cleanup left resources behind:\n%s", logged)
+			}
+			if tt.leaked != "" && !strings.Contains(logged, tt.leaked) {
+				t.Errorf("cleanup did not report %q:\n%s", tt.leaked, logged)
+			}
+		})
+	}
+}
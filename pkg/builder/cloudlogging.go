@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ssh"
+)
+
+// cloudLogName is the log the build VM's Ops Agent writes the build log to, as
+// configured by the bootstrap script
+const cloudLogName = "gke_image_cache_builder"
+
+// buildLogPath is the build log on the VM that the Ops Agent ships
+const buildLogPath = "/var/log/gke-image-cache-builder/build.log"
+
+// flushCloudLoggingTimeout bounds the wait for the Ops Agent to ship the end of
+// the build log before the build VM is deleted
+const flushCloudLoggingTimeout = 2 * time.Minute
+
+// flushCloudLoggingScript gives the Ops Agent time to read the end of the build
+// log, waits for its buffered chunks to be sent and stops it, which flushes the
+// rest
+const flushCloudLoggingScript = `sleep 5
+for i in $(seq 1 30); do
+  [ -z "$(find /var/lib/google-cloud-ops-agent/fluent-bit/buffers -type f 2>/dev/null | head -n 1)" ] && break
+  sleep 2
+done
+systemctl stop google-cloud-ops-agent`
+
+// newRunID returns an ID for this run of the builder, e.g. 20261017-091203-3fa9c1,
+// that the log entries of all its build VMs are labeled with
+func newRunID() string {
+	var suffix [3]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		// Only the uniqueness across runs suffers
+		return time.Now().UTC().Format("20060102-150405")
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix[:])
+}
+
+// cloudLoggingQuery returns the Logs Explorer query selecting a run's build log
+func cloudLoggingQuery(project, runID string) string {
+	return fmt.Sprintf(`logName="projects/%s/logs/%s" AND labels.run_id="%s"`, project, cloudLogName, runID)
+}
+
+// logCloudLoggingLink logs where the build VMs' log of this run is in Cloud Logging
+func (b *Builder) logCloudLoggingLink() {
+	query := cloudLoggingQuery(b.config.ProjectName, b.runID)
+	// Logs Explorer does not decode "+" as a space
+	escaped := strings.ReplaceAll(url.QueryEscape(query), "+", "%20")
+	b.logger.Infof("Build VM log in Cloud Logging (run ID %s): https://console.cloud.google.com/logs/query;query=%s?project=%s\n  Query: %s",
+		b.runID, escaped, url.QueryEscape(b.config.ProjectName), query)
+}
+
+// flushCloudLogging has the build VM's Ops Agent ship what it has not shipped
+// of the build log yet, so that deleting the VM does not cut the log short
+func (w *Workflow) flushCloudLogging(ctx context.Context, client *ssh.Client) {
+	ctx, cancel := context.WithTimeout(ctx, flushCloudLoggingTimeout)
+	defer cancel()
+	if output, err := image.RunAsRoot(ctx, client, flushCloudLoggingScript, nil); err != nil {
+		w.logger.Warnf("The Ops Agent may not have shipped the end of the build log to Cloud Logging: %v: %s", err, strings.TrimSpace(output))
+	}
+}
@@ -0,0 +1,87 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ssh"
+)
+
+// containerdVersionLabel records the containerd release a Linux cache was laid down with.
+// Label values cannot hold dots, so 1.7.13 is stored as 1-7-13.
+const containerdVersionLabel = "cache-containerd-version"
+
+// gkeContainerdMinors maps GKE minor versions to the containerd minor version
+// their node images ship. It is a hint only: patch releases and node image
+// types vary, so mismatches are warned about rather than rejected.
+var gkeContainerdMinors = map[string]string{
+	"1.24": "1.6",
+	"1.25": "1.6",
+	"1.26": "1.6",
+	"1.27": "1.7",
+	"1.28": "1.7",
+	"1.29": "1.7",
+	"1.30": "1.7",
+	"1.31": "1.7",
+	"1.32": "1.7",
+	"1.33": "2.0",
+}
+
+var containerdVersionPattern = regexp.MustCompile(`\bv?([0-9]+\.[0-9]+\.[0-9]+)\b`)
+
+// containerdLabelValue returns a containerd version in label value form
+func containerdLabelValue(version string) string {
+	return strings.ReplaceAll(version, ".", "-")
+}
+
+// minorVersion returns the major.minor part of a version
+func minorVersion(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// checkContainerdVersion reads the containerd version set up on the build VM.
+// A pinned version must have been installed exactly; with --gke-version, a
+// version other than the one the nodes ship is warned about.
+func (w *Workflow) checkContainerdVersion(ctx context.Context, client *ssh.Client) error {
+	output, err := client.Run(ctx, "containerd --version")
+	if err != nil {
+		return fmt.Errorf("failed to read the containerd version: %w", err)
+	}
+	match := containerdVersionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return fmt.Errorf("unexpected containerd version output: %s", strings.TrimSpace(output))
+	}
+	version := match[1]
+	w.containerdVersion = version
+	w.logger.Infof("Build VM runs containerd %s", version)
+
+	if w.config.ContainerdVersion != "" && version != w.config.ContainerdVersion {
+		return fmt.Errorf("build VM runs containerd %s, not the pinned %s", version, w.config.ContainerdVersion)
+	}
+
+	if w.config.GKEVersion != "" {
+		gkeMinor := minorVersion(w.config.GKEVersion)
+		expected, ok := gkeContainerdMinors[gkeMinor]
+		switch {
+		case !ok:
+			w.logger.Warnf("No known containerd version for GKE %s; cannot check that the cache matches the nodes", gkeMinor)
+		case minorVersion(version) == expected:
+			// The cache matches the nodes
+		case !strings.HasPrefix(expected, "1."):
+			// --containerd-version only installs 1.x releases
+			w.logger.Warnf("GKE %s nodes ship containerd %s.x, but the cache is built with containerd %s; "+
+				"containerd %s cannot be pinned yet, so check that the nodes read the cache before rolling it out",
+				gkeMinor, expected, version, expected)
+		default:
+			w.logger.Warnf("GKE %s nodes ship containerd %s.x, but the cache is built with containerd %s; pin a matching release with --containerd-version",
+				gkeMinor, expected, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/deadline"
+)
+
+// controlDialTimeout bounds connecting to and talking with a running build
+const controlDialTimeout = 5 * time.Second
+
+// ControlStatus describes a running build as reported over its control socket
+type ControlStatus struct {
+	PID       int       `json:"pid"`
+	DiskImage string    `json:"disk_image"`
+	Deadline  time.Time `json:"deadline"`
+	Extended  string    `json:"extended"`  // extensions granted so far
+	Remaining string    `json:"remaining"` // extensions that can still be granted
+}
+
+type controlRequest struct {
+	Command  string `json:"command"` // status or extend
+	Duration string `json:"duration,omitempty"`
+}
+
+type controlResponse struct {
+	Status *ControlStatus `json:"status,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// controlDir returns the directory of the control sockets of running builds, next to last-build.json
+func controlDir() (string, error) {
+	lastBuild, err := DefaultLastBuildPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(lastBuild), "control"), nil
+}
+
+// serveControl accepts "control" commands for this build on <control dir>/<pid>.sock
+// until the returned function is called
+func (b *Builder) serveControl(controller *deadline.Controller, recorder *buildRecorder) (func(), error) {
+	dir, err := controlDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, strconv.Itoa(os.Getpid())+".sock")
+	os.Remove(path) // left by an earlier process with the same PID
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	b.logger.Debugf("Accepting control commands on %s", path)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // closed
+			}
+			go b.handleControl(conn, controller, recorder)
+		}
+	}()
+	return func() { listener.Close() }, nil
+}
+
+func (b *Builder) handleControl(conn net.Conn, controller *deadline.Controller, recorder *buildRecorder) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlDialTimeout))
+
+	var request controlRequest
+	response := controlResponse{}
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&request); err != nil {
+		response.Error = fmt.Sprintf("invalid request: %v", err)
+	} else if err := b.runControl(request, controller, recorder); err != nil {
+		response.Error = err.Error()
+	}
+	if response.Error == "" {
+		response.Status = b.controlStatus(controller)
+	}
+	json.NewEncoder(conn).Encode(response)
+}
+
+func (b *Builder) runControl(request controlRequest, controller *deadline.Controller, recorder *buildRecorder) error {
+	switch request.Command {
+	case "status":
+		return nil
+	case "extend":
+		by, err := time.ParseDuration(request.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration '%s': %w", request.Duration, err)
+		}
+		newDeadline, err := controller.Extend(by)
+		if err != nil {
+			return err
+		}
+		b.logger.Infof("Build timeout extended by %s on request, deadline now %s (%s more can be added)",
+			by, newDeadline.Local().Format(time.TimeOnly), controller.Remaining())
+		recorder.addExtension(by, newDeadline)
+		return nil
+	default:
+		return fmt.Errorf("unknown command '%s'", request.Command)
+	}
+}
+
+func (b *Builder) controlStatus(controller *deadline.Controller) *ControlStatus {
+	status := &ControlStatus{
+		PID:       os.Getpid(),
+		DiskImage: b.config.DiskImageName,
+		Extended:  controller.Extended().String(),
+		Remaining: controller.Remaining().String(),
+	}
+	status.Deadline, _ = controller.Deadline()
+	return status
+}
+
+// RunningBuilds returns the status of the builds running on this machine that
+// accept control commands
+func RunningBuilds() ([]*ControlStatus, error) {
+	dir, err := controlDir()
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+
+	var builds []*ControlStatus
+	for _, socket := range sockets {
+		status, err := sendControl(socket, controlRequest{Command: "status"})
+		if err != nil {
+			continue // a build that ended without removing its socket
+		}
+		builds = append(builds, status)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].PID < builds[j].PID })
+	return builds, nil
+}
+
+// ExtendBuild pushes the deadline of the running build with the PID back by the
+// duration and returns its new status
+func ExtendBuild(pid int, by time.Duration) (*ControlStatus, error) {
+	dir, err := controlDir()
+	if err != nil {
+		return nil, err
+	}
+	return sendControl(filepath.Join(dir, strconv.Itoa(pid)+".sock"), controlRequest{Command: "extend", Duration: by.String()})
+}
+
+func sendControl(socket string, request controlRequest) (*ControlStatus, error) {
+	conn, err := net.DialTimeout("unix", socket, controlDialTimeout)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no running build accepts control commands at %s", socket)
+		}
+		return nil, fmt.Errorf("failed to reach the build at %s: %w", socket, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlDialTimeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("failed to send control command: %w", err)
+	}
+	var response controlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read control response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, errors.New(strings.TrimSpace(response.Error))
+	}
+	return response.Status, nil
+}
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/deadline"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// TestControlExtend extends the timeout of a build through its control
+// socket, as "control extend" does from another process
+func TestControlExtend(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	logger := log.NewLogger(false, true, log.NewTextImpl(io.Discard))
+	cfg := config.NewConfig()
+	cfg.DiskImageName = "cache"
+	b := &Builder{config: cfg, logger: logger}
+	recorder := newBuildRecorder(cfg, "", logger)
+
+	controller := deadline.NewController(time.Hour)
+	ctx, cancel := controller.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	before, _ := ctx.Deadline()
+
+	stop, err := b.serveControl(controller, recorder)
+	if err != nil {
+		t.Fatalf("serveControl() error = %v", err)
+	}
+
+	builds, err := RunningBuilds()
+	if err != nil || len(builds) != 1 || builds[0].PID != os.Getpid() || builds[0].DiskImage != "cache" {
+		t.Fatalf("RunningBuilds() = %v, %v, want this build", builds, err)
+	}
+
+	status, err := ExtendBuild(os.Getpid(), 30*time.Minute)
+	if err != nil {
+		t.Fatalf("ExtendBuild() error = %v", err)
+	}
+	after, _ := ctx.Deadline()
+	if got := after.Sub(before); got != 30*time.Minute {
+		t.Errorf("deadline moved by %s, want 30m", got)
+	}
+	if !status.Deadline.Equal(after) || status.Extended != "30m0s" || status.Remaining != "30m0s" {
+		t.Errorf("ExtendBuild() = %+v, want deadline %s, 30m0s extended and 30m0s remaining", status, after)
+	}
+
+	if _, err := ExtendBuild(os.Getpid(), 45*time.Minute); err == nil || !strings.Contains(err.Error(), "maximum extension") {
+		t.Errorf("ExtendBuild() beyond the maximum error = %v, want the maximum exceeded", err)
+	}
+	if _, err := sendControl(controlSocket(t), controlRequest{Command: "shorten"}); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("sendControl() of an unknown command error = %v", err)
+	}
+	if after2, _ := ctx.Deadline(); !after2.Equal(after) {
+		t.Errorf("deadline = %s after refused commands, want %s", after2, after)
+	}
+
+	record, err := LastBuild()
+	if err != nil || record == nil || len(record.Extensions) != 1 || record.Extensions[0].By != "30m0s" {
+		t.Errorf("LastBuild() = %+v, %v, want the extension by 30m0s recorded", record, err)
+	}
+
+	stop()
+	if _, err := ExtendBuild(os.Getpid(), time.Minute); err == nil {
+		t.Error("ExtendBuild() succeeded once the build stopped accepting commands")
+	}
+}
+
+// controlSocket returns the control socket of this process
+func controlSocket(t *testing.T) string {
+	t.Helper()
+	dir, err := controlDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, strconv.Itoa(os.Getpid())+".sock")
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"sort"
+	"strings"
+)
+
+// credentialProvidersLabel records the kubelet image credential providers the
+// nodes need for the cached images, joined with '_'
+const credentialProvidersLabel = "cache-credential-providers"
+
+// maxLabelValueLength is the longest value Compute Engine accepts for a label
+const maxLabelValueLength = 63
+
+// checkCredentialProviders finds the kubelet image credential providers the
+// nodes need to verify the cached images without pulling them. A kubelet that
+// checks the credentials of images already on the node asks the provider
+// matching the image; without one, the cached image is pulled again or not
+// used at all, although the build succeeded.
+func (w *Workflow) checkCredentialProviders() {
+	w.credentialProviders = make(map[string][]string)
+	for _, img := range w.images {
+		for _, provider := range w.config.CredentialProvidersFor(img) {
+			w.credentialProviders[provider] = append(w.credentialProviders[provider], img)
+		}
+	}
+
+	names := w.credentialProviderNames()
+	for _, provider := range names {
+		images := w.credentialProviders[provider]
+		w.logger.Infof("%d cached images need credential provider %s on the nodes: %s", len(images), provider, strings.Join(images, ", "))
+	}
+	if len(names) > 0 && w.credentialProvidersLabelValue() == "" {
+		w.logger.Warnf("The credential providers %s do not fit in the %s label and are not recorded on the image",
+			strings.Join(names, ", "), credentialProvidersLabel)
+	}
+}
+
+// credentialProviderNames returns the providers the cached images need, sorted
+func (w *Workflow) credentialProviderNames() []string {
+	names := make([]string, 0, len(w.credentialProviders))
+	for provider := range w.credentialProviders {
+		names = append(names, provider)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// credentialProvidersLabelValue returns the value of credentialProvidersLabel,
+// or "" if the images need no provider or the names do not fit in a label
+func (w *Workflow) credentialProvidersLabelValue() string {
+	value := strings.Join(w.credentialProviderNames(), "_")
+	if len(value) > maxLabelValueLength {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,322 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/auth"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// DoctorStatus is the outcome of a doctor check
+type DoctorStatus int
+
+const (
+	DoctorPassed DoctorStatus = iota
+	DoctorWarning
+	DoctorFailed
+	DoctorSkipped
+)
+
+// DoctorCheck is one item of the doctor checklist
+type DoctorCheck struct {
+	Name   string
+	Status DoctorStatus
+	Detail string // what the check found
+	Hint   string // how to fix a warning or failure, or why the check was skipped
+}
+
+// doctorPermissions are the project permissions builds use in either mode
+var doctorPermissions = []string{
+	"compute.disks.create",
+	"compute.disks.delete",
+	"compute.disks.use",
+	"compute.images.create",
+	"compute.images.get",
+	"compute.instances.detachDisk",
+	"compute.globalOperations.get",
+	"compute.zoneOperations.get",
+}
+
+// doctorLocalPermissions are the permissions only local mode uses, to attach
+// the cache disk to this VM
+var doctorLocalPermissions = []string{
+	"compute.instances.attachDisk",
+}
+
+// doctorRemotePermissions are the permissions only remote mode uses, to run
+// the build VM
+var doctorRemotePermissions = []string{
+	"compute.instances.create",
+	"compute.instances.delete",
+	"compute.instances.getGuestAttributes",
+	"compute.instances.setMetadata",
+	"iam.serviceAccounts.actAs",
+}
+
+// modePermissions returns the permissions builds in the configured mode use,
+// or builds in either mode when none is configured
+func modePermissions(cfg *config.Config) []string {
+	permissions := slices.Clone(doctorPermissions)
+	if !cfg.IsRemoteMode() {
+		permissions = append(permissions, doctorLocalPermissions...)
+	}
+	if !cfg.IsLocalMode() {
+		permissions = append(permissions, doctorRemotePermissions...)
+	}
+	return permissions
+}
+
+// doctorRegistries are the registries whose reachability is checked
+var doctorRegistries = []string{
+	"registry-1.docker.io",
+	"gcr.io",
+	"us-docker.pkg.dev",
+	"registry.k8s.io",
+	"ghcr.io",
+	"quay.io",
+}
+
+// containerdSocket is where local mode reaches containerd
+const containerdSocket = "/run/containerd/containerd.sock"
+
+// doctor runs the checks of Diagnose, collecting what later checks build on
+type doctor struct {
+	cfg    *config.Config
+	checks []DoctorCheck
+
+	onGCP           bool
+	metadataProject string
+	project         string // --project-name, else the credentials' or this VM's project
+}
+
+// Diagnose checks whether this machine is set up to run builds: credentials,
+// the Compute Engine API and permissions in the project, the metadata server
+// (whether this is a GCP VM, which local mode needs), containerd for local
+// mode and access to common registries. It only reads; nothing is created.
+// cfg supplies the project, credentials file, API endpoint and mode, all optional.
+func Diagnose(ctx context.Context, cfg *config.Config) []DoctorCheck {
+	d := &doctor{cfg: cfg}
+	d.checkMetadataServer()
+	if creds := d.checkCredentials(ctx); creds != nil {
+		if client := d.checkComputeAPI(ctx, creds); client != nil {
+			d.checkPermissions(ctx, client)
+		}
+	} else {
+		d.skip("Compute Engine API", "needs working credentials")
+		d.skip("IAM permissions", "needs working credentials")
+	}
+	d.checkContainerd()
+	d.checkRegistries(ctx)
+	return d.checks
+}
+
+func (d *doctor) add(name string, status DoctorStatus, detail, hint string) {
+	d.checks = append(d.checks, DoctorCheck{Name: name, Status: status, Detail: detail, Hint: hint})
+}
+
+func (d *doctor) skip(name, reason string) {
+	d.add(name, DoctorSkipped, "", reason)
+}
+
+// checkMetadataServer tells a GCP VM, where both modes work, from other machines
+func (d *doctor) checkMetadataServer() {
+	const name = "Metadata server"
+	instance, err := gcp.QueryMetadata("instance/name")
+	switch {
+	case err != nil && d.cfg.IsRemoteMode():
+		d.add(name, DoctorPassed, "not reachable: this machine is not a GCP VM, which remote mode (-R) does not need", "")
+		return
+	case err != nil:
+		status := DoctorWarning
+		if d.cfg.IsLocalMode() {
+			status = DoctorFailed
+		}
+		d.add(name, status, "not reachable: this machine is not a GCP VM, so only remote mode (-R) can build",
+			"Run on a GCP VM to use local mode (-L)")
+		return
+	}
+	d.onGCP = true
+	zone, _ := gcp.QueryMetadata("instance/zone")
+	d.metadataProject, _ = gcp.QueryMetadata("project/project-id")
+	d.add(name, DoctorPassed, fmt.Sprintf("GCP VM %s in %s: local (-L) and remote (-R) mode are available",
+		instance, gcp.ResourceName(zone)), "")
+}
+
+// checkCredentials looks up the credentials builds use and gets an access token
+// with them, returning them if that worked
+func (d *doctor) checkCredentials(ctx context.Context) *auth.GCPAuth {
+	const name = "GCP credentials"
+	source := "Application Default Credentials"
+	if d.cfg.GCPOAuth != "" {
+		source = "key file " + d.cfg.GCPOAuth
+	}
+
+	gcpAuth := auth.NewGCPAuth(d.cfg.GCPOAuth)
+	creds, err := gcpAuth.GetCredentials(ctx)
+	if err != nil {
+		d.add(name, DoctorFailed, err.Error(),
+			"Run 'gcloud auth application-default login', or pass a service account key file with --gcp-oauth")
+		return nil
+	}
+	if _, err := creds.TokenSource.Token(); err != nil {
+		d.add(name, DoctorFailed, fmt.Sprintf("%s found, but getting an access token failed: %v", source, err),
+			"Log in again with 'gcloud auth application-default login', or check that the key is not deleted or disabled")
+		return nil
+	}
+
+	d.project = d.cfg.ProjectName
+	if d.project == "" {
+		d.project = creds.ProjectID
+	}
+	if d.project == "" {
+		d.project = d.metadataProject
+	}
+	d.add(name, DoctorPassed, "access token obtained with "+source, "")
+	return gcpAuth
+}
+
+// checkComputeAPI reads the project through the Compute Engine API, returning
+// a client for the project if that worked
+func (d *doctor) checkComputeAPI(ctx context.Context, gcpAuth *auth.GCPAuth) *gcp.Client {
+	const name = "Compute Engine API"
+	project := d.project
+	if project == "" {
+		d.skip(name, "no project: pass --project-name")
+		d.skip("IAM permissions", "no project: pass --project-name")
+		return nil
+	}
+
+	credentials, err := gcpAuth.GetClientOption(ctx)
+	if err != nil {
+		d.add(name, DoctorFailed, err.Error(), "")
+		return nil
+	}
+	client, err := gcp.NewClient(project, credentials, d.cfg.APIEndpoint)
+	if err != nil {
+		d.add(name, DoctorFailed, err.Error(), "")
+		return nil
+	}
+
+	if _, err := client.CheckProject(ctx, project); err != nil {
+		if disabled, ok := gcp.AsAPINotEnabled(err); ok {
+			d.add(name, DoctorFailed, disabled.Error(), "Enable it with: "+disabled.EnableCommand())
+			return nil
+		}
+		hint := "Check the network path to the API"
+		switch {
+		case errors.Is(err, gcp.ErrProjectNotFound):
+			hint = "Check the project ID for a typo"
+		case errors.Is(err, gcp.ErrProjectAccessDenied):
+			hint = "Grant the credentials a role in the project, e.g. roles/compute.viewer"
+		}
+		d.add(name, DoctorFailed, err.Error(), hint)
+		return nil
+	}
+	d.add(name, DoctorPassed, "enabled in project "+project, "")
+	return client
+}
+
+// checkPermissions lists the build permissions the credentials lack in the project
+func (d *doctor) checkPermissions(ctx context.Context, client *gcp.Client) {
+	const name = "IAM permissions"
+	permissions := modePermissions(d.cfg)
+	granted, err := client.TestPermissions(ctx, permissions)
+	if err != nil {
+		hint := "The permissions are checked when the build uses them"
+		if disabled, ok := gcp.AsAPINotEnabled(err); ok {
+			hint = "Enable the API to check them up front: " + disabled.EnableCommand()
+		}
+		d.add(name, DoctorWarning, err.Error(), hint)
+		return
+	}
+
+	held := make(map[string]bool, len(granted))
+	for _, permission := range granted {
+		held[permission] = true
+	}
+	var missing []string
+	for _, permission := range permissions {
+		if !held[permission] {
+			missing = append(missing, permission)
+		}
+	}
+	if len(missing) > 0 {
+		d.add(name, DoctorFailed, fmt.Sprintf("%d of %d permissions missing in project %s: %s",
+			len(missing), len(permissions), client.ProjectName(), strings.Join(missing, ", ")),
+			"Grant them, e.g. with roles/compute.instanceAdmin.v1, roles/compute.storageAdmin and roles/iam.serviceAccountUser")
+		return
+	}
+	d.add(name, DoctorPassed, fmt.Sprintf("all %d permissions builds use are granted in project %s", len(permissions), client.ProjectName()), "")
+}
+
+// checkContainerd looks for the containerd local mode caches images with
+func (d *doctor) checkContainerd() {
+	const name = "containerd (local mode)"
+	if d.cfg.IsRemoteMode() || (!d.onGCP && !d.cfg.IsLocalMode()) {
+		d.skip(name, "only local mode uses this machine's containerd; the remote build VM brings its own")
+		return
+	}
+
+	var found []string
+	for _, tool := range []string{"containerd", "ctr", "docker"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			found = append(found, tool)
+		}
+	}
+	_, socketErr := os.Stat(containerdSocket)
+	switch {
+	case len(found) == 0 || found[0] != "containerd":
+		detail := "containerd is not installed"
+		if len(found) > 0 {
+			detail += fmt.Sprintf(" (found %s)", strings.Join(found, ", "))
+		}
+		d.add(name, DoctorWarning, detail,
+			"Local mode installs containerd from github.com, which this machine must reach; or install it beforehand")
+	case socketErr != nil:
+		d.add(name, DoctorWarning, fmt.Sprintf("%s installed, but %s is missing: containerd is not running", strings.Join(found, ", "), containerdSocket),
+			"Start it with 'sudo systemctl start containerd'")
+	default:
+		d.add(name, DoctorPassed, fmt.Sprintf("%s installed, listening on %s", strings.Join(found, ", "), containerdSocket), "")
+	}
+}
+
+// checkRegistries checks that this machine reaches common registries. The
+// remote build VM pulls through its own network path, which is not checked.
+func (d *doctor) checkRegistries(ctx context.Context) {
+	errs := make([]error, len(doctorRegistries))
+	var wg sync.WaitGroup
+	for i, host := range doctorRegistries {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			errs[i] = image.Reachable(ctx, host)
+		}(i, host)
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for i, err := range errs {
+		if err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s (%v)", doctorRegistries[i], err))
+		}
+	}
+
+	const name = "Registry access"
+	if len(unreachable) == 0 {
+		d.add(name, DoctorPassed, "reached "+strings.Join(doctorRegistries, ", "), "")
+		return
+	}
+	d.add(name, DoctorWarning, fmt.Sprintf("%d of %d registries unreachable: %s",
+		len(unreachable), len(doctorRegistries), strings.Join(unreachable, "; ")),
+		"Images from these registries cannot be validated or pulled from here; allow HTTPS egress or set HTTPS_PROXY. "+
+			"Remote build VMs without an external IP need Cloud NAT")
+}
@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+)
+
+func TestModePermissions(t *testing.T) {
+	tests := []struct {
+		mode          config.ExecutionMode
+		want, wantNot []string
+	}{
+		{
+			mode:    config.ModeLocal,
+			want:    []string{"compute.instances.attachDisk", "compute.instances.detachDisk"},
+			wantNot: []string{"compute.instances.create", "compute.instances.delete", "compute.instances.getGuestAttributes", "compute.instances.setMetadata", "iam.serviceAccounts.actAs"},
+		},
+		{
+			mode:    config.ModeRemote,
+			want:    []string{"compute.instances.create", "compute.instances.detachDisk", "iam.serviceAccounts.actAs"},
+			wantNot: []string{"compute.instances.attachDisk"},
+		},
+		{
+			mode: config.ModeUnspecified,
+			want: []string{"compute.instances.attachDisk", "compute.instances.create"},
+		},
+	}
+	for _, tt := range tests {
+		cfg := config.NewConfig()
+		cfg.SetMode(tt.mode, "test")
+		got := modePermissions(cfg)
+		for _, permission := range tt.want {
+			if !slices.Contains(got, permission) {
+				t.Errorf("mode %v: %s not checked", tt.mode, permission)
+			}
+		}
+		for _, permission := range tt.wantNot {
+			if slices.Contains(got, permission) {
+				t.Errorf("mode %v: %s checked, but not used", tt.mode, permission)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+)
+
+// platformLabel records the node platform a cache image is for, e.g.
+// linux-amd64: label values cannot hold the '/' of linux/amd64
+const platformLabel = "cache-platform"
+
+// maxListedFamilyImages bounds the images named per architecture in the
+// family check's message
+const maxListedFamilyImages = 3
+
+// platformLabelValue returns the value of platformLabel for an os/arch platform
+func platformLabelValue(platform string) string {
+	return strings.ReplaceAll(platform, "/", "-")
+}
+
+// checkFamilyArchitecture compares the architecture of the image being built
+// with the images already in its family. A node pool using a family gets its
+// latest image whatever the architecture, so a family mixing amd64 and arm64
+// caches hands some node pools a cache their nodes cannot use. It warns, or
+// fails with --strict-family-arch.
+func (w *Workflow) checkFamilyArchitecture(ctx context.Context) error {
+	if !w.config.OutputsImage() {
+		return nil
+	}
+	family := w.config.DiskFamilyName
+	members, err := w.diskManager.ListFamily(ctx, family)
+	if err != nil {
+		if w.config.StrictFamilyArch {
+			return fmt.Errorf("cannot check the architectures of image family %s (--strict-family-arch): %w", family, err)
+		}
+		w.logger.Warnf("Cannot check the architectures of image family %s: %v", family, err)
+		return nil
+	}
+
+	_, arch, _ := strings.Cut(w.config.Platform(), "/")
+	others := make(map[string][]string)
+	for _, member := range members {
+		if memberArch := familyImageArchitecture(member); memberArch != "" && memberArch != arch {
+			others[memberArch] = append(others[memberArch], member.Name)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+
+	archs := make([]string, 0, len(others))
+	for other := range others {
+		archs = append(archs, other)
+	}
+	sort.Strings(archs)
+	held := make([]string, 0, len(archs))
+	for _, other := range archs {
+		names := others[other]
+		sort.Strings(names)
+		listed := strings.Join(names[:min(len(names), maxListedFamilyImages)], ", ")
+		if len(names) > maxListedFamilyImages {
+			listed += fmt.Sprintf(" and %d more", len(names)-maxListedFamilyImages)
+		}
+		held = append(held, fmt.Sprintf("%s (%s)", other, listed))
+	}
+
+	problem := fmt.Sprintf("image family %s already holds %s images, and this build adds an %s image: a node pool using the family gets its latest image whatever the architecture. "+
+		"Use one family per architecture, e.g. --disk-family=%s-%s", family, strings.Join(held, ", "), arch, family, arch)
+	if w.config.StrictFamilyArch {
+		return fmt.Errorf("%s (--strict-family-arch)", problem)
+	}
+	w.logger.Warnf("The %s (--strict-family-arch fails such builds)", problem)
+	return nil
+}
+
+// familyImageArchitecture returns the node architecture of an image of the
+// family, amd64 or arm64: from its platform label, or for images built before
+// it was recorded, from the image's architecture. It is "" if unknown.
+func familyImageArchitecture(img disk.FamilyImage) string {
+	if platform := img.Labels[platformLabel]; platform != "" {
+		if _, arch, ok := strings.Cut(platform, "-"); ok {
+			return arch
+		}
+	}
+	switch img.Architecture {
+	case disk.Architecture(config.ArchX86_64):
+		return "amd64"
+	case disk.Architecture(config.ArchARM64):
+		return "arm64"
+	}
+	return ""
+}
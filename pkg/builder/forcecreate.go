@@ -0,0 +1,76 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// flushedMarker is printed by flushDiskScript once the cache disk's writes are flushed
+const flushedMarker = "CACHE-DISK-FLUSHED"
+
+// flushDiskScript refuses a cache disk $DEVICE that is mounted, flushes all
+// writes to it, and prints flushedMarker
+const flushDiskScript = `set -e
+dev="/dev/disk/by-id/google-$DEVICE"
+[ -e "$dev" ] || { echo "device $dev not found" >&2; exit 1; }
+if findmnt -rn -S "$(readlink -f "$dev")" >/dev/null; then
+  echo "$dev is still mounted" >&2
+  exit 1
+fi
+sync
+blockdev --flushbufs "$dev"
+echo ` + flushedMarker
+
+// detachCacheDisk detaches the cache disk from the build VM before the image
+// is created from it, so that nothing writes to it while it is captured
+func (w *Workflow) detachCacheDisk(ctx context.Context, resources *WorkflowResources) error {
+	start := time.Now()
+	if err := w.vmManager.DetachDisk(ctx, resources.VMInstance.Name, w.config.Zone, resources.CacheDisk.Name); err != nil {
+		return err
+	}
+	resources.CacheDiskDetached = true
+	w.logger.Infof("Detached cache disk %s in %s", resources.CacheDisk.Name, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// flushCacheDisk makes sure the cache disk still attached to the build VM is
+// not mounted and has all writes flushed, before an image is force-created from it
+func (w *Workflow) flushCacheDisk(ctx context.Context, resources *WorkflowResources) error {
+	if resources.SSHClient == nil {
+		return fmt.Errorf("cannot flush cache disk %s: no SSH connection to the build VM", resources.CacheDisk.Name)
+	}
+	output, err := image.RunAsRoot(ctx, resources.SSHClient, flushDiskScript, []string{"DEVICE=" + resources.CacheDisk.Name})
+	if err != nil {
+		return fmt.Errorf("failed to flush cache disk %s: %w: %s", resources.CacheDisk.Name, err, strings.TrimSpace(output))
+	}
+	if !strings.Contains(output, flushedMarker) {
+		return fmt.Errorf("failed to flush cache disk %s: the build VM did not confirm it: %s", resources.CacheDisk.Name, strings.TrimSpace(output))
+	}
+	w.logger.Infof("Flushed cache disk %s on the build VM", resources.CacheDisk.Name)
+	return nil
+}
+
+// detachAfterForceCreate starts detaching the cache disk an image was
+// force-created from, and returns the channel its result is sent on, or nil
+// if there is nothing to detach
+func (w *Workflow) detachAfterForceCreate(ctx context.Context, resources *WorkflowResources) <-chan error {
+	if !w.config.ImageForceCreate || resources.VMInstance == nil || resources.CacheDiskDetached {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		err := w.vmManager.DetachDisk(ctx, resources.VMInstance.Name, w.config.Zone, resources.CacheDisk.Name)
+		if err == nil {
+			resources.CacheDiskDetached = true
+			w.logger.Infof("Detached cache disk %s in %s while the image was verified (time saved by --image-force-create)",
+				resources.CacheDisk.Name, time.Since(start).Round(time.Second))
+		}
+		done <- err
+	}()
+	return done
+}
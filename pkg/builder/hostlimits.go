@@ -0,0 +1,214 @@
+package builder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// memoryPerPull is the memory each image pulled at a time needs on the
+// machine unpacking it: ctr decompresses the layers and writes their files
+// through the page cache, and a small VM unpacking several large images at
+// once ends in the OOM killer, which takes the cache disk's mount with it
+const memoryPerPull = 2 << 30
+
+// cgroupRoot is where the cgroup hierarchy of this process is mounted; in a
+// container with its own cgroup namespace, the root is the container's cgroup
+const cgroupRoot = "/sys/fs/cgroup"
+
+// hostResources are the vCPUs and memory of the machine a local build pulls on
+type hostResources struct {
+	vcpus       int
+	memory      int64  // bytes, 0 if unknown
+	machineType string // "" if unknown
+}
+
+// readHostResources reads this machine's vCPUs, its memory from /proc/meminfo
+// and its machine type from the metadata server. A cgroup limit lower than
+// those, e.g. of the container the tool runs in, takes their place.
+func (w *Workflow) readHostResources() hostResources {
+	host := hostResources{vcpus: runtime.NumCPU()}
+	memory, err := memTotal()
+	if err != nil {
+		w.logger.Debugf("Cannot read this machine's memory, limiting pulls by its vCPUs only: %v", err)
+	}
+	host.memory = memory
+	vcpus, memoryLimit := cgroupLimits(cgroupRoot)
+	if vcpus > 0 && vcpus < host.vcpus {
+		w.logger.Debugf("Limited to %d vCPUs by cgroup", vcpus)
+		host.vcpus = vcpus
+	}
+	if memoryLimit > 0 && (host.memory == 0 || memoryLimit < host.memory) {
+		w.logger.Debugf("Limited to %d bytes of memory by cgroup", memoryLimit)
+		host.memory = memoryLimit
+	}
+	if machineType, err := gcp.QueryMetadata("instance/machine-type"); err == nil {
+		host.machineType = gcp.ResourceName(machineType)
+	}
+	return host
+}
+
+// memTotal returns the MemTotal of /proc/meminfo in bytes
+func memTotal() (int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "MemTotal:")
+		if !ok {
+			continue
+		}
+		kib, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected MemTotal in /proc/meminfo: %q", value)
+		}
+		return kib << 10, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
+
+// cgroupLimits returns the vCPUs and memory in bytes this process's cgroup
+// under root may use, 0 for no or an unknown limit. cgroup v2's cpu.max and
+// memory.max are read, or cgroup v1's CFS quota and memory limit.
+func cgroupLimits(root string) (vcpus int, memory int64) {
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	if cpuMax := read("cpu.max"); cpuMax != "" {
+		// "max 100000" or "<quota> <period>", in microseconds
+		if fields := strings.Fields(cpuMax); len(fields) == 2 {
+			vcpus = cpuQuota(fields[0], fields[1])
+		}
+	} else {
+		vcpus = cpuQuota(read("cpu/cpu.cfs_quota_us"), read("cpu/cpu.cfs_period_us"))
+	}
+
+	limit := read("memory.max")
+	if limit == "" {
+		limit = read("memory/memory.limit_in_bytes")
+	}
+	// "max" without a limit in v2; v1 reports a huge number, which the
+	// machine's own memory is lower than
+	if bytes, err := strconv.ParseInt(limit, 10, 64); err == nil && bytes > 0 {
+		memory = bytes
+	}
+	return vcpus, memory
+}
+
+// cpuQuota returns the vCPUs a CFS quota and period allow, rounded up, or 0
+// without a quota ("max", or -1 in cgroup v1)
+func cpuQuota(quota, period string) int {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return int((q + p - 1) / p)
+}
+
+// pullConcurrency returns how many images the machine can safely pull and
+// unpack at a time: one per vCPU and per memoryPerPull, at least one
+func (h hostResources) pullConcurrency() int {
+	limit := h.vcpus
+	if h.memory > 0 {
+		limit = min(limit, int(h.memory/memoryPerPull))
+	}
+	return max(limit, 1)
+}
+
+// downloadsPerPull returns how many layers each of pulls images pulled at a
+// time may download and unpack at once: the vCPUs shared among them, at least one
+func (h hostResources) downloadsPerPull(pulls int) int {
+	return max(h.vcpus/max(pulls, 1), 1)
+}
+
+func (h hostResources) String() string {
+	s := fmt.Sprintf("%d vCPUs", h.vcpus)
+	if h.memory > 0 {
+		s += fmt.Sprintf(", %.1f GiB memory", float64(h.memory)/(1<<30))
+	}
+	if h.machineType != "" {
+		s += ", " + h.machineType
+	}
+	return s
+}
+
+// checkHostLimits bounds the pulls of a local build by this machine's memory
+// and vCPUs unless --pull-concurrency is set, and the layers each pull
+// downloads at once unless a --pull-arg sets --max-concurrent-downloads. It
+// warns when the configured concurrency or the images unpacked at a time look
+// too large for the machine.
+func (w *Workflow) checkHostLimits(ctx context.Context) {
+	host := w.readHostResources()
+	safe := host.pullConcurrency()
+	switch {
+	case w.pullConcurrency == 0:
+		w.pullConcurrency = safe
+		w.logger.Infof("Pulling up to %d images at a time on this machine (%s; set --pull-concurrency to change it)", safe, host)
+	case w.config.PullConcurrency > safe:
+		w.logger.Warnf("--pull-concurrency=%d is more than the %d images this machine (%s) can safely unpack at a time: "+
+			"running out of memory fails the pulls and can take the cache disk's mount with them", w.config.PullConcurrency, safe, host)
+	}
+	if !slices.ContainsFunc(w.config.PullArgs, func(arg string) bool { return strings.HasPrefix(arg, "--max-concurrent-downloads") }) {
+		w.maxDownloads = host.downloadsPerPull(w.pullConcurrency)
+		w.logger.Debugf("Each pull downloads up to %d layers at a time (set --pull-arg=--max-concurrent-downloads=N to change it)", w.maxDownloads)
+	}
+	if host.memory == 0 {
+		return
+	}
+
+	// Unpacking needs a multiple of the compressed size; warn once the largest
+	// images that may be unpacked together come to half the memory compressed
+	sizes := w.imageSizes(ctx, w.logger.Debugf)
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return sizes[order[i]] > sizes[order[j]] })
+
+	var together int64
+	var largest []string
+	for _, i := range order[:min(w.pullConcurrency, len(order))] {
+		if sizes[i] <= 0 {
+			break
+		}
+		together += sizes[i]
+		largest = append(largest, w.images[i])
+	}
+	if together <= host.memory/2 {
+		return
+	}
+
+	total, _ := totalImageSize(sizes)
+	machineType, _, _ := w.autoMachineType(total)
+	advice := fmt.Sprintf("consider remote mode (-R) with --machine-type=%s", machineType)
+	if w.pullConcurrency > 1 {
+		advice += " or a lower --pull-concurrency"
+	}
+	w.logger.Warnf("This machine has %.1f GiB of memory; unpacking %s (%.1f GiB compressed) at a time may run out of memory and fail: %s",
+		float64(host.memory)/(1<<30), strings.Join(largest, ", "), float64(together)/(1<<30), advice)
+}
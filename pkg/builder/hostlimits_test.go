@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCgroupLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		wantVCPUs  int
+		wantMemory int64
+	}{
+		{
+			name:       "v2 limited",
+			files:      map[string]string{"cpu.max": "150000 100000", "memory.max": "2147483648"},
+			wantVCPUs:  2,
+			wantMemory: 2 << 30,
+		},
+		{
+			name:  "v2 unlimited",
+			files: map[string]string{"cpu.max": "max 100000", "memory.max": "max"},
+		},
+		{
+			name:       "v1 limited",
+			files:      map[string]string{"cpu/cpu.cfs_quota_us": "100000", "cpu/cpu.cfs_period_us": "100000", "memory/memory.limit_in_bytes": "1073741824"},
+			wantVCPUs:  1,
+			wantMemory: 1 << 30,
+		},
+		{
+			name:       "v1 unlimited",
+			files:      map[string]string{"cpu/cpu.cfs_quota_us": "-1", "cpu/cpu.cfs_period_us": "100000", "memory/memory.limit_in_bytes": "9223372036854771712"},
+			wantMemory: 9223372036854771712,
+		},
+		{
+			name: "no cgroup",
+		},
+	}
+	for _, tt := range tests {
+		vcpus, memory := cgroupLimits(writeCgroupFiles(t, tt.files))
+		if vcpus != tt.wantVCPUs || memory != tt.wantMemory {
+			t.Errorf("%s: cgroupLimits() = %d vCPUs, %d bytes, want %d, %d", tt.name, vcpus, memory, tt.wantVCPUs, tt.wantMemory)
+		}
+	}
+}
+
+func TestHostLimits(t *testing.T) {
+	small := hostResources{vcpus: 2, memory: 2 << 30}
+	if got := small.pullConcurrency(); got != 1 {
+		t.Errorf("pullConcurrency() of %s = %d, want 1", small, got)
+	}
+	if got := small.downloadsPerPull(1); got != 2 {
+		t.Errorf("downloadsPerPull(1) of %s = %d, want 2", small, got)
+	}
+	large := hostResources{vcpus: 16, memory: 64 << 30}
+	if got := large.downloadsPerPull(large.pullConcurrency()); got != 1 {
+		t.Errorf("downloadsPerPull(%d) of %s = %d, want 1", large.pullConcurrency(), large, got)
+	}
+	if got := large.downloadsPerPull(0); got != 16 {
+		t.Errorf("downloadsPerPull(0) of %s = %d, want 16", large, got)
+	}
+}
@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// writeImageRef writes the reference of each image a successful build produced,
+// or found with --skip-if-exists, to --write-image-ref: one line per image, in
+// partition order for a partitioned build
+func (b *Builder) writeImageRef(images []string) error {
+	path := b.config.WriteImageRef
+	if path == "" {
+		return nil
+	}
+
+	var refs strings.Builder
+	for _, name := range images {
+		ref := name
+		if b.config.ImageRefFormat == config.ImageRefSelfLink {
+			ref = (&gcp.ImagePath{Project: b.config.ProjectName, Name: name}).SelfLink()
+		}
+		refs.WriteString(ref + "\n")
+	}
+
+	if err := os.WriteFile(path, []byte(refs.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write image reference: %w", err)
+	}
+	b.logger.Infof("Wrote image reference: %s", path)
+	return nil
+}
+
+// removePreviousOutput deletes the file, described by what, a previous build
+// wrote to path
+func removePreviousOutput(path, what string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove previous %s: %w", what, err)
+	}
+	return nil
+}
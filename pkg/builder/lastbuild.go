@@ -0,0 +1,424 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// Build status values recorded in last-build.json
+const (
+	buildRunning   = "running"
+	buildSucceeded = "succeeded"
+	buildFailed    = "failed"
+)
+
+// BuildRecord is the content of last-build.json: where the most recent build ran,
+// so a failed build's VM can be found and inspected afterwards
+type BuildRecord struct {
+	BuildID    string     `json:"build_id,omitempty"` // set for remote builds, which "watch" can finish
+	Project    string     `json:"project"`
+	Zone       string     `json:"zone"`
+	DiskImage  string     `json:"disk_image"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	VMs        []VMRecord `json:"vms,omitempty"`
+
+	Extensions []ExtensionRecord `json:"timeout_extensions,omitempty"`
+
+	// ArtifactType is what the build produces, its --output-type: image,
+	// disk-only or both; Disks are the self-links of the cache disks it kept
+	ArtifactType string   `json:"artifact_type"`
+	Disks        []string `json:"disks,omitempty"`
+
+	// Images are the images the build created; a failed build may leave them
+	Images []string `json:"images,omitempty"`
+
+	Pulls []PullRecord `json:"pulls,omitempty"`
+
+	// HTTP counts the requests to registries, token endpoints and the
+	// metadata server, and the retried pulls
+	HTTP *HTTPRecord `json:"http,omitempty"`
+
+	// Leaked are the commands deleting the temporary resources cleanup
+	// could not delete, in this build or earlier ones: they are carried over
+	// until the cleanup command deletes them
+	Leaked []string `json:"leaked,omitempty"`
+}
+
+// PullRecord is the measured pull of one image
+type PullRecord struct {
+	Image    string  `json:"image"`
+	Registry string  `json:"registry"`
+	Bytes    int64   `json:"bytes,omitempty"` // compressed size in the registry, omitted if unknown
+	Seconds  float64 `json:"seconds"`
+	MBps     float64 `json:"mb_per_second,omitempty"`
+}
+
+// HTTPRecord holds the counters of httputil when the build finished
+type HTTPRecord struct {
+	Requests     int64 `json:"requests"`
+	Retries      int64 `json:"retries"`
+	ClientErrors int64 `json:"client_errors"`
+	ServerErrors int64 `json:"server_errors"`
+	Failures     int64 `json:"failures"`
+	PullRetries  int64 `json:"pull_retries"`
+}
+
+// ExtensionRecord is a timeout extension granted while the build ran
+type ExtensionRecord struct {
+	At       time.Time `json:"at"`
+	By       string    `json:"by"`
+	Deadline time.Time `json:"deadline"` // the build's deadline after the extension
+}
+
+// VMRecord identifies a build VM
+type VMRecord struct {
+	Name          string `json:"name"`
+	Zone          string `json:"zone"`
+	InstanceID    uint64 `json:"instance_id"`
+	MachineType   string `json:"machine_type"`
+	BootImage     string `json:"boot_image,omitempty"`
+	Preemptible   bool   `json:"preemptible"`
+	SelfLink      string `json:"self_link"`
+	InternalIP    string `json:"internal_ip,omitempty"`
+	ExternalIP    string `json:"external_ip,omitempty"`
+	SerialConsole string `json:"serial_console"`
+	Deleted       bool   `json:"deleted"`
+}
+
+// DefaultLastBuildPath returns last-build.json under the tool's config directory
+func DefaultLastBuildPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "gke-image-cache-builder", "last-build.json"), nil
+}
+
+// buildRecorder keeps last-build.json up to date as VMs are created and deleted.
+// It is shared by the workflows of a partitioned build.
+type buildRecorder struct {
+	mu     sync.Mutex
+	path   string
+	record BuildRecord
+	logger *log.Logger
+
+	// previous is the record of the build before this one, if there was one
+	previous *BuildRecord
+}
+
+func newBuildRecorder(cfg *config.Config, buildID string, logger *log.Logger) *buildRecorder {
+	r := &buildRecorder{
+		logger: logger,
+		record: BuildRecord{
+			BuildID:   buildID,
+			Project:   cfg.ProjectName,
+			Zone:      cfg.Zone,
+			DiskImage: cfg.DiskImageName,
+			Status:    buildRunning,
+			StartedAt: time.Now().UTC(),
+
+			ArtifactType: cfg.OutputType,
+		},
+	}
+
+	path, err := DefaultLastBuildPath()
+	if err != nil {
+		logger.Warnf("Build details will not be recorded: %v", err)
+		return r
+	}
+	r.path = path
+	r.previous = loadBuildRecord(path)
+	if r.previous != nil && len(r.previous.Leaked) > 0 {
+		// They are billed until "cleanup" deletes them; this record replaces
+		// the only other trace of them
+		r.record.Leaked = append(r.record.Leaked, r.previous.Leaked...)
+		logger.Warnf("%d temporary resources earlier builds left behind are still recorded in %s; the cleanup command deletes them",
+			len(r.previous.Leaked), path)
+	}
+	r.save()
+	return r
+}
+
+// LastBuild returns the record of the most recent build on this machine, or
+// nil if there is none
+func LastBuild() (*BuildRecord, error) {
+	path, err := DefaultLastBuildPath()
+	if err != nil {
+		return nil, err
+	}
+	return loadBuildRecord(path), nil
+}
+
+// loadBuildRecord reads a build record, or returns nil if there is none
+func loadBuildRecord(path string) *BuildRecord {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var record BuildRecord
+	if json.Unmarshal(data, &record) != nil {
+		return nil
+	}
+	return &record
+}
+
+// addVM records a newly created build VM
+func (r *buildRecorder) addVM(instance *vm.Instance, project string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.VMs = append(r.record.VMs, VMRecord{
+		Name:          instance.Name,
+		Zone:          instance.Zone,
+		InstanceID:    instance.ID,
+		MachineType:   instance.MachineType,
+		BootImage:     instance.BootImage,
+		Preemptible:   instance.Preemptible,
+		SelfLink:      instance.SelfLink(),
+		InternalIP:    instance.InternalIP,
+		ExternalIP:    instance.ExternalIP,
+		SerialConsole: instance.SerialConsoleCommand(project),
+	})
+	r.save()
+}
+
+// addExtension records a timeout extension
+func (r *buildRecorder) addExtension(by time.Duration, deadline time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.Extensions = append(r.record.Extensions, ExtensionRecord{
+		At:       time.Now().UTC(),
+		By:       by.String(),
+		Deadline: deadline.UTC(),
+	})
+	r.save()
+}
+
+// addPulls records measured image pulls
+func (r *buildRecorder) addPulls(stats []image.PullStats) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range stats {
+		r.record.Pulls = append(r.record.Pulls, PullRecord{
+			Image:    s.Image,
+			Registry: s.Registry,
+			Bytes:    s.Bytes,
+			Seconds:  s.Duration.Seconds(),
+			MBps:     s.MBps(),
+		})
+	}
+	r.save()
+}
+
+// addDisk records a cache disk kept as an output of the build
+func (r *buildRecorder) addDisk(selfLink string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.Disks = append(r.record.Disks, selfLink)
+	r.save()
+}
+
+// addImage records an image the build created
+func (r *buildRecorder) addImage(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.Images = append(r.record.Images, name)
+	r.save()
+}
+
+// imageDeleted forgets an image the build created and deleted again
+func (r *buildRecorder) imageDeleted(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.Images = slices.DeleteFunc(r.record.Images, func(image string) bool { return image == name })
+	r.save()
+}
+
+// createdImages returns the images the build created so far
+func (r *buildRecorder) createdImages() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.record.Images)
+}
+
+// addLeaked records the commands deleting resources cleanup left behind
+func (r *buildRecorder) addLeaked(commands []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.Leaked = append(r.record.Leaked, commands...)
+	r.save()
+}
+
+// vmDeleted marks a build VM as cleaned up
+func (r *buildRecorder) vmDeleted(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.record.VMs {
+		if r.record.VMs[i].Name == name {
+			r.record.VMs[i].Deleted = true
+		}
+	}
+	r.save()
+}
+
+// finish records the outcome of the build and returns the final record
+func (r *buildRecorder) finish(buildErr error) BuildRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	r.record.FinishedAt = &now
+	if stats := httputil.Snapshot(); stats.Requests > 0 || stats.PullRetries > 0 {
+		r.record.HTTP = &HTTPRecord{
+			Requests:     stats.Requests,
+			Retries:      stats.Retries,
+			ClientErrors: stats.ClientErrors,
+			ServerErrors: stats.ServerErrors,
+			Failures:     stats.Failures,
+			PullRetries:  stats.PullRetries,
+		}
+	}
+	r.record.Status = buildSucceeded
+	if buildErr != nil {
+		r.record.Status = buildFailed
+		r.record.Error = buildErr.Error()
+	}
+	r.save()
+
+	record := r.record
+	record.VMs = append([]VMRecord(nil), r.record.VMs...)
+	record.Disks = append([]string(nil), r.record.Disks...)
+	record.Images = append([]string(nil), r.record.Images...)
+	record.Leaked = append([]string(nil), r.record.Leaked...)
+	return record
+}
+
+// save writes the record; failing to do so never fails the build
+func (r *buildRecorder) save() {
+	if r.path == "" {
+		return
+	}
+
+	if err := saveBuildRecord(r.path, &r.record); err != nil {
+		r.logger.Warnf("Failed to record build details in %s: %v", r.path, err)
+		r.path = ""
+	}
+}
+
+// saveBuildRecord writes a build record to path
+func saveBuildRecord(path string, record *BuildRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// logVMs prints where the build VMs ran and how to inspect them
+func (r *buildRecorder) logVMs(record BuildRecord) {
+	for _, v := range record.VMs {
+		state := "still running"
+		if v.Deleted {
+			state = "deleted"
+		}
+		r.logger.Errorf("Build VM %s (ID %d, %s) in %s, internal IP %s, external IP %s: %s",
+			v.Name, v.InstanceID, v.MachineType, v.Zone, orNone(v.InternalIP), orNone(v.ExternalIP), state)
+		r.logger.Errorf("  Serial console: %s", v.SerialConsole)
+	}
+	if r.path != "" {
+		r.logger.Errorf("Build details saved to %s", r.path)
+	}
+}
+
+// logLeaked repeats, at the end of the build, the resources its cleanups left
+// behind: they keep costing money, and the warnings of each workflow's
+// cleanup are easily lost in the log
+func (r *buildRecorder) logLeaked(record BuildRecord) {
+	if len(record.Leaked) == 0 {
+		return
+	}
+	r.logger.Errorf("%d temporary resources were left behind and are still billed; delete them with:\n  %s",
+		len(record.Leaked), strings.Join(record.Leaked, "\n  "))
+	if r.path != "" {
+		r.logger.Errorf("The commands are also saved to %s, from where the cleanup command deletes them", r.path)
+	}
+}
+
+// logOutputs lists, after a failed build, the images and disks it produced
+// anyway, e.g. in the partitions that succeeded: they are outputs, so cleanup
+// leaves them, but no image reference points to them
+func (r *buildRecorder) logOutputs(record BuildRecord) {
+	if len(record.Images)+len(record.Disks) == 0 {
+		return
+	}
+	var outputs []string
+	for _, name := range record.Images {
+		outputs = append(outputs, fmt.Sprintf("image %s (gcloud compute images delete %s --project=%s)", name, name, record.Project))
+	}
+	for _, selfLink := range record.Disks {
+		outputs = append(outputs, "disk "+selfLink)
+	}
+	r.logger.Errorf("The failed build still produced:\n  %s", strings.Join(outputs, "\n  "))
+	if r.path != "" {
+		r.logger.Errorf("They are also recorded in %s", r.path)
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
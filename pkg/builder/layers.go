@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// reportLargestLayers is how many of the largest layers --report-layers lists
+const reportLargestLayers = 10
+
+// ReportLayers reads the manifests of the configured images for the build's
+// platform and reports how much sharing layers saves on the cache disk. Images
+// whose manifest cannot be read are left out with a warning.
+func (b *Builder) ReportLayers(ctx context.Context) (*image.LayerReport, error) {
+	platform := b.config.Platform()
+
+	var images []string
+	layers := make(map[string][]image.Layer)
+	for _, img := range b.config.ContainerImages {
+		l, err := b.imageCache.Layers(ctx, img, platform)
+		if err != nil {
+			b.logger.Warnf("Leaving %s out of the report: %v", img, err)
+			continue
+		}
+		images = append(images, img)
+		layers[img] = l
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("the layers of none of the %d images could be read", len(b.config.ContainerImages))
+	}
+
+	return image.NewLayerReport(images, layers, reportLargestLayers), nil
+}
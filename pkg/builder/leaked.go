@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// leakedResource is a temporary resource a build's cleanup left behind, as
+// recorded in last-build.json by the gcloud command deleting it
+type leakedResource struct {
+	command string
+	kind    string // "instances" or "disks"
+	name    string
+	zone    string
+	project string
+}
+
+// parseLeaked parses a command cleanupResources recorded, e.g.
+// "gcloud compute disks delete NAME --zone=ZONE --project=PROJECT"
+func parseLeaked(command string) (leakedResource, bool) {
+	fields := strings.Fields(command)
+	if len(fields) != 7 || fields[0] != "gcloud" || fields[1] != "compute" || fields[3] != "delete" {
+		return leakedResource{}, false
+	}
+	if fields[2] != "instances" && fields[2] != "disks" {
+		return leakedResource{}, false
+	}
+	zone, hasZone := strings.CutPrefix(fields[5], "--zone=")
+	project, hasProject := strings.CutPrefix(fields[6], "--project=")
+	if !hasZone || !hasProject {
+		return leakedResource{}, false
+	}
+	return leakedResource{command: command, kind: fields[2], name: fields[4], zone: zone, project: project}, true
+}
+
+// LeakedResult is what CleanupLeaked did with the resources earlier builds
+// left behind: the commands deleting those it deleted, or found gone, and of
+// those still left
+type LeakedResult struct {
+	Deleted []string
+	Pending []string
+}
+
+// CleanupLeaked deletes the temporary resources the cleanups of earlier builds
+// left behind, as recorded under "leaked" in last-build.json, and removes them
+// from the record. Resources of other projects than --project-name, and those
+// that cannot be deleted, stay recorded.
+func (b *Builder) CleanupLeaked(ctx context.Context) (*LeakedResult, error) {
+	path, err := DefaultLastBuildPath()
+	if err != nil {
+		return nil, err
+	}
+	record := loadBuildRecord(path)
+	if record == nil {
+		return &LeakedResult{}, nil
+	}
+
+	result := &LeakedResult{}
+	var errs []error
+	for _, command := range record.Leaked {
+		resource, ok := parseLeaked(command)
+		switch {
+		case !ok:
+			b.logger.Warnf("Not a command cleanup recorded, run it by hand: %s", command)
+			result.Pending = append(result.Pending, command)
+		case resource.project != b.config.ProjectName:
+			b.logger.Warnf("%s %s is in project %s, run cleanup with --project-name=%s for it",
+				strings.TrimSuffix(resource.kind, "s"), resource.name, resource.project, resource.project)
+			result.Pending = append(result.Pending, command)
+		default:
+			if err := b.deleteLeaked(ctx, resource); err != nil {
+				errs = append(errs, err)
+				result.Pending = append(result.Pending, command)
+				continue
+			}
+			result.Deleted = append(result.Deleted, command)
+		}
+	}
+	if len(result.Deleted) == 0 {
+		return result, errors.Join(errs...)
+	}
+
+	// Read the record again: a build started since replaced it, carrying the
+	// entries over
+	if current := loadBuildRecord(path); current != nil {
+		record = current
+	}
+	deleted := make(map[string]bool, len(result.Deleted))
+	for _, command := range result.Deleted {
+		deleted[command] = true
+	}
+	var remaining []string
+	for _, command := range record.Leaked {
+		if !deleted[command] {
+			remaining = append(remaining, command)
+		}
+	}
+	record.Leaked = remaining
+	if err := saveBuildRecord(path, record); err != nil {
+		errs = append(errs, fmt.Errorf("failed to update %s: %w", path, err))
+	}
+	return result, errors.Join(errs...)
+}
+
+// CleanupBootDisks deletes the boot disks of build VMs that outlived their VM,
+// e.g. when auto-delete failed and the build's cleanup was cut short before
+// it noticed, and returns them. They are found by the label every build VM
+// and its boot disk carry, which cache disks do not.
+func (b *Builder) CleanupBootDisks(ctx context.Context) ([]string, error) {
+	disks, err := b.diskManager.FindUnusedDisks(ctx, vmImageLabel)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	var errs []error
+	for _, d := range disks {
+		if err := b.diskManager.DeleteDisk(ctx, d.Name, d.Zone); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, fmt.Sprintf("%s in %s (build of %s)", d.Name, d.Zone, d.Labels[vmImageLabel]))
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// deleteLeaked deletes a leaked resource; one already gone counts as deleted
+func (b *Builder) deleteLeaked(ctx context.Context, resource leakedResource) error {
+	if resource.kind == "instances" {
+		return b.vmManager.DeleteVM(ctx, resource.name, resource.zone)
+	}
+	_, err := b.diskManager.DeleteDiskIfExists(ctx, resource.name, resource.zone)
+	return err
+}
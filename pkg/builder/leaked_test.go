@@ -0,0 +1,182 @@
+package builder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/gcptest"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+func TestParseLeaked(t *testing.T) {
+	tests := []struct {
+		command string
+		want    leakedResource
+		ok      bool
+	}{
+		{
+			command: "gcloud compute instances delete builder --zone=z --project=p",
+			want:    leakedResource{kind: "instances", name: "builder", zone: "z", project: "p"},
+			ok:      true,
+		},
+		{
+			command: "gcloud compute disks delete cache --zone=z --project=p",
+			want:    leakedResource{kind: "disks", name: "cache", zone: "z", project: "p"},
+			ok:      true,
+		},
+		{command: "gcloud compute images delete cache --project=p"},
+		{command: "gcloud compute disks delete cache --project=p --zone=z"},
+		{command: "rm -rf /"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, ok := parseLeaked(tt.command)
+			if ok != tt.ok {
+				t.Fatalf("parseLeaked() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok {
+				tt.want.command = tt.command
+			}
+			if got != tt.want {
+				t.Errorf("parseLeaked() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLeakedCarriedOver keeps the resources an earlier build left behind in
+// the record of the next one
+func TestLeakedCarriedOver(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path, err := DefaultLastBuildPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	earlier := []string{"gcloud compute disks delete cache --zone=z --project=p"}
+	if err := saveBuildRecord(path, &BuildRecord{Project: "p", Status: buildFailed, Leaked: earlier}); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.NewLogger(false, true, log.NewTextImpl(io.Discard))
+	recorder := newBuildRecorder(config.NewConfig(), "", logger)
+	leaked := "gcloud compute instances delete builder --zone=z --project=p"
+	recorder.addLeaked([]string{leaked})
+	record := recorder.finish(nil)
+
+	want := append(earlier, leaked)
+	if !reflect.DeepEqual(record.Leaked, want) {
+		t.Errorf("Leaked = %q, want %q", record.Leaked, want)
+	}
+	if saved := loadBuildRecord(path); saved == nil || !reflect.DeepEqual(saved.Leaked, want) {
+		t.Errorf("last-build.json = %+v, want leaked %q", saved, want)
+	}
+}
+
+// TestCleanupLeaked deletes what earlier builds left behind and keeps the
+// rest listed
+func TestCleanupLeaked(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path, err := DefaultLastBuildPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		vmGone      = "gcloud compute instances delete gone --zone=z --project=p"
+		vmLeft      = "gcloud compute instances delete builder --zone=z --project=p"
+		diskLeft    = "gcloud compute disks delete cache --zone=z --project=p"
+		diskDenied  = "gcloud compute disks delete denied --zone=z --project=p"
+		otherProj   = "gcloud compute disks delete cache --zone=z --project=other"
+		unparseable = "gcloud compute images delete cache --project=p"
+	)
+	leaked := []string{vmGone, vmLeft, diskLeft, diskDenied, otherProj, unparseable}
+	if err := saveBuildRecord(path, &BuildRecord{Project: "p", Status: buildSucceeded, Leaked: leaked}); err != nil {
+		t.Fatal(err)
+	}
+
+	server := gcptest.NewServer(t)
+	server.Handle(http.MethodDelete, "projects/p/zones/z/instances/gone", gcptest.Fail(http.StatusNotFound, "not found"))
+	server.Handle(http.MethodDelete, "projects/p/zones/z/instances/builder", gcptest.Operation("delete-vm"))
+	server.Handle(http.MethodGet, "projects/p/zones/z/disks/cache", gcptest.Respond(map[string]any{"name": "cache"}))
+	server.Handle(http.MethodDelete, "projects/p/zones/z/disks/cache", gcptest.Operation("delete-cache"))
+	server.Handle(http.MethodGet, "projects/p/zones/z/disks/denied", gcptest.Respond(map[string]any{"name": "denied"}))
+	server.Handle(http.MethodDelete, "projects/p/zones/z/disks/denied", gcptest.Fail(http.StatusForbidden, "denied"))
+
+	client, err := gcp.NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewLogger(false, true, log.NewTextImpl(io.Discard))
+	cfg := config.NewConfig()
+	cfg.ProjectName = "p"
+	b := &Builder{
+		config:      cfg,
+		logger:      logger,
+		vmManager:   vm.NewManager(client, logger, vm.DefaultTuning()),
+		diskManager: disk.NewManager(client, logger),
+	}
+
+	result, err := b.CleanupLeaked(context.Background())
+	if err == nil {
+		t.Error("CleanupLeaked() succeeded with a disk it may not delete")
+	}
+	if want := []string{vmGone, vmLeft, diskLeft}; !reflect.DeepEqual(result.Deleted, want) {
+		t.Errorf("Deleted = %q, want %q", result.Deleted, want)
+	}
+	pending := []string{diskDenied, otherProj, unparseable}
+	if !reflect.DeepEqual(result.Pending, pending) {
+		t.Errorf("Pending = %q, want %q", result.Pending, pending)
+	}
+	if saved := loadBuildRecord(path); saved == nil || !reflect.DeepEqual(saved.Leaked, pending) {
+		t.Errorf("last-build.json = %+v, want leaked %q", saved, pending)
+	}
+	if n := len(server.Requests(http.MethodDelete, "projects/other/zones/z/disks/cache")); n != 0 {
+		t.Errorf("disk of another project deleted %d times", n)
+	}
+}
+
+// TestCleanupBootDisks deletes the build VMs' boot disks attached to no
+// instance, and only those
+func TestCleanupBootDisks(t *testing.T) {
+	server := gcptest.NewServer(t)
+	server.Handle(http.MethodGet, "projects/p/aggregated/disks", gcptest.Respond(map[string]any{
+		"items": map[string]any{
+			"zones/a": map[string]any{"disks": []map[string]any{
+				{"name": "left", "zone": "zones/a", "status": "READY", "labels": map[string]string{vmImageLabel: "cache"}},
+				{"name": "in-use", "zone": "zones/a", "status": "READY", "labels": map[string]string{vmImageLabel: "cache"},
+					"users": []string{"projects/p/zones/a/instances/builder"}},
+			}},
+			"zones/b": map[string]any{"disks": []map[string]any{
+				{"name": "creating", "zone": "zones/b", "status": "CREATING", "labels": map[string]string{vmImageLabel: "cache"}},
+			}},
+		},
+	}))
+	server.Handle(http.MethodGet, "projects/p/zones/a/disks/left", gcptest.Respond(map[string]any{"name": "left"}))
+	server.Handle(http.MethodDelete, "projects/p/zones/a/disks/left", gcptest.Operation("delete-left"))
+
+	client, err := gcp.NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewLogger(false, true, log.NewTextImpl(io.Discard))
+	b := &Builder{config: config.NewConfig(), logger: logger, diskManager: disk.NewManager(client, logger)}
+
+	deleted, err := b.CleanupBootDisks(context.Background())
+	if err != nil {
+		t.Fatalf("CleanupBootDisks() error = %v", err)
+	}
+	if want := []string{"left in a (build of cache)"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("CleanupBootDisks() = %q, want %q", deleted, want)
+	}
+	if n := len(server.Requests(http.MethodDelete, "projects/p/zones/*/disks/*")); n != 1 {
+		t.Errorf("%d disks deleted, want 1", n)
+	}
+}
@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+)
+
+// machineTier is the build VM size for image sets up to a total compressed size and image count
+type machineTier struct {
+	vcpus     int
+	maxBytes  int64
+	maxImages int
+}
+
+// machineTiers are tried smallest first. Pulling is bound by decompressing
+// and unpacking layers, which scales with vCPUs; small caches gain little
+// from a larger VM, so they stay on the cheapest size.
+var machineTiers = []machineTier{
+	{vcpus: 2, maxBytes: 2 << 30, maxImages: 10},
+	{vcpus: 4, maxBytes: 10 << 30, maxImages: 40},
+	{vcpus: 8, maxBytes: 30 << 30, maxImages: 100},
+}
+
+// maxAutoVCPUs is the size chosen for image sets beyond the largest tier
+const maxAutoVCPUs = 16
+
+// selectMachineType picks a build VM machine type for --machine-type=auto from
+// the number of images and their estimated compressed size
+func (w *Workflow) selectMachineType(ctx context.Context) string {
+	total, estimate := totalImageSize(w.imageSizes(ctx, w.logger.Warnf))
+	machineType, vcpus, reason := w.autoMachineType(total)
+
+	w.logger.Infof("Machine type auto: %s for %d images (%s; %d vCPUs for %s)",
+		machineType, len(w.images), estimate, vcpus, reason)
+	return machineType
+}
+
+// imageSizes returns the compressed size of each image, -1 where it cannot be
+// read, which is reported to logf
+func (w *Workflow) imageSizes(ctx context.Context, logf func(format string, args ...interface{})) []int64 {
+	platform := w.config.Platform()
+	sizes := make([]int64, len(w.images))
+	for i, img := range w.images {
+		size, err := w.imageCache.CompressedSize(ctx, img, platform)
+		if err != nil {
+			logf("Cannot estimate the size of %s: %v", img, err)
+			size = -1
+		}
+		sizes[i] = size
+	}
+	return sizes
+}
+
+// totalImageSize sums the sizes imageSizes read, and describes the estimate
+func totalImageSize(sizes []int64) (total int64, estimate string) {
+	known := 0
+	for _, size := range sizes {
+		if size >= 0 {
+			total += size
+			known++
+		}
+	}
+
+	estimate = fmt.Sprintf("%.1f GiB compressed", float64(total)/(1<<30))
+	switch {
+	case known == 0:
+		estimate = "size unknown"
+	case known < len(sizes):
+		// Assume the images that could not be read are of average size
+		total = total * int64(len(sizes)) / int64(known)
+		estimate = fmt.Sprintf("~%.1f GiB compressed, extrapolated from %d of them", float64(total)/(1<<30), known)
+	}
+	return total, estimate
+}
+
+// autoMachineType returns the machine type --machine-type=auto picks for the
+// images, total bytes compressed, with its vCPUs and the reason
+func (w *Workflow) autoMachineType(total int64) (machineType string, vcpus int, reason string) {
+	vcpus, reason = maxAutoVCPUs, fmt.Sprintf("more than %d GiB or %d images",
+		machineTiers[len(machineTiers)-1].maxBytes>>30, machineTiers[len(machineTiers)-1].maxImages)
+	for _, tier := range machineTiers {
+		if total <= tier.maxBytes && len(w.images) <= tier.maxImages {
+			vcpus, reason = tier.vcpus, fmt.Sprintf("up to %d GiB and %d images", tier.maxBytes>>30, tier.maxImages)
+			break
+		}
+	}
+
+	family := "e2-standard"
+	if w.config.IsARM64() {
+		family = "t2a-standard"
+	}
+	return fmt.Sprintf("%s-%d", family, vcpus), vcpus, reason
+}
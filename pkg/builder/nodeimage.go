@@ -0,0 +1,87 @@
+package builder
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// nodeImageTypeLabel records the node image type a cache was declared for
+const nodeImageTypeLabel = "cache-node-image-type"
+
+// checkNodeImageType warns when a build VM the user chose, with
+// --build-vm-image or local mode, runs another OS family than the declared
+// node image type: the cache is laid down by the build VM's containerd, and
+// nothing but a node of the declared type shows that its containerd uses it.
+// The smoke test is such a node for COS. The default build VM image differs
+// from COS by design, which is only noted.
+func (w *Workflow) checkNodeImageType() {
+	if w.config.NodeImageType == "" {
+		return
+	}
+
+	var buildVM string
+	if w.config.IsLocalMode() {
+		buildVM = localImageType()
+	} else {
+		buildVM = bootImageType(w.bootImage)
+	}
+	switch {
+	case buildVM == "":
+		w.logger.Debugf("Cannot tell the OS family of the build VM, not comparing it with --node-image-type=%s", w.config.NodeImageType)
+	case buildVM == w.config.NodeImageType:
+	case w.config.NodeImageType == config.NodeImageCOS && w.config.SmokeTest:
+		w.logger.Infof("The build VM runs %s, not COS; --smoke-test checks the image on a COS node", buildVM)
+	case w.config.IsRemoteMode() && w.config.BuildVMImage == "":
+		w.logger.Infof("The default build VM runs %s, not %s like the nodes; check the cache on a test node pool before rolling it out",
+			buildVM, w.config.NodeImageType)
+	default:
+		w.logger.Warnf("The build VM runs %s but --node-image-type is %s: the cache may not line up with the nodes' containerd. "+
+			"Check it on a test node pool before rolling it out (for COS nodes, --smoke-test does)", buildVM, w.config.NodeImageType)
+	}
+}
+
+// bootImageType returns the node image type matching the OS family of a
+// build VM boot image, or "" for other families
+func bootImageType(bootImage string) string {
+	path, err := gcp.ParseImagePath(bootImage)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case path.Project == "cos-cloud" || strings.HasPrefix(path.Name, "cos-"):
+		return config.NodeImageCOS
+	case strings.Contains(path.Project, "ubuntu") || strings.HasPrefix(path.Name, "ubuntu-"):
+		return config.NodeImageUbuntu
+	}
+	return ""
+}
+
+// localImageType returns the node image type matching the OS family of this
+// machine, from the ID in /etc/os-release, or "" for other families
+func localImageType() string {
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		id, ok := strings.CutPrefix(scanner.Text(), "ID=")
+		if !ok {
+			continue
+		}
+		switch strings.Trim(id, `"`) {
+		case "cos":
+			return config.NodeImageCOS
+		case "ubuntu":
+			return config.NodeImageUbuntu
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// nodePoolImageType returns the GKE image type of the node pools the cache is
+// built for, or "" if --node-image-type does not declare it
+func nodePoolImageType(cfg *config.Config) string {
+	switch {
+	case cfg.IsWindows():
+		return "WINDOWS_LTSC_CONTAINERD"
+	case cfg.NodeImageType == config.NodeImageCOS:
+		return "COS_CONTAINERD"
+	case cfg.NodeImageType == config.NodeImageUbuntu:
+		return "UBUNTU_CONTAINERD"
+	}
+	return ""
+}
+
+// imagePaths returns the disk-image path of each image, as node pools reference them
+func (r *BuildResult) imagePaths() []string {
+	paths := make([]string, 0, len(r.Images))
+	for _, name := range r.Images {
+		paths = append(paths, (&gcp.ImagePath{Project: r.Project, Name: name}).String())
+	}
+	return paths
+}
+
+// NodePoolCommand returns the gcloud command creating a node pool that
+// attaches the images as secondary boot disks, one per partition. The pool,
+// cluster and location, and an Arm node pool's machine type, are placeholders
+// in angle brackets.
+func (r *BuildResult) NodePoolCommand() string {
+	command := []string{"gcloud container node-pools create <POOL> --cluster=<CLUSTER> --location=<LOCATION>"}
+	if r.ImageType != "" {
+		command = append(command, "--image-type="+r.ImageType)
+	}
+	if r.Arm {
+		command = append(command, "--machine-type=<ARM_MACHINE_TYPE>")
+	}
+	for _, path := range r.imagePaths() {
+		command = append(command, fmt.Sprintf("--secondary-boot-disk=disk-image=%s,mode=CONTAINER_IMAGE_CACHE", path))
+	}
+	return strings.Join(command, " \\\n  ")
+}
+
+// NodePoolTerraform returns a google_container_node_pool resource that
+// attaches the images as secondary boot disks, with the placeholders of
+// NodePoolCommand
+func (r *BuildResult) NodePoolTerraform() string {
+	var tf strings.Builder
+	tf.WriteString("resource \"google_container_node_pool\" \"image_cache\" {\n")
+	tf.WriteString("  name     = \"<POOL>\"\n")
+	tf.WriteString("  cluster  = \"<CLUSTER>\"\n")
+	tf.WriteString("  location = \"<LOCATION>\"\n\n")
+	tf.WriteString("  node_config {\n")
+	key := "image_type"
+	if r.Arm {
+		key = "image_type  " // aligned with machine_type, as terraform fmt does
+	}
+	if r.ImageType != "" {
+		fmt.Fprintf(&tf, "    %s = %q\n", key, r.ImageType)
+	}
+	if r.Arm {
+		tf.WriteString("    machine_type = \"<ARM_MACHINE_TYPE>\"\n")
+	}
+	for _, path := range r.imagePaths() {
+		tf.WriteString("\n    secondary_boot_disks {\n")
+		fmt.Fprintf(&tf, "      disk_image = %q\n", path)
+		tf.WriteString("      mode       = \"CONTAINER_IMAGE_CACHE\"\n")
+		tf.WriteString("    }\n")
+	}
+	tf.WriteString("  }\n")
+	tf.WriteString("}")
+	return tf.String()
+}
+
+// writeNodePoolConfig writes the configuration of a node pool attaching the
+// images a successful build produced, or found with --skip-if-exists, to
+// --node-pool-config
+func (b *Builder) writeNodePoolConfig(images []string) error {
+	path := b.config.NodePoolConfig
+	if path == "" || len(images) == 0 {
+		return nil
+	}
+
+	result := b.newResult(images, nil)
+	content := result.NodePoolCommand()
+	if b.config.NodePoolConfigFormat == config.NodePoolConfigTerraform {
+		content = result.NodePoolTerraform()
+	}
+	if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write node pool configuration: %w", err)
+	}
+	b.logger.Infof("Wrote node pool configuration: %s", path)
+	return nil
+}
@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+)
+
+// buildPartitioned splits the image set across several workflows that run concurrently,
+// each on its own VM and cache disk. Every partition produces a separate image in the
+// shared family; partitions are not merged into a single image. It returns the
+// names of the partition images and the self-links of the partition disks kept,
+// also those of the partitions that succeeded when others failed.
+func (b *Builder) buildPartitioned(ctx context.Context, recorder *buildRecorder, snapshot *configSnapshot, attestor *attestor) ([]string, []string, error) {
+	// Resolve the image set once so every partition pulls the same pinned digests
+	root := NewWorkflow(b.config, b.logger, b.vmManager, b.diskManager, b.imageCache)
+	if err := root.resolveImageSet(ctx); err != nil {
+		return nil, nil, fmt.Errorf("prerequisite validation failed: %w", err)
+	}
+
+	partitions := partitionImages(root.images, b.config.Partitions)
+	if len(partitions) < b.config.Partitions {
+		b.logger.Warnf("Only %d images to cache, reducing partitions from %d to %d",
+			len(root.images), b.config.Partitions, len(partitions))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(partitions))
+	results := make([]string, len(partitions))
+	disks := make([]string, len(partitions))
+
+	for i, images := range partitions {
+		cfg := b.partitionConfig(i, len(partitions), images)
+		b.logger.Infof("Partition %d/%d: %s (%d images)", i+1, len(partitions), cfg.DiskImageName, len(images))
+
+		wg.Add(1)
+		go func(index int, cfg *config.Config) {
+			defer wg.Done()
+
+			workflow := NewWorkflow(cfg, b.logger, b.vmManager, b.diskManager, b.imageCache)
+			workflow.recorder = recorder
+			workflow.snapshot = snapshot
+			workflow.attestor = attestor
+			workflow.runID = b.runID
+			workflow.buildID = b.buildID
+			if err := workflow.Execute(ctx); err != nil {
+				errs[index] = fmt.Errorf("partition %s: %w", cfg.DiskImageName, err)
+			}
+			results[index] = workflow.resultImage
+			disks[index] = workflow.resultDisk
+		}(i, cfg)
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		// The successful partitions' outputs are recorded in last-build.json too
+		empty := func(s string) bool { return s == "" }
+		return slices.DeleteFunc(results, empty), slices.DeleteFunc(disks, empty), fmt.Errorf("workflow execution failed: %w", err)
+	}
+
+	if b.config.WriteLockfile != "" {
+		if err := root.lockfile.Write(b.config.WriteLockfile); err != nil {
+			return nil, nil, err
+		}
+		b.logger.Infof("Wrote image lockfile: %s", b.config.WriteLockfile)
+	}
+
+	if !b.config.OutputsImage() {
+		results = nil
+	}
+	if !b.config.OutputsDisk() {
+		disks = nil
+	}
+	return results, disks, nil
+}
+
+// partitionConfig derives the configuration of a single partition build
+func (b *Builder) partitionConfig(index, total int, images []string) *config.Config {
+	cfg := *b.config
+	suffix := fmt.Sprintf("-p%d", index+1)
+
+	cfg.DiskImageName = b.config.DiskImageName + suffix
+	cfg.JobName = b.config.JobName + suffix
+	cfg.ContainerImages = images
+	cfg.Partitions = 1
+
+	// The image set is already pinned and the lockfile is written once for all partitions
+	cfg.Lockfile = ""
+	cfg.WriteLockfile = ""
+
+	cfg.DiskLabels = make(map[string]string, len(b.config.DiskLabels)+2)
+	for k, v := range b.config.DiskLabels {
+		cfg.DiskLabels[k] = v
+	}
+	cfg.DiskLabels["cache-partition"] = strconv.Itoa(index + 1)
+	cfg.DiskLabels["cache-partitions"] = strconv.Itoa(total)
+
+	return &cfg
+}
+
+// partitionImages distributes images round-robin into at most n non-empty partitions
+func partitionImages(images []string, n int) [][]string {
+	if n > len(images) {
+		n = len(images)
+	}
+
+	partitions := make([][]string, n)
+	for i, img := range images {
+		partitions[i%n] = append(partitions[i%n], img)
+	}
+	return partitions
+}
@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+)
+
+func TestPartitionImages(t *testing.T) {
+	images := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		n    int
+		want [][]string
+	}{
+		{1, [][]string{{"a", "b", "c", "d", "e"}}},
+		{2, [][]string{{"a", "c", "e"}, {"b", "d"}}},
+		{8, [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}},
+	}
+	for _, tt := range tests {
+		if got := partitionImages(images, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("partitionImages(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+// TestPartitionConfigsDoNotShareLabels runs partitions side by side, as
+// buildPartitioned does, each using and changing the labels of its own
+// configuration while the build's configuration is read
+func TestPartitionConfigsDoNotShareLabels(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.DiskImageName = "cache"
+	cfg.DiskLabels = map[string]string{"team": "web"}
+	b := &Builder{config: cfg}
+
+	const total = 4
+	var wg sync.WaitGroup
+	configs := make([]*config.Config, total)
+	for i := 0; i < total; i++ {
+		configs[i] = b.partitionConfig(i, total, []string{fmt.Sprintf("image-%d", i)})
+	}
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(index int, cfg *config.Config) {
+			defer wg.Done()
+			w := NewWorkflow(cfg, nil, nil, nil, nil)
+			_ = w.vmLabels()
+			_ = w.imageLabels()
+			cfg.DiskLabels["partition-state"] = strconv.Itoa(index)
+			_ = b.config.DiskLabels["team"]
+		}(i, configs[i])
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(cfg.DiskLabels, map[string]string{"team": "web"}) {
+		t.Errorf("build labels = %v, changed by the partitions", cfg.DiskLabels)
+	}
+	for i, c := range configs {
+		want := map[string]string{
+			"team":             "web",
+			"cache-partition":  strconv.Itoa(i + 1),
+			"cache-partitions": strconv.Itoa(total),
+			"partition-state":  strconv.Itoa(i),
+		}
+		if !reflect.DeepEqual(c.DiskLabels, want) {
+			t.Errorf("partition %d labels = %v, want %v", i+1, c.DiskLabels, want)
+		}
+		if c.DiskImageName != fmt.Sprintf("cache-p%d", i+1) {
+			t.Errorf("partition %d image = %s", i+1, c.DiskImageName)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+)
+
+// checkProject confirms, before anything is created, that the configured
+// project exists and is readable, and that it is the project of
+// --expected-project-number. A project ID is accepted verbatim, so a typo
+// or a copy-pasted ID would otherwise only show in what the build creates.
+func (b *Builder) checkProject(ctx context.Context) error {
+	project := b.config.ProjectName
+	number, err := b.gcpClient.CheckProject(ctx, project)
+	if err != nil {
+		return err
+	}
+	if expected := b.config.ExpectedProjectNumber; expected != "" && number != expected {
+		return fmt.Errorf("project %s has number %s, not the expected %s: refusing to run in it; check --project-name for a typo",
+			project, number, expected)
+	}
+	b.logger.Debugf("Project %s exists (number %s)", project, number)
+	return nil
+}
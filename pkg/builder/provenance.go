@@ -0,0 +1,202 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/attest"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/trace"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// ToolVersion identifies this tool as the builder in provenance statements; main sets it
+var ToolVersion = "dev"
+
+// attestationRefLabel names the bucket holding an image's provenance. As with
+// config-ref, the object name is derived from the image name. It is set once
+// the provenance is uploaded, so an image carrying it always has one.
+const attestationRefLabel = "attestation-ref"
+
+// attestationPrefix is where provenance envelopes are stored in the bucket
+const attestationPrefix = "gke-image-cache-builder/attestations/"
+
+// provenanceBuildType identifies how this tool builds images, for verifiers
+const provenanceBuildType = "https://github.com/0x00fafa/gke-image-cache-builder/cache-image/v1"
+
+// attestor stores a SLSA provenance statement for each cache image a build creates
+type attestor struct {
+	client    *gcp.Client
+	bucket    string
+	signer    attest.Signer // nil leaves the envelopes unsigned
+	startedOn time.Time
+}
+
+// newAttestor returns the attestor configured with --attestation-bucket, or nil
+func (b *Builder) newAttestor() (*attestor, error) {
+	if b.config.AttestationBucket == "" {
+		return nil, nil
+	}
+	a := &attestor{
+		client:    b.gcpClient,
+		bucket:    b.config.AttestationBucket,
+		startedOn: time.Now().UTC(),
+	}
+
+	switch {
+	case b.config.AttestationKMSKey != "":
+		a.signer = attest.NewKMSSigner(b.gcpClient, b.config.AttestationKMSKey)
+	case b.config.AttestationKeyFile != "":
+		signer, err := attest.LoadKeyFile(b.config.AttestationKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.signer = signer
+	default:
+		b.logger.Warn("Provenance will be stored unsigned: no --attestation-kms-key or --attestation-key-file")
+	}
+	return a, nil
+}
+
+// labels returns the image labels referencing the provenance
+func (a *attestor) labels() map[string]string {
+	return map[string]string{attestationRefLabel: a.bucket}
+}
+
+func attestationObject(imageName string) string {
+	return attestationPrefix + imageName + ".intoto.jsonl"
+}
+
+// recordProvenance stores the provenance of the image the workflow created
+func (w *Workflow) recordProvenance(ctx context.Context) (err error) {
+	if w.attestor == nil {
+		return nil
+	}
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "record provenance")
+	defer func() { span.End(err) }()
+
+	statement, err := w.provenance()
+	if err != nil {
+		return err
+	}
+	envelope, err := attest.Seal(ctx, statement, w.attestor.signer)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode provenance: %w", err)
+	}
+
+	object := attestationObject(w.config.DiskImageName)
+	if err := w.attestor.client.UploadObject(ctx, w.attestor.bucket, object, "application/jsonl", append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to upload provenance: %w", err)
+	}
+	signed := "unsigned"
+	if w.attestor.signer != nil {
+		signed = "signed by " + w.attestor.signer.KeyID()
+	}
+	w.logger.Infof("Uploaded provenance (%s): gs://%s/%s", signed, w.attestor.bucket, object)
+
+	if err := w.diskManager.AddImageLabels(ctx, w.config.DiskImageName, w.attestor.labels()); err != nil {
+		return fmt.Errorf("provenance uploaded, but not referenced from the image: %w", err)
+	}
+	return nil
+}
+
+// provenance describes the image the workflow created. The subject's digest is
+// the full image set digest, whose prefix is the image-set-hash label: Compute
+// Engine images have no content digest of their own.
+func (w *Workflow) provenance() (*attest.Statement, error) {
+	setDigest, err := image.ImageSetDigest(w.pinnedImages)
+	if err != nil {
+		return nil, err
+	}
+	subject := attest.Subject{
+		Name:   (&gcp.ImagePath{Project: w.config.ProjectName, Name: w.config.DiskImageName}).String(),
+		Digest: map[string]string{"sha256": setDigest},
+	}
+
+	dependencies := make([]attest.ResourceDescriptor, 0, len(w.pinnedImages)+1)
+	for _, pinned := range w.pinnedImages {
+		ref, err := image.ParseReference(pinned)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, hex, _ := strings.Cut(ref.Digest, ":")
+		dependencies = append(dependencies, attest.ResourceDescriptor{
+			URI:    "docker://" + pinned,
+			Digest: map[string]string{algorithm: hex},
+		})
+	}
+	parameters := map[string]any{
+		"project":      w.config.ProjectName,
+		"image":        w.config.DiskImageName,
+		"family":       w.config.DiskFamilyName,
+		"images":       w.config.ContainerImages,
+		"osType":       w.config.OSType,
+		"architecture": w.config.Arch,
+	}
+	if !w.config.IsWindows() {
+		parameters["snapshotter"] = w.config.Snapshotter
+	}
+	if w.config.PostPullCommand != "" {
+		parameters["postPullCommand"] = w.config.PostPullCommand
+	}
+	if len(w.config.PrePullCommands) > 0 {
+		parameters["prePullCommands"] = w.config.PrePullCommands
+	}
+	if len(w.config.PostPullCommands) > 0 {
+		parameters["postPullCommands"] = w.config.PostPullCommands
+	}
+	if len(w.credentialProviders) > 0 {
+		parameters["credentialProviders"] = w.credentialProviders
+	}
+	dependencies = append(dependencies, additionalScriptDependencies(w.additionalScripts)...)
+	if path, err := gcp.ParseImagePath(w.bootImage); err == nil {
+		dependencies = append(dependencies, attest.ResourceDescriptor{
+			Name: "build-vm-image",
+			URI:  path.SelfLink(),
+		})
+	}
+	if w.snapshot != nil {
+		parameters["configHash"] = w.snapshot.hash
+		config := attest.ResourceDescriptor{
+			Name:   "config",
+			Digest: map[string]string{"sha256": w.snapshot.digest},
+		}
+		if w.snapshot.bucket != "" {
+			config.URI = fmt.Sprintf("gs://%s/%s", w.snapshot.bucket, configSnapshotObject(w.snapshot.hash))
+		}
+		dependencies = append(dependencies, config)
+	}
+
+	versions := map[string]string{"gke-image-cache-builder": ToolVersion}
+	if w.containerdVersion != "" {
+		versions["containerd"] = w.containerdVersion
+	}
+	finishedOn := time.Now().UTC()
+
+	return attest.NewProvenance(
+		[]attest.Subject{subject},
+		attest.BuildDefinition{
+			BuildType:            provenanceBuildType,
+			ExternalParameters:   parameters,
+			ResolvedDependencies: dependencies,
+		},
+		attest.RunDetails{
+			Builder: attest.Builder{
+				ID:      "https://github.com/0x00fafa/gke-image-cache-builder@v" + ToolVersion,
+				Version: versions,
+			},
+			Metadata: &attest.BuildMetadata{
+				InvocationID: w.config.JobName,
+				StartedOn:    &w.attestor.startedOn,
+				FinishedOn:   &finishedOn,
+			},
+		},
+	), nil
+}
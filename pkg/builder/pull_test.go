@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// newPullWorkflow returns a workflow pulling images with pull, at most
+// concurrency at a time
+func newPullWorkflow(images []string, concurrency int, pull func(ref string) error) *Workflow {
+	cfg := config.NewConfig()
+	cfg.Mode = config.ModeRemote
+	cfg.ContainerImages = images
+	w := NewWorkflow(cfg, log.NewLogger(false, true, log.NewTextImpl(io.Discard)), nil, nil, nil)
+	w.pullConcurrency = concurrency
+	w.pullImage = func(ctx context.Context, runner image.Runner, ref string, opts image.PullOptions) (image.PullStats, error) {
+		return image.PullStats{Image: ref}, pull(ref)
+	}
+	return w
+}
+
+func TestPullImagesLimitsConcurrency(t *testing.T) {
+	var images []string
+	for i := 0; i < 12; i++ {
+		images = append(images, fmt.Sprintf("registry.example.com/app-%d:1", i))
+	}
+
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	pulled := make(map[string]bool)
+	w := newPullWorkflow(images, 3, func(ref string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		pulled[ref] = true
+		mu.Unlock()
+		return nil
+	})
+
+	if err := w.pullImages(context.Background(), &WorkflowResources{}); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > 3 || p < 2 {
+		t.Errorf("%d pulls ran at once, want at most 3 and more than 1", p)
+	}
+	if len(pulled) != len(images) {
+		t.Errorf("%d images pulled, want %d", len(pulled), len(images))
+	}
+}
+
+func TestPullImagesStopsWaitingWhenCancelled(t *testing.T) {
+	images := []string{"registry.example.com/a:1", "registry.example.com/b:1", "registry.example.com/c:1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	var started atomic.Int32
+	w := newPullWorkflow(images, 1, func(ref string) error {
+		started.Add(1)
+		cancel()
+		<-release
+		return context.Canceled
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- w.pullImages(ctx, &WorkflowResources{}) }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("pullImages() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pullImages() did not return after the context was cancelled")
+	}
+	if n := started.Load(); n != 1 {
+		t.Errorf("%d pulls started, want only the one holding the slot", n)
+	}
+}
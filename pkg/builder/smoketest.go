@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/deadline"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/trace"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+)
+
+// smokeTestImage checks the created image the way a node consumes it: a disk
+// is created from the image and attached read-only to a small Container-Optimized
+// OS VM whose startup script asks containerd, pointed at the disk's content,
+// whether every image resolves. The VM and disk are deleted afterwards; the
+// image is kept either way.
+func (w *Workflow) smokeTestImage(ctx context.Context) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "smoke test")
+	defer func() { span.End(err) }()
+
+	w.logger.Infof("Smoke testing cache image %s on a node VM...", w.config.DiskImageName)
+	start := time.Now()
+
+	smokeCtx, cancel := deadline.WithTimeout(ctx, w.config.SmokeTestTimeout)
+	defer cancel()
+
+	// The references containerd records, one per line in the metadata
+	refs := make([]string, 0, len(w.images))
+	for _, img := range w.images {
+		ref, err := image.ParseReference(img)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref.String())
+	}
+
+	suffix := strconv.FormatInt(time.Now().Unix(), 36)
+	resources := &WorkflowResources{}
+	defer w.cleanupResources(ctx, resources)
+
+	smokeDisk, err := w.diskManager.CreateDisk(smokeCtx, &disk.Config{
+		Name:         verifyResourceName(w.config.DiskImageName, "smoke-"+suffix),
+		Zone:         w.config.Zone,
+		Type:         w.config.DiskType,
+		Labels:       w.vmLabels(),
+		Architecture: disk.Architecture(w.config.Arch),
+		SourceImage:  fmt.Sprintf("projects/%s/global/images/%s", w.config.ProjectName, w.config.DiskImageName),
+	})
+	if err != nil {
+		return w.smokeTestError(ctx, smokeCtx, err)
+	}
+	resources.CacheDisk = smokeDisk
+
+	machineType := verifyMachineType
+	if w.config.IsARM64() {
+		machineType = verifyArmMachineType
+	}
+	instance, err := w.vmManager.CreateVM(smokeCtx, &vm.Config{
+		Name:           "cache-smoke-" + suffix,
+		Zone:           w.config.Zone,
+		MachineType:    machineType,
+		Network:        w.config.Network,
+		Subnet:         w.config.Subnet,
+		ServiceAccount: w.config.ServiceAccount,
+		Arch:           w.config.Arch,
+		BootImage:      vm.NodeBootImage(w.config.Arch),
+		NoExternalIP:   w.config.NoExternalIP,
+		ShieldedVM:     w.config.ShieldedVM,
+		DataDisks:      []string{smokeDisk.Name},
+		ReadOnlyDisks:  true,
+		Labels:         w.vmLabels(),
+		StartupScript:  scripts.GetSmokeTestScript(),
+		Metadata: map[string]string{
+			"smoke-test-device": smokeDisk.Name,
+			"smoke-test-images": strings.Join(refs, "\n"),
+		},
+	})
+	if err != nil {
+		return w.smokeTestError(ctx, smokeCtx, fmt.Errorf("failed to create smoke test VM: %w", err))
+	}
+	resources.VMInstance = instance
+	w.recorder.addVM(instance, w.config.ProjectName)
+	w.logger.Infof("Created smoke test VM: %s (%s)", instance.Name, instance.MachineType)
+
+	status, err := w.vmManager.WaitForStatus(smokeCtx, instance, "smoke-test", 0)
+	if err != nil {
+		return w.smokeTestError(ctx, smokeCtx, err)
+	}
+	attrs, err := w.vmManager.GetGuestAttributes(smokeCtx, instance)
+	if err != nil {
+		return w.smokeTestError(ctx, smokeCtx, err)
+	}
+
+	switch {
+	case status == "passed":
+		w.logger.Infof("Smoke test passed: all %d images resolve from the image on %s (%s)",
+			len(refs), instance.Name, time.Since(start).Round(time.Second))
+		return nil
+	case attrs["smoke-test-missing"] != "":
+		missing := strings.Fields(attrs["smoke-test-missing"])
+		return fmt.Errorf("smoke test failed: %d of %d images do not resolve from image %s:\n  %s",
+			len(missing), len(refs), w.config.DiskImageName, strings.Join(missing, "\n  "))
+	default:
+		return fmt.Errorf("smoke test could not check image %s: %s (check the serial console: %s)",
+			w.config.DiskImageName, attrs["smoke-test-error"], instance.SerialConsoleCommand(w.config.ProjectName))
+	}
+}
+
+// smokeTestError names --smoke-test-timeout when the smoke test ran out of it
+func (w *Workflow) smokeTestError(ctx, smokeCtx context.Context, err error) error {
+	if ctx.Err() == nil && smokeCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("smoke test exceeded --smoke-test-timeout of %s: %w", w.config.SmokeTestTimeout, err)
+	}
+	return fmt.Errorf("smoke test failed: %w", err)
+}
@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/auth"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// configHashLabel identifies the effective configuration a cache image was built with
+const configHashLabel = "config-hash"
+
+// configRefLabel names the bucket holding the configuration snapshot. Label values
+// cannot hold a path, so the object name is derived from the config hash.
+const configRefLabel = "config-ref"
+
+// configSnapshotPrefix is where configuration snapshots are stored in the bucket
+const configSnapshotPrefix = "gke-image-cache-builder/configs/"
+
+// configSnapshot identifies the configuration of a build on the images it produces
+type configSnapshot struct {
+	hash   string
+	digest string // full hex SHA-256 of the snapshot, which hash is shortened from
+	bucket string // empty unless the snapshot was uploaded
+}
+
+// labels returns the image labels recording the snapshot
+func (s *configSnapshot) labels() map[string]string {
+	if s == nil {
+		return nil
+	}
+	labels := map[string]string{configHashLabel: s.hash}
+	if s.bucket != "" {
+		labels[configRefLabel] = s.bucket
+	}
+	return labels
+}
+
+func configSnapshotObject(hash string) string {
+	return configSnapshotPrefix + hash + ".yaml"
+}
+
+// publishConfigSnapshot hashes the effective configuration and, with
+// --config-snapshot-bucket, uploads it so the build can be reproduced
+func (b *Builder) publishConfigSnapshot(ctx context.Context) (*configSnapshot, error) {
+	data, err := b.config.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := b.config.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	snapshot := &configSnapshot{hash: hash, digest: hex.EncodeToString(sum[:])}
+	b.logger.Debugf("Configuration hash: %s", hash)
+
+	if b.config.ConfigSnapshotBucket == "" {
+		return snapshot, nil
+	}
+	object := configSnapshotObject(hash)
+	if err := b.gcpClient.UploadObject(ctx, b.config.ConfigSnapshotBucket, object, "application/yaml", data); err != nil {
+		return nil, fmt.Errorf("failed to upload configuration snapshot: %w", err)
+	}
+	snapshot.bucket = b.config.ConfigSnapshotBucket
+	b.logger.Infof("Uploaded configuration snapshot: gs://%s/%s", snapshot.bucket, object)
+	return snapshot, nil
+}
+
+// RecordedConfig is what a cache image records about the build that produced it
+type RecordedConfig struct {
+	Snapshot     []byte // the effective configuration, as YAML
+	BuildVMImage string // name of the image the build VM booted from; empty for local builds
+}
+
+// FetchConfigSnapshot downloads the configuration a cache image was built with.
+// The image is a name in project or a projects/<project>/global/images/<name> path.
+func FetchConfigSnapshot(ctx context.Context, project, credentials, apiEndpoint, imageName string) (*RecordedConfig, error) {
+	if path, err := gcp.ParseImagePath(imageName); err == nil {
+		if path.Family {
+			return nil, fmt.Errorf("give an image name, not a family: %s", imageName)
+		}
+		project, imageName = path.Project, path.Name
+	}
+	if project == "" {
+		return nil, fmt.Errorf("project name is required to find image %s (use --project-name)", imageName)
+	}
+
+	clientOption, err := auth.NewGCPAuth(credentials).GetClientOption(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := gcp.NewClient(project, clientOption, apiEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCP client: %w", err)
+	}
+	img, err := client.Compute().Images.Get(project, imageName).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %s: %w", imageName, err)
+	}
+
+	hash, bucket := img.Labels[configHashLabel], img.Labels[configRefLabel]
+	if hash == "" {
+		return nil, fmt.Errorf("image %s has no %s label: it was not built with a configuration snapshot", imageName, configHashLabel)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("image %s has no %s label: rebuild it with --config-snapshot-bucket to record its configuration", imageName, configRefLabel)
+	}
+
+	data, err := client.DownloadObject(ctx, bucket, configSnapshotObject(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the configuration of image %s: %w", imageName, err)
+	}
+	return &RecordedConfig{Snapshot: data, BuildVMImage: img.Labels[buildVMImageLabel]}, nil
+}
@@ -0,0 +1,40 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/attest"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+// loadAdditionalScripts reads the --additional-startup-script files, named
+// after their base names in the build VM's log
+func loadAdditionalScripts(paths []string) ([]scripts.AdditionalScript, error) {
+	loaded := make([]scripts.AdditionalScript, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read additional startup script: %w", err)
+		}
+		loaded = append(loaded, scripts.AdditionalScript{Name: filepath.Base(path), Content: string(content)})
+	}
+	return loaded, nil
+}
+
+// additionalScriptDependencies describes the additional startup scripts the
+// build VM ran, for the provenance
+func additionalScriptDependencies(additional []scripts.AdditionalScript) []attest.ResourceDescriptor {
+	dependencies := make([]attest.ResourceDescriptor, 0, len(additional))
+	for _, script := range additional {
+		sum := sha256.Sum256([]byte(script.Content))
+		dependencies = append(dependencies, attest.ResourceDescriptor{
+			Name:   "additional-startup-script:" + script.Name,
+			Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		})
+	}
+	return dependencies
+}
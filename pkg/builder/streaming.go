@@ -0,0 +1,42 @@
+package builder
+
+import (
+	"context"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// imageStreamingService is the API GKE Image Streaming reads images through
+const imageStreamingService = "containerfilesystem.googleapis.com"
+
+// imageStreamingDocs explains when Image Streaming replaces a cache disk
+const imageStreamingDocs = "https://cloud.google.com/kubernetes-engine/docs/how-to/image-streaming"
+
+// checkImageStreaming warns when every image is in Artifact Registry and the
+// project has Image Streaming's API enabled: nodes can then stream the images
+// without a cache disk, and the build may be redundant. --assume-no-streaming
+// acknowledges it. The check is advisory, so failures to run it are only logged.
+func (b *Builder) checkImageStreaming(ctx context.Context) {
+	if b.config.AssumeNoStreaming || len(b.config.ContainerImages) == 0 {
+		return
+	}
+	for _, img := range b.config.ContainerImages {
+		ref, err := image.ParseReference(img)
+		if err != nil || !ref.IsArtifactRegistry() {
+			return
+		}
+	}
+
+	enabled, err := b.gcpClient.ServiceEnabled(ctx, imageStreamingService)
+	if err != nil {
+		b.logger.Debugf("Cannot tell whether Image Streaming is enabled: %v", err)
+		return
+	}
+	if !enabled {
+		return
+	}
+	b.logger.Warnf("All %d images are in Artifact Registry and %s is enabled in project %s: "+
+		"GKE Image Streaming may serve them without a cache disk, see %s "+
+		"(pass --assume-no-streaming if the node pools do not use it)",
+		len(b.config.ContainerImages), imageStreamingService, b.config.ProjectName, imageStreamingDocs)
+}
@@ -0,0 +1,81 @@
+package builder
+
+import (
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// trendThreshold is the change in a registry's pull rate from the previous
+// build that is called out as faster or slower
+const trendThreshold = 0.25
+
+// reportPullThroughput logs the pull rate of every image, warns about
+// registries slower than --min-pull-throughput and records the pulls in
+// last-build.json
+func (w *Workflow) reportPullThroughput(stats []image.PullStats) {
+	w.recorder.addPulls(stats)
+
+	for _, s := range stats {
+		if s.Bytes == 0 {
+			w.logger.Debugf("Pulled %s in %s (size unknown)", s.Image, s.Duration.Round(100*time.Millisecond))
+			continue
+		}
+		w.logger.Infof("Pulled %s: %.1f MB in %s (%.1f MB/s)", s.Image, float64(s.Bytes)/1e6, s.Duration.Round(100*time.Millisecond), s.MBps())
+	}
+
+	threshold := w.config.MinPullThroughput
+	if threshold == 0 {
+		return
+	}
+	for _, t := range image.ByRegistry(stats) {
+		if t.MBps() < threshold {
+			w.logger.Warnf("Registry %s is slow: %.1f MB/s over %d image(s) is below --min-pull-throughput of %.1f MB/s",
+				t.Registry, t.MBps(), t.Images, threshold)
+		}
+	}
+}
+
+// logPullSummary logs the pull rate of each registry over the whole build and
+// how it compares with the previous build recorded in last-build.json
+func (r *buildRecorder) logPullSummary(record BuildRecord) {
+	previousRates := make(map[string]float64)
+	previous := r.previous
+	if previous != nil {
+		for _, t := range image.ByRegistry(pullStats(previous.Pulls)) {
+			previousRates[t.Registry] = t.MBps()
+		}
+	}
+
+	for _, t := range image.ByRegistry(pullStats(record.Pulls)) {
+		r.logger.Infof("Registry %s: %.1f MB/s over %d image(s)", t.Registry, t.MBps(), t.Images)
+
+		before, ok := previousRates[t.Registry]
+		if !ok {
+			continue
+		}
+		change := (t.MBps() - before) / before
+		if change <= -trendThreshold || change >= trendThreshold {
+			trend := "faster"
+			if change < 0 {
+				trend, change = "slower", -change
+			}
+			r.logger.Infof("  %.0f%% %s than in the previous build (%s, %s): %.1f MB/s",
+				change*100, trend, previous.DiskImage, previous.StartedAt.Local().Format("2006-01-02 15:04"), before)
+		}
+	}
+}
+
+// pullStats converts recorded pulls back to measurements
+func pullStats(pulls []PullRecord) []image.PullStats {
+	stats := make([]image.PullStats, 0, len(pulls))
+	for _, p := range pulls {
+		stats = append(stats, image.PullStats{
+			Image:    p.Image,
+			Registry: p.Registry,
+			Bytes:    p.Bytes,
+			Duration: time.Duration(p.Seconds * float64(time.Second)),
+		})
+	}
+	return stats
+}
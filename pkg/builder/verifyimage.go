@@ -0,0 +1,282 @@
+package builder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ssh"
+)
+
+// Verification VM machine types: the check reads and hashes files, nothing more
+const (
+	verifyMachineType    = "e2-micro"
+	verifyArmMachineType = "t2a-standard-1"
+)
+
+// mountScript waits for the disk $DEVICE, mounts the first filesystem found on it
+// or its partitions read-only at $MOUNT without replaying journals, confirms the
+// kernel kept the mount read-only, and prints "FS <type>" and "CONTENT <dir>" with
+// the containerd content store it holds: at the filesystem root on Linux caches,
+// under ProgramData on Windows caches.
+const mountScript = `set -e
+dev="/dev/disk/by-id/google-$DEVICE"
+for i in $(seq 1 30); do [ -e "$dev" ] && break; sleep 2; done
+[ -e "$dev" ] || { echo "device $dev did not appear" >&2; exit 1; }
+mkdir -p "$MOUNT"
+for part in "$dev" "$dev"-part*; do
+  [ -e "$part" ] || continue
+  fs=$(blkid -o value -s TYPE "$part" || true)
+  case "$fs" in
+    ext2|ext3|ext4) mount -o ro,noload "$part" "$MOUNT" ;;
+    xfs) mount -o ro,norecovery "$part" "$MOUNT" ;;
+    ntfs)
+      if ! mount -t ntfs3 -o ro "$part" "$MOUNT" 2>/dev/null; then
+        command -v ntfs-3g >/dev/null || apt-get install -y -qq ntfs-3g >/dev/null
+        mount -t ntfs-3g -o ro "$part" "$MOUNT"
+      fi ;;
+    *) continue ;;
+  esac
+  # A node sees the disk exactly as the image left it; refuse to check a writable mount
+  opts=$(findmnt -no OPTIONS "$MOUNT")
+  case ",$opts," in
+    *,ro,*) ;;
+    *) umount "$MOUNT"; echo "$part was mounted read-write ($opts)" >&2; exit 1 ;;
+  esac
+  echo "FS $fs"
+  for root in "$MOUNT" "$MOUNT/ProgramData/containerd/root"; do
+    if [ -d "$root/io.containerd.content.v1.content/blobs" ]; then
+      echo "CONTENT $root/io.containerd.content.v1.content"
+      break
+    fi
+  done
+  exit 0
+done
+echo "no supported filesystem on $dev" >&2
+exit 1`
+
+// ImageVerification is the result of verify-image
+type ImageVerification struct {
+	Image      string   `json:"image"`
+	Project    string   `json:"project"`
+	Family     string   `json:"family,omitempty"`
+	Aliases    []string `json:"family_aliases,omitempty"` // recorded with --family-alias
+	Passed     bool     `json:"passed"`
+	Deep       bool     `json:"deep"` // blob contents were rehashed
+	Filesystem string   `json:"filesystem,omitempty"`
+	Images     int      `json:"images"`
+	Manifests  int      `json:"manifests"`
+	Blobs      int      `json:"blobs"`
+	Bytes      int64    `json:"bytes"`
+	Problems   []string `json:"problems"`
+	Duration   string   `json:"duration"`
+}
+
+// VerifyImage checks an existing cache image (the configured disk image name)
+// the way a node would consume it: a throwaway disk is created from the image,
+// attached read-only to this VM (local mode) or to a small VM (remote mode) and
+// mounted read-only, and every manifest in its containerd content store is walked
+// to confirm the referenced blobs. With deep, blob contents are also rehashed.
+// Content problems are reported in the result; an error means the check could not run.
+func (b *Builder) VerifyImage(ctx context.Context, deep bool) (*ImageVerification, error) {
+	start := time.Now()
+	ctx = retry.WithBudget(ctx, retry.NewBudget(b.config.RetryBudget))
+	project, name := b.config.ProjectName, b.config.DiskImageName
+	result := &ImageVerification{Image: name, Project: project, Deep: deep, Problems: []string{}}
+
+	if err := b.checkProject(ctx); err != nil {
+		return nil, err
+	}
+
+	img, err := b.gcpClient.Compute().Images.Get(project, name).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %s: %w", name, err)
+	}
+	if img.Status != disk.StatusReady {
+		return nil, fmt.Errorf("image %s is in status %s, expected READY", name, img.Status)
+	}
+	result.Family = img.Family
+	result.Aliases = config.FamilyAliasesFromLabels(img.Labels)
+	arch := config.ArchX86_64
+	if img.Architecture == "ARM64" {
+		arch = config.ArchARM64
+	}
+
+	// A verification VM mirrors a build VM of the image's architecture
+	cfg := *b.config
+	cfg.OSType, cfg.Arch = config.OSLinux, arch
+	w := NewWorkflow(&cfg, b.logger, b.vmManager, b.diskManager, b.imageCache)
+
+	suffix := strconv.FormatInt(time.Now().Unix(), 36)
+	resources := &WorkflowResources{}
+	defer w.cleanupResources(ctx, resources)
+
+	verifyDisk, err := b.diskManager.CreateDisk(ctx, &disk.Config{
+		Name:         verifyResourceName(name, "verify-"+suffix),
+		Zone:         cfg.Zone,
+		Type:         cfg.DiskType,
+		Labels:       map[string]string{vmImageLabel: name},
+		Architecture: img.Architecture,
+		SourceImage:  img.SelfLink,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resources.CacheDisk = verifyDisk
+
+	mountPoint := "/mnt/gke-image-cache-verify-" + suffix
+	runner, detach, err := w.attachForVerify(ctx, resources, "cache-verify-"+suffix, mountPoint)
+	if detach != nil {
+		defer detach()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	device := verifyDisk.Name
+	if resources.CacheDevice != "" {
+		device = resources.CacheDevice
+	}
+
+	output, err := image.RunAsRoot(ctx, runner, mountScript, []string{"DEVICE=" + device, "MOUNT=" + mountPoint})
+	if err != nil {
+		return nil, fmt.Errorf("failed to mount disk %s read-only: %w: %s", verifyDisk.Name, err, strings.TrimSpace(output))
+	}
+	defer func() {
+		// Bounded like cleanup, also after the context ended
+		umountCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.config.CleanupTimeout)
+		defer cancel()
+		if _, err := runner.Run(umountCtx, "sudo umount "+mountPoint+" && sudo rmdir "+mountPoint); err != nil {
+			b.logger.Warnf("Failed to unmount %s: %v", mountPoint, err)
+		}
+	}()
+
+	contentDir := ""
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if fs, ok := strings.CutPrefix(scanner.Text(), "FS "); ok {
+			result.Filesystem = fs
+		}
+		if dir, ok := strings.CutPrefix(scanner.Text(), "CONTENT "); ok {
+			contentDir = dir
+		}
+	}
+
+	if contentDir == "" {
+		result.Problems = append(result.Problems, "no containerd content store (io.containerd.content.v1.content) on the disk")
+	} else {
+		b.logger.Infof("Inspecting content store %s (%s)...", contentDir, result.Filesystem)
+		report, err := image.InspectContentStore(ctx, runner, contentDir, deep, b.config.ParallelVerify)
+		if err != nil {
+			return nil, err
+		}
+		result.Images, result.Manifests = report.Images, report.Manifests
+		result.Blobs, result.Bytes = report.Blobs, report.Bytes
+		result.Problems = append(result.Problems, report.Problems...)
+	}
+
+	result.Passed = len(result.Problems) == 0
+	result.Duration = time.Since(start).Round(time.Second).String()
+	return result, nil
+}
+
+// attachForVerify attaches the verification disk read-only where it can be
+// mounted: to this VM in local mode, or to a new small VM reached over SSH in
+// remote mode. The returned function detaches the disk from this VM; the VM
+// and disk themselves are left to cleanupResources. A local attachment is
+// recorded in an AttachState until the detach, mounted at mountPoint.
+func (w *Workflow) attachForVerify(ctx context.Context, resources *WorkflowResources, vmName, mountPoint string) (image.Runner, func(), error) {
+	diskName := resources.CacheDisk.Name
+
+	if w.config.IsLocalMode() {
+		state, err := w.attachLocal(ctx, diskName, true, mountPoint, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		resources.CacheDevice = state.DeviceName
+		detach := func() {
+			if err := w.detachLocal(ctx, state); err != nil {
+				w.logger.Warnf("Failed to detach disk %s: %v", diskName, err)
+			}
+		}
+		return image.LocalRunner{}, detach, nil
+	}
+
+	source := w.config.BuildVMImage
+	if source == "" {
+		source = vm.DefaultBootImage(w.config.OSType, w.config.Arch)
+	}
+	bootImage, err := w.vmManager.ResolveBootImage(ctx, source, w.config.BuildVMImageVersion, w.config.Arch)
+	if err != nil {
+		return nil, nil, err
+	}
+	additional, err := loadAdditionalScripts(w.config.AdditionalStartupScripts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := w.sshKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	resources.SSHKey = key
+
+	machineType := verifyMachineType
+	if w.config.IsARM64() {
+		machineType = verifyArmMachineType
+	}
+	instance, err := w.vmManager.CreateVM(ctx, &vm.Config{
+		Name:           vmName,
+		Zone:           w.config.Zone,
+		MachineType:    machineType,
+		Network:        w.config.Network,
+		Subnet:         w.config.Subnet,
+		ServiceAccount: w.config.ServiceAccount,
+		OSType:         w.config.OSType,
+		Arch:           w.config.Arch,
+		BootImage:      bootImage,
+		NoExternalIP:   w.config.NoExternalIP,
+		ShieldedVM:     w.config.ShieldedVM,
+		DataDisks:      []string{diskName},
+		ReadOnlyDisks:  true,
+		SerialPort:     w.config.SerialPort,
+		Labels:         w.vmLabels(),
+		Metadata: map[string]string{
+			"ssh-keys": key.MetadataEntry(ssh.DefaultUser, time.Now().Add(w.config.Timeout+sshKeyGracePeriod)),
+		},
+		AdditionalScripts:      additional,
+		AdditionalScriptsAfter: w.config.AdditionalStartupScriptsOrder == config.StartupScriptsAfter,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create verification VM: %w", err)
+	}
+	resources.VMInstance = instance
+	w.logger.Infof("Created verification VM: %s (%s)", instance.Name, instance.MachineType)
+
+	if err := w.vmManager.SetupVM(ctx, instance); err != nil {
+		return nil, nil, fmt.Errorf("verification VM setup failed: %w", err)
+	}
+	client, err := w.connectSSH(ctx, instance, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("verification VM setup failed: %w", err)
+	}
+	resources.SSHClient = client
+	return client, nil, nil
+}
+
+// verifyResourceName appends a suffix to a resource name, shortening the name to
+// stay within the 63 character limit of Compute resource names
+func verifyResourceName(name, suffix string) string {
+	if limit := 62 - len(suffix); len(name) > limit {
+		name = strings.TrimRight(name[:limit], "-")
+	}
+	return name + "-" + suffix
+}
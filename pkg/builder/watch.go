@@ -0,0 +1,417 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ssh"
+)
+
+// buildIDLabel labels the build VMs of a remote build with its build ID, so
+// "watch" can find them from any machine
+const buildIDLabel = "cache-build-id"
+
+// buildStateKey is the build VM's metadata key holding its buildState
+const buildStateKey = "cache-build-state"
+
+// heartbeatInterval is how often a running build records its state again, to
+// tell "watch" that its invocation is still running it
+const heartbeatInterval = time.Minute
+
+// heartbeatTimeout is how long after its last heartbeat a build is taken for
+// lost by "watch"
+const heartbeatTimeout = 5 * heartbeatInterval
+
+// waitForPullsScript waits for the detached pulls on a build VM to exit
+const waitForPullsScript = `while systemctl list-units --no-legend --state=activating,active 'gke-image-cache-pull-*.service' | grep -q .; do sleep 5; done`
+
+// Build phases recorded in buildState
+const (
+	phasePulling = "pulling" // the cache disk is being filled
+	phasePulled  = "pulled"  // the cache disk is complete and can be captured
+)
+
+// buildState is what a build VM records about its build, so that "watch" can
+// finish the build from another machine once the invocation that started it
+// is gone
+type buildState struct {
+	BuildID    string    `json:"build_id"`
+	Phase      string    `json:"phase"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	OSType     string    `json:"os_type"`
+	SerialPort int       `json:"serial_port,omitempty"`
+	OutputType string    `json:"output_type"`
+	CacheDisk  string    `json:"cache_disk"`
+
+	// Image is the image to create from CacheDisk; its labels are final once
+	// the phase is phasePulled
+	Image *disk.ImageConfig `json:"image"`
+
+	// Images are the exact references pulled onto CacheDisk, and the fields
+	// after them how; "watch" pulls them again if the build was lost while
+	// pulling on a Linux build VM
+	Images          []string `json:"images,omitempty"`
+	Arch            string   `json:"arch,omitempty"`
+	Snapshotter     string   `json:"snapshotter,omitempty"`
+	PullArgs        []string `json:"pull_args,omitempty"`
+	PullConcurrency int      `json:"pull_concurrency,omitempty"`
+	VerifyLayers    bool     `json:"verify_layers,omitempty"`
+	VerifyDigests   bool     `json:"verify_digests,omitempty"`
+
+	// UserCommands is set when hooks or a post-pull command run around the
+	// pulls, which "watch" cannot repeat
+	UserCommands bool `json:"user_commands,omitempty"`
+}
+
+// buildState returns the state the build VM of resources records in phase
+func (w *Workflow) buildState(resources *WorkflowResources, phase string) *buildState {
+	return &buildState{
+		BuildID:    w.buildID,
+		Phase:      phase,
+		UpdatedAt:  time.Now().UTC(),
+		OSType:     w.config.OSType,
+		SerialPort: w.config.SerialPort,
+		OutputType: w.config.OutputType,
+		CacheDisk:  resources.CacheDisk.Name,
+		Image:      w.imageConfig(resources),
+
+		Images:          w.images,
+		Arch:            w.config.Arch,
+		Snapshotter:     w.config.Snapshotter,
+		PullArgs:        w.config.PullArgs,
+		PullConcurrency: w.pullConcurrency,
+		VerifyLayers:    w.config.VerifyNoLayersMissing,
+		VerifyDigests:   w.config.VerifyLayerDigests,
+		UserCommands:    len(w.config.PrePullCommands)+len(w.config.PostPullCommands) > 0 || w.config.PostPullCommand != "",
+	}
+}
+
+func (s *buildState) encode() string {
+	data, _ := json.Marshal(s) // plain data
+	return string(data)
+}
+
+// decodeBuildState parses the build state a build VM records. A serial port
+// left out by older versions is port 1, the default.
+func decodeBuildState(data string) (*buildState, error) {
+	var state buildState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return nil, err
+	}
+	if state.Image == nil {
+		return nil, errors.New("no image recorded")
+	}
+	state.SerialPort = max(state.SerialPort, 1)
+	return &state, nil
+}
+
+// recordPhase updates the build state on the build VM. A build whose VM does
+// not record its last phase cannot be finished by "watch", but still runs.
+func (w *Workflow) recordPhase(ctx context.Context, resources *WorkflowResources, phase string) {
+	if w.buildID == "" || resources.VMInstance == nil {
+		return
+	}
+	w.stateMu.Lock()
+	defer w.stateMu.Unlock()
+	state := w.buildState(resources, phase)
+	if err := w.vmManager.SetMetadata(ctx, resources.VMInstance, buildStateKey, state.encode()); err != nil {
+		w.logger.Warnf("Build phase '%s' not recorded on the build VM, so 'watch --build-id %s' cannot finish the build: %v", phase, w.buildID, err)
+		return
+	}
+	w.state = state
+}
+
+// startHeartbeat records the build state on the build VM again every
+// heartbeatInterval until the returned function is called, so that "watch"
+// leaves the build alone while this invocation runs it
+func (w *Workflow) startHeartbeat(ctx context.Context, resources *WorkflowResources) (stop func()) {
+	if w.buildID == "" || resources.VMInstance == nil || w.state == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.heartbeat(ctx, resources)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// heartbeat records the last build state with the current time
+func (w *Workflow) heartbeat(ctx context.Context, resources *WorkflowResources) {
+	w.stateMu.Lock()
+	defer w.stateMu.Unlock()
+	state := *w.state
+	state.UpdatedAt = time.Now().UTC()
+	if err := w.vmManager.SetMetadata(ctx, resources.VMInstance, buildStateKey, state.encode()); err != nil {
+		w.logger.Debugf("Build state heartbeat not recorded: %v", err)
+		return
+	}
+	w.state = &state
+}
+
+// checkHeartbeat refuses to take over a build whose state was recorded less
+// than heartbeatTimeout before now, unless forced: the invocation running it
+// is likely still there
+func checkHeartbeat(state *buildState, now time.Time, force bool) error {
+	age := now.Sub(state.UpdatedAt)
+	if force || age >= heartbeatTimeout {
+		return nil
+	}
+	return fmt.Errorf("build %s recorded its state %s ago, so the invocation that started it is likely still running it; use --force if it is gone",
+		state.BuildID, age.Round(time.Second))
+}
+
+// WatchResult describes what watch did with the build VMs of a build
+type WatchResult struct {
+	BuildResult
+
+	// Abandoned are the build VMs whose resources were deleted without
+	// creating an image, with --abandon
+	Abandoned []string
+}
+
+// Watch re-attaches to the remote build with the given ID, started by an
+// invocation that is gone, e.g. on a laptop that lost its connection. For each
+// of the build's VMs it waits until the VM has filled the cache disk, then
+// detaches the disk, creates and verifies the image and deletes the temporary
+// resources, skipping what is already done. Each step is safe to repeat, so
+// Watch can be run again after it was interrupted itself.
+//
+// Windows build VMs pull by themselves, and are waited for. The pulls of a
+// Linux build VM run detached from the invocation that started them; they are
+// waited for and repeated over SSH, which only fetches what is missing. A
+// build whose invocation still records a heartbeat is left alone unless
+// force is set. With abandon, the resources are deleted without creating an
+// image.
+func (b *Builder) Watch(ctx context.Context, buildID string, abandon, force bool) (*WatchResult, error) {
+	ctx = retry.WithBudget(ctx, retry.NewBudget(b.config.RetryBudget))
+	if err := b.checkProject(ctx); err != nil {
+		return nil, err
+	}
+
+	instances, err := b.vmManager.FindInstances(ctx, b.config.Zone, buildIDLabel, buildID, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		where := "project " + b.config.ProjectName
+		if b.config.Zone != "" {
+			where = fmt.Sprintf("zone %s of %s", b.config.Zone, where)
+		}
+		return nil, fmt.Errorf("no build VM of build %s in %s: the build finished or was cleaned up, or runs in another zone or project", buildID, where)
+	}
+
+	result := &WatchResult{BuildResult: BuildResult{Project: b.config.ProjectName, Mode: config.ModeRemote}}
+	var errs []error
+	for _, instance := range instances {
+		state, err := b.loadBuildState(ctx, instance)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkHeartbeat(state, time.Now(), force); err != nil {
+			errs = append(errs, fmt.Errorf("build VM %s: %w", instance.Name, err))
+			continue
+		}
+		result.Zone = instance.Zone
+		w := b.resumeWorkflow(instance, state)
+		resources := &WorkflowResources{
+			VMInstance: instance,
+			CacheDisk:  &disk.Disk{Name: state.CacheDisk, SizeGB: state.Image.SourceDiskSizeGB},
+		}
+
+		if abandon {
+			w.cleanupResources(ctx, resources)
+			result.Abandoned = append(result.Abandoned, instance.Name)
+			continue
+		}
+		if err := w.resume(ctx, resources, state); err != nil {
+			errs = append(errs, fmt.Errorf("build VM %s: %w", instance.Name, err))
+			continue
+		}
+		if w.config.OutputsImage() {
+			result.Images = append(result.Images, state.Image.Name)
+		}
+		if resources.KeepCacheDisk {
+			result.Disks = append(result.Disks, resources.CacheDisk.SelfLink())
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// loadBuildState reads the build state a build VM records
+func (b *Builder) loadBuildState(ctx context.Context, instance *vm.Instance) (*buildState, error) {
+	data, err := b.vmManager.GetMetadata(ctx, instance, buildStateKey)
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return nil, fmt.Errorf("build VM %s records no build state (created by an older version?); delete it with: gcloud compute instances delete %s --zone=%s --project=%s",
+			instance.Name, instance.Name, instance.Zone, b.config.ProjectName)
+	}
+	state, err := decodeBuildState(data)
+	if err != nil {
+		return nil, fmt.Errorf("build VM %s records an unreadable build state: %w", instance.Name, err)
+	}
+
+	instance.OSType = state.OSType
+	instance.SerialPort = state.SerialPort
+	b.logger.Infof("Build VM %s in %s (%s): phase '%s' since %s", instance.Name, instance.Zone, instance.Status,
+		state.Phase, state.UpdatedAt.Local().Format(time.DateTime))
+	b.logger.Infof("Serial console: %s", instance.SerialConsoleCommand(b.config.ProjectName))
+	return state, nil
+}
+
+// resumeWorkflow returns a workflow for the remaining steps of the build the
+// VM recorded state of
+func (b *Builder) resumeWorkflow(instance *vm.Instance, state *buildState) *Workflow {
+	cfg := *b.config
+	cfg.Zone = instance.Zone
+	cfg.OSType = state.OSType
+	cfg.OutputType = state.OutputType
+	cfg.SerialPort = state.SerialPort
+	cfg.DiskImageName = state.Image.Name
+	if state.Arch != "" {
+		cfg.Arch = state.Arch
+	}
+	cfg.Snapshotter = state.Snapshotter
+	cfg.PullArgs = state.PullArgs
+	cfg.PullConcurrency = state.PullConcurrency
+	w := NewWorkflow(&cfg, b.logger, b.vmManager, b.diskManager, b.imageCache)
+	w.buildID = state.BuildID
+	w.images = state.Images
+	return w
+}
+
+// resume waits for the build VM to fill the cache disk, or fills it, then
+// captures it and cleans up like Execute does from step 5 on
+func (w *Workflow) resume(ctx context.Context, resources *WorkflowResources, state *buildState) error {
+	switch {
+	case state.Phase == phasePulled:
+	case w.config.IsWindows():
+		w.logger.Info("Waiting for the Windows build VM to pull the images...")
+		if err := w.waitForWindowsPulls(ctx, resources); err != nil {
+			w.cleanupResources(ctx, resources)
+			return err
+		}
+	case state.UserCommands || len(state.Images) == 0:
+		// Left alone, so that the build can be repeated by hand
+		return fmt.Errorf("the build runs commands around its pulls, or was started by an older version, so watch cannot finish its pulls; "+
+			"start the build again and delete this one's resources with 'watch --build-id %s --abandon'", state.BuildID)
+	default:
+		if err := w.finishPulls(ctx, resources, state); err != nil {
+			w.cleanupResources(ctx, resources)
+			return err
+		}
+	}
+	defer w.cleanupResources(ctx, resources)
+
+	// The disk may have been detached, and the image created, before the invocation was lost
+	cacheDisk, err := w.diskManager.GetDisk(ctx, state.CacheDisk, w.config.Zone)
+	if err != nil {
+		return err
+	}
+	resources.CacheDisk = cacheDisk
+	if cacheDisk.IsAttached() {
+		if err := w.detachCacheDisk(ctx, resources); err != nil {
+			return err
+		}
+	}
+	resources.CacheDiskDetached = true
+
+	if w.config.OutputsDisk() {
+		if err := w.diskManager.SetLabels(ctx, cacheDisk.Name, w.config.Zone, state.Image.Labels); err != nil {
+			return err
+		}
+	}
+	if w.config.OutputsImage() {
+		exists, err := w.diskManager.ImageExists(ctx, state.Image.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			w.logger.Infof("Image %s was already created", state.Image.Name)
+		} else if err := w.diskManager.CreateImage(ctx, state.Image); err != nil {
+			return fmt.Errorf("cache image creation failed: %w", err)
+		}
+		if err := w.diskManager.VerifyImage(ctx, state.Image, cacheDisk); err != nil {
+			return fmt.Errorf("cache image verification failed: %w", err)
+		}
+		w.logger.Infof("Cache image '%s' verified; provenance, smoke tests and lockfiles of the build are not produced when it is finished by watch", state.Image.Name)
+	}
+	if w.config.OutputsDisk() {
+		resources.KeepCacheDisk = true
+	}
+	return nil
+}
+
+// finishPulls takes over the pulls of a Linux build VM from the invocation
+// that started them: it connects with a key of its own, waits for the pulls
+// left running, pulls every image again, which only fetches what is missing,
+// and releases the cache disk like Execute does
+func (w *Workflow) finishPulls(ctx context.Context, resources *WorkflowResources, state *buildState) error {
+	w.state = state
+	defer w.startHeartbeat(ctx, resources)()
+
+	key, err := w.sshKey()
+	if err != nil {
+		return err
+	}
+	resources.SSHKey = key
+	expireOn := time.Now().Add(w.config.Timeout + sshKeyGracePeriod)
+	w.stateMu.Lock()
+	err = w.vmManager.SetMetadata(ctx, resources.VMInstance, "ssh-keys", key.MetadataEntry(ssh.DefaultUser, expireOn))
+	w.stateMu.Unlock()
+	if err != nil {
+		return err
+	}
+	client, err := w.connectSSH(ctx, resources.VMInstance, key)
+	if err != nil {
+		return err
+	}
+	resources.SSHClient = client
+	resources.Store = image.Store{Root: image.DefaultRoot}
+
+	w.logger.Info("Waiting for the pulls left running on the build VM...")
+	if output, err := image.RunAsRoot(ctx, client, waitForPullsScript, nil); err != nil {
+		return fmt.Errorf("failed to wait for the pulls on the build VM: %w: %s", err, strings.TrimSpace(output))
+	}
+	if err := w.pullImages(ctx, resources); err != nil {
+		return fmt.Errorf("image processing failed: %w", err)
+	}
+	if state.VerifyLayers || state.VerifyDigests {
+		if err := w.imageCache.VerifyLayers(ctx, client, resources.Store, w.images, state.VerifyDigests, w.config.ParallelVerify); err != nil {
+			return fmt.Errorf("layer verification failed: %w", err)
+		}
+	}
+	if err := w.releaseCacheDisk(ctx, resources); err != nil {
+		return err
+	}
+	w.recordPhase(ctx, resources, phasePulled)
+	return nil
+}
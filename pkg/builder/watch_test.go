@@ -0,0 +1,87 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+)
+
+func TestCheckHeartbeat(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		age     time.Duration
+		force   bool
+		wantErr bool
+	}{
+		{name: "fresh", age: time.Minute, wantErr: true},
+		{name: "fresh forced", age: time.Minute, force: true},
+		{name: "just stale", age: heartbeatTimeout},
+		{name: "stale", age: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &buildState{BuildID: "b1", UpdatedAt: now.Add(-tt.age)}
+			err := checkHeartbeat(state, now, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkHeartbeat() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--force") {
+				t.Errorf("error %q does not mention --force", err)
+			}
+		})
+	}
+}
+
+func TestDecodeBuildState(t *testing.T) {
+	state := &buildState{
+		BuildID:    "b1",
+		Phase:      phasePulling,
+		OSType:     "linux",
+		SerialPort: 2,
+		CacheDisk:  "cache-disk",
+		Image:      &disk.ImageConfig{Name: "cache"},
+		Images:     []string{"docker.io/library/nginx@sha256:0123"},
+		PullArgs:   []string{"--all-platforms"},
+	}
+	decoded, err := decodeBuildState(state.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Decoding what was encoded gives the same state, however often it is repeated
+	if again, err := decodeBuildState(decoded.encode()); err != nil || again.encode() != state.encode() {
+		t.Errorf("round trip = %s, %v, want %s", again.encode(), err, state.encode())
+	}
+	if decoded.SerialPort != 2 {
+		t.Errorf("SerialPort = %d, want 2", decoded.SerialPort)
+	}
+}
+
+func TestDecodeBuildStateDefaultsSerialPort(t *testing.T) {
+	// Older versions left the serial port out
+	state, err := decodeBuildState(`{"build_id":"b1","phase":"pulled","image":{"name":"cache"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SerialPort != 1 {
+		t.Errorf("SerialPort = %d, want 1", state.SerialPort)
+	}
+
+	b := &Builder{config: config.NewConfig()}
+	w := b.resumeWorkflow(&vm.Instance{Name: "cache-builder-b1", Zone: "us-central1-a"}, state)
+	if w.config.SerialPort != state.SerialPort {
+		t.Errorf("resumed SerialPort = %d, want %d as loaded", w.config.SerialPort, state.SerialPort)
+	}
+}
+
+func TestDecodeBuildStateRejectsMissingImage(t *testing.T) {
+	for _, data := range []string{`{"build_id":"b1"}`, `not json`} {
+		if _, err := decodeBuildState(data); err == nil {
+			t.Errorf("decodeBuildState(%q) succeeded", data)
+		}
+	}
+}
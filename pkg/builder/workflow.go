@@ -2,16 +2,45 @@ package builder
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/0x00fafa/gke-image-cache-builder/internal/deadline"
 	"github.com/0x00fafa/gke-image-cache-builder/internal/disk"
 	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/trace"
 	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
 	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/ssh"
 )
 
+// sshKeyGracePeriod keeps the build VM's SSH key valid a little past the build timeout
+const sshKeyGracePeriod = 30 * time.Minute
+
+// vmImageLabel names the cache image a build VM is building
+const vmImageLabel = "cache-image"
+
+// buildVMImageLabel records the exact image the build VM booted from. Label
+// values cannot hold a path, so only the image name is stored; the project is
+// the --build-vm-image one or the default image's.
+const buildVMImageLabel = "build-vm-image"
+
+// snapshotterLabel records the containerd snapshotter a Linux cache image was laid down with
+const snapshotterLabel = "cache-snapshotter"
+
+// remoteSetupScript is where the setup script is uploaded on Linux build VMs
+const remoteSetupScript = "/tmp/gke-image-cache-builder/setup-and-verify.sh"
+
 // Workflow manages the step-by-step execution of image cache building
 type Workflow struct {
 	config      *config.Config
@@ -19,6 +48,62 @@ type Workflow struct {
 	vmManager   *vm.Manager
 	diskManager *disk.Manager
 	imageCache  *image.Cache
+	recorder    *buildRecorder  // optional
+	snapshot    *configSnapshot // optional; recorded in the cache image's labels
+	attestor    *attestor       // optional; records the provenance of the cache image
+	runID       string          // optional; labels the build VM's log in Cloud Logging
+	buildID     string          // optional; labels the build VM so "watch" can finish the build
+
+	// state is the build state last recorded on the build VM, which the
+	// heartbeat records again with a fresh time; stateMu also serializes the
+	// other updates of the build VM's metadata
+	stateMu sync.Mutex
+	state   *buildState
+
+	// images holds the exact references to pull, pinned to digests when a lockfile is involved
+	images   []string
+	lockfile *image.Lockfile
+
+	// bootImage is the concrete image the build VM boots from
+	bootImage string
+
+	// additionalScripts are the --additional-startup-script files, read once validated
+	additionalScripts []scripts.AdditionalScript
+
+	// machineType is the build VM's machine type, chosen from the images with --machine-type=auto
+	machineType string
+
+	// pullConcurrency is how many images are pulled at a time, 0 for all at
+	// once; chosen from this machine's resources in local mode
+	pullConcurrency int
+
+	// maxDownloads is how many layers each pull downloads at a time, 0 for
+	// ctr's default; chosen from this machine's resources in local mode
+	maxDownloads int
+
+	// pullImage pulls an image into the cache: the image cache's PullAndCache
+	pullImage func(ctx context.Context, runner image.Runner, ref string, opts image.PullOptions) (image.PullStats, error)
+
+	// containerdVersion is the containerd release on the build VM; recorded as a label on Linux caches
+	containerdVersion string
+
+	// imageSetHash identifies the resolved image set; stored as a label on the cache image
+	imageSetHash string
+
+	// pinnedImages are the images resolved to the digests imageSetHash was computed from
+	pinnedImages []string
+
+	// credentialProviders maps the kubelet image credential providers the
+	// nodes need to the cached images they cover; recorded on the cache image
+	credentialProviders map[string][]string
+
+	// resultImage is the image a successful Execute created, or the existing
+	// image it found with --skip-if-exists
+	resultImage string
+
+	// resultDisk is the self-link of the cache disk a successful Execute kept
+	// as an output with --output-type disk-only or both
+	resultDisk string
 }
 
 // NewWorkflow creates a new workflow instance
@@ -29,49 +114,201 @@ func NewWorkflow(cfg *config.Config, logger *log.Logger, vmMgr *vm.Manager, disk
 		vmManager:   vmMgr,
 		diskManager: diskMgr,
 		imageCache:  imgCache,
+		images:      cfg.ContainerImages,
+		machineType: cfg.MachineType,
+
+		pullConcurrency: cfg.PullConcurrency,
+		pullImage:       imgCache.PullAndCache,
 	}
 }
 
 // Execute runs the complete workflow
-func (w *Workflow) Execute(ctx context.Context) error {
+func (w *Workflow) Execute(ctx context.Context) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "workflow", "image", w.config.DiskImageName)
+	defer func() { span.End(err) }()
+
 	// Step 1: Validate prerequisites
 	if err := w.validatePrerequisites(ctx); err != nil {
 		return fmt.Errorf("prerequisite validation failed: %w", err)
 	}
 
+	// Skip the build if a cache of exactly this image set already exists
+	if w.config.SkipIfExists {
+		labels := map[string]string{image.ImageSetHashLabel: w.imageSetHash}
+		if !w.config.IsWindows() {
+			// A cache laid down with another snapshotter is not usable by the same nodes
+			labels[snapshotterLabel] = w.config.Snapshotter
+		}
+		if w.config.ContainerdVersion != "" {
+			// Only a pinned release is known before the build VM is set up
+			labels[containerdVersionLabel] = containerdLabelValue(w.config.ContainerdVersion)
+		}
+		existing, err := w.diskManager.FindImageByLabels(ctx, w.config.DiskFamilyName, labels)
+		if err != nil {
+			return err
+		}
+		if existing != "" {
+			w.logger.Successf("Image cache is up to date: %s (%s=%s), skipping build", existing, image.ImageSetHashLabel, w.imageSetHash)
+			w.resultImage = existing
+			return nil
+		}
+		w.logger.Infof("No image in family %s matches %s=%s, building", w.config.DiskFamilyName, image.ImageSetHashLabel, w.imageSetHash)
+	}
+
 	// Step 2: Setup execution environment
 	resources, err := w.setupEnvironment(ctx)
+	if resources != nil {
+		// Partially created resources are cleaned up too
+		defer w.cleanupResources(ctx, resources)
+	}
 	if err != nil {
 		return fmt.Errorf("environment setup failed: %w", err)
 	}
-	defer w.cleanupResources(ctx, resources)
+	// Tells "watch" that this invocation is still running the build
+	defer w.startHeartbeat(ctx, resources)()
+	// The build VM's SSH key expires with the build, whose deadline "control extend" moves
+	if controller := deadline.FromContext(ctx); controller != nil && resources.SSHKey != nil {
+		defer controller.OnExtend(func(buildDeadline time.Time) { w.refreshSSHKey(ctx, resources, buildDeadline) })()
+	}
 
 	// Step 3: Setup VM if in remote mode
 	if w.config.IsRemoteMode() && resources.VMInstance != nil {
+		if w.config.ConnectivityCheck {
+			hosts := append([]string{"storage.googleapis.com"}, w.registryHosts()...)
+			if err := w.vmManager.RunConnectivityTests(ctx, resources.VMInstance, hosts); err != nil {
+				return fmt.Errorf("VM setup failed: %w", err)
+			}
+		}
 		if err := w.vmManager.SetupVM(ctx, resources.VMInstance); err != nil {
 			return fmt.Errorf("VM setup failed: %w", err)
 		}
+		if resources.SSHKey != nil {
+			client, err := w.connectSSH(ctx, resources.VMInstance, resources.SSHKey)
+			if err != nil {
+				return fmt.Errorf("VM setup failed: %w", err)
+			}
+			resources.SSHClient = client
+
+			if err := w.runSetupScript(ctx, client, resources.CacheDisk.Name); err != nil {
+				return fmt.Errorf("VM setup failed: %w", err)
+			}
+			if err := w.checkContainerdVersion(ctx, client); err != nil {
+				return fmt.Errorf("VM setup failed: %w", err)
+			}
+		}
 	}
 
-	// Step 4: Process container images
+	// Step 4: Process container images, between the user's hooks
+	if err := w.runHooks(ctx, resources, "pre-pull", w.config.PrePullCommands); err != nil {
+		return err
+	}
 	if err := w.processContainerImages(ctx, resources); err != nil {
 		return fmt.Errorf("image processing failed: %w", err)
 	}
+	if err := w.runHooks(ctx, resources, "post-pull", w.config.PostPullCommands); err != nil {
+		return err
+	}
+
+	// Step 4a: Prepare the cache with the user's command
+	if w.config.PostPullCommand != "" {
+		if err := w.runPostPullCommand(ctx, resources); err != nil {
+			return err
+		}
+	}
 
-	// Step 5: Create cache disk image
-	if err := w.createCacheImage(ctx, resources); err != nil {
-		return fmt.Errorf("cache image creation failed: %w", err)
+	// Step 4b: Check the content store before it is frozen into an image
+	if w.config.VerifyNoLayersMissing || w.config.VerifyLayerDigests {
+		verifyCtx, span := trace.Start(ctx, trace.CategoryPhase, "verify layers")
+		err := w.imageCache.VerifyLayers(verifyCtx, w.runner(resources), resources.Store, w.images, w.config.VerifyLayerDigests, w.config.ParallelVerify)
+		span.End(err)
+		if err != nil {
+			return fmt.Errorf("layer verification failed: %w", err)
+		}
 	}
 
-	// Step 6: Verify cache image
-	if err := w.verifyCacheImage(ctx); err != nil {
-		return fmt.Errorf("cache image verification failed: %w", err)
+	// A strict build does not publish an image after a warning
+	if err := checkWarnings(w.config, w.logger); err != nil {
+		return err
 	}
 
+	// The disk is captured or kept as containerd left it, unmounted
+	if err := w.releaseCacheDisk(ctx, resources); err != nil {
+		return err
+	}
+
+	// From here on "watch" can finish the build if this invocation is lost
+	w.recordPhase(ctx, resources, phasePulled)
+
+	// Step 4c: Label a cache disk kept as an output like its image
+	if w.config.OutputsDisk() {
+		if err := w.diskManager.SetLabels(ctx, resources.CacheDisk.Name, w.config.Zone, w.imageLabels()); err != nil {
+			return err
+		}
+	}
+
+	if w.config.OutputsImage() {
+		// Step 5: Create cache disk image
+		if err := w.createCacheImage(ctx, resources); err != nil {
+			return fmt.Errorf("cache image creation failed: %w", err)
+		}
+
+		// Step 6: Verify cache image, detaching a force-created image's disk meanwhile
+		detached := w.detachAfterForceCreate(ctx, resources)
+		if err := w.verifyCacheImage(ctx, resources); err != nil {
+			if detached != nil {
+				<-detached
+			}
+			return fmt.Errorf("cache image verification failed: %w", err)
+		}
+		if detached != nil {
+			if err := <-detached; err != nil {
+				return fmt.Errorf("failed to detach cache disk after creating the image: %w", err)
+			}
+		}
+
+		// Step 6b: Check that a node resolves every image from the image,
+		// before the image is attested or handed to node pools
+		if w.config.SmokeTest {
+			if err := w.smokeTestImage(ctx); err != nil {
+				return err
+			}
+		}
+
+		// A strict build does not attest an image after a warning, nor keep it
+		if err := checkWarnings(w.config, w.logger); err != nil {
+			return errors.Join(err, discardImages(ctx, w.config, w.diskManager, w.recorder, []string{w.config.DiskImageName}))
+		}
+
+		// Step 6c: Record how the image was built
+		if err := w.recordProvenance(ctx); err != nil {
+			return fmt.Errorf("provenance attestation failed: %w", err)
+		}
+	}
+
+	// Step 7: Record the exact digests that were cached
+	if w.config.WriteLockfile != "" {
+		if err := w.lockfile.Write(w.config.WriteLockfile); err != nil {
+			return err
+		}
+		w.logger.Infof("Wrote image lockfile: %s", w.config.WriteLockfile)
+	}
+
+	if w.config.OutputsImage() {
+		w.resultImage = w.config.DiskImageName
+	}
+	if w.config.OutputsDisk() {
+		// From now on the disk is the build's output, not a temporary resource
+		resources.KeepCacheDisk = true
+		w.resultDisk = resources.CacheDisk.SelfLink()
+		w.recorder.addDisk(w.resultDisk)
+	}
 	return nil
 }
 
-func (w *Workflow) validatePrerequisites(ctx context.Context) error {
+func (w *Workflow) validatePrerequisites(ctx context.Context) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "validate prerequisites")
+	defer func() { span.End(err) }()
+
 	w.logger.Info("Validating prerequisites...")
 
 	// Validate GCP permissions
@@ -79,8 +316,98 @@ func (w *Workflow) validatePrerequisites(ctx context.Context) error {
 		return fmt.Errorf("GCP permissions validation failed: %w", err)
 	}
 
+	// Disks a crashed local run left attached to this VM come first
+	if w.config.IsLocalMode() {
+		instance, err := gcp.QueryMetadata("instance/name")
+		if err != nil {
+			return fmt.Errorf("failed to get this VM's name: %w", err)
+		}
+		if err := w.recoverAttachments(ctx, instance, w.config.AutoRecover); err != nil {
+			return err
+		}
+	}
+
+	// Pin the build VM to a concrete image; a custom one may live in a project the credentials cannot read
+	if w.config.IsRemoteMode() {
+		source := w.config.BuildVMImage
+		if source == "" {
+			source = vm.DefaultBootImage(w.config.OSType, w.config.Arch)
+		}
+		bootImage, err := w.vmManager.ResolveBootImage(ctx, source, w.config.BuildVMImageVersion, w.config.Arch)
+		if err != nil {
+			return err
+		}
+		w.bootImage = bootImage
+
+		// Read now so the scripts cannot change between validation and VM creation
+		additional, err := loadAdditionalScripts(w.config.AdditionalStartupScripts)
+		if err != nil {
+			return err
+		}
+		w.additionalScripts = additional
+	}
+
+	// A cache declared for one node image but laid down on another OS family is suspect
+	w.checkNodeImageType()
+
+	// A family mixing architectures hands node pools caches their nodes cannot use
+	if err := w.checkFamilyArchitecture(ctx); err != nil {
+		return err
+	}
+
+	// Image licenses are only checked by the API when the image is created, after the pull
+	if err := w.diskManager.ValidateLicenses(ctx, w.config.ImageLicenses); err != nil {
+		return err
+	}
+
+	// Resolve the exact image set (lockfile or pinned digests)
+	if err := w.resolveImageSet(ctx); err != nil {
+		return err
+	}
+
+	// Only security-reviewed image versions may be cached
+	if w.config.ApprovedDigests != "" {
+		if err := w.checkApprovedDigests(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Identify the image set by content so unchanged caches can be detected
+	if err := w.computeImageSetHash(ctx); err != nil {
+		if w.config.SkipIfExists {
+			return fmt.Errorf("cannot check for an existing cache: %w", err)
+		}
+		if w.attestor != nil {
+			return fmt.Errorf("cannot record the provenance of the cache: %w", err)
+		}
+		w.logger.Warnf("Cache image will not be labeled with %s: %v", image.ImageSetHashLabel, err)
+	}
+
+	// Reject image lists that cannot run on a single node OS
+	if err := w.validatePlatforms(ctx); err != nil {
+		return err
+	}
+
+	// Nodes verifying cached images need the credential providers of private registries
+	w.checkCredentialProviders()
+
+	// The labels the builder adds count against the resources' limit too
+	if err := w.checkLabelCounts(); err != nil {
+		return err
+	}
+
+	// Size the build VM now that the exact images are known
+	if w.config.IsRemoteMode() && w.config.MachineType == config.MachineTypeAuto {
+		w.machineType = w.selectMachineType(ctx)
+	}
+
+	// Unpacking too much at once runs a small local machine out of memory
+	if w.config.IsLocalMode() && !w.config.IsWindows() {
+		w.checkHostLimits(ctx)
+	}
+
 	// Validate container image accessibility
-	for _, img := range w.config.ContainerImages {
+	for _, img := range w.images {
 		if err := w.imageCache.ValidateImageAccess(ctx, img); err != nil {
 			return fmt.Errorf("image access validation failed for %s: %w", img, err)
 		}
@@ -90,66 +417,558 @@ func (w *Workflow) validatePrerequisites(ctx context.Context) error {
 	return nil
 }
 
-func (w *Workflow) setupEnvironment(ctx context.Context) (*WorkflowResources, error) {
+// resolveImageSet pins the images to pull to exact digests when a lockfile is read or written.
+// A lockfile given with --lockfile is authoritative: its digests are pulled regardless of where
+// the tags currently point, and the build fails if any digest is no longer available.
+func (w *Workflow) resolveImageSet(ctx context.Context) error {
+	if w.config.Lockfile != "" {
+		lock, err := image.LoadLockfile(w.config.Lockfile)
+		if err != nil {
+			return err
+		}
+
+		if len(w.config.ContainerImages) > 0 {
+			w.logger.Warnf("Ignoring %d container images from command line/config file: lockfile %s is authoritative",
+				len(w.config.ContainerImages), w.config.Lockfile)
+		}
+
+		if len(lock.Images) > w.config.MaxImages {
+			return fmt.Errorf("lockfile %s lists %d images, more than max-images of %d (use --max-images or 'advanced.max_images' in config file)",
+				w.config.Lockfile, len(lock.Images), w.config.MaxImages)
+		}
+
+		images := make([]string, 0, len(lock.Images))
+		for _, name := range lock.ImageNames() {
+			pinned, err := lock.PinnedReference(name)
+			if err != nil {
+				return fmt.Errorf("invalid lockfile entry %s: %w", name, err)
+			}
+			if err := w.imageCache.VerifyDigestAvailable(ctx, pinned); err != nil {
+				return fmt.Errorf("lockfile image %s: %w", name, err)
+			}
+			images = append(images, pinned)
+		}
+
+		w.logger.Infof("Using %d images pinned by lockfile %s", len(images), w.config.Lockfile)
+		w.images = images
+		w.lockfile = lock
+		return nil
+	}
+
+	if w.config.WriteLockfile != "" {
+		// Pin pulls to the digests resolved now so the lockfile matches exactly what gets cached
+		lock := image.NewLockfile()
+		images := make([]string, 0, len(w.config.ContainerImages))
+		for _, img := range w.config.ContainerImages {
+			digest, err := w.imageCache.ResolveDigest(ctx, img)
+			if err != nil {
+				return err
+			}
+			ref, err := image.ParseReference(img)
+			if err != nil {
+				return err
+			}
+			lock.Images[img] = digest
+			images = append(images, ref.WithDigest(digest))
+		}
+
+		w.images = images
+		w.lockfile = lock
+	}
+
+	return nil
+}
+
+// computeImageSetHash hashes the image set after resolving every tag to its current digest
+func (w *Workflow) computeImageSetHash(ctx context.Context) error {
+	pinned := make([]string, 0, len(w.images))
+	for _, img := range w.images {
+		ref, err := image.ParseReference(img)
+		if err != nil {
+			return err
+		}
+		if ref.Digest == "" {
+			digest, err := w.imageCache.ResolveDigest(ctx, img)
+			if err != nil {
+				return err
+			}
+			img = ref.WithDigest(digest)
+		}
+		pinned = append(pinned, img)
+	}
+
+	hash, err := image.ImageSetHash(pinned)
+	if err != nil {
+		return err
+	}
+
+	w.imageSetHash = hash
+	w.pinnedImages = pinned
+	w.logger.Debugf("Image set hash: %s", hash)
+	return nil
+}
+
+// registryHosts returns the unique registry API hosts the image set is pulled from
+func (w *Workflow) registryHosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, img := range w.images {
+		ref, err := image.ParseReference(img)
+		if err != nil {
+			continue
+		}
+		if host := ref.APIHost(); !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (w *Workflow) setupEnvironment(ctx context.Context) (_ *WorkflowResources, err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "set up environment")
+	defer func() { span.End(err) }()
+
 	w.logger.Info("Setting up execution environment...")
 
 	resources := &WorkflowResources{}
 
+	// Create cache disk
+	diskConfig := &disk.Config{
+		Name:         fmt.Sprintf("%s-disk", w.config.DiskImageName),
+		Zone:         w.config.Zone,
+		SizeGB:       w.config.DiskSizeGB,
+		Type:         w.config.DiskType,
+		Labels:       maps.Clone(w.config.DiskLabels),
+		Architecture: disk.Architecture(w.config.Arch),
+	}
+
+	cacheDisk, err := w.diskManager.CreateDisk(ctx, diskConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache disk: %w", err)
+	}
+	resources.CacheDisk = cacheDisk
+	w.logger.Infof("Created cache disk: %s (%d GB %s)", cacheDisk.Name, cacheDisk.SizeGB, cacheDisk.Type)
+
+	if w.config.IsLocalMode() {
+		// The images are pulled straight onto the cache disk
+		if err := w.mountLocalCacheDisk(ctx, resources); err != nil {
+			return resources, err
+		}
+	}
+
 	if w.config.IsRemoteMode() {
-		// Create temporary VM
+		// Mounted as containerd's root by the setup script
+		resources.Store = image.Store{Root: image.DefaultRoot}
+
+		// Create temporary VM with the cache disk attached
 		vmConfig := &vm.Config{
 			Name:           fmt.Sprintf("cache-builder-%s", w.config.JobName),
 			Zone:           w.config.Zone,
-			MachineType:    w.config.MachineType,
+			MachineType:    w.machineType,
 			Network:        w.config.Network,
 			Subnet:         w.config.Subnet,
 			ServiceAccount: w.config.ServiceAccount,
 			Preemptible:    w.config.Preemptible,
+			OSType:         w.config.OSType,
+			Arch:           w.config.Arch,
+			BootImage:      w.bootImage,
+			NoExternalIP:   w.config.NoExternalIP,
+			ShieldedVM:     w.config.ShieldedVM,
+			DataDisks:      []string{cacheDisk.Name},
+			Labels:         w.vmLabels(),
+			SerialPort:     w.config.SerialPort,
+			Metadata: map[string]string{
+				// Probed by the setup script before any image is pulled
+				"registries": strings.Join(w.registryHosts(), " "),
+			},
+		}
+		if w.config.IsWindows() {
+			// Pulled by the Windows startup script
+			vmConfig.Metadata["images"] = strings.Join(w.images, " ")
+			vmConfig.Metadata["pull-args"] = strings.Join(w.config.PullArgs, " ")
+		} else {
+			key, err := w.sshKey()
+			if err != nil {
+				return resources, err
+			}
+			resources.SSHKey = key
+			// Valid until the build's deadline; refreshed when it is extended
+			expireOn := time.Now().Add(w.config.TotalTimeout() + sshKeyGracePeriod)
+			vmConfig.Metadata["ssh-keys"] = key.MetadataEntry(ssh.DefaultUser, expireOn)
+			if w.runID != "" {
+				// Read by the bootstrap script, which sets up the Ops Agent
+				vmConfig.Metadata["cloud-logging-run-id"] = w.runID
+			}
+			vmConfig.AdditionalScripts = w.additionalScripts
+			vmConfig.AdditionalScriptsAfter = w.config.AdditionalStartupScriptsOrder == config.StartupScriptsAfter
+		}
+		if w.buildID != "" {
+			w.state = w.buildState(resources, phasePulling)
+			vmConfig.Metadata[buildStateKey] = w.state.encode()
 		}
 
 		vmInstance, err := w.vmManager.CreateVM(ctx, vmConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create VM: %w", err)
+			return resources, fmt.Errorf("failed to create VM: %w", err)
 		}
 		resources.VMInstance = vmInstance
-		w.logger.Infof("Created temporary VM: %s", vmInstance.Name)
+		w.recorder.addVM(vmInstance, w.config.ProjectName)
+		w.logger.Infof("Created temporary VM: %s (ID %d, %s)", vmInstance.Name, vmInstance.ID, vmInstance.MachineType)
+		w.logger.Debugf("Serial console: %s", vmInstance.SerialConsoleCommand(w.config.ProjectName))
 	}
 
-	// Create cache disk
-	diskConfig := &disk.Config{
-		Name:   fmt.Sprintf("%s-disk", w.config.DiskImageName),
-		Zone:   w.config.Zone,
-		SizeGB: w.config.DiskSizeGB,
-		Type:   w.config.DiskType,
+	w.logger.Info("Environment setup completed")
+	return resources, nil
+}
+
+// vmLabels returns the disk labels overridden by the VM labels, plus the image
+// being built, so billing export can attribute the build VM's cost
+func (w *Workflow) vmLabels() map[string]string {
+	labels := make(map[string]string, len(w.config.DiskLabels)+len(w.config.VMLabels)+1)
+	for k, v := range w.config.DiskLabels {
+		labels[k] = v
 	}
+	for k, v := range w.config.VMLabels {
+		labels[k] = v
+	}
+	labels[vmImageLabel] = w.config.DiskImageName
+	if w.buildID != "" {
+		labels[buildIDLabel] = w.buildID
+	}
+	return labels
+}
 
-	cacheDisk, err := w.diskManager.CreateDisk(ctx, diskConfig)
+// sshKey returns the user-provided key, or generates an ephemeral one for this build
+func (w *Workflow) sshKey() (*ssh.KeyPair, error) {
+	if w.config.SSHKeyFile != "" {
+		return ssh.LoadKeyPair(w.config.SSHKeyFile)
+	}
+
+	key, err := ssh.GenerateKeyPair()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cache disk: %w", err)
+		return nil, err
 	}
-	resources.CacheDisk = cacheDisk
-	w.logger.Infof("Created cache disk: %s", cacheDisk.Name)
+	w.logger.Debugf("Generated ephemeral SSH key %s", key.PrivateKeyPath)
+	return key, nil
+}
 
-	w.logger.Info("Environment setup completed")
-	return resources, nil
+// refreshSSHKey moves the expiry of the build VM's SSH key past the build's
+// new deadline
+func (w *Workflow) refreshSSHKey(ctx context.Context, resources *WorkflowResources, buildDeadline time.Time) {
+	w.stateMu.Lock()
+	defer w.stateMu.Unlock()
+	expireOn := buildDeadline.Add(sshKeyGracePeriod)
+	if err := w.vmManager.SetMetadata(ctx, resources.VMInstance, "ssh-keys", resources.SSHKey.MetadataEntry(ssh.DefaultUser, expireOn)); err != nil {
+		w.logger.Warnf("The build VM's SSH key still expires before the extended deadline: %v", err)
+		return
+	}
+	w.logger.Debugf("The build VM's SSH key now expires at %s", expireOn.Local().Format(time.TimeOnly))
+}
+
+// connectSSH waits for the VM's SSH server and connects with its pinned host keys,
+// through the proxy jump if one is configured
+func (w *Workflow) connectSSH(ctx context.Context, instance *vm.Instance, key *ssh.KeyPair) (_ *ssh.Client, err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "connect SSH", "instance", instance.Name)
+	defer func() { span.End(err) }()
+
+	hostKeys, err := w.hostKeyVerifier(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	jump, err := w.proxyJump()
+	if err != nil {
+		return nil, err
+	}
+	host, err := w.sshHost(ctx, instance, key, jump)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.WaitForSSHReady(ctx, ssh.Address(host), ssh.DefaultUser, key, hostKeys, jump, w.config.Tuning.SSHReadyTimeout)
+}
+
+// sshHost picks the build VM address to SSH to and prints how to connect manually.
+// In auto mode the internal IP is used when this machine shares the VM's VPC network.
+func (w *Workflow) sshHost(ctx context.Context, instance *vm.Instance, key *ssh.KeyPair, jump *ssh.ProxyJump) (string, error) {
+	internal := w.config.SSHAddressType == config.SSHAddressInternal
+	reason := "--ssh-address-type"
+	switch {
+	case jump != nil:
+		// The bastion reaches the VM on its VPC address
+		internal, reason = true, "through bastion"
+	case w.config.NoExternalIP:
+		internal, reason = true, "--no-external-ip"
+	case w.config.SSHAddressType == config.SSHAddressAuto:
+		shared, err := w.vmManager.SharesNetworkWithController(ctx, instance)
+		if err != nil {
+			w.logger.Warnf("Cannot tell whether this machine shares the build VM's network, using its external IP: %v", err)
+		}
+		internal = shared
+		reason = "this machine is outside the build VM's network"
+		if shared {
+			reason = "this machine is in the build VM's network"
+		}
+	}
+
+	host, kind := instance.ExternalIP, "external"
+	if internal {
+		host, kind = instance.InternalIP, "internal"
+	}
+	if host == "" {
+		return "", fmt.Errorf("build VM %s has no %s IP address to connect to over SSH", instance.Name, kind)
+	}
+
+	w.logger.Infof("Using the build VM's %s IP %s for SSH (%s)", kind, host, reason)
+	hint := fmt.Sprintf("ssh -i %s", key.PrivateKeyPath)
+	if jump != nil {
+		hint += fmt.Sprintf(" -J %s@%s", jump.User, jump.Addr)
+	}
+	w.logger.Infof("Connect manually while the build runs: %s %s@%s", hint, ssh.DefaultUser, host)
+	return host, nil
+}
+
+// proxyJump returns the bastion SSH is tunnelled through, or nil to connect directly.
+// The bastion's host key is trusted on first use and pinned in known_hosts.
+func (w *Workflow) proxyJump() (*ssh.ProxyJump, error) {
+	if w.config.SSHProxyJump == "" {
+		return nil, nil
+	}
+
+	user, addr, err := config.ParseProxyJump(w.config.SSHProxyJump)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile := w.config.SSHProxyJumpKeyFile
+	if keyFile == "" {
+		keyFile = w.config.SSHKeyFile
+	}
+	key, err := ssh.LoadKeyPair(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys := ssh.InsecureIgnoreHostKey()
+	if !w.config.SSHInsecure {
+		knownHosts, err := ssh.DefaultKnownHostsPath()
+		if err != nil {
+			return nil, err
+		}
+		if hostKeys, err = ssh.TrustOnFirstUse(knownHosts, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	w.logger.Infof("Connecting to the build VM through bastion %s@%s", user, addr)
+	return &ssh.ProxyJump{User: user, Addr: addr, Key: key, HostKeys: hostKeys}, nil
+}
+
+// hostKeyVerifier pins the host keys the VM published through guest attributes,
+// falling back to trust-on-first-use when none are available
+func (w *Workflow) hostKeyVerifier(ctx context.Context, instance *vm.Instance) (*ssh.HostKeyVerifier, error) {
+	if w.config.SSHInsecure {
+		w.logger.Warn("SSH host key verification disabled (--ssh-insecure)")
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	keys, err := w.vmManager.GetHostKeys(ctx, instance)
+	if err != nil {
+		w.logger.Warnf("Cannot read published SSH host keys: %v", err)
+	}
+	if len(keys) > 0 {
+		w.logger.Debugf("Verifying SSH host key against %d published keys", len(keys))
+		return ssh.FixedHostKeys(keys)
+	}
+
+	knownHosts, err := ssh.DefaultKnownHostsPath()
+	if err != nil {
+		return nil, err
+	}
+	w.logger.Warnf("VM %s published no SSH host keys, trusting on first use (%s)", instance.Name, knownHosts)
+
+	// Instance IDs are never reused, unlike names and ephemeral IPs
+	return ssh.TrustOnFirstUse(knownHosts, fmt.Sprintf("%s.%d", instance.Name, instance.ID))
+}
+
+// runSetupScript uploads the setup script to the build VM and runs it as root,
+// mounting the cache disk (attached as device cacheDevice) as containerd's root.
+// Uploading avoids the metadata size limit on startup scripts.
+func (w *Workflow) runSetupScript(ctx context.Context, client *ssh.Client, cacheDevice string) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "run setup script")
+	defer func() { span.End(err) }()
+
+	w.logger.Info("Uploading setup script to build VM...")
+	err = client.UploadContent(ctx, []byte(scripts.GetSetupScript()), remoteSetupScript, ssh.TransferOptions{Mode: 0755})
+	if err != nil {
+		return err
+	}
+
+	// The output is captured and logged, so it must not carry color codes
+	env := "NO_COLOR=1 SNAPSHOTTER=" + w.config.Snapshotter + " CACHE_DEVICE=" + cacheDevice
+	if w.config.ContainerdVersion != "" {
+		env += " CONTAINERD_VERSION=" + w.config.ContainerdVersion
+	}
+	if w.runID != "" {
+		env += " BUILD_LOG=" + buildLogPath
+	}
+
+	w.logger.Info("Running setup script on build VM...")
+	output, err := client.Run(ctx, "sudo "+env+" bash "+remoteSetupScript)
+	if err != nil {
+		w.logger.Debugf("Setup script output:\n%s", output)
+		if reported := scriptErrors(output); reported != "" {
+			return fmt.Errorf("setup script failed: %s: %w", reported, err)
+		}
+		return fmt.Errorf("setup script failed: %w", err)
+	}
+	return nil
+}
+
+// runPostPullCommand runs --post-pull-command as root where the images were
+// pulled, logging its output; a non-zero exit fails the build. The command
+// gets the pulled images in CACHE_IMAGES (space separated) and ctr's namespace
+// in CONTAINERD_NAMESPACE.
+func (w *Workflow) runPostPullCommand(ctx context.Context, resources *WorkflowResources) error {
+	w.logger.Info("Running post-pull command...")
+	if err := w.runUserScript(ctx, resources, "post-pull", w.config.PostPullCommand); err != nil {
+		return fmt.Errorf("post-pull command failed: %w", err)
+	}
+	w.logger.Info("Post-pull command completed")
+	return nil
+}
+
+// runHooks runs the pre- or post-pull hooks of the config file in order; the
+// first one to fail fails the build, named as <stage>[<number>]
+func (w *Workflow) runHooks(ctx context.Context, resources *WorkflowResources, stage string, hooks []string) error {
+	for i, script := range hooks {
+		name := fmt.Sprintf("%s[%d]", stage, i+1)
+		w.logger.Infof("Running %s hook %d of %d...", stage, i+1, len(hooks))
+		if err := w.runUserScript(ctx, resources, name, script); err != nil {
+			return fmt.Errorf("%s hook failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runUserScript runs a user's bash script as root where the images are pulled,
+// logging its output line by line under tag
+func (w *Workflow) runUserScript(ctx context.Context, resources *WorkflowResources, tag, script string) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "script "+tag)
+	defer func() { span.End(err) }()
+
+	env := []string{
+		"CONTAINERD_NAMESPACE=k8s.io",
+		"CACHE_IMAGES=" + strings.Join(w.images, " "),
+	}
+	if w.config.IsLocalMode() {
+		env = append(env, gcp.ProxyEnv()...)
+	}
+	env = append(env, resources.Store.Env()...)
+
+	output, err := image.RunAsRoot(ctx, w.runner(resources), script, env)
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if line != "" {
+			w.logger.Infof("  %s: %s", tag, line)
+		}
+	}
+	return err
+}
+
+// scriptErrors returns the messages the setup script logged as errors, such as a failed download
+func scriptErrors(output string) string {
+	var messages []string
+	for _, line := range strings.Split(output, "\n") {
+		if i := strings.Index(line, "[ERROR]"); i >= 0 {
+			message := strings.TrimSpace(line[i+len("[ERROR]"):])
+			// Drop the timestamp and any ANSI reset ("\x1b[0m 2024-01-02 15:04:05 - ")
+			if j := strings.Index(message, " - "); j >= 0 {
+				message = message[j+len(" - "):]
+			}
+			if message != "Script failed. Performing cleanup..." {
+				messages = append(messages, message)
+			}
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// runner returns where images are pulled: the build VM over SSH, or this machine in local mode
+func (w *Workflow) runner(resources *WorkflowResources) image.Runner {
+	if resources.SSHClient != nil {
+		return resources.SSHClient
+	}
+	return image.LocalRunner{}
+}
+
+func (w *Workflow) processContainerImages(ctx context.Context, resources *WorkflowResources) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "pull images", "images", strconv.Itoa(len(w.images)))
+	defer func() { span.End(err) }()
+
+	w.logger.Infof("Processing %d container images...", len(w.images))
+
+	if w.config.PullTimeout == 0 {
+		return w.pullImages(ctx, resources)
+	}
+
+	// The pulls get their own budget: the time they take does not count against --timeout
+	if controller := deadline.FromContext(ctx); controller != nil {
+		defer controller.Hold(w.config.PullTimeout)()
+	}
+	pullCtx, cancel := deadline.WithTimeout(ctx, w.config.PullTimeout)
+	defer cancel()
+	err = w.pullImages(pullCtx, resources)
+	if err != nil && ctx.Err() == nil && pullCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("pulling images exceeded --pull-timeout of %s: %w", w.config.PullTimeout, err)
+	}
+	return err
 }
 
-func (w *Workflow) processContainerImages(ctx context.Context, resources *WorkflowResources) error {
-	w.logger.Infof("Processing %d container images...", len(w.config.ContainerImages))
+func (w *Workflow) pullImages(ctx context.Context, resources *WorkflowResources) error {
+	if w.config.IsWindows() {
+		return w.waitForWindowsPulls(ctx, resources)
+	}
+
+	runner := w.runner(resources)
+	opts := image.PullOptions{Platform: w.config.Platform(), Snapshotter: w.config.Snapshotter, Args: w.config.PullArgs, MaxDownloads: w.maxDownloads}
+	if w.config.IsLocalMode() {
+		// ctr pulls on this machine, through the same proxy as the tool
+		opts.Env = gcp.ProxyEnv()
+	}
+	opts.Env = append(opts.Env, resources.Store.Env()...)
+	// A pull on the build VM finishes even if this invocation is lost, for "watch" to take over
+	opts.Detach = resources.SSHClient != nil
 
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(w.config.ContainerImages))
+	errChan := make(chan error, len(w.images))
+	stats := make([]image.PullStats, len(w.images))
+	slots := make(chan struct{}, len(w.images))
+	if w.pullConcurrency > 0 {
+		slots = make(chan struct{}, w.pullConcurrency)
+	}
 
 	// Process images in parallel for better performance
-	for i, img := range w.config.ContainerImages {
+	for i, img := range w.images {
 		wg.Add(1)
 		go func(index int, image string) {
 			defer wg.Done()
-			w.logger.Progressf(index+1, len(w.config.ContainerImages), "Processing %s", image)
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				errChan <- fmt.Errorf("failed to process image %s: %w", image, ctx.Err())
+				return
+			}
+			w.logger.Progressf(index+1, len(w.images), "Processing %s", image)
 
-			if err := w.imageCache.PullAndCache(ctx, image, resources.CacheDisk); err != nil {
+			pullCtx, span := trace.Start(ctx, trace.CategoryImage, "pull "+image, "image", image)
+			pulled, err := w.pullImage(pullCtx, runner, image, opts)
+			span.End(err)
+			if err != nil {
 				errChan <- fmt.Errorf("failed to process image %s: %w", image, err)
+				return
 			}
+			stats[index] = pulled
 		}(i, img)
 	}
 
@@ -164,33 +983,202 @@ func (w *Workflow) processContainerImages(ctx context.Context, resources *Workfl
 	}
 
 	w.logger.Info("All container images processed successfully")
+	w.reportPullThroughput(stats)
+	return nil
+}
+
+// waitForWindowsPulls waits for the Windows startup script, which pulls the windows/amd64
+// variant of every image itself since Windows build VMs are driven through metadata only
+func (w *Workflow) waitForWindowsPulls(ctx context.Context, resources *WorkflowResources) error {
+	// Bounded by the build's or the pull phase's deadline only, which can be extended
+	status, err := w.vmManager.WaitForStatus(ctx, resources.VMInstance, "pull", 0)
+	if err != nil {
+		return err
+	}
+	if status != "done" {
+		attrs, err := w.vmManager.GetStatus(ctx, resources.VMInstance)
+		if err != nil {
+			return fmt.Errorf("failed to pull an image on Windows build VM, and to read which: %w (check serial port %d output)", err, w.config.SerialPort)
+		}
+		failed := attrs["pull-failed"]
+		if failed == "" {
+			failed = "an image"
+		}
+		return fmt.Errorf("failed to pull %s on Windows build VM (check serial port %d output)", failed, w.config.SerialPort)
+	}
+
+	w.logger.Info("All container images processed successfully")
+	return nil
+}
+
+// validatePlatforms rejects image lists mixing Linux-only and Windows-only images,
+// and images without a variant for the configured node OS
+func (w *Workflow) validatePlatforms(ctx context.Context) error {
+	var linuxOnly, windowsOnly []string
+
+	for _, img := range w.images {
+		systems, err := w.imageCache.PlatformOS(ctx, img)
+		if err != nil {
+			w.logger.Warnf("Cannot determine platforms of %s, skipping OS check: %v", img, err)
+			continue
+		}
+
+		hasLinux, hasWindows := false, false
+		for _, system := range systems {
+			switch system {
+			case config.OSLinux:
+				hasLinux = true
+			case config.OSWindows:
+				hasWindows = true
+			}
+		}
+
+		switch {
+		case hasLinux && !hasWindows:
+			linuxOnly = append(linuxOnly, img)
+		case hasWindows && !hasLinux:
+			windowsOnly = append(windowsOnly, img)
+		}
+	}
+
+	if len(linuxOnly) > 0 && len(windowsOnly) > 0 {
+		return fmt.Errorf("mixed Linux/Windows image list: %s only support linux but %s only support windows; build them separately",
+			strings.Join(linuxOnly, ", "), strings.Join(windowsOnly, ", "))
+	}
+	if w.config.IsWindows() && len(linuxOnly) > 0 {
+		return fmt.Errorf("images without a windows variant cannot be cached with --os-type=windows: %s", strings.Join(linuxOnly, ", "))
+	}
+	if !w.config.IsWindows() && len(windowsOnly) > 0 {
+		return fmt.Errorf("images that only support windows require --os-type=windows: %s", strings.Join(windowsOnly, ", "))
+	}
+
 	return nil
 }
 
-func (w *Workflow) createCacheImage(ctx context.Context, resources *WorkflowResources) error {
+func (w *Workflow) createCacheImage(ctx context.Context, resources *WorkflowResources) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "create image", "image", w.config.DiskImageName)
+	defer func() { span.End(err) }()
+
 	w.logger.Info("Creating cache disk image...")
 
-	imageConfig := &disk.ImageConfig{
-		Name:        w.config.DiskImageName,
-		SourceDisk:  resources.CacheDisk.Name,
-		Zone:        w.config.Zone,
-		Family:      w.config.DiskFamilyName,
-		Labels:      w.config.DiskLabels,
-		Description: fmt.Sprintf("Image cache containing %d container images", len(w.config.ContainerImages)),
+	// Remote builds capture the disk attached to the build VM
+	if resources.VMInstance != nil && !resources.CacheDiskDetached {
+		if w.config.ImageForceCreate {
+			if err := w.flushCacheDisk(ctx, resources); err != nil {
+				return err
+			}
+		} else if err := w.detachCacheDisk(ctx, resources); err != nil {
+			return err
+		}
 	}
 
+	imageConfig := w.imageConfig(resources)
+	imageConfig.ForceCreate = resources.VMInstance != nil && !resources.CacheDiskDetached
 	if err := w.diskManager.CreateImage(ctx, imageConfig); err != nil {
 		return fmt.Errorf("failed to create cache image: %w", err)
 	}
 
+	w.recorder.addImage(w.config.DiskImageName)
 	w.logger.Infof("Cache image '%s' created successfully", w.config.DiskImageName)
 	return nil
 }
 
-func (w *Workflow) verifyCacheImage(ctx context.Context) error {
+// imageConfig describes the cache image, both for creating and for verifying it
+func (w *Workflow) imageConfig(resources *WorkflowResources) *disk.ImageConfig {
+	return &disk.ImageConfig{
+		Name:            w.config.DiskImageName,
+		SourceDisk:      resources.CacheDisk.Name,
+		Zone:            w.config.Zone,
+		Family:          w.config.DiskFamilyName,
+		Labels:          w.imageLabels(),
+		Licenses:        w.imageLicenses(),
+		Description:     fmt.Sprintf("Image cache containing %d container images", len(w.images)),
+		GuestOSFeatures: disk.GuestOSFeatures(w.config.OSType),
+		Architecture:    disk.Architecture(w.config.Arch),
+
+		SourceDiskSizeGB: resources.CacheDisk.SizeGB,
+	}
+}
+
+// imageLicenses returns the configured image licenses in canonical form
+func (w *Workflow) imageLicenses() []string {
+	licenses := make([]string, 0, len(w.config.ImageLicenses))
+	for _, license := range w.config.ImageLicenses {
+		if path, err := gcp.ParseLicensePath(license); err == nil { // validated with the configuration
+			licenses = append(licenses, path.String())
+		}
+	}
+	return licenses
+}
+
+// imageLabels returns the configured disk labels and family aliases plus the image set hash, if known,
+// the configuration snapshot, the platform, the snapshotter and containerd version of Linux caches
+// and the credential providers the images need
+func (w *Workflow) imageLabels() map[string]string {
+	labels := make(map[string]string, len(w.config.DiskLabels)+4)
+	for k, v := range w.config.DiskLabels {
+		labels[k] = v
+	}
+	for k, v := range w.config.FamilyAliasLabels() {
+		labels[k] = v
+	}
+	for k, v := range w.snapshot.labels() {
+		labels[k] = v
+	}
+	if w.imageSetHash != "" {
+		labels[image.ImageSetHashLabel] = w.imageSetHash
+	}
+	if !w.config.IsWindows() {
+		labels[snapshotterLabel] = w.config.Snapshotter
+	}
+	labels[platformLabel] = platformLabelValue(w.config.Platform())
+	if w.config.NodeImageType != "" {
+		labels[nodeImageTypeLabel] = strings.ToLower(w.config.NodeImageType)
+	}
+	if w.containerdVersion != "" {
+		labels[containerdVersionLabel] = containerdLabelValue(w.containerdVersion)
+	}
+	if providers := w.credentialProvidersLabelValue(); providers != "" {
+		labels[credentialProvidersLabel] = providers
+	}
+	if path, err := gcp.ParseImagePath(w.bootImage); err == nil { // empty in local mode
+		labels[buildVMImageLabel] = path.Name
+	}
+	return labels
+}
+
+// checkLabelCounts fails if the build VM or the cache image would carry more
+// labels than Compute Engine allows, counting the labels set on the image
+// once the build VM is up or the provenance is stored
+func (w *Workflow) checkLabelCounts() error {
+	if w.config.IsRemoteMode() {
+		if err := gcp.CheckLabelCount("the build VM", w.vmLabels()); err != nil {
+			return fmt.Errorf("%w: drop some --disk-labels or --vm-labels", err)
+		}
+	}
+	if !w.config.OutputsImage() {
+		return nil
+	}
+	labels := w.imageLabels()
+	if !w.config.IsWindows() && w.containerdVersion == "" {
+		labels[containerdVersionLabel] = ""
+	}
+	if w.attestor != nil {
+		maps.Copy(labels, w.attestor.labels())
+	}
+	if err := gcp.CheckLabelCount("the cache image", labels); err != nil {
+		return fmt.Errorf("%w: drop some --disk-labels or --family-alias", err)
+	}
+	return nil
+}
+
+func (w *Workflow) verifyCacheImage(ctx context.Context, resources *WorkflowResources) (err error) {
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "verify image", "image", w.config.DiskImageName)
+	defer func() { span.End(err) }()
+
 	w.logger.Info("Verifying cache image...")
 
-	if err := w.diskManager.VerifyImage(ctx, w.config.DiskImageName); err != nil {
+	if err := w.diskManager.VerifyImage(ctx, w.imageConfig(resources), resources.CacheDisk); err != nil {
 		return fmt.Errorf("cache image verification failed: %w", err)
 	}
 
@@ -198,30 +1186,123 @@ func (w *Workflow) verifyCacheImage(ctx context.Context) error {
 	return nil
 }
 
+// cleanupResources deletes the temporary resources of a build. It gets its own
+// deadline, CleanupTimeout, so that it runs on after the build's context is
+// cancelled but cannot hang forever; whatever it could not delete is listed
+// with the commands to delete it.
 func (w *Workflow) cleanupResources(ctx context.Context, resources *WorkflowResources) {
 	w.logger.Info("Cleaning up temporary resources...")
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.config.CleanupTimeout)
+	defer cancel()
+	// Deletions are retried even once the build spent its retry budget
+	ctx = retry.WithBudget(ctx, nil)
+	ctx, span := trace.Start(ctx, trace.CategoryPhase, "clean up")
+	var leaked []string
+	defer func() {
+		if len(leaked) > 0 {
+			span.End(fmt.Errorf("cleanup left %d resources behind: %s", len(leaked), strings.Join(leaked, "; ")))
+			return
+		}
+		span.End(nil)
+	}()
+
+	if resources.SSHClient != nil {
+		if w.runID != "" {
+			w.flushCloudLogging(ctx, resources.SSHClient)
+		}
+		resources.SSHClient.Close()
+	}
+
+	// A disk still attached to this machine cannot be deleted
+	if resources.Attachment != nil {
+		if err := w.releaseCacheDisk(ctx, resources); err != nil {
+			w.logger.Warnf("Failed to release cache disk %s: %v", resources.CacheDisk.Name, err)
+		}
+	}
 
 	if resources.VMInstance != nil {
 		if err := w.vmManager.DeleteVM(ctx, resources.VMInstance.Name, w.config.Zone); err != nil {
 			w.logger.Warnf("Failed to cleanup VM %s: %v", resources.VMInstance.Name, err)
+			leaked = append(leaked, fmt.Sprintf("gcloud compute instances delete %s --zone=%s --project=%s",
+				resources.VMInstance.Name, w.config.Zone, w.config.ProjectName))
+			if resources.VMInstance.BootDisk != "" {
+				w.logger.Warnf("Boot disk %s of VM %s may be left behind too", resources.VMInstance.BootDisk, resources.VMInstance.Name)
+			}
 		} else {
+			w.recorder.vmDeleted(resources.VMInstance.Name)
 			w.logger.Infof("Cleaned up VM: %s", resources.VMInstance.Name)
+			if w.cleanupBootDisk(ctx, resources.VMInstance) {
+				leaked = append(leaked, fmt.Sprintf("gcloud compute disks delete %s --zone=%s --project=%s",
+					resources.VMInstance.BootDisk, w.config.Zone, w.config.ProjectName))
+			}
 		}
 	}
 
-	if resources.CacheDisk != nil {
+	if resources.CacheDisk != nil && resources.KeepCacheDisk {
+		w.logger.Infof("Kept disk as build output: %s", resources.CacheDisk.Name)
+	} else if resources.CacheDisk != nil {
 		if err := w.diskManager.DeleteDisk(ctx, resources.CacheDisk.Name, w.config.Zone); err != nil {
 			w.logger.Warnf("Failed to cleanup disk %s: %v", resources.CacheDisk.Name, err)
+			leaked = append(leaked, fmt.Sprintf("gcloud compute disks delete %s --zone=%s --project=%s",
+				resources.CacheDisk.Name, w.config.Zone, w.config.ProjectName))
 		} else {
 			w.logger.Infof("Cleaned up disk: %s", resources.CacheDisk.Name)
 		}
 	}
 
+	if resources.SSHKey != nil {
+		if err := resources.SSHKey.Cleanup(); err != nil {
+			w.logger.Warnf("Failed to remove SSH key %s: %v", resources.SSHKey.PrivateKeyPath, err)
+		}
+	}
+
+	if len(leaked) > 0 {
+		reason := ""
+		if ctx.Err() != nil {
+			reason = fmt.Sprintf(" (gave up after --cleanup-timeout of %s)", w.config.CleanupTimeout)
+		}
+		w.logger.Warnf("Cleanup left %d resources behind%s; delete them with:\n  %s", len(leaked), reason, strings.Join(leaked, "\n  "))
+		w.recorder.addLeaked(leaked)
+		return
+	}
 	w.logger.Info("Resource cleanup completed")
 }
 
+// cleanupBootDisk deletes the boot disk of a deleted VM, which auto-delete
+// normally removed with it; only a leftover disk is logged. It reports
+// whether the disk could not be deleted.
+func (w *Workflow) cleanupBootDisk(ctx context.Context, instance *vm.Instance) (leaked bool) {
+	if instance.BootDisk == "" {
+		return false
+	}
+	existed, err := w.diskManager.DeleteDiskIfExists(ctx, instance.BootDisk, w.config.Zone)
+	switch {
+	case err != nil:
+		w.logger.Warnf("Failed to cleanup boot disk %s left behind by VM %s: %v", instance.BootDisk, instance.Name, err)
+		return true
+	case existed:
+		w.logger.Infof("Cleaned up boot disk left behind by VM %s: %s", instance.Name, instance.BootDisk)
+	}
+	return false
+}
+
 // WorkflowResources holds references to temporary resources
 type WorkflowResources struct {
-	VMInstance *vm.Instance
-	CacheDisk  *disk.Disk
+	VMInstance  *vm.Instance
+	CacheDisk   *disk.Disk
+	CacheDevice string // device name CacheDisk is attached under, when it differs from the disk name
+	SSHKey      *ssh.KeyPair
+	SSHClient   *ssh.Client
+
+	// KeepCacheDisk marks CacheDisk as an output of the build, which cleanup leaves in place
+	KeepCacheDisk bool
+
+	// CacheDiskDetached is set once CacheDisk was detached from VMInstance
+	CacheDiskDetached bool
+
+	// Attachment records CacheDisk attached to this machine in local mode, until it is released
+	Attachment *AttachState
+
+	// Store is the containerd whose root CacheDisk is mounted as
+	Store image.Store
 }
@@ -0,0 +1,128 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/gcptest"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+func TestCheckLabelCounts(t *testing.T) {
+	labels := func(n int) map[string]string {
+		m := make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			m[fmt.Sprintf("label-%d", i)] = "x"
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		disk, vm int
+		attested bool
+		want     string // in the error, "" for none
+	}{
+		{name: "room to spare", disk: 40, vm: 10},
+		{name: "build VM", disk: 40, vm: 24, want: "the build VM would carry 65 labels"},
+		// Six labels of the builder's own push 59 disk labels over the limit
+		{name: "cache image", disk: 59, want: "the cache image would carry 65 labels"},
+		{name: "attestation reference", disk: 58, attested: true, want: "the cache image would carry 65 labels"},
+		{name: "attestation reference fits", disk: 57, attested: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.Mode = config.ModeRemote
+			cfg.DiskImageName = "cache"
+			cfg.NodeImageType = "COS_CONTAINERD"
+			cfg.DiskLabels = labels(tt.disk)
+			cfg.VMLabels = make(map[string]string, tt.vm)
+			for i := 0; i < tt.vm; i++ {
+				cfg.VMLabels[fmt.Sprintf("vm-label-%d", i)] = "x"
+			}
+			w := NewWorkflow(cfg, nil, nil, nil, nil)
+			w.imageSetHash = "0123abcd"
+			w.bootImage = "projects/ubuntu-os-cloud/global/images/ubuntu-2204-jammy-v20240101"
+			if tt.attested {
+				w.attestor = &attestor{bucket: "provenance"}
+			}
+
+			err := w.checkLabelCounts()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("checkLabelCounts() = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("checkLabelCounts() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestWaitForWindowsPullsFailure names the image a Windows build VM failed to
+// pull, or says why it cannot
+func TestWaitForWindowsPullsFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		readAgain http.HandlerFunc // the read after the pull status, nil to serve output again
+		want      string
+	}{
+		{
+			name:   "image reported",
+			output: "GKE-IMAGE-CACHE-STATUS pull-failed=nginx:1.25\nGKE-IMAGE-CACHE-STATUS pull=failed\n",
+			want:   "failed to pull nginx:1.25 on Windows build VM",
+		},
+		{
+			name:   "image not reported",
+			output: "GKE-IMAGE-CACHE-STATUS pull=failed\n",
+			want:   "failed to pull an image on Windows build VM",
+		},
+		{
+			name:      "status unreadable",
+			output:    "GKE-IMAGE-CACHE-STATUS pull=failed\n",
+			readAgain: gcptest.Fail(http.StatusServiceUnavailable, "backend error"),
+			want:      "and to read which: failed to read serial port output of builder",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gcptest.NewServer(t)
+			reads := 0
+			server.Handle(http.MethodGet, "projects/p/zones/z/instances/builder/serialPort", func(w http.ResponseWriter, r *http.Request) {
+				reads++
+				if reads > 1 && tt.readAgain != nil {
+					tt.readAgain(w, r)
+					return
+				}
+				gcptest.WriteJSON(w, map[string]any{"contents": tt.output, "next": fmt.Sprint(len(tt.output))})
+			})
+
+			client, err := gcp.NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger := log.NewLogger(false, true, log.NewTextImpl(io.Discard))
+			cfg := config.NewConfig()
+			cfg.ProjectName = "p"
+			cfg.Zone = "z"
+			w := NewWorkflow(cfg, logger, vm.NewManager(client, logger, vm.DefaultTuning()), nil, nil)
+
+			err = w.waitForWindowsPulls(context.Background(), &WorkflowResources{
+				VMInstance: &vm.Instance{Name: "builder", Zone: "z", OSType: vm.OSWindows},
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("waitForWindowsPulls() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
 )
 
 // ExecutionMode defines how the tool executes
@@ -13,37 +15,367 @@ const (
 	ModeRemote                    // Create temporary GCP VM
 )
 
+// Node operating systems a cache disk can be built for
+const (
+	OSLinux   = "linux"
+	OSWindows = "windows"
+)
+
+// CPU architectures a cache disk can be built for
+const (
+	ArchX86_64 = "x86_64"
+	ArchARM64  = "arm64"
+)
+
+// containerd snapshotters a Linux cache can be laid down with; the node must use the same one
+const (
+	SnapshotterOverlayfs = "overlayfs" // GKE's default
+	SnapshotterNative    = "native"
+	SnapshotterStargz    = "stargz"
+)
+
+// GKE node image types a Linux cache can be declared for
+const (
+	NodeImageCOS    = "COS"
+	NodeImageUbuntu = "UBUNTU"
+)
+
+// What --write-image-ref writes for each created image
+const (
+	ImageRefSelfLink = "self-link" // https://www.googleapis.com/compute/v1/projects/<p>/global/images/<name>
+	ImageRefName     = "name"
+)
+
+// What --node-pool-config writes
+const (
+	NodePoolConfigGcloud    = "gcloud"    // a gcloud container node-pools create command
+	NodePoolConfigTerraform = "terraform" // a google_container_node_pool resource
+)
+
+// What a build produces
+const (
+	OutputImage    = "image"     // the cache image; the cache disk is deleted
+	OutputDiskOnly = "disk-only" // the populated cache disk, kept; no image is created
+	OutputBoth     = "both"      // the cache image, and the cache disk kept
+)
+
+// Formats of the build log
+const (
+	LogFormatText = "text" // "timestamp [LEVEL] message"
+	LogFormatJSON = "json" // one JSON object per message
+)
+
+// When the build VM runs --additional-startup-script files
+const (
+	StartupScriptsBefore = "before" // before the bootstrap's own steps
+	StartupScriptsAfter  = "after"  // after them, before the VM reports ready
+)
+
+// How the builder picks the build VM address it connects to over SSH
+const (
+	SSHAddressAuto     = "auto"
+	SSHAddressInternal = "internal"
+	SSHAddressExternal = "external"
+)
+
+// Build VM machine types used when none is configured
+const (
+	defaultMachineType    = "e2-standard-2"
+	defaultArmMachineType = "t2a-standard-2"
+)
+
+// MachineTypeAuto sizes the build VM from the count and size of the images to cache
+const MachineTypeAuto = "auto"
+
 // Config holds all configuration for the image cache builder
 type Config struct {
 	// Execution mode
 	Mode ExecutionMode
 
+	// modeSource names what set Mode, e.g. "-R" or "'execution.mode' in build.yaml"
+	modeSource string
+
 	// Required fields
 	ProjectName     string
 	DiskImageName   string // 修改：从 CacheName 改为 DiskImageName
 	Zone            string
 	ContainerImages []string
 
+	// ExpectedProjectNumber fails the build unless ProjectName is the project
+	// of this number. AllowedProjects, only set by config files such as a
+	// shared policy file, lists the projects builds may target. Both catch a
+	// mistyped or copy-pasted ProjectName before anything is created in it.
+	ExpectedProjectNumber string
+	AllowedProjects       []string
+
+	// diskImageNameTemplate is DiskImageName as given when it has {date},
+	// {time}, {run-id} or {git-sha} tokens, which validation expands; nameTime
+	// is the time {date} and {time} stand for
+	diskImageNameTemplate string
+	nameTime              time.Time
+
+	// FromSpec is an ImageCacheSpec document whose images LoadSpec adds to
+	// ContainerImages; its name and labels are the defaults of DiskImageName
+	// and DiskLabels. specNotes records what it left out.
+	FromSpec  string
+	specNotes []string
+
+	// MaxImages is the most container images a build caches; ForbidMultipleTags
+	// fails validation when the list holds several tags of one repository
+	// instead of warning about them
+	MaxImages          int
+	ForbidMultipleTags bool
+
+	// duplicateImages are the repeats validation removed from ContainerImages;
+	// multipleTags the repositories listed with several tags
+	duplicateImages []string
+	multipleTags    []RepositoryTags
+
+	// Image lockfile support
+	Lockfile      string // Authoritative image->digest lockfile to pull from
+	WriteLockfile string // Path to write the resolved image->digest lockfile after a build
+	SkipIfExists  bool   // Skip the build if an image with the same image set hash exists in the family
+
+	// ApprovedDigests is a file of the image digests allowed to be cached; the
+	// build fails if any image resolves to a digest it does not list
+	ApprovedDigests string
+
+	// WriteImageRef is a file the created image's reference is written to after
+	// a successful build, in ImageRefFormat (ImageRefSelfLink or ImageRefName)
+	WriteImageRef  string
+	ImageRefFormat string
+
+	// NodePoolConfig is a file the configuration of a node pool attaching the
+	// created images as secondary boot disks is written to after a successful
+	// build, in NodePoolConfigFormat (NodePoolConfigGcloud or NodePoolConfigTerraform)
+	NodePoolConfig       string
+	NodePoolConfigFormat string
+
+	// OutputType is what a build produces: OutputImage, OutputDiskOnly or OutputBoth
+	OutputType string
+
+	// ImageForceCreate creates the image from the cache disk while it is still
+	// attached to the build VM, once its writes are flushed, and detaches the
+	// disk while the image is verified. By default it is detached first.
+	ImageForceCreate bool
+
+	// SmokeTest boots a small Container-Optimized OS VM with the created image
+	// attached read-only and checks that containerd resolves every image from
+	// it, within SmokeTestTimeout, which is added to the build's deadline
+	SmokeTest        bool
+	SmokeTestTimeout time.Duration
+
+	// CloudLogging ships the build VM's log to Cloud Logging through the Ops
+	// Agent, labeled with the build's run ID
+	CloudLogging bool
+
+	// AssumeNoStreaming acknowledges that the target nodes do not use GKE Image
+	// Streaming, silencing the warning that the cache may be redundant
+	AssumeNoStreaming bool
+
+	// StrictFamilyArch fails a build adding an image to a family that holds
+	// images of another architecture, instead of warning
+	StrictFamilyArch bool
+
 	// Optional fields with defaults
 	DiskFamilyName string            // 改为 DiskFamilyName
+	FamilyAliases  []string          // Secondary families, recorded as family-alias-<n> labels on the image
 	DiskLabels     map[string]string // 改为 DiskLabels
+	VMLabels       map[string]string // Build VM labels, merged over DiskLabels for cost attribution
+	ImageLicenses  []string          // License paths attached to the cache image (projects/<project>/global/licenses/<name>)
 	JobName        string
 	GCPOAuth       string
 	DiskSizeGB     int // 改为 DiskSizeGB
 	ImagePullAuth  string
 	Timeout        time.Duration
+	PullTimeout    time.Duration // Bounds the pull phase separately; 0 means it shares Timeout
 	Network        string
 	Subnet         string
 	ServiceAccount string
+	SSHKeyFile     string // Private key for the build VM; an ephemeral key is generated when empty
+	SSHInsecure    bool   // Skip SSH host key verification
+
+	// CredentialProviders maps registry patterns, in the matchImages syntax of
+	// the kubelet's CredentialProviderConfig, to the image credential provider
+	// the nodes run for them. The providers the cached images need are
+	// recorded on the image.
+	CredentialProviders map[string]string
+
+	// SSHProxyJump tunnels SSH through a bastion ("[user@]host[:port]") to the
+	// build VM's internal IP. The bastion key defaults to SSHKeyFile.
+	SSHProxyJump        string
+	SSHProxyJumpKeyFile string
+
+	// SSHAddressType selects the build VM's internal or external IP for SSH;
+	// auto uses the internal IP when this machine is in the VM's VPC network
+	SSHAddressType string
+
+	// ConnectivityCheck additionally runs Network Management connectivity
+	// tests from the build VM (remote mode only)
+	ConnectivityCheck bool
+
+	// NoExternalIP creates the build VM without an external IP, for projects
+	// where compute.vmExternalIpAccess forbids one
+	NoExternalIP bool
+
+	// ShieldedVM enables Secure Boot, vTPM and integrity monitoring on the
+	// build VM, for projects where compute.requireShieldedVm is enforced
+	ShieldedVM bool
 
 	// Advanced options
-	MachineType string
-	Preemptible bool
-	DiskType    string
+	MachineType  string
+	BuildVMImage string // Boot image of the build VM (projects/<project>/global/images/[family/]<name>), e.g. a hardened golden image
+	Preemptible  bool
+	DiskType     string
+	OSType       string   // Node OS the cache is built for: linux or windows (NTFS disk, windows/amd64 images)
+	Arch         string   // CPU architecture of the nodes: x86_64 or arm64 (Arm build VM, linux/arm64 images)
+	Snapshotter  string   // containerd snapshotter the images are unpacked with; must match the nodes'
+	Partitions   int      // Number of cache disks built in parallel, each producing its own image
+	PullArgs     []string // Extra arguments appended to every ctr image pull
+
+	// NodeImageType is the node image of the target node pools, NodeImageCOS
+	// or NodeImageUbuntu; empty if not declared
+	NodeImageType string
+
+	// COSVersion is the COS milestone or version of the target nodes;
+	// validation finds the images their node image already holds, from the
+	// built-in table or PreinstalledImagesFile, and preinstalledImages records them
+	COSVersion             string
+	PreinstalledImagesFile string
+	preinstalledImages     []string
+
+	// PostPullCommand is a bash script run as root on the build VM (this
+	// machine in local mode) after the images are pulled, before the image is created
+	PostPullCommand string
 
-	// Logging options (console only, no GCS)
+	// PrePullCommands and PostPullCommands are hooks from the config file:
+	// bash scripts run in order as root where PostPullCommand runs, before
+	// and after the images are pulled. They only run with AllowHooks, which
+	// is set on the command line, never by a config file.
+	PrePullCommands  []string
+	PostPullCommands []string
+	AllowHooks       bool
+
+	// fileScripts names the config file keys that set PostPullCommand or
+	// AdditionalStartupScripts; like the hooks, those only run with AllowHooks
+	fileScripts []string
+
+	// AdditionalStartupScripts are bash script files the Linux build VM's
+	// startup script runs, in order, StartupScriptsBefore or StartupScriptsAfter
+	// its own steps (AdditionalStartupScriptsOrder), e.g. org-mandated agents
+	AdditionalStartupScripts      []string
+	AdditionalStartupScriptsOrder string
+
+	// BuildVMImageVersion pins the build VM to this image of the BuildVMImage
+	// family, or of the default one, e.g. ubuntu-2204-jammy-v20240126
+	BuildVMImageVersion string
+
+	// VerifyNoLayersMissing checks every manifest, config and layer blob of the
+	// pulled images in the content store before the image is created;
+	// VerifyLayerDigests additionally rehashes each blob
+	VerifyNoLayersMissing bool
+	VerifyLayerDigests    bool
+
+	// ParallelVerify is how many images layer verification and verify-image
+	// check at a time, each over its own command on the VM (1: one after another)
+	ParallelVerify int
+
+	// PullConcurrency is how many images are pulled, and unpacked, at a time
+	// on Linux; 0 picks a limit from this machine's memory and vCPUs in local
+	// mode and pulls every image at once on the build VM in remote mode
+	PullConcurrency int
+
+	// ContainerdVersion pins the containerd release (official binaries)
+	// installed on the build VM instead of whatever the boot image ships;
+	// GKEVersion is the GKE version of the target nodes, used to warn when the
+	// build's containerd differs from theirs
+	ContainerdVersion string
+	GKEVersion        string
+
+	// IncludeGKESystemImages adds the system images of this GKE minor version
+	// (pause, kube-dns, metrics-server) to ContainerImages during validation,
+	// from the built-in table or SystemImagesManifest; systemImages records them
+	IncludeGKESystemImages string
+	SystemImagesManifest   string
+	systemImages           []string
+
+	// ConfigSnapshotBucket is a Cloud Storage bucket the effective configuration
+	// is uploaded to, so the image can be rebuilt with --reproduce-from
+	ConfigSnapshotBucket string
+
+	// AttestationBucket is a Cloud Storage bucket the SLSA provenance of each
+	// cache image is stored in, as a DSSE envelope referenced from the image's labels
+	AttestationBucket string
+
+	// AttestationKMSKey is the Cloud KMS key version that signs the provenance;
+	// AttestationKeyFile a local private key doing the same. Unsigned when both are empty.
+	AttestationKMSKey  string
+	AttestationKeyFile string
+
+	// AutoRecover unmounts, detaches and deletes the disks a crashed local-mode
+	// run left attached to this VM instead of refusing to start
+	AutoRecover bool
+
+	// AbortOnWarning fails the build once any warning was logged, so strict
+	// pipelines only publish clean builds
+	AbortOnWarning bool
+
+	// RetryBudget is the number of retried failures allowed across the whole
+	// build before it stops, so systemic problems fail fast
+	RetryBudget int
+
+	// MaxTimeoutExtension bounds the total time "control extend" can add to a
+	// running build's deadline; 0 disables extending
+	MaxTimeoutExtension time.Duration
+
+	// CleanupTimeout bounds deleting the temporary resources after a build,
+	// which runs on even when the build itself timed out or was cancelled
+	CleanupTimeout time.Duration
+
+	// Tuning holds the waits for the build VM and how often it is polled
+	Tuning vm.Tuning
+
+	// MinPullThroughput is the pull rate in MB/s below which a registry is
+	// reported as slow; 0 disables the warning
+	MinPullThroughput float64
+
+	// SerialPort is the build VM serial port (1-4) the setup script reports status
+	// to and the builder reads, so the log can be kept apart from boot noise on port 1
+	SerialPort int
+
+	// APIEndpoint replaces the Compute Engine API endpoint, e.g.
+	// https://compute.restricted.googleapis.com inside a VPC Service Controls perimeter
+	APIEndpoint string
+
+	// Logging options
 	Verbose bool
 	Quiet   bool
+
+	// LogFormat is how log messages are written: LogFormatText or LogFormatJSON
+	LogFormat string
+
+	// LogFile also appends the log, in LogFormat, to this file
+	LogFile string
+
+	// DebugHTTP logs the requests of the metadata, registry and token clients
+	// at trace level: method, URL without credentials, status and latency
+	DebugHTTP bool
+
+	// TraceFile receives a timeline of the build's phases, GCP operations
+	// and image pulls in the Trace Event Format, for chrome://tracing
+	TraceFile string
+
+	// NoColor turns off colored log output; it is also off when NO_COLOR is set
+	// or the output is not a terminal
+	NoColor bool
+
+	// Offline makes validation skip the checks that query the network, such as
+	// the metadata server lookups of local mode; skipped records which were skipped
+	Offline bool
+	skipped []string
+
+	// secretDir holds the files of secrets resolved from Secret Manager
+	secretDir string
 }
 
 // NewConfig creates a new configuration with defaults
@@ -54,14 +386,96 @@ func NewConfig() *Config {
 		JobName:        "image-cache-build",
 		DiskSizeGB:     10, // 改为 DiskSizeGB
 		ImagePullAuth:  "None",
+		Partitions:     1,
+		ParallelVerify: 1,
 		Timeout:        20 * time.Minute,
 		Network:        "default",
 		Subnet:         "default",
 		ServiceAccount: "default",
-		MachineType:    "e2-standard-2",
+		MachineType:    defaultMachineType,
 		DiskType:       "pd-standard",
+		OSType:         OSLinux,
+		Arch:           ArchX86_64,
+		Snapshotter:    SnapshotterOverlayfs,
+		SSHAddressType: SSHAddressAuto,
+		ImageRefFormat: ImageRefSelfLink,
+		LogFormat:      LogFormatText,
+		RetryBudget:    DefaultRetryBudget,
+		MaxImages:      DefaultMaxImages,
 		DiskLabels:     make(map[string]string), // 改为 DiskLabels
+		VMLabels:       make(map[string]string),
+
+		CredentialProviders: make(map[string]string),
+
+		NodePoolConfigFormat: NodePoolConfigGcloud,
+
+		MaxTimeoutExtension: DefaultMaxTimeoutExtension,
+		CleanupTimeout:      DefaultCleanupTimeout,
+		SmokeTestTimeout:    DefaultSmokeTestTimeout,
+		Tuning:              vm.DefaultTuning(),
+		SerialPort:          1,
+
+		AdditionalStartupScriptsOrder: StartupScriptsBefore,
+
+		OutputType: OutputImage,
+	}
+}
+
+// OutputsImage reports whether a build creates the cache image
+func (c *Config) OutputsImage() bool {
+	return c.OutputType != OutputDiskOnly
+}
+
+// OutputsDisk reports whether a build keeps the populated cache disk
+func (c *Config) OutputsDisk() bool {
+	return c.OutputType == OutputDiskOnly || c.OutputType == OutputBoth
+}
+
+// DefaultMaxTimeoutExtension is how much "control extend" can add to a build's deadline by default
+const DefaultMaxTimeoutExtension = 4 * time.Hour
+
+// DefaultCleanupTimeout is how long deleting a build's temporary resources may take by default
+const DefaultCleanupTimeout = 5 * time.Minute
+
+// MaxPreemptibleRunTime is how long Compute Engine runs a preemptible VM at most
+const MaxPreemptibleRunTime = 24 * time.Hour
+
+// DefaultSmokeTestTimeout is how long the smoke test of a created image may take by default
+const DefaultSmokeTestTimeout = 10 * time.Minute
+
+// DefaultRetryBudget is how many retried failures one build allows by default
+const DefaultRetryBudget = 100
+
+// TotalTimeout returns the longest the whole build may run before extensions.
+// With a pull timeout, Timeout covers everything except the pull phase, which
+// gets its own budget; a smoke test gets its own budget too.
+func (c *Config) TotalTimeout() time.Duration {
+	total := c.Timeout + c.PullTimeout
+	if c.SmokeTest {
+		total += c.SmokeTestTimeout
 	}
+	return total
+}
+
+// SetMode sets the execution mode and records source, the flag or file that
+// chose it, for error messages. A mode set this way is kept when a config
+// file is loaded afterwards.
+func (c *Config) SetMode(mode ExecutionMode, source string) {
+	c.Mode = mode
+	c.modeSource = source
+}
+
+// modeName describes the effective mode and where it was set, e.g.
+// "local mode (-L)" or "local mode ('execution.mode' in build.yaml)"
+func (c *Config) modeName() string {
+	name := "remote mode"
+	if c.IsLocalMode() {
+		name = "local mode"
+	}
+	if c.modeSource == "" {
+		return name
+	}
+	return name + " (" + c.modeSource + ")"
 }
 
 // IsLocalMode returns true if executing on current GCP VM
@@ -73,3 +487,25 @@ func (c *Config) IsLocalMode() bool {
 func (c *Config) IsRemoteMode() bool {
 	return c.Mode == ModeRemote
 }
+
+// IsARM64 returns true if the cache is built for Arm node pools
+func (c *Config) IsARM64() bool {
+	return c.Arch == ArchARM64
+}
+
+// Platform returns the containerd platform images are pulled for
+func (c *Config) Platform() string {
+	os, arch := OSLinux, "amd64"
+	if c.IsWindows() {
+		os = OSWindows
+	}
+	if c.IsARM64() {
+		arch = "arm64"
+	}
+	return os + "/" + arch
+}
+
+// IsWindows returns true if the cache is built for Windows Server node pools
+func (c *Config) IsWindows() bool {
+	return c.OSType == OSWindows
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/auth"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// GKECredentialProvider is the kubelet image credential provider GKE nodes run
+// for Container Registry and Artifact Registry
+const GKECredentialProvider = "auth-provider-gcp"
+
+// gkeCredentialProviderImages are the matchImages of GKE's credential provider
+var gkeCredentialProviderImages = []string{"container.cloud.google.com", "gcr.io", "*.gcr.io", "*.pkg.dev"}
+
+// credentialProviderNamePattern keeps provider names usable in an image label
+// value, joined with '_'. Provider names are executable names, conventionally
+// lowercase with dashes.
+var credentialProviderNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// validateCredentialProviders checks the patterns and provider names of CredentialProviders
+func (c *Config) validateCredentialProviders(problems *ValidationErrors) {
+	const field = "auth.credential_providers"
+	patterns := make([]string, 0, len(c.CredentialProviders))
+	for pattern := range c.CredentialProviders {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if err := validateMatchImage(pattern); err != nil {
+			problems.addf(field, "invalid credential provider pattern '%s': %w (use --credential-provider or '%s' in config file)", pattern, err, field)
+		}
+		if provider := c.CredentialProviders[pattern]; !credentialProviderNamePattern.MatchString(provider) {
+			problems.addf(field, "invalid credential provider '%s' for %s: the name of the provider's executable must contain at most 63 lowercase letters, digits or '-' (use --credential-provider or '%s' in config file)",
+				provider, pattern, field)
+		}
+	}
+}
+
+// validateMatchImage checks a pattern of the kubelet's matchImages syntax:
+// a host whose dot-separated parts may be globs, with an optional port and
+// repository path prefix, e.g. *.dkr.ecr.*.amazonaws.com or
+// registry.example.com:5000/team
+func validateMatchImage(pattern string) error {
+	if strings.Contains(pattern, "://") {
+		return fmt.Errorf("leave out the scheme")
+	}
+	host, _ := splitMatchImage(pattern)
+	hostname, _ := splitHostPort(host)
+	if hostname == "" {
+		return fmt.Errorf("no registry host")
+	}
+	for _, part := range strings.Split(hostname, ".") {
+		if part == "" {
+			return fmt.Errorf("empty host part")
+		}
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("host part '%s': %w", part, err)
+		}
+	}
+	return nil
+}
+
+// matchImage reports whether pattern matches an image, the way the kubelet
+// matches images against a provider's matchImages: each host part of the
+// pattern globs the part of the image's registry at the same position, the
+// ports are equal and the pattern's path is a prefix of the repository
+func matchImage(pattern string, ref *image.Reference) bool {
+	patternHost, patternPath := splitMatchImage(pattern)
+	patternName, patternPort := splitHostPort(patternHost)
+	registryName, registryPort := splitHostPort(ref.Registry)
+	if patternPort != registryPort {
+		return false
+	}
+
+	patternParts := strings.Split(patternName, ".")
+	registryParts := strings.Split(registryName, ".")
+	if len(patternParts) != len(registryParts) {
+		return false
+	}
+	for i := range patternParts {
+		if matched, err := path.Match(patternParts[i], registryParts[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return strings.HasPrefix(ref.Repository, patternPath)
+}
+
+// splitMatchImage splits a matchImages pattern into its host and path
+func splitMatchImage(pattern string) (host, repositoryPath string) {
+	host, repositoryPath, _ = strings.Cut(pattern, "/")
+	return host, repositoryPath
+}
+
+func splitHostPort(host string) (name, port string) {
+	if name, port, err := net.SplitHostPort(host); err == nil {
+		return name, port
+	}
+	return host, ""
+}
+
+// CredentialProvidersFor returns the kubelet image credential providers the
+// nodes need to verify a cached image without pulling it: those of
+// CredentialProviders whose pattern matches it, else GKE's provider when the
+// build pulls it with GCP credentials (image pull auth ServiceAccountToken).
+// Public images need none. The providers are sorted.
+func (c *Config) CredentialProvidersFor(img string) []string {
+	ref, err := image.ParseReference(img)
+	if err != nil {
+		return nil
+	}
+
+	var providers []string
+	for pattern, provider := range c.CredentialProviders {
+		if matchImage(pattern, ref) && !slices.Contains(providers, provider) {
+			providers = append(providers, provider)
+		}
+	}
+	if len(providers) == 0 && c.ImagePullAuth == "ServiceAccountToken" && auth.IsGCPRegistry(ref.Registry) {
+		for _, pattern := range gkeCredentialProviderImages {
+			if matchImage(pattern, ref) {
+				return []string{GKECredentialProvider}
+			}
+		}
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// UnusedCredentialProviders returns the patterns of CredentialProviders that
+// match none of images, sorted
+func (c *Config) UnusedCredentialProviders(images []string) []string {
+	var unused []string
+	for pattern := range c.CredentialProviders {
+		used := false
+		for _, img := range images {
+			if ref, err := image.ParseReference(img); err == nil && matchImage(pattern, ref) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, pattern)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
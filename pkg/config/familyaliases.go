@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FamilyAliasLabelPrefix starts the keys of the labels recording an image's
+// family aliases: family-alias-1, family-alias-2, ...
+const FamilyAliasLabelPrefix = "family-alias-"
+
+// maxFamilyAliases bounds the family aliases of an image, which share the
+// image's labels with the user's and the builder's own
+const maxFamilyAliases = 10
+
+// FamilyAliasLabels returns the labels recording FamilyAliases, numbered from 1
+// in the order given. An image has a single family; the aliases let it be
+// found by other groupings, e.g. by team and by app.
+func (c *Config) FamilyAliasLabels() map[string]string {
+	labels := make(map[string]string, len(c.FamilyAliases))
+	for i, alias := range c.FamilyAliases {
+		labels[FamilyAliasLabelPrefix+strconv.Itoa(i+1)] = alias
+	}
+	return labels
+}
+
+// FamilyAliasesFromLabels returns the family aliases an image's labels
+// record, in their numbered order
+func FamilyAliasesFromLabels(labels map[string]string) []string {
+	type numbered struct {
+		n     int
+		alias string
+	}
+	var found []numbered
+	for key, value := range labels {
+		suffix, ok := strings.CutPrefix(key, FamilyAliasLabelPrefix)
+		if n, err := strconv.Atoi(suffix); ok && err == nil {
+			found = append(found, numbered{n, value})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].n < found[j].n })
+
+	aliases := make([]string, len(found))
+	for i, f := range found {
+		aliases[i] = f.alias
+	}
+	return aliases
+}
+
+// validateFamilyAliases checks that the aliases are non-empty label values,
+// distinct from each other and from the family, and that the disk labels
+// leave their keys alone
+func (c *Config) validateFamilyAliases(problems *ValidationErrors) {
+	const field = "disk.family_aliases"
+	if len(c.FamilyAliases) > maxFamilyAliases {
+		problems.addf(field, "too many family aliases (%d), at most %d are allowed", len(c.FamilyAliases), maxFamilyAliases)
+	}
+	if len(c.DiskLabels)+len(c.FamilyAliases) > maxLabels {
+		problems.addf(field, "%d disk labels and %d family aliases exceed the %d labels an image can carry besides the builder's own",
+			len(c.DiskLabels), len(c.FamilyAliases), maxLabels)
+	}
+
+	seen := make(map[string]bool, len(c.FamilyAliases))
+	for i, alias := range c.FamilyAliases {
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		key := FamilyAliasLabelPrefix + strconv.Itoa(i+1)
+		switch err := validateLabel(key, alias); {
+		case alias == "":
+			problems.addf(itemField, "family alias must not be empty (use --family-alias or '%s' in config file)", field)
+		case err != nil:
+			problems.addf(itemField, "invalid family alias: %w (use --family-alias or '%s' in config file)", err, field)
+		case alias == c.DiskFamilyName:
+			problems.addf(itemField, "family alias '%s' is the image's family already", alias)
+		case seen[alias]:
+			problems.addf(itemField, "family alias '%s' is listed twice", alias)
+		}
+		seen[alias] = true
+	}
+
+	for key := range c.DiskLabels {
+		if strings.HasPrefix(key, FamilyAliasLabelPrefix) {
+			problems.addf("disk.labels."+key, "label keys starting with %s are reserved for family aliases; use --family-alias", FamilyAliasLabelPrefix)
+		}
+	}
+}
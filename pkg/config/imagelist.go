@@ -0,0 +1,84 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// DefaultMaxImages is the most container images a build caches unless configured
+const DefaultMaxImages = 100
+
+// RepositoryTags is a repository the image list holds several references of,
+// e.g. nginx:1.21 and nginx:1.25
+type RepositoryTags struct {
+	Repository string   // registry/repository
+	Images     []string // the references as listed
+}
+
+// checkImageList removes exact repeats from ContainerImages, comparing the
+// fully-qualified references so that nginx:1.25 and
+// docker.io/library/nginx:1.25 are one image, finds repositories listed with
+// several tags and enforces MaxImages. The list that remains is what the build
+// caches and what --show-config prints. Removing repeats is idempotent, so
+// validating twice keeps the repeats of the first validation.
+func (c *Config) checkImageList(problems *ValidationErrors) {
+	seen := make(map[string]bool, len(c.ContainerImages))
+	byRepository := make(map[string][]string)
+	var repositories []string
+	images := c.ContainerImages[:0:0]
+	for _, img := range c.ContainerImages {
+		ref, err := image.ParseReference(img)
+		if err != nil {
+			// Reported with the other problems of the image
+			images = append(images, img)
+			continue
+		}
+		if seen[ref.String()] {
+			c.duplicateImages = append(c.duplicateImages, img)
+			continue
+		}
+		seen[ref.String()] = true
+		images = append(images, img)
+
+		if byRepository[ref.Name()] == nil {
+			repositories = append(repositories, ref.Name())
+		}
+		byRepository[ref.Name()] = append(byRepository[ref.Name()], img)
+	}
+	c.ContainerImages = images
+
+	c.multipleTags = nil
+	for _, repository := range repositories {
+		if len(byRepository[repository]) > 1 {
+			c.multipleTags = append(c.multipleTags, RepositoryTags{Repository: repository, Images: byRepository[repository]})
+		}
+	}
+	if c.ForbidMultipleTags && len(c.multipleTags) > 0 {
+		var lists []string
+		for _, tags := range c.multipleTags {
+			lists = append(lists, strings.Join(tags.Images, ", "))
+		}
+		problems.addf("images", "images list several tags of the same repository, each cached in full: %s; keep one tag of each or drop --forbid-multiple-tags ('advanced.forbid_multiple_tags' in config file)",
+			strings.Join(lists, "; "))
+	}
+
+	switch {
+	case c.MaxImages < 1:
+		problems.addf("advanced.max_images", "max-images must be at least 1 (use --max-images or 'advanced.max_images' in config file)")
+	case len(c.ContainerImages) > c.MaxImages:
+		problems.addf("advanced.max_images", "%d container images exceed max-images of %d: split them into several caches, or raise the limit if the list is intended (use --max-images or 'advanced.max_images' in config file)",
+			len(c.ContainerImages), c.MaxImages)
+	}
+}
+
+// DuplicateImages returns the repeats validation removed from ContainerImages
+func (c *Config) DuplicateImages() []string {
+	return c.duplicateImages
+}
+
+// MultipleTags returns the repositories the last validation found listed with
+// several tags, in the order of ContainerImages
+func (c *Config) MultipleTags() []RepositoryTags {
+	return c.multipleTags
+}
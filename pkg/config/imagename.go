@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// nameTokenPattern matches a {token} in DiskImageName
+var nameTokenPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// runIDVariables hold the CI run ID {run-id} expands to, in the order they are
+// tried: GitHub Actions, GitLab CI, Cloud Build and Jenkins
+var runIDVariables = []string{"GITHUB_RUN_ID", "CI_PIPELINE_ID", "BUILD_ID", "BUILD_NUMBER"}
+
+// gitSHAVariables hold the commit {git-sha} expands to before git is asked
+var gitSHAVariables = []string{"GITHUB_SHA", "CI_COMMIT_SHA", "COMMIT_SHA", "GIT_COMMIT"}
+
+// shortSHALength is the number of hex digits {git-sha} expands to
+const shortSHALength = 7
+
+// expandDiskImageName expands the {tokens} of DiskImageName and checks the
+// result against the Compute image naming rules. The name as given is kept,
+// so validating twice expands it to the same name.
+func (c *Config) expandDiskImageName(problems *ValidationErrors) {
+	if c.diskImageNameTemplate == "" {
+		if !strings.ContainsAny(c.DiskImageName, "{}") {
+			return
+		}
+		c.diskImageNameTemplate = c.DiskImageName
+		c.nameTime = time.Now().UTC()
+	}
+
+	var failed []string
+	name := nameTokenPattern.ReplaceAllStringFunc(c.diskImageNameTemplate, func(token string) string {
+		value, err := c.nameToken(strings.Trim(token, "{}"))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", token, err))
+			return token
+		}
+		return value
+	})
+	c.DiskImageName = name
+	if len(failed) > 0 {
+		problems.addf("disk.name", "cannot expand disk-image-name '%s': %s (use --disk-image-name or 'disk.name' in config file)",
+			c.diskImageNameTemplate, strings.Join(failed, "; "))
+		return
+	}
+	if !gcp.IsImageName(name) {
+		problems.addf("disk.name", "disk-image-name '%s' expands to '%s', which is not a valid image name: it must start with a lowercase letter, contain only lowercase letters, digits and hyphens, not end with a hyphen, and be at most 63 characters (use --disk-image-name or 'disk.name' in config file)",
+			c.diskImageNameTemplate, name)
+	}
+}
+
+// nameToken returns the value of a DiskImageName token
+func (c *Config) nameToken(token string) (string, error) {
+	switch token {
+	case "date":
+		return c.nameTime.Format("20060102"), nil
+	case "time":
+		return c.nameTime.Format("150405"), nil
+	case "run-id":
+		for _, variable := range runIDVariables {
+			if value := os.Getenv(variable); value != "" {
+				return nameSafe(value), nil
+			}
+		}
+		return "", fmt.Errorf("no CI run ID is set (%s)", strings.Join(runIDVariables, ", "))
+	case "git-sha":
+		return gitSHA()
+	default:
+		return "", fmt.Errorf("unknown token (supported: {date}, {time}, {run-id}, {git-sha})")
+	}
+}
+
+// gitSHA returns the abbreviated commit being built, from the CI environment
+// or from the git repository in the working directory
+func gitSHA() (string, error) {
+	sha := ""
+	for _, variable := range gitSHAVariables {
+		if sha = os.Getenv(variable); sha != "" {
+			break
+		}
+	}
+	if sha == "" {
+		out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+		if err != nil {
+			return "", fmt.Errorf("no commit is set (%s) and git rev-parse HEAD failed: %v", strings.Join(gitSHAVariables, ", "), err)
+		}
+		sha = strings.TrimSpace(string(out))
+	}
+	sha = nameSafe(sha)
+	if len(sha) > shortSHALength {
+		sha = sha[:shortSHALength]
+	}
+	return sha, nil
+}
+
+// nameSafe lowercases a token value and replaces the characters image names
+// cannot contain with hyphens
+func nameSafe(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, value)
+}
+
+// DiskImageNameTemplate returns DiskImageName as given when it had {tokens}
+// that validation expanded, or ""
+func (c *Config) DiskImageNameTemplate() string {
+	return c.diskImageNameTemplate
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	_ "embed"
+	"os"
+	"regexp"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/image"
+)
+
+// preinstalledImagesTable is the embedded image set per COS milestone, with the
+// GKE version each was taken from; see the file for how to update it
+//
+//go:embed preinstalled-images.yaml
+var preinstalledImagesTable []byte
+
+// cosVersionPattern matches a COS milestone (113), a COS version
+// (113-18244-85-29) or a COS image name (cos-113-18244-85-29)
+var cosVersionPattern = regexp.MustCompile(`^(?:cos-)?([0-9]+)(?:-[0-9]+){0,3}$`)
+
+// checkPreinstalledImages finds the images of ContainerImages that nodes of
+// COSVersion already hold, from the built-in table or PreinstalledImagesFile,
+// which replaces it. An image matches a listed image of the same repository
+// and tag, or digest if the listed image is pinned to one.
+func (c *Config) checkPreinstalledImages(problems *ValidationErrors) {
+	const field = "disk.cos_version"
+	c.preinstalledImages = nil
+	if c.COSVersion == "" {
+		if c.PreinstalledImagesFile != "" {
+			problems.addf("advanced.preinstalled_images_file", "--preinstalled-images-file requires --cos-version")
+		}
+		return
+	}
+	if c.NodeImageType != NodeImageCOS {
+		problems.addf(field, "--cos-version requires --node-image-type=%s: only COS node images are listed", NodeImageCOS)
+		return
+	}
+	match := cosVersionPattern.FindStringSubmatch(c.COSVersion)
+	if match == nil {
+		problems.addf(field, "invalid COS version '%s': expected a milestone such as 113, or a version such as cos-113-18244-85-29 (use --cos-version or '%s' in config file)", c.COSVersion, field)
+		return
+	}
+
+	data, source := preinstalledImagesTable, "the built-in table"
+	if c.PreinstalledImagesFile != "" {
+		var err error
+		data, err = os.ReadFile(c.PreinstalledImagesFile)
+		if err != nil {
+			problems.addf("advanced.preinstalled_images_file", "cannot read preinstalled images file: %w", err)
+			return
+		}
+		source = c.PreinstalledImagesFile
+	}
+	manifest, err := parseSystemImagesManifest(data)
+	if err != nil {
+		problems.addf("advanced.preinstalled_images_file", "invalid preinstalled images file %s: %w", source, err)
+		return
+	}
+
+	milestone := match[1]
+	preinstalled, ok := manifest.Versions[milestone]
+	if !ok {
+		problems.addf(field, "no preinstalled images are known for COS %s in %s: supported milestones are %s (use --preinstalled-images-file for others)",
+			milestone, source, manifest.supportedList())
+		return
+	}
+
+	var refs []*image.Reference
+	for _, entry := range preinstalled {
+		ref, err := image.ParseReference(entry)
+		if err != nil {
+			problems.addf("advanced.preinstalled_images_file", "invalid image %s in %s: %w", entry, source, err)
+			return
+		}
+		refs = append(refs, ref)
+	}
+	for _, img := range c.ContainerImages {
+		ref, err := image.ParseReference(img)
+		if err != nil {
+			continue // reported with the other problems of the image
+		}
+		for _, p := range refs {
+			if ref.Name() == p.Name() && ((p.Digest != "" && ref.Digest == p.Digest) || (p.Digest == "" && ref.Tag == p.Tag)) {
+				c.preinstalledImages = append(c.preinstalledImages, img)
+				break
+			}
+		}
+	}
+}
+
+// PreinstalledImages returns the images of ContainerImages that the last
+// validation found on COSVersion's node image already
+func (c *Config) PreinstalledImages() []string {
+	return c.preinstalledImages
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreinstalledImagesTableIsValid(t *testing.T) {
+	manifest, err := parseSystemImagesManifest(preinstalledImagesTable)
+	if err != nil {
+		t.Fatalf("built-in table: %v", err)
+	}
+	for milestone := range manifest.Versions {
+		if !cosVersionPattern.MatchString(milestone) {
+			t.Errorf("built-in table lists %q, not a COS milestone", milestone)
+		}
+	}
+}
+
+func TestCheckPreinstalledImages(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "preinstalled.yaml")
+	if err := os.WriteFile(file, []byte("schema: 1\nversions:\n  \"200\":\n    - gke.gcr.io/pause:3.99\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		file    string
+		want    []string
+		wantErr string
+	}{
+		{name: "built-in milestone", version: "113", want: []string{"gke.gcr.io/pause:3.9"}},
+		{name: "built-in COS image name", version: "cos-121-18867-90-4", want: []string{"gke.gcr.io/pause:3.10"}},
+		{name: "unknown milestone", version: "97", wantErr: "supported milestones are 105, 109, 113, 117, 121"},
+		{name: "file replaces the table", version: "200", file: file, want: []string{"gke.gcr.io/pause:3.99"}},
+		{name: "file without the milestone", version: "113", file: file, wantErr: "supported milestones are 200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig()
+			c.NodeImageType = NodeImageCOS
+			c.COSVersion = tt.version
+			c.PreinstalledImagesFile = tt.file
+			c.ContainerImages = []string{"nginx:1.25", "gke.gcr.io/pause:3.9", "gke.gcr.io/pause:3.10", "gke.gcr.io/pause:3.99"}
+
+			var problems ValidationErrors
+			c.checkPreinstalledImages(&problems)
+			if tt.wantErr != "" {
+				if len(problems) != 1 || !strings.Contains(problems[0].Error(), tt.wantErr) {
+					t.Errorf("problems = %v, want one containing %q", problems, tt.wantErr)
+				}
+				return
+			}
+			if len(problems) != 0 {
+				t.Fatalf("problems = %v", problems)
+			}
+			if got := c.PreinstalledImages(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PreinstalledImages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
+)
+
+// secretField is a config field that may be given as a secret:// URI. Each
+// of them holds a file path, so the secret is written to a private file.
+type secretField struct {
+	option string // flag and config file key, for messages
+	field  string // config file key, for validation problems
+	value  *string
+	file   string // name of the file the secret is written to
+}
+
+// secretFields lists the fields that accept secret:// URIs
+func (c *Config) secretFields() []secretField {
+	return []secretField{
+		{option: "--gcp-oauth or 'auth.gcp_oauth'", field: "auth.gcp_oauth", value: &c.GCPOAuth, file: "gcp-oauth.json"},
+		{option: "--ssh-key-file or 'auth.ssh_key_file'", field: "auth.ssh_key_file", value: &c.SSHKeyFile, file: "ssh-key"},
+		{option: "--ssh-proxy-jump-key-file or 'advanced.ssh_proxy_jump_key_file'", field: "advanced.ssh_proxy_jump_key_file", value: &c.SSHProxyJumpKeyFile, file: "ssh-proxy-jump-key"},
+		{option: "--attestation-key-file or 'auth.attestation_key_file'", field: "auth.attestation_key_file", value: &c.AttestationKeyFile, file: "attestation-key"},
+	}
+}
+
+// validateSecretURIs checks the format of secret:// values without accessing them
+func (c *Config) validateSecretURIs(problems *ValidationErrors) {
+	for _, field := range c.secretFields() {
+		if !gcp.IsSecretURI(*field.value) {
+			continue
+		}
+		if _, err := gcp.ParseSecretURI(*field.value); err != nil {
+			problems.addf(field.field, "invalid secret: %w (use %s in config file)", err, field.option)
+		}
+	}
+}
+
+// ResolveSecrets reads the fields given as secret:// URIs from Secret Manager
+// and replaces them with the path of a private file holding the secret. The
+// GCP credentials are resolved first and used for the others; when they are
+// not set, application default credentials are used. Fields that are already
+// resolved are left alone, so it can be called again after loading more
+// configuration. RemoveSecrets deletes the files.
+func (c *Config) ResolveSecrets(ctx context.Context) error {
+	for _, field := range c.secretFields() {
+		if !gcp.IsSecretURI(*field.value) {
+			continue
+		}
+
+		credentials := c.GCPOAuth
+		if gcp.IsSecretURI(credentials) {
+			credentials = ""
+		}
+		data, err := gcp.AccessSecret(ctx, credentials, *field.value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", field.option, err)
+		}
+
+		if c.secretDir == "" {
+			dir, err := os.MkdirTemp("", "gke-image-cache-builder-secrets-")
+			if err != nil {
+				return fmt.Errorf("failed to create secrets directory: %w", err)
+			}
+			c.secretDir = dir
+		}
+		path := filepath.Join(c.secretDir, field.file)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return fmt.Errorf("failed to write secret for %s: %w", field.option, err)
+		}
+		*field.value = path
+	}
+	return nil
+}
+
+// RemoveSecrets deletes the files written by ResolveSecrets
+func (c *Config) RemoveSecrets() {
+	if c.secretDir == "" {
+		return
+	}
+	os.RemoveAll(c.secretDir)
+	c.secretDir = ""
+}
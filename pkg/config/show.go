@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces credential values in shown configuration
+const redacted = "<redacted>"
+
+// ToYAMLConfig converts the resolved configuration back into the config file
+// structure, so the output of --show-config can be used as a config file
+func (c *Config) ToYAMLConfig() *YAMLConfig {
+	mode := ""
+	switch c.Mode {
+	case ModeLocal:
+		mode = "local"
+	case ModeRemote:
+		mode = "remote"
+	}
+
+	pullTimeout := ""
+	if c.PullTimeout > 0 {
+		pullTimeout = c.PullTimeout.String()
+	}
+
+	return &YAMLConfig{
+		Execution: ExecutionConfig{Mode: mode, Zone: c.Zone},
+		Project:   ProjectConfig{Name: c.ProjectName, ExpectedNumber: c.ExpectedProjectNumber, Allow: c.AllowedProjects},
+		Disk: DiskConfig{
+			Name:     c.DiskImageName,
+			SizeGB:   c.DiskSizeGB,
+			Family:   c.DiskFamilyName,
+			Aliases:  c.FamilyAliases,
+			Labels:   c.DiskLabels,
+			Licenses: c.ImageLicenses,
+			DiskType: c.DiskType,
+			OSType:   c.OSType,
+			Arch:     c.Arch,
+
+			Snapshotter:   c.Snapshotter,
+			NodeImageType: c.NodeImageType,
+			COSVersion:    c.COSVersion,
+
+			StrictFamilyArch: c.StrictFamilyArch,
+		},
+		Images: c.ContainerImages,
+		Network: NetworkConfig{
+			Network:           c.Network,
+			Subnet:            c.Subnet,
+			ConnectivityCheck: c.ConnectivityCheck,
+			NoExternalIP:      c.NoExternalIP,
+		},
+		Advanced: AdvancedConfig{
+			Timeout:               c.Timeout.String(),
+			PullTimeout:           pullTimeout,
+			JobName:               c.JobName,
+			MachineType:           c.MachineType,
+			ShieldedVM:            c.ShieldedVM,
+			BuildVMImage:          c.BuildVMImage,
+			BuildVMImageVersion:   c.BuildVMImageVersion,
+			PostPullCommand:       c.PostPullCommand,
+			PrePullCommands:       c.PrePullCommands,
+			PostPullCommands:      c.PostPullCommands,
+			Preemptible:           c.Preemptible,
+			Partitions:            c.Partitions,
+			PullArgs:              c.PullArgs,
+			Lockfile:              c.Lockfile,
+			WriteLockfile:         c.WriteLockfile,
+			ApprovedDigests:       c.ApprovedDigests,
+			SkipIfExists:          c.SkipIfExists,
+			WriteImageRef:         c.WriteImageRef,
+			ImageRefFormat:        c.ImageRefFormat,
+			NodePoolConfig:        c.NodePoolConfig,
+			NodePoolConfigFormat:  c.NodePoolConfigFormat,
+			OutputType:            c.OutputType,
+			ImageForceCreate:      c.ImageForceCreate,
+			SmokeTest:             c.SmokeTest,
+			SmokeTestTimeout:      c.SmokeTestTimeout.String(),
+			AssumeNoStreaming:     c.AssumeNoStreaming,
+			CloudLogging:          c.CloudLogging,
+			VMLabels:              c.VMLabels,
+			SSHProxyJump:          c.SSHProxyJump,
+			SSHProxyJumpKeyFile:   c.SSHProxyJumpKeyFile,
+			SSHAddressType:        c.SSHAddressType,
+			VerifyNoLayersMissing: c.VerifyNoLayersMissing,
+			VerifyLayerDigests:    c.VerifyLayerDigests,
+			ParallelVerify:        c.ParallelVerify,
+			PullConcurrency:       c.PullConcurrency,
+			ContainerdVersion:     c.ContainerdVersion,
+			GKEVersion:            c.GKEVersion,
+			ConfigSnapshotBucket:  c.ConfigSnapshotBucket,
+			AttestationBucket:     c.AttestationBucket,
+			AttestationKMSKey:     c.AttestationKMSKey,
+			APIEndpoint:           c.APIEndpoint,
+			RetryBudget:           c.RetryBudget,
+			FromSpec:              c.FromSpec,
+			MaxImages:             c.MaxImages,
+			ForbidMultipleTags:    c.ForbidMultipleTags,
+			AutoRecover:           c.AutoRecover,
+			AbortOnWarning:        c.AbortOnWarning,
+			MaxTimeoutExtension:   c.MaxTimeoutExtension.String(),
+			CleanupTimeout:        c.CleanupTimeout.String(),
+			SerialPort:            c.SerialPort,
+			MinPullThroughput:     c.MinPullThroughput,
+			Tuning:                tuningConfig(c.Tuning),
+
+			IncludeGKESystemImages: c.IncludeGKESystemImages,
+			SystemImagesManifest:   c.SystemImagesManifest,
+
+			PreinstalledImagesFile: c.PreinstalledImagesFile,
+
+			AdditionalStartupScripts:      c.AdditionalStartupScripts,
+			AdditionalStartupScriptsOrder: c.AdditionalStartupScriptsOrder,
+		},
+		Auth: AuthConfig{
+			GCPOAuth:       redactInline(c.GCPOAuth),
+			ServiceAccount: c.ServiceAccount,
+			SSHKeyFile:     c.SSHKeyFile,
+
+			AttestationKeyFile: c.AttestationKeyFile,
+			SSHInsecure:        c.SSHInsecure,
+			ImagePullAuth:      c.ImagePullAuth,
+
+			CredentialProviders: c.CredentialProviders,
+		},
+		Logging: LoggingConfig{Verbose: c.Verbose, Quiet: c.Quiet, NoColor: c.NoColor, Format: c.LogFormat, File: c.LogFile, DebugHTTP: c.DebugHTTP, Trace: c.TraceFile},
+	}
+}
+
+// Show writes the resolved configuration as "yaml" or "json". Fields that hold
+// credentials inline are redacted; paths to credential files are shown.
+func (c *Config) Show(w io.Writer, format string) error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.ToYAMLConfig()); err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	data := buf.Bytes()
+
+	switch format {
+	case "yaml":
+		fmt.Fprintln(w, "# Effective configuration (command line > config file > defaults)")
+		_, err := w.Write(data)
+		return err
+	case "json":
+		// Round-trip through YAML so JSON keys match the config file
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to encode configuration: %w", err)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(doc)
+	default:
+		return fmt.Errorf("unsupported format '%s', supported formats: yaml, json", format)
+	}
+}
+
+// configHashLength keeps the hash well within the 63 character label value limit
+const configHashLength = 32
+
+// Snapshot returns the configuration as canonical YAML for reproducing a build.
+// Credentials and machine-local key files are left out, as are logging options.
+// System images are recorded in the image list, not as the version they came from,
+// and the preinstalled images check, which only warns, is left out.
+func (c *Config) Snapshot() ([]byte, error) {
+	snapshot := c.ToYAMLConfig()
+	snapshot.Auth.GCPOAuth = ""
+	snapshot.Auth.SSHKeyFile = ""
+	snapshot.Auth.AttestationKeyFile = ""
+	snapshot.Advanced.SSHProxyJumpKeyFile = ""
+	snapshot.Advanced.IncludeGKESystemImages = ""
+	snapshot.Advanced.SystemImagesManifest = ""
+	snapshot.Disk.COSVersion = ""
+	snapshot.Advanced.PreinstalledImagesFile = ""
+	snapshot.Logging = LoggingConfig{}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Hash returns a hash of the configuration snapshot identifying the build's configuration
+func (c *Config) Hash() (string, error) {
+	snapshot, err := c.Snapshot()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(snapshot)
+	return hex.EncodeToString(sum[:])[:configHashLength], nil
+}
+
+// redactInline hides credentials given inline (a JSON key or an access token)
+// rather than as a file path
+func redactInline(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "ya29.") {
+		return redacted
+	}
+	return value
+}
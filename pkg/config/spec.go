@@ -0,0 +1,212 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The apiVersion and kind of the image cache specs --from-spec reads
+const (
+	SpecAPIVersion = "imagecache.gke-image-cache-builder.io/v1alpha1"
+	SpecKind       = "ImageCacheSpec"
+)
+
+// specPullPolicies are the values of an image's policy in a spec, as in a
+// container's imagePullPolicy
+var specPullPolicies = []string{"Always", "IfNotPresent", "Never"}
+
+// ImageCacheSpec is the Kubernetes-style document --from-spec reads, the same
+// one an in-cluster preloader can apply:
+//
+//	apiVersion: imagecache.gke-image-cache-builder.io/v1alpha1
+//	kind: ImageCacheSpec
+//	metadata:
+//	  name: web-cache
+//	  labels: {team: web}
+//	spec:
+//	  images:
+//	    - image: nginx:1.25
+//	      platform: linux/amd64
+//	      policy: IfNotPresent
+type ImageCacheSpec struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name   string            `yaml:"name"`
+		Labels map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Images []SpecImage `yaml:"images"`
+	} `yaml:"spec"`
+}
+
+// SpecImage is one entry of spec.images
+type SpecImage struct {
+	Image string `yaml:"image"`
+
+	// Platform ("os/arch", optionally with a variant) limits the image to
+	// builds for that platform; empty caches it on every platform. Parsing
+	// normalizes it to the form of Config.Platform.
+	Platform string `yaml:"platform,omitempty"`
+
+	// Policy is the pull policy of the in-cluster preloader. The disk serves
+	// any policy, so it is only checked.
+	Policy string `yaml:"policy,omitempty"`
+}
+
+// parseImageCacheSpec reads a spec, rejecting YAML of another apiVersion or
+// kind and fields the spec does not have
+func parseImageCacheSpec(data []byte) (*ImageCacheSpec, error) {
+	// Tell other documents apart before their fields are reported as unknown
+	var header struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if header.APIVersion != SpecAPIVersion || header.Kind != SpecKind {
+		return nil, fmt.Errorf("expected apiVersion %s and kind %s, got apiVersion '%s' and kind '%s'",
+			SpecAPIVersion, SpecKind, header.APIVersion, header.Kind)
+	}
+
+	var spec ImageCacheSpec
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, err
+	}
+	if len(spec.Spec.Images) == 0 {
+		return nil, fmt.Errorf("spec.images lists no images")
+	}
+	for i, img := range spec.Spec.Images {
+		if img.Image == "" {
+			return nil, fmt.Errorf("spec.images[%d] has no image", i)
+		}
+		if img.Policy != "" && !slices.Contains(specPullPolicies, img.Policy) {
+			return nil, fmt.Errorf("spec.images[%d] (%s) has policy '%s', supported policies: %s",
+				i, img.Image, img.Policy, strings.Join(specPullPolicies, ", "))
+		}
+		if img.Platform != "" {
+			platform, err := normalizeSpecPlatform(img.Platform)
+			if err != nil {
+				return nil, fmt.Errorf("spec.images[%d] (%s): %w", i, img.Image, err)
+			}
+			spec.Spec.Images[i].Platform = platform
+		}
+	}
+	return &spec, nil
+}
+
+// specArchitectures maps the architecture names of platforms, including the
+// aliases uname and Docker use, to those of Config.Platform
+var specArchitectures = map[string]string{
+	"amd64":   "amd64",
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+}
+
+// normalizeSpecPlatform returns an "os/arch[/variant]" platform in the form of
+// Config.Platform, e.g. linux/x86_64 as linux/amd64 and linux/arm64/v8 as
+// linux/arm64. Platforms no build is for are an error, not silently skipped.
+func normalizeSpecPlatform(platform string) (string, error) {
+	parts := strings.Split(strings.ToLower(platform), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", fmt.Errorf("invalid platform '%s': expected os/arch, e.g. linux/amd64", platform)
+	}
+	osName, variant := parts[0], ""
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+	if osName != OSLinux && osName != OSWindows {
+		return "", fmt.Errorf("unsupported platform '%s': os must be %s or %s", platform, OSLinux, OSWindows)
+	}
+	arch, ok := specArchitectures[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("unsupported platform '%s': architecture must be amd64 or arm64", platform)
+	}
+	// v8 is the only arm64 variant, and builds are for any amd64 level
+	if variant != "" && !(arch == "arm64" && variant == "v8") && !(arch == "amd64" && slices.Contains([]string{"v1", "v2", "v3", "v4"}, variant)) {
+		return "", fmt.Errorf("unsupported platform '%s': unknown variant %s of %s", platform, variant, arch)
+	}
+	return osName + "/" + arch, nil
+}
+
+// LoadSpec adds the images of the FromSpec document for this build's platform
+// to ContainerImages, after the images given and skipping those already
+// listed. metadata.name becomes the disk image name and metadata.labels the
+// disk labels, unless set otherwise. Labels that are not valid Compute Engine
+// labels, such as app.kubernetes.io/name, are left out. It runs once the
+// flags and config file are applied and before Validate, and is idempotent.
+func (c *Config) LoadSpec() error {
+	const field = "advanced.from_spec"
+	var problems ValidationErrors
+	c.specNotes = nil
+	if c.FromSpec == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.FromSpec)
+	if err != nil {
+		problems.addf(field, "cannot read image cache spec: %w", err)
+		return problems.err()
+	}
+	spec, err := parseImageCacheSpec(data)
+	if err != nil {
+		problems.addf(field, "invalid image cache spec %s: %w", c.FromSpec, err)
+		return problems.err()
+	}
+
+	listed := make(map[string]bool, len(c.ContainerImages))
+	for _, img := range c.ContainerImages {
+		listed[img] = true
+	}
+	for _, img := range spec.Spec.Images {
+		if img.Platform != "" && img.Platform != c.Platform() {
+			c.specNotes = append(c.specNotes, fmt.Sprintf("%s is for %s, not cached on %s", img.Image, img.Platform, c.Platform()))
+			continue
+		}
+		if !listed[img.Image] {
+			listed[img.Image] = true
+			c.ContainerImages = append(c.ContainerImages, img.Image)
+		}
+	}
+
+	if c.DiskImageName == "" {
+		c.DiskImageName = spec.Metadata.Name
+	}
+
+	keys := make([]string, 0, len(spec.Metadata.Labels))
+	for key := range spec.Metadata.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, set := c.DiskLabels[key]; set {
+			continue
+		}
+		if err := validateLabel(key, spec.Metadata.Labels[key]); err != nil {
+			c.specNotes = append(c.specNotes, fmt.Sprintf("label %s not applied to the image: %v", key, err))
+			continue
+		}
+		if c.DiskLabels == nil {
+			c.DiskLabels = make(map[string]string)
+		}
+		c.DiskLabels[key] = spec.Metadata.Labels[key]
+	}
+	return nil
+}
+
+// SpecNotes returns what LoadSpec left out of the FromSpec
+// document: images of other platforms and labels Compute Engine rejects
+func (c *Config) SpecNotes() []string {
+	return c.specNotes
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeSpecPlatform(t *testing.T) {
+	tests := map[string]string{
+		"linux/amd64":    "linux/amd64",
+		"linux/x86_64":   "linux/amd64",
+		"Linux/AMD64":    "linux/amd64",
+		"linux/arm64/v8": "linux/arm64",
+		"linux/aarch64":  "linux/arm64",
+		"linux/amd64/v3": "linux/amd64",
+		"windows/amd64":  "windows/amd64",
+	}
+	for platform, want := range tests {
+		got, err := normalizeSpecPlatform(platform)
+		if err != nil || got != want {
+			t.Errorf("normalizeSpecPlatform(%q) = %q, %v, want %q", platform, got, err, want)
+		}
+	}
+	for _, platform := range []string{"linux", "linux/arm", "darwin/arm64", "linux/arm64/v7", "linux/amd64/v8", "linux/amd64/v3/x"} {
+		if got, err := normalizeSpecPlatform(platform); err == nil {
+			t.Errorf("normalizeSpecPlatform(%q) = %q, want an error", platform, got)
+		}
+	}
+}
+
+func writeSpec(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSpec(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ContainerImages = []string{"nginx:1.25"}
+	cfg.DiskLabels = map[string]string{"team": "cli"}
+	cfg.FromSpec = writeSpec(t, `apiVersion: imagecache.gke-image-cache-builder.io/v1alpha1
+kind: ImageCacheSpec
+metadata:
+  name: web-cache
+  labels: {team: web, tier: frontend}
+spec:
+  images:
+    - image: nginx:1.25
+    - image: redis:7.2
+      platform: linux/x86_64
+    - image: busybox:1.36
+      platform: linux/arm64/v8
+`)
+
+	for i := 0; i < 2; i++ {
+		if err := cfg.LoadSpec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"nginx:1.25", "redis:7.2"}; !slices.Equal(cfg.ContainerImages, want) {
+		t.Errorf("ContainerImages = %v, want %v", cfg.ContainerImages, want)
+	}
+	if cfg.DiskImageName != "web-cache" {
+		t.Errorf("DiskImageName = %q, want web-cache", cfg.DiskImageName)
+	}
+	if cfg.DiskLabels["team"] != "cli" || cfg.DiskLabels["tier"] != "frontend" {
+		t.Errorf("DiskLabels = %v, want team from the flags and tier from the spec", cfg.DiskLabels)
+	}
+	if len(cfg.SpecNotes()) != 1 {
+		t.Errorf("SpecNotes() = %v, want the arm64 image left out", cfg.SpecNotes())
+	}
+}
+
+func TestLoadSpecRejectsUnknownPlatform(t *testing.T) {
+	cfg := NewConfig()
+	cfg.FromSpec = writeSpec(t, `apiVersion: imagecache.gke-image-cache-builder.io/v1alpha1
+kind: ImageCacheSpec
+spec:
+  images:
+    - image: redis:7.2
+      platform: linux/amd46
+`)
+	if err := cfg.LoadSpec(); err == nil {
+		t.Error("spec with an unknown platform loaded")
+	}
+}
+
+func TestValidateLeavesSpecAlone(t *testing.T) {
+	cfg := NewConfig()
+	cfg.FromSpec = filepath.Join(t.TempDir(), "missing.yaml")
+	cfg.ContainerImages = []string{"nginx:1.25"}
+	_ = cfg.Validate()
+	if cfg.DiskImageName != "" || len(cfg.DiskLabels) != 0 || len(cfg.ContainerImages) != 1 {
+		t.Errorf("Validate changed the spec's fields: name %q, labels %v, images %v", cfg.DiskImageName, cfg.DiskLabels, cfg.ContainerImages)
+	}
+}
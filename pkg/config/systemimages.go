@@ -0,0 +1,143 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// systemImagesTable is the embedded image set per GKE minor version; see the
+// file for how to update it
+//
+//go:embed system-images.yaml
+var systemImagesTable []byte
+
+// systemImagesSchema is the table format version this build reads
+const systemImagesSchema = 1
+
+// systemImagesManifest is the format of the embedded table and of
+// --system-images-manifest files, and of the preinstalled images tables keyed
+// by COS milestone
+type systemImagesManifest struct {
+	Schema   int                 `yaml:"schema"`
+	Versions map[string][]string `yaml:"versions"`
+}
+
+// parseSystemImagesManifest reads a system images table
+func parseSystemImagesManifest(data []byte) (*systemImagesManifest, error) {
+	var manifest systemImagesManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Schema != systemImagesSchema {
+		return nil, fmt.Errorf("unsupported schema %d, expected %d", manifest.Schema, systemImagesSchema)
+	}
+	if len(manifest.Versions) == 0 {
+		return nil, fmt.Errorf("no versions listed under 'versions'")
+	}
+	return &manifest, nil
+}
+
+// supportedRange describes the versions a table covers, e.g. "1.26 to 1.33"
+func (m *systemImagesManifest) supportedRange() string {
+	versions := m.sortedVersions()
+	if len(versions) == 1 {
+		return versions[0]
+	}
+	return versions[0] + " to " + versions[len(versions)-1]
+}
+
+// supportedList lists the versions a table covers, for tables with gaps,
+// e.g. "105, 109, 113"
+func (m *systemImagesManifest) supportedList() string {
+	return strings.Join(m.sortedVersions(), ", ")
+}
+
+// sortedVersions returns the versions a table covers, in ascending order
+func (m *systemImagesManifest) sortedVersions() []string {
+	versions := make([]string, 0, len(m.Versions))
+	for version := range m.Versions {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return minorNumber(versions[i]) < minorNumber(versions[j]) })
+	return versions
+}
+
+// minorNumber returns the minor number of a 1.x version, or -1
+func minorNumber(version string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "1."))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// expandSystemImages adds the system images of IncludeGKESystemImages to
+// ContainerImages, after the images given and skipping those already listed.
+// It is idempotent, so validating twice does not add them twice.
+func (c *Config) expandSystemImages(problems *ValidationErrors) {
+	const field = "advanced.include_gke_system_images"
+	c.systemImages = nil
+	if c.IncludeGKESystemImages == "" {
+		if c.SystemImagesManifest != "" {
+			problems.addf("advanced.system_images_manifest", "--system-images-manifest requires --include-gke-system-images")
+		}
+		return
+	}
+	if c.IsWindows() {
+		problems.addf(field, "GKE system images are Linux images and cannot be cached with --os-type=windows")
+		return
+	}
+	if !gkeVersionPattern.MatchString(c.IncludeGKESystemImages) {
+		problems.addf(field, "invalid GKE version '%s': expected a minor version such as 1.29 (use --include-gke-system-images or '%s' in config file)", c.IncludeGKESystemImages, field)
+		return
+	}
+
+	data, source := systemImagesTable, "the built-in table"
+	if c.SystemImagesManifest != "" {
+		var err error
+		data, err = os.ReadFile(c.SystemImagesManifest)
+		if err != nil {
+			problems.addf("advanced.system_images_manifest", "cannot read system images manifest: %w", err)
+			return
+		}
+		source = c.SystemImagesManifest
+	}
+	manifest, err := parseSystemImagesManifest(data)
+	if err != nil {
+		problems.addf("advanced.system_images_manifest", "invalid system images manifest %s: %w", source, err)
+		return
+	}
+
+	parts := strings.SplitN(c.IncludeGKESystemImages, ".", 3)
+	minor := parts[0] + "." + parts[1]
+	images, ok := manifest.Versions[minor]
+	if !ok {
+		problems.addf(field, "no system images are known for GKE %s in %s: supported versions are %s (use --system-images-manifest for others)",
+			minor, source, manifest.supportedRange())
+		return
+	}
+
+	listed := make(map[string]bool, len(c.ContainerImages))
+	for _, image := range c.ContainerImages {
+		listed[image] = true
+	}
+	for _, image := range images {
+		c.systemImages = append(c.systemImages, image)
+		if !listed[image] {
+			listed[image] = true
+			c.ContainerImages = append(c.ContainerImages, image)
+		}
+	}
+}
+
+// SystemImages returns the images --include-gke-system-images expanded to in
+// the last validation
+func (c *Config) SystemImages() []string {
+	return c.systemImages
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/vm"
+)
+
+// TuningConfig is advanced.tuning in the config file; empty values keep the defaults
+type TuningConfig struct {
+	VMBootTimeout       string `yaml:"vm_boot_timeout,omitempty"`
+	BootstrapTimeout    string `yaml:"bootstrap_timeout,omitempty"`
+	WindowsSetupTimeout string `yaml:"windows_setup_timeout,omitempty"`
+	SSHReadyTimeout     string `yaml:"ssh_ready_timeout,omitempty"`
+	StatusPollInterval  string `yaml:"status_poll_interval,omitempty"`
+
+	ConnectivityTestTimeout string `yaml:"connectivity_test_timeout,omitempty"`
+}
+
+// tuningField is one advanced.tuning key
+type tuningField struct {
+	key   string
+	value *time.Duration
+}
+
+// tuningFields returns the advanced.tuning keys with their Tuning fields, in file order
+func tuningFields(tuning *vm.Tuning) []tuningField {
+	return []tuningField{
+		{"vm_boot_timeout", &tuning.BootTimeout},
+		{"bootstrap_timeout", &tuning.BootstrapTimeout},
+		{"windows_setup_timeout", &tuning.WindowsSetupTimeout},
+		{"ssh_ready_timeout", &tuning.SSHReadyTimeout},
+		{"status_poll_interval", &tuning.StatusPollInterval},
+		{"connectivity_test_timeout", &tuning.ConnectivityTestTimeout},
+	}
+}
+
+// apply parses the values set in the config file into tuning
+func (t *TuningConfig) apply(tuning *vm.Tuning, filePath string) error {
+	values := []string{t.VMBootTimeout, t.BootstrapTimeout, t.WindowsSetupTimeout, t.SSHReadyTimeout, t.StatusPollInterval, t.ConnectivityTestTimeout}
+	for i, field := range tuningFields(tuning) {
+		if values[i] == "" {
+			continue
+		}
+		value, err := time.ParseDuration(values[i])
+		if err != nil {
+			return fmt.Errorf("invalid tuning.%s format '%s' in %s: %w", field.key, values[i], filePath, err)
+		}
+		*field.value = value
+	}
+	return nil
+}
+
+// tuningConfig returns the config file form of a Tuning
+func tuningConfig(tuning vm.Tuning) TuningConfig {
+	return TuningConfig{
+		VMBootTimeout:           tuning.BootTimeout.String(),
+		BootstrapTimeout:        tuning.BootstrapTimeout.String(),
+		WindowsSetupTimeout:     tuning.WindowsSetupTimeout.String(),
+		SSHReadyTimeout:         tuning.SSHReadyTimeout.String(),
+		StatusPollInterval:      tuning.StatusPollInterval.String(),
+		ConnectivityTestTimeout: tuning.ConnectivityTestTimeout.String(),
+	}
+}
+
+func (c *Config) validateTuning(problems *ValidationErrors) {
+	for _, field := range tuningFields(&c.Tuning) {
+		if field.key == "status_poll_interval" {
+			if *field.value < time.Second || *field.value > time.Minute {
+				problems.addf("advanced.tuning."+field.key, "tuning.%s must be between 1 second and 1 minute ('advanced.tuning.%s' in config file)", field.key, field.key)
+			}
+		} else if *field.value < time.Minute {
+			problems.addf("advanced.tuning."+field.key, "tuning.%s must be at least 1 minute ('advanced.tuning.%s' in config file)", field.key, field.key)
+		}
+	}
+}
@@ -1,30 +1,115 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
 )
 
-// Validate checks if all required fields are set and valid
-func (c *Config) Validate() error {
-	if err := c.validateExecutionMode(); err != nil {
-		return err
+// maxPartitions bounds how many build VMs and cache disks run concurrently
+const maxPartitions = 16
+
+// maxParallelVerify bounds the concurrent verification commands on one VM
+const maxParallelVerify = 32
+
+// Problems that error messages explain apart from the others of their field
+var (
+	// ErrNotOnGCP is a local build on a machine that is not a GCP VM
+	ErrNotOnGCP = errors.New("requires execution on a GCP VM instance")
+
+	// ErrOtherProject is a local build for another project than this VM's
+	ErrOtherProject = errors.New("builds in this VM's project")
+)
+
+// ValidationError is a configuration problem and the option it concerns
+type ValidationError struct {
+	Field string // config file path of the option, e.g. "disk.labels.env" or "images[2]"
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors is every problem Validate found, in the order of the checks
+type ValidationErrors []*ValidationError
+
+// Error returns the message of a single problem as is, and numbers several
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
 	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problems:", len(e))
+	for i, problem := range e {
+		fmt.Fprintf(&b, "\n  %d. %s: %v", i+1, problem.Field, problem.Err)
+	}
+	return b.String()
+}
 
-	if err := c.validateRequiredFields(); err != nil {
-		return err
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, problem := range e {
+		errs[i] = problem
 	}
+	return errs
+}
 
-	if err := c.validateModeSpecificFields(); err != nil {
-		return err
+// add records err, if any, as a problem with field
+func (e *ValidationErrors) add(field string, err error) {
+	if err != nil {
+		*e = append(*e, &ValidationError{Field: field, Err: err})
 	}
+}
 
-	if err := c.validateOptionalFields(); err != nil {
-		return err
+// addf records a problem with field
+func (e *ValidationErrors) addf(field, format string, args ...any) {
+	e.add(field, fmt.Errorf(format, args...))
+}
+
+// err returns the problems as an error, or nil if there are none
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
 	}
+	return e
+}
 
-	return nil
+// Validate checks if all required fields are set and valid. It reports every
+// problem it finds as ValidationErrors, not just the first.
+func (c *Config) Validate() error {
+	var problems ValidationErrors
+	c.skipped = nil
+
+	if err := c.validateExecutionMode(); err != nil {
+		problems.add("execution.mode", err)
+	}
+
+	c.expandSystemImages(&problems)
+	c.checkImageList(&problems)
+	c.expandDiskImageName(&problems)
+	c.validateRequiredFields(&problems)
+	c.validateProjectGuards(&problems)
+	c.validateModeSpecificFields(&problems)
+	c.validateOptionalFields(&problems)
+	c.validateSecretURIs(&problems)
+
+	return problems.err()
 }
 
 func (c *Config) validateExecutionMode() error {
@@ -34,75 +119,447 @@ func (c *Config) validateExecutionMode() error {
 	return nil
 }
 
-func (c *Config) validateRequiredFields() error {
+// skip records a check that Offline left out
+func (c *Config) skip(check string) {
+	c.skipped = append(c.skipped, check)
+}
+
+// SkippedChecks returns the checks the last validation left out because of
+// Offline, so they can be reported
+func (c *Config) SkippedChecks() []string {
+	return c.skipped
+}
+
+// projectNumberPattern matches project numbers, which are never 0-prefixed
+var projectNumberPattern = regexp.MustCompile(`^[1-9][0-9]*$`)
+
+// validateProjectGuards checks ProjectName against AllowedProjects. Whether it
+// is the project of ExpectedProjectNumber takes the API and is checked when
+// the build starts.
+func (c *Config) validateProjectGuards(problems *ValidationErrors) {
+	if c.ExpectedProjectNumber != "" && !projectNumberPattern.MatchString(c.ExpectedProjectNumber) {
+		problems.addf("project.expected_number", "invalid expected project number '%s': give the project number, e.g. 123456789012, not the project ID (use --expected-project-number or 'project.expected_number' in config file)",
+			c.ExpectedProjectNumber)
+	}
+	if len(c.AllowedProjects) > 0 && c.ProjectName != "" && !slices.Contains(c.AllowedProjects, c.ProjectName) {
+		problems.addf("project.name", "project %s is not one of the projects builds may target (%s, 'project.allow' in config file); check --project-name for a typo",
+			c.ProjectName, strings.Join(c.AllowedProjects, ", "))
+	}
+}
+
+// notRemote reports whether a mode other than remote was chosen. Remote-only
+// options are checked with it, so a missing mode is reported on its own.
+func (c *Config) notRemote() bool {
+	return c.Mode != ModeUnspecified && !c.IsRemoteMode()
+}
+
+func (c *Config) validateRequiredFields(problems *ValidationErrors) {
 	if c.ProjectName == "" {
-		return fmt.Errorf("project-name is required (use --project-name or 'project.name' in config file)")
+		problems.addf("project.name", "project-name is required (use --project-name or 'project.name' in config file)")
 	}
 	if c.DiskImageName == "" {
-		return fmt.Errorf("disk-image-name is required (use --disk-image-name or 'cache.name' in config file)")
+		problems.addf("disk.name", "disk-image-name is required (use --disk-image-name, 'cache.name' in config file or metadata.name of --from-spec)")
 	}
-	if len(c.ContainerImages) == 0 {
-		return fmt.Errorf("at least one container-image is required (use --container-image or 'images' list in config file)")
+	if len(c.ContainerImages) == 0 && c.Lockfile == "" {
+		problems.addf("images", "at least one container-image is required (use --container-image, 'images' list in config file, --from-spec or --lockfile)")
+	}
+}
+
+// validateHooks checks the pre- and post-pull commands, which run as root on
+// the build host: from a config file they need --allow-hooks
+func (c *Config) validateHooks(problems *ValidationErrors) {
+	hooks := map[string][]string{
+		"advanced.pre_pull_commands":  c.PrePullCommands,
+		"advanced.post_pull_commands": c.PostPullCommands,
+	}
+	field := "advanced.pre_pull_commands"
+	if len(c.PrePullCommands) == 0 {
+		field = "advanced.post_pull_commands"
+	}
+	if !c.AllowHooks {
+		for _, key := range c.fileScripts {
+			problems.addf(key, "'%s' in the config file runs as root on the build host; rerun with --allow-hooks if the config file is trusted", key)
+		}
+	}
+
+	total := len(c.PrePullCommands) + len(c.PostPullCommands)
+	switch {
+	case total == 0:
+		return
+	case c.IsWindows():
+		problems.addf(field, "pre- and post-pull commands are not supported for Windows builds: the Windows build VM is driven through its startup script")
+		return
+	case !c.AllowHooks:
+		problems.addf(field, "the config file defines %d pre- and post-pull commands, which run as root on the build host; rerun with --allow-hooks if the config file is trusted", total)
+	}
+	for _, name := range []string{"advanced.pre_pull_commands", "advanced.post_pull_commands"} {
+		for i, script := range hooks[name] {
+			if strings.TrimSpace(script) == "" || strings.ContainsRune(script, 0) {
+				problems.addf(fmt.Sprintf("%s[%d]", name, i), "each command in '%s' must be a non-empty bash script", name)
+			}
+		}
+	}
+}
+
+func (c *Config) validateImageForceCreate(problems *ValidationErrors) {
+	const field = "advanced.image_force_create"
+	switch {
+	case !c.ImageForceCreate:
+	case c.notRemote():
+		problems.addf(field, "image-force-create skips detaching the cache disk from the build VM and requires remote mode (-R)")
+	case c.IsWindows():
+		problems.addf(field, "image-force-create is not supported for os type windows: flushing the cache disk needs SSH to the build VM")
+	case !c.OutputsImage():
+		problems.addf(field, "image-force-create applies to creating the image, and output type %s creates none", OutputDiskOnly)
+	}
+}
+
+// validatePreemptible rejects builds a preemptible build VM cannot finish. A
+// preemption itself fails the build like any lost VM, and the VM and cache
+// disk are deleted; builds that only could outlive the VM through "control
+// extend" are warned about when they start.
+func (c *Config) validatePreemptible(problems *ValidationErrors) {
+	const field = "advanced.preemptible"
+	switch {
+	case !c.Preemptible:
+	case c.notRemote():
+		problems.addf(field, "preemptible configures the build VM and requires remote mode (-R): local mode builds on this machine")
+	case c.TotalTimeout() > MaxPreemptibleRunTime:
+		problems.addf(field, "a preemptible build VM is stopped after %s at most, but the build may take %s (timeout, pull timeout and smoke test timeout); shorten them or drop --preemptible",
+			MaxPreemptibleRunTime, c.TotalTimeout())
 	}
-	return nil
 }
 
-func (c *Config) validateModeSpecificFields() error {
+func (c *Config) validateCloudLogging(problems *ValidationErrors) {
+	const field = "advanced.cloud_logging"
+	switch {
+	case !c.CloudLogging:
+	case c.notRemote():
+		problems.addf(field, "cloud-logging ships the build VM's log and requires remote mode (-R): in local mode the log is this tool's own")
+	case c.IsWindows():
+		problems.addf(field, "cloud-logging is not supported for os type windows: the Windows build VM reports on its serial port only")
+	case c.ServiceAccount == "" || strings.EqualFold(c.ServiceAccount, "none"):
+		problems.addf(field, "cloud-logging needs a service account on the build VM with roles/logging.logWriter, which writes the log entries (use --service-account or 'auth.service_account' in config file)")
+	}
+}
+
+// validateSmokeTest checks --smoke-test: the smoke test VM runs Container-Optimized
+// OS, boots from the created image, and needs at least a minute to do so
+func (c *Config) validateSmokeTest(problems *ValidationErrors) {
+	const field = "advanced.smoke_test"
+	switch {
+	case !c.SmokeTest:
+	case c.IsWindows():
+		problems.addf(field, "smoke-test is not supported for os type windows: the smoke test VM runs Container-Optimized OS")
+	case !c.OutputsImage():
+		problems.addf(field, "smoke-test checks the created image, and output type %s creates none", OutputDiskOnly)
+	case c.SmokeTestTimeout < time.Minute:
+		problems.addf("advanced.smoke_test_timeout", "smoke-test-timeout must be at least 1 minute (use --smoke-test-timeout or 'advanced.smoke_test_timeout' in config file)")
+	}
+}
+
+// validateOutputType checks --output-type and the options that need an image
+func (c *Config) validateOutputType(problems *ValidationErrors) {
+	const field = "advanced.output_type"
+	switch c.OutputType {
+	case OutputImage:
+		return
+	case OutputDiskOnly, OutputBoth:
+	default:
+		problems.addf(field, "invalid output type '%s': supported types: %s, %s, %s (use --output-type or '%s' in config file)",
+			c.OutputType, OutputImage, OutputDiskOnly, OutputBoth, field)
+		return
+	}
+
+	// The kept disk is detached when the build VM is deleted
+	if c.notRemote() {
+		problems.addf(field, "output type %s requires remote mode (-R): the cache disk is kept once the build VM it is attached to is deleted", c.OutputType)
+	}
+	if c.OutputType != OutputDiskOnly {
+		return
+	}
+	if c.SkipIfExists {
+		problems.addf("advanced.skip_if_exists", "skip-if-exists looks for an existing image, and output type %s creates none", OutputDiskOnly)
+	}
+	if c.WriteImageRef != "" {
+		problems.addf("advanced.write_image_ref", "write-image-ref writes the created image's reference, and output type %s creates none", OutputDiskOnly)
+	}
+	if c.NodePoolConfig != "" {
+		problems.addf("advanced.node_pool_config", "node-pool-config attaches the created image to a node pool, and output type %s creates none", OutputDiskOnly)
+	}
+	if c.AttestationBucket != "" {
+		problems.addf("advanced.attestation_bucket", "attestation-bucket records the provenance of the created image, and output type %s creates none", OutputDiskOnly)
+	}
+	if len(c.ImageLicenses) > 0 {
+		problems.addf("disk.licenses", "image licenses apply to the created image, and output type %s creates none", OutputDiskOnly)
+	}
+}
+
+func (c *Config) validateModeSpecificFields(problems *ValidationErrors) {
 	if c.IsRemoteMode() {
 		if c.Zone == "" {
-			return fmt.Errorf("zone is required for remote mode (use --zone or 'execution.zone' in config file)")
+			problems.addf("execution.zone", "zone is required for remote mode (use --zone or 'execution.zone' in config file)")
 		}
 	}
 
 	if c.IsLocalMode() {
+		if c.Offline {
+			c.skip("GCP VM detection for local mode")
+			if c.Zone == "" {
+				c.skip("zone auto-detection for local mode")
+			}
+			if c.ProjectName != "" {
+				c.skip("check that the project is this VM's project")
+			}
+			return
+		}
 		if !isRunningOnGCP() {
-			return fmt.Errorf("local mode (-L) requires execution on a GCP VM instance")
+			problems.addf("execution.mode", "%s %w", c.modeName(), ErrNotOnGCP)
+			return
 		}
 		// Auto-detect zone if not specified
 		if c.Zone == "" {
 			zone, err := getCurrentVMZone()
 			if err != nil {
-				return fmt.Errorf("failed to auto-detect zone in local mode: %w", err)
+				problems.addf("execution.zone", "failed to auto-detect zone in %s: %w", c.modeName(), err)
+			} else {
+				c.Zone = zone
 			}
-			c.Zone = zone
+		}
+		if c.ProjectName != "" {
+			problems.add("project.name", c.validateLocalProject())
 		}
 	}
+}
 
+// validateLocalProject refuses local builds for another project than this VM's:
+// local mode attaches the cache disk to this VM, and Compute Engine only
+// attaches disks to instances of the disk's own project
+func (c *Config) validateLocalProject() error {
+	project, err := gcp.QueryMetadata("project/project-id")
+	if err != nil {
+		return fmt.Errorf("failed to detect this VM's project in %s: %w", c.modeName(), err)
+	}
+	if c.ProjectName != project {
+		return fmt.Errorf("%s %w '%s', not '%s': the cache disk is attached to this VM, "+
+			"and disks can only be attached to VMs of their own project. Use --project-name=%s, or remote mode (-R) to build in '%s'",
+			c.modeName(), ErrOtherProject, project, c.ProjectName, project, c.ProjectName)
+	}
 	return nil
 }
 
-func (c *Config) validateOptionalFields() error {
+func (c *Config) validateOptionalFields(problems *ValidationErrors) {
 	if c.DiskSizeGB < 10 || c.DiskSizeGB > 1000 {
-		return fmt.Errorf("disk-size must be between 10 and 1000 GB (use --disk-size or 'disk.size_gb' in config file)")
+		problems.addf("disk.size_gb", "disk-size must be between 10 and 1000 GB (use --disk-size or 'disk.size_gb' in config file)")
 	}
 
 	if c.Timeout < time.Minute {
-		return fmt.Errorf("timeout must be at least 1 minute (use --timeout or 'advanced.timeout' in config file)")
+		problems.addf("advanced.timeout", "timeout must be at least 1 minute (use --timeout or 'advanced.timeout' in config file)")
+	}
+
+	if c.RetryBudget < 1 {
+		problems.addf("advanced.retry_budget", "retry-budget must be at least 1 (use --retry-budget or 'advanced.retry_budget' in config file)")
+	}
+
+	if c.PullTimeout != 0 && c.PullTimeout < time.Minute {
+		problems.addf("advanced.pull_timeout", "pull-timeout must be at least 1 minute (use --pull-timeout or 'advanced.pull_timeout' in config file)")
+	}
+
+	if c.CleanupTimeout < 30*time.Second {
+		problems.addf("advanced.cleanup_timeout", "cleanup-timeout must be at least 30 seconds (use --cleanup-timeout or 'advanced.cleanup_timeout' in config file)")
+	}
+
+	if c.MaxTimeoutExtension < 0 {
+		problems.addf("advanced.max_timeout_extension", "max-timeout-extension must not be negative (use --max-timeout-extension or 'advanced.max_timeout_extension' in config file)")
+	}
+
+	if c.MinPullThroughput < 0 {
+		problems.addf("advanced.min_pull_throughput", "min-pull-throughput must not be negative (use --min-pull-throughput or 'advanced.min_pull_throughput' in config file)")
+	}
+
+	c.validateTuning(problems)
+
+	if c.SerialPort < 1 || c.SerialPort > 4 {
+		problems.addf("advanced.serial_port", "serial-port must be between 1 and 4, got %d (use --serial-port or 'advanced.serial_port' in config file)", c.SerialPort)
+	}
+
+	validateLabels(problems, "disk.labels", c.DiskLabels, "invalid disk label: %w (check --disk-labels or 'disk.labels' in config file)")
+	validateLabels(problems, "advanced.vm_labels", c.VMLabels, "invalid VM label: %w (check --vm-labels or 'advanced.vm_labels' in config file)")
+	c.validateFamilyAliases(problems)
+
+	if c.Partitions < 1 || c.Partitions > maxPartitions {
+		problems.addf("advanced.partitions", "partitions must be between 1 and %d (use --partitions or 'advanced.partitions' in config file)", maxPartitions)
+	} else if c.Partitions > 1 && c.notRemote() {
+		problems.addf("advanced.partitions", "partitions > 1 requires remote mode (-R): each partition is built on its own VM")
 	}
 
 	// Validate container image formats
 	for i, image := range c.ContainerImages {
 		if err := validateContainerImage(image); err != nil {
-			return fmt.Errorf("invalid container image #%d '%s': %w (check --container-image or 'images' list in config file)", i+1, image, err)
+			problems.addf(fmt.Sprintf("images[%d]", i), "invalid container image #%d '%s': %w (check --container-image or 'images' list in config file)", i+1, image, err)
+		}
+	}
+
+	// Validate extra pull arguments; they end up in a shell command on the build VM
+	for i, arg := range c.PullArgs {
+		if err := validatePullArg(arg); err != nil {
+			problems.addf(fmt.Sprintf("advanced.pull_args[%d]", i), "invalid pull argument '%s': %w (check --pull-arg or 'advanced.pull_args' in config file)", arg, err)
 		}
 	}
 
+	// Arm caches are built on an Arm VM unless a machine type was chosen
+	if c.IsARM64() && c.MachineType == defaultMachineType {
+		c.MachineType = defaultArmMachineType
+	}
+
 	// Validate machine type
 	if err := validateMachineType(c.MachineType); err != nil {
-		return fmt.Errorf("invalid machine type '%s': %w (use --machine-type or 'advanced.machine_type' in config file)", c.MachineType, err)
+		problems.addf("advanced.machine_type", "invalid machine type '%s': %w (use --machine-type or 'advanced.machine_type' in config file)", c.MachineType, err)
+	}
+
+	if (c.NoExternalIP || c.ShieldedVM) && c.notRemote() {
+		field := "network.no_external_ip"
+		if !c.NoExternalIP {
+			field = "advanced.shielded_vm"
+		}
+		problems.addf(field, "--no-external-ip and --shielded-vm configure the build VM and require remote mode (-R)")
+	}
+	c.validatePreemptible(problems)
+
+	if c.NoExternalIP && c.SSHAddressType == SSHAddressExternal {
+		problems.addf("advanced.ssh_address_type", "the build VM has no external IP with --no-external-ip; drop --ssh-address-type=external")
+	}
+
+	if c.BuildVMImage != "" {
+		if c.notRemote() {
+			problems.addf("advanced.build_vm_image", "build VM image requires remote mode (-R): local mode builds on this machine")
+		} else if _, err := gcp.ParseImagePath(c.BuildVMImage); err != nil {
+			problems.addf("advanced.build_vm_image", "invalid build VM image: %w (use --build-vm-image or 'advanced.build_vm_image' in config file)", err)
+		}
+	}
+
+	if c.BuildVMImageVersion != "" {
+		if c.notRemote() {
+			problems.addf("advanced.build_vm_image_version", "build VM image version requires remote mode (-R): local mode builds on this machine")
+		} else if !gcp.IsImageName(c.BuildVMImageVersion) {
+			problems.addf("advanced.build_vm_image_version", "invalid build VM image version '%s': expected an image name such as ubuntu-2204-jammy-v20240126 (use --build-vm-image-version or 'advanced.build_vm_image_version' in config file)", c.BuildVMImageVersion)
+		} else if path, err := gcp.ParseImagePath(c.BuildVMImage); err == nil && !path.Family {
+			problems.addf("advanced.build_vm_image_version", "build VM image %s already names an exact image; a version only applies to an image family", c.BuildVMImage)
+		}
+	}
+
+	if c.PostPullCommand != "" {
+		if c.IsWindows() {
+			problems.addf("advanced.post_pull_command", "post-pull command is not supported for Windows builds: the Windows build VM is driven through its startup script")
+		} else if strings.TrimSpace(c.PostPullCommand) == "" || strings.ContainsRune(c.PostPullCommand, 0) {
+			problems.addf("advanced.post_pull_command", "post-pull command must be a non-empty bash script (use --post-pull-command or 'advanced.post_pull_command' in config file)")
+		}
+	}
+
+	c.validateHooks(problems)
+	c.validateAdditionalStartupScripts(problems)
+
+	for i, license := range c.ImageLicenses {
+		if _, err := gcp.ParseLicensePath(license); err != nil {
+			problems.addf(fmt.Sprintf("disk.licenses[%d]", i), "invalid image license: %w (use --image-license or 'disk.licenses' in config file)", err)
+		}
+	}
+
+	if err := validateBucketName(c.ConfigSnapshotBucket); err != nil {
+		problems.addf("advanced.config_snapshot_bucket", "invalid config snapshot bucket '%s': %w (use --config-snapshot-bucket or 'advanced.config_snapshot_bucket' in config file)", c.ConfigSnapshotBucket, err)
+	}
+
+	c.validateAttestation(problems)
+
+	problems.add("advanced.api_endpoint", c.validateAPIEndpoint())
+
+	if c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		problems.addf("logging.format", "invalid log format '%s': supported formats: %s, %s (use --log-format or 'logging.format' in config file)", c.LogFormat, LogFormatText, LogFormatJSON)
+	}
+
+	if c.ImageRefFormat != ImageRefSelfLink && c.ImageRefFormat != ImageRefName {
+		problems.addf("advanced.image_ref_format", "invalid image ref format '%s': supported formats: %s, %s (use --image-ref-format or 'advanced.image_ref_format' in config file)", c.ImageRefFormat, ImageRefSelfLink, ImageRefName)
+	}
+
+	if c.NodePoolConfigFormat != NodePoolConfigGcloud && c.NodePoolConfigFormat != NodePoolConfigTerraform {
+		problems.addf("advanced.node_pool_config_format", "invalid node pool config format '%s': supported formats: %s, %s (use --node-pool-config-format or 'advanced.node_pool_config_format' in config file)", c.NodePoolConfigFormat, NodePoolConfigGcloud, NodePoolConfigTerraform)
+	}
+
+	c.validateOutputType(problems)
+	c.validateImageForceCreate(problems)
+	c.validateSmokeTest(problems)
+	c.validateCloudLogging(problems)
+
+	if c.ApprovedDigests != "" {
+		if _, err := os.Stat(c.ApprovedDigests); err != nil {
+			problems.addf("advanced.approved_digests", "cannot read approved digests: %w (check --approved-digests or 'advanced.approved_digests' in config file)", err)
+		}
 	}
 
 	// Validate disk type
 	if err := validateDiskType(c.DiskType); err != nil {
-		return fmt.Errorf("invalid disk type '%s': %w (use --disk-type or 'disk.disk_type' in config file)", c.DiskType, err)
+		problems.addf("disk.disk_type", "invalid disk type '%s': %w (use --disk-type or 'disk.disk_type' in config file)", c.DiskType, err)
 	}
 
-	// Validate image pull auth
-	if err := validateImagePullAuth(c.ImagePullAuth); err != nil {
-		return fmt.Errorf("invalid image pull auth '%s': %w (use --image-pull-auth or 'auth.image_pull_auth' in config file)", c.ImagePullAuth, err)
+	// Validate node OS
+	if err := validateOSType(c.OSType); err != nil {
+		problems.addf("disk.os_type", "invalid os type '%s': %w (use --os-type or 'disk.os_type' in config file)", c.OSType, err)
+	}
+	if c.IsWindows() && c.notRemote() {
+		problems.addf("disk.os_type", "os type windows requires remote mode (-R): Windows caches are built on a Windows Server VM")
 	}
 
-	return nil
+	c.validateArchitecture(problems)
+
+	if err := validateSnapshotter(c.Snapshotter); err != nil {
+		problems.addf("disk.snapshotter", "invalid snapshotter '%s': %w (use --snapshotter or 'disk.snapshotter' in config file)", c.Snapshotter, err)
+	} else if c.IsWindows() && c.Snapshotter != SnapshotterOverlayfs {
+		problems.addf("disk.snapshotter", "--snapshotter applies to Linux caches only: Windows nodes always use the windows snapshotter")
+	}
+
+	c.validateNodeImageType(problems)
+	c.checkPreinstalledImages(problems)
+
+	c.validateContainerd(problems)
+	c.validateSSH(problems)
+
+	if c.IsWindows() && (c.VerifyNoLayersMissing || c.VerifyLayerDigests) {
+		field := "advanced.verify_no_layers_missing"
+		if !c.VerifyNoLayersMissing {
+			field = "advanced.verify_layer_digests"
+		}
+		problems.addf(field, "layer verification is not supported for os type windows: Windows build VMs are not reachable over SSH")
+	}
+	c.validateParallelVerify(problems)
+	c.validatePullConcurrency(problems)
+
+	c.validateImagePullAuth(problems)
+	c.validateCredentialProviders(problems)
+}
+
+// unsupportedPullAuth explains why pull auth mechanisms other tools offer cannot work here
+var unsupportedPullAuth = map[string]string{
+	"DockerConfig": "ctr pulls as root, on the build VM in remote mode, and never reads this machine's ~/.docker/config.json",
+	"BasicAuth":    "registry usernames and passwords are never passed to ctr, so pulls would run unauthenticated",
+}
+
+// validateImagePullAuth checks that the image pull auth can work in the
+// execution mode, before a build VM is created for it
+func (c *Config) validateImagePullAuth(problems *ValidationErrors) {
+	const field = "auth.image_pull_auth"
+	if reason, ok := unsupportedPullAuth[c.ImagePullAuth]; ok {
+		problems.addf(field, "image pull auth %s cannot work: %s; use ServiceAccountToken for Artifact Registry and Container Registry, or None for public images (use --image-pull-auth or '%s' in config file)",
+			c.ImagePullAuth, reason, field)
+		return
+	}
+	if err := validateImagePullAuth(c.ImagePullAuth); err != nil {
+		problems.addf(field, "invalid image pull auth '%s': %w (use --image-pull-auth or '%s' in config file)", c.ImagePullAuth, err, field)
+		return
+	}
+	// The build VM reaches the registries as its own service account, not with this tool's credentials
+	if c.ImagePullAuth == "ServiceAccountToken" && c.IsRemoteMode() && (c.ServiceAccount == "" || strings.EqualFold(c.ServiceAccount, "none")) {
+		problems.addf(field, "image pull auth ServiceAccountToken in remote mode needs a service account on the build VM, which pulls with its own credentials; set one with read access to the registries (use --service-account or 'auth.service_account' in config file)")
+	}
 }
 
 func validateContainerImage(image string) error {
@@ -122,13 +579,30 @@ func validateContainerImage(image string) error {
 	return nil
 }
 
+// pullArgPattern allows flags and values (e.g. --label=team=ml, --snapshotter=overlayfs)
+// but no whitespace, quotes or shell metacharacters
+var pullArgPattern = regexp.MustCompile(`^-{0,2}[A-Za-z0-9][A-Za-z0-9_.,:/@+=-]*$`)
+
+func validatePullArg(arg string) error {
+	if !pullArgPattern.MatchString(arg) {
+		return fmt.Errorf("only letters, digits and _ . , : / @ + = - are allowed")
+	}
+	return nil
+}
+
 func validateMachineType(machineType string) error {
+	if machineType == MachineTypeAuto {
+		return nil
+	}
+
 	validTypes := []string{
 		"e2-standard-2", "e2-standard-4", "e2-standard-8", "e2-standard-16",
 		"e2-highmem-2", "e2-highmem-4", "e2-highmem-8", "e2-highmem-16",
 		"e2-highcpu-2", "e2-highcpu-4", "e2-highcpu-8", "e2-highcpu-16",
 		"n1-standard-1", "n1-standard-2", "n1-standard-4", "n1-standard-8",
 		"n2-standard-2", "n2-standard-4", "n2-standard-8", "n2-standard-16",
+		"t2a-standard-1", "t2a-standard-2", "t2a-standard-4", "t2a-standard-8", "t2a-standard-16",
+		"c4a-standard-4", "c4a-standard-8", "c4a-standard-16",
 	}
 
 	for _, valid := range validTypes {
@@ -152,6 +626,375 @@ func validateDiskType(diskType string) error {
 	return fmt.Errorf("unsupported disk type, supported types: %s", strings.Join(validTypes, ", "))
 }
 
+// GCP label keys start with a lowercase letter; keys and values are at most 63
+// lowercase letters, digits, underscores and hyphens
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// maxLabels is the most labels one option may set. The build checks the
+// labels each resource ends up with, the builder's own included, against
+// Compute Engine's limit (gcp.MaxLabels).
+const maxLabels = 60
+
+// validateLabels records a problem with field.<key> for each invalid label, in
+// key order; format wraps the label's error
+func validateLabels(problems *ValidationErrors, field string, labels map[string]string, format string) {
+	if len(labels) > maxLabels {
+		problems.addf(field, format, fmt.Errorf("too many labels (%d), at most %d are allowed", len(labels), maxLabels))
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := validateLabel(key, labels[key]); err != nil {
+			problems.addf(field+"."+key, format, err)
+		}
+	}
+}
+
+func validateLabel(key, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("key '%s' must start with a lowercase letter and contain at most 63 lowercase letters, digits, '_' or '-'", key)
+	}
+	if !labelValuePattern.MatchString(value) {
+		return fmt.Errorf("value '%s' of '%s' must contain at most 63 lowercase letters, digits, '_' or '-'", value, key)
+	}
+	return nil
+}
+
+// validateArchitecture ensures the cache is built on a machine of the same
+// architecture, since the setup script and containerd run natively there
+func (c *Config) validateArchitecture(problems *ValidationErrors) {
+	switch c.Arch {
+	case ArchX86_64, ArchARM64:
+	default:
+		problems.addf("disk.architecture", "invalid disk architecture '%s': supported architectures: %s, %s (use --disk-architecture or 'disk.architecture' in config file)",
+			c.Arch, ArchX86_64, ArchARM64)
+		return
+	}
+
+	if c.IsARM64() && c.IsWindows() {
+		problems.addf("disk.architecture", "disk architecture arm64 is not supported for os type windows")
+		return
+	}
+
+	if c.IsRemoteMode() && c.MachineType != MachineTypeAuto && c.IsARM64() != isArmMachineType(c.MachineType) {
+		want := "an x86"
+		if c.IsARM64() {
+			want = "an Arm (t2a, c4a)"
+		}
+		problems.addf("advanced.machine_type", "machine type '%s' does not match disk architecture %s: the cache must be built on %s build VM (use --machine-type or 'advanced.machine_type' in config file)",
+			c.MachineType, c.Arch, want)
+	}
+
+	if c.IsLocalMode() && c.IsARM64() != (runtime.GOARCH == "arm64") {
+		problems.addf("disk.architecture", "%s builds for this machine's architecture (%s), not disk architecture %s; use remote mode (-R) to build on a matching VM",
+			c.modeName(), runtime.GOARCH, c.Arch)
+	}
+}
+
+// isArmMachineType reports whether a machine type belongs to an Arm (Ampere/Axion) family
+func isArmMachineType(machineType string) bool {
+	return strings.HasPrefix(machineType, "t2a-") || strings.HasPrefix(machineType, "c4a-")
+}
+
+func (c *Config) validateSSH(problems *ValidationErrors) {
+	switch c.SSHAddressType {
+	case SSHAddressAuto, SSHAddressInternal, SSHAddressExternal:
+	default:
+		problems.addf("advanced.ssh_address_type", "invalid SSH address type '%s': supported types: %s, %s, %s (use --ssh-address-type or 'advanced.ssh_address_type' in config file)",
+			c.SSHAddressType, SSHAddressAuto, SSHAddressInternal, SSHAddressExternal)
+	}
+
+	if c.SSHProxyJump != "" {
+		problems.add("advanced.ssh_proxy_jump", c.validateProxyJump())
+	}
+}
+
+// ValidateVerify checks the options used by verify-image, which inspects an
+// existing cache image (DiskImageName) instead of building one
+func (c *Config) ValidateVerify() error {
+	var problems ValidationErrors
+	if err := c.validateExecutionMode(); err != nil {
+		problems.add("execution.mode", err)
+	}
+	if c.ProjectName == "" {
+		problems.addf("project.name", "project-name is required (use --project-name)")
+	}
+	if c.DiskImageName == "" {
+		problems.addf("disk.name", "image is required (use --image)")
+	}
+	c.validateModeSpecificFields(&problems)
+	if c.Timeout < time.Minute {
+		problems.addf("advanced.timeout", "timeout must be at least 1 minute (use --timeout)")
+	}
+	if c.NoExternalIP && c.notRemote() {
+		problems.addf("network.no_external_ip", "--no-external-ip configures the verification VM and requires remote mode (-R)")
+	}
+	if c.NoExternalIP && c.SSHAddressType == SSHAddressExternal {
+		problems.addf("advanced.ssh_address_type", "the verification VM has no external IP with --no-external-ip; drop --ssh-address-type=external")
+	}
+	c.validateSSH(&problems)
+	c.validateAdditionalStartupScripts(&problems)
+	c.validateParallelVerify(&problems)
+	problems.add("advanced.api_endpoint", c.validateAPIEndpoint())
+	c.validateSecretURIs(&problems)
+	return problems.err()
+}
+
+// ValidateWatch checks the options used by watch, which finishes a remote
+// build started by another invocation
+func (c *Config) ValidateWatch() error {
+	var problems ValidationErrors
+	if c.ProjectName == "" {
+		problems.addf("project.name", "project-name is required (use --project-name)")
+	}
+	if c.Timeout < time.Minute {
+		problems.addf("advanced.timeout", "timeout must be at least 1 minute (use --timeout)")
+	}
+	if c.CleanupTimeout < 30*time.Second {
+		problems.addf("advanced.cleanup_timeout", "cleanup-timeout must be at least 30 seconds (use --cleanup-timeout)")
+	}
+	problems.add("advanced.api_endpoint", c.validateAPIEndpoint())
+	return problems.err()
+}
+
+// ValidateCleanup checks the options used by cleanup, which recovers the
+// disks crashed local-mode runs left attached to this VM
+func (c *Config) ValidateCleanup() error {
+	var problems ValidationErrors
+	if c.ProjectName == "" {
+		problems.addf("project.name", "project-name is required (use --project-name) when this machine is not a GCP VM")
+	}
+	if c.Timeout < time.Minute {
+		problems.addf("advanced.timeout", "timeout must be at least 1 minute (use --timeout)")
+	}
+	problems.add("advanced.api_endpoint", c.validateAPIEndpoint())
+	return problems.err()
+}
+
+func (c *Config) validatePullConcurrency(problems *ValidationErrors) {
+	if c.PullConcurrency < 0 {
+		problems.addf("advanced.pull_concurrency", "pull-concurrency must be 0 (auto) or more (use --pull-concurrency or 'advanced.pull_concurrency' in config file)")
+	}
+}
+
+func (c *Config) validateParallelVerify(problems *ValidationErrors) {
+	if c.ParallelVerify < 1 || c.ParallelVerify > maxParallelVerify {
+		problems.addf("advanced.parallel_verify", "parallel-verify must be between 1 and %d (use --parallel-verify or 'advanced.parallel_verify' in config file)", maxParallelVerify)
+	}
+}
+
+func (c *Config) validateAttestation(problems *ValidationErrors) {
+	if err := validateBucketName(c.AttestationBucket); err != nil {
+		problems.addf("advanced.attestation_bucket", "invalid attestation bucket '%s': %w (use --attestation-bucket or 'advanced.attestation_bucket' in config file)", c.AttestationBucket, err)
+	}
+	if c.AttestationKMSKey != "" {
+		if err := gcp.ValidateKMSKeyVersion(c.AttestationKMSKey); err != nil {
+			problems.addf("advanced.attestation_kms_key", "invalid attestation KMS key: %w (use --attestation-kms-key or 'advanced.attestation_kms_key' in config file)", err)
+		}
+		if c.AttestationKeyFile != "" {
+			problems.addf("auth.attestation_key_file", "--attestation-kms-key and --attestation-key-file both sign the provenance; choose one")
+		}
+	}
+	if (c.AttestationKMSKey != "" || c.AttestationKeyFile != "") && c.AttestationBucket == "" {
+		field := "advanced.attestation_kms_key"
+		if c.AttestationKMSKey == "" {
+			field = "auth.attestation_key_file"
+		}
+		problems.addf(field, "signing the provenance requires a bucket to store it in (use --attestation-bucket or 'advanced.attestation_bucket' in config file)")
+	}
+}
+
+func (c *Config) validateAPIEndpoint() error {
+	if c.APIEndpoint == "" {
+		return nil
+	}
+	if _, err := gcp.ComputeEndpoint(c.APIEndpoint); err != nil {
+		return fmt.Errorf("invalid API endpoint: %w (use --api-endpoint or 'advanced.api_endpoint' in config file)", err)
+	}
+	return nil
+}
+
+// ParseProxyJump splits a "[user@]host[:port]" bastion spec. The user defaults
+// to the local user and the port to 22.
+func ParseProxyJump(spec string) (username, addr string, err error) {
+	host := spec
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		username, host = spec[:i], spec[i+1:]
+		if username == "" {
+			return "", "", fmt.Errorf("empty user in %q", spec)
+		}
+	} else {
+		current, err := user.Current()
+		if err != nil {
+			return "", "", fmt.Errorf("no user in %q and the local user is unknown: %w", spec, err)
+		}
+		username = current.Username
+	}
+
+	port := "22"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	if host == "" || strings.ContainsAny(host, " /") {
+		return "", "", fmt.Errorf("invalid host in %q, expected user@host[:port]", spec)
+	}
+	return username, net.JoinHostPort(host, port), nil
+}
+
+func (c *Config) validateProxyJump() error {
+	if _, _, err := ParseProxyJump(c.SSHProxyJump); err != nil {
+		return fmt.Errorf("invalid SSH proxy jump: %w (use --ssh-proxy-jump or 'advanced.ssh_proxy_jump' in config file)", err)
+	}
+	if c.notRemote() || c.IsWindows() {
+		return fmt.Errorf("SSH proxy jump only applies to Linux builds in remote mode (-R)")
+	}
+	if c.SSHAddressType == SSHAddressExternal {
+		return fmt.Errorf("SSH proxy jump always reaches the build VM on its internal IP; drop --ssh-address-type=external")
+	}
+	if c.SSHProxyJumpKeyFile == "" && c.SSHKeyFile == "" {
+		return fmt.Errorf("SSH proxy jump needs a key the bastion accepts; the per-build ephemeral key is unknown to it (use --ssh-proxy-jump-key-file or --ssh-key-file)")
+	}
+	return nil
+}
+
+var (
+	containerdVersionPattern = regexp.MustCompile(`^1\.([0-9]+)\.[0-9]+$`)
+	gkeVersionPattern        = regexp.MustCompile(`^1\.[0-9]+(\.[0-9]+(-gke\.[0-9]+)?)?$`)
+)
+
+// minContainerdMinor is the oldest containerd 1.x release the setup script can install and configure
+const minContainerdMinor = 6
+
+func (c *Config) validateContainerd(problems *ValidationErrors) {
+	if c.ContainerdVersion == "" && c.GKEVersion == "" {
+		return
+	}
+	if c.notRemote() || c.IsWindows() {
+		field := "advanced.containerd_version"
+		if c.ContainerdVersion == "" {
+			field = "advanced.gke_version"
+		}
+		problems.addf(field, "--containerd-version and --gke-version apply to Linux builds in remote mode (-R)")
+		return
+	}
+
+	if c.ContainerdVersion != "" {
+		match := containerdVersionPattern.FindStringSubmatch(c.ContainerdVersion)
+		if match == nil {
+			problems.addf("advanced.containerd_version", "invalid containerd version '%s': expected a release such as 1.7.13 (use --containerd-version or 'advanced.containerd_version' in config file)", c.ContainerdVersion)
+		} else if minor, _ := strconv.Atoi(match[1]); minor < minContainerdMinor {
+			problems.addf("advanced.containerd_version", "invalid containerd version '%s': releases older than 1.%d are not supported (use --containerd-version or 'advanced.containerd_version' in config file)", c.ContainerdVersion, minContainerdMinor)
+		}
+	}
+
+	if c.GKEVersion != "" && !gkeVersionPattern.MatchString(c.GKEVersion) {
+		problems.addf("advanced.gke_version", "invalid GKE version '%s': expected a minor version such as 1.29 (use --gke-version or 'advanced.gke_version' in config file)", c.GKEVersion)
+	}
+}
+
+// maxAdditionalStartupScriptBytes keeps the startup-script metadata value,
+// limited to 256 KB, with room for the bootstrap script itself
+const maxAdditionalStartupScriptBytes = 200 << 10
+
+func (c *Config) validateAdditionalStartupScripts(problems *ValidationErrors) {
+	if c.AdditionalStartupScriptsOrder != StartupScriptsBefore && c.AdditionalStartupScriptsOrder != StartupScriptsAfter {
+		problems.addf("advanced.additional_startup_scripts_order", "invalid additional startup scripts order '%s': supported orders: %s, %s (use --additional-startup-scripts-order or 'advanced.additional_startup_scripts_order' in config file)",
+			c.AdditionalStartupScriptsOrder, StartupScriptsBefore, StartupScriptsAfter)
+	}
+	if len(c.AdditionalStartupScripts) == 0 {
+		return
+	}
+	if c.notRemote() || c.IsWindows() {
+		problems.addf("advanced.additional_startup_scripts", "additional startup scripts apply to Linux build VMs in remote mode (-R)")
+		return
+	}
+
+	var total int64
+	for i, path := range c.AdditionalStartupScripts {
+		field := fmt.Sprintf("advanced.additional_startup_scripts[%d]", i)
+		info, err := os.Stat(path)
+		if err != nil {
+			problems.addf(field, "cannot read additional startup script: %w (check --additional-startup-script or 'advanced.additional_startup_scripts' in config file)", err)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			problems.addf(field, "additional startup script %s is not a regular file", path)
+			continue
+		}
+		total += info.Size()
+	}
+	if total > maxAdditionalStartupScriptBytes {
+		problems.addf("advanced.additional_startup_scripts", "additional startup scripts total %d KB, more than the %d KB that fit in the startup-script metadata; have a script download the rest",
+			total>>10, maxAdditionalStartupScriptBytes>>10)
+	}
+}
+
+func validateOSType(osType string) error {
+	validTypes := []string{OSLinux, OSWindows}
+
+	for _, valid := range validTypes {
+		if osType == valid {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported os type, supported types: %s", strings.Join(validTypes, ", "))
+}
+
+func validateSnapshotter(snapshotter string) error {
+	validTypes := []string{SnapshotterOverlayfs, SnapshotterNative, SnapshotterStargz}
+
+	for _, valid := range validTypes {
+		if snapshotter == valid {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported snapshotter, supported snapshotters: %s", strings.Join(validTypes, ", "))
+}
+
+// validateNodeImageType accepts the node image types case-insensitively, and
+// GKE's names for them (COS_CONTAINERD, UBUNTU_CONTAINERD), storing the short form
+func (c *Config) validateNodeImageType(problems *ValidationErrors) {
+	if c.NodeImageType == "" {
+		return
+	}
+	nodeImageType := strings.TrimSuffix(strings.ToUpper(c.NodeImageType), "_CONTAINERD")
+	switch {
+	case nodeImageType != NodeImageCOS && nodeImageType != NodeImageUbuntu:
+		problems.addf("disk.node_image_type", "invalid node image type '%s': supported types: %s, %s (use --node-image-type or 'disk.node_image_type' in config file)",
+			c.NodeImageType, NodeImageCOS, NodeImageUbuntu)
+	case c.IsWindows():
+		problems.addf("disk.node_image_type", "--node-image-type applies to Linux caches only: Windows node pools run Windows Server images")
+	default:
+		c.NodeImageType = nodeImageType
+	}
+}
+
+// bucketNamePattern matches bucket names that fit in a label value (no dotted, domain-named buckets)
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,61}[a-z0-9]$`)
+
+func validateBucketName(bucket string) error {
+	if bucket == "" {
+		return nil
+	}
+	if strings.HasPrefix(bucket, "gs://") {
+		return fmt.Errorf("give the bucket name without gs://")
+	}
+	if !bucketNamePattern.MatchString(bucket) {
+		return fmt.Errorf("must be 3-63 lowercase letters, digits, hyphens and underscores; it is recorded in an image label, so dotted bucket names are not supported")
+	}
+	return nil
+}
+
 func validateImagePullAuth(authType string) error {
 	validTypes := []string{"None", "ServiceAccountToken"}
 
@@ -166,12 +1009,16 @@ func validateImagePullAuth(authType string) error {
 
 // isRunningOnGCP checks if the current environment is a GCP VM
 func isRunningOnGCP() bool {
-	// This would implement actual GCP metadata server check
-	return true
+	_, err := gcp.QueryMetadata("instance/id")
+	return err == nil
 }
 
 // getCurrentVMZone gets the zone of the current GCP VM
 func getCurrentVMZone() (string, error) {
-	// This would implement actual GCP metadata server query
-	return "us-west1-b", nil
+	// Returned as projects/<number>/zones/<zone>
+	zone, err := gcp.QueryMetadata("instance/zone")
+	if err != nil {
+		return "", err
+	}
+	return zone[strings.LastIndex(zone, "/")+1:], nil
 }
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeMetadataServer serves the metadata of a VM of project vm-project in
+// zone us-central1-a to this process, as the GCE metadata server would
+func fakeMetadataServer(t *testing.T) {
+	t.Helper()
+	values := map[string]string{
+		"instance/id":        "1234",
+		"instance/zone":      "projects/42/zones/us-central1-a",
+		"project/project-id": "vm-project",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, ok := values[strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")]
+		if !ok || r.Header.Get("Metadata-Flavor") != "Google" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Write([]byte(value))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+}
+
+func TestLocalModeProject(t *testing.T) {
+	fakeMetadataServer(t)
+
+	tests := []struct {
+		name    string
+		project string
+		wantErr bool
+	}{
+		{name: "this VM's project", project: "vm-project"},
+		{name: "another project", project: "other-project", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig()
+			c.SetMode(ModeLocal, "-L")
+			c.ProjectName = tt.project
+
+			var problems ValidationErrors
+			c.validateModeSpecificFields(&problems)
+			if c.Zone != "us-central1-a" {
+				t.Errorf("Zone = %q, want the detected us-central1-a", c.Zone)
+			}
+			if !tt.wantErr {
+				if len(problems) != 0 {
+					t.Errorf("problems = %v, want none", problems)
+				}
+				return
+			}
+
+			if len(problems) != 1 {
+				t.Fatalf("problems = %v, want one", problems)
+			}
+			problem := problems[0]
+			if problem.Field != "project.name" || !errors.Is(problem, ErrOtherProject) {
+				t.Errorf("problem = %s: %v, want project.name: %v", problem.Field, problem, ErrOtherProject)
+			}
+			for _, want := range []string{"local mode (-L)", "'vm-project'", "'other-project'", "--project-name=vm-project", "remote mode (-R)"} {
+				if !strings.Contains(problem.Error(), want) {
+					t.Errorf("problem %q does not mention %q", problem, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalModeOffGCP(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	c := NewConfig()
+	c.SetMode(ModeLocal, "-L")
+	c.ProjectName = "other-project"
+
+	var problems ValidationErrors
+	c.validateModeSpecificFields(&problems)
+	if len(problems) != 1 || problems[0].Field != "execution.mode" || !errors.Is(problems[0], ErrNotOnGCP) {
+		t.Errorf("problems = %v, want execution.mode: %v", problems, ErrNotOnGCP)
+	}
+}
+
+// TestModeResolution loads a config file after the command line set the mode,
+// or did not, for every combination of the two. The environment checks follow
+// the effective mode alone, and name where it was set.
+func TestModeResolution(t *testing.T) {
+	// Off GCP: local mode fails its environment check
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	tests := []struct {
+		flag     string // -L, -R or none
+		yamlMode string // execution.mode, or none
+		want     ExecutionMode
+		wantName string
+	}{
+		{want: ModeUnspecified, wantName: "remote mode"},
+		{yamlMode: "local", want: ModeLocal, wantName: "local mode ('execution.mode' in build.yaml)"},
+		{yamlMode: "remote", want: ModeRemote, wantName: "remote mode ('execution.mode' in build.yaml)"},
+		{flag: "-L", want: ModeLocal, wantName: "local mode (-L)"},
+		{flag: "-L", yamlMode: "local", want: ModeLocal, wantName: "local mode (-L)"},
+		{flag: "-L", yamlMode: "remote", want: ModeLocal, wantName: "local mode (-L)"},
+		{flag: "-R", want: ModeRemote, wantName: "remote mode (-R)"},
+		{flag: "-R", yamlMode: "local", want: ModeRemote, wantName: "remote mode (-R)"},
+		{flag: "-R", yamlMode: "remote", want: ModeRemote, wantName: "remote mode (-R)"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("flag %q, file %q", tt.flag, tt.yamlMode), func(t *testing.T) {
+			c := NewConfig()
+			switch tt.flag {
+			case "-L":
+				c.SetMode(ModeLocal, "-L")
+			case "-R":
+				c.SetMode(ModeRemote, "-R")
+			}
+			yaml := "project:\n  name: p\n"
+			if tt.yamlMode != "" {
+				yaml += "execution:\n  mode: " + tt.yamlMode + "\n"
+			}
+			if err := c.LoadFromYAMLData([]byte(yaml), "build.yaml"); err != nil {
+				t.Fatalf("LoadFromYAMLData() error = %v", err)
+			}
+
+			if c.Mode != tt.want || c.modeName() != tt.wantName {
+				t.Fatalf("mode = %v, %q, want %v, %q", c.Mode, c.modeName(), tt.want, tt.wantName)
+			}
+
+			var problems ValidationErrors
+			problems.add("execution.mode", c.validateExecutionMode())
+			c.validateModeSpecificFields(&problems)
+			if len(problems) != 1 {
+				t.Fatalf("problems = %v, want one", problems)
+			}
+			problem := problems[0]
+			switch tt.want {
+			case ModeUnspecified:
+				if problem.Field != "execution.mode" || !strings.Contains(problem.Error(), "execution mode required") {
+					t.Errorf("problem = %s: %v, want the mode required", problem.Field, problem)
+				}
+			case ModeLocal:
+				if problem.Field != "execution.mode" || !errors.Is(problem, ErrNotOnGCP) || !strings.HasPrefix(problem.Error(), tt.wantName+" ") {
+					t.Errorf("problem = %s: %v, want %s %v", problem.Field, problem, tt.wantName, ErrNotOnGCP)
+				}
+			case ModeRemote:
+				// Only the remote mode's own check, none of local mode's
+				if problem.Field != "execution.zone" {
+					t.Errorf("problem = %s: %v, want the zone required", problem.Field, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidFileModeWithFlag(t *testing.T) {
+	for _, flag := range []ExecutionMode{ModeUnspecified, ModeLocal, ModeRemote} {
+		c := NewConfig()
+		if flag != ModeUnspecified {
+			c.SetMode(flag, "flag")
+		}
+		err := c.LoadFromYAMLData([]byte("execution:\n  mode: remtoe\n"), "build.yaml")
+		if err == nil || !strings.Contains(err.Error(), "invalid execution mode 'remtoe' in build.yaml") {
+			t.Errorf("LoadFromYAMLData() with mode %v set error = %v, want the invalid mode", flag, err)
+		}
+	}
+}
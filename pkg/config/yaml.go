@@ -29,37 +29,146 @@ type ExecutionConfig struct {
 
 type ProjectConfig struct {
 	Name string `yaml:"name"`
+
+	ExpectedNumber string   `yaml:"expected_number,omitempty"`
+	Allow          []string `yaml:"allow,omitempty"`
 }
 
 type DiskConfig struct { // 改为 DiskConfig
 	Name     string            `yaml:"name"`
 	SizeGB   int               `yaml:"size_gb,omitempty"`
 	Family   string            `yaml:"family,omitempty"`
+	Aliases  []string          `yaml:"family_aliases,omitempty"`
 	Labels   map[string]string `yaml:"labels,omitempty"`
+	Licenses []string          `yaml:"licenses,omitempty"`
 	DiskType string            `yaml:"disk_type,omitempty"`
+	OSType   string            `yaml:"os_type,omitempty"`
+	Arch     string            `yaml:"architecture,omitempty"`
+
+	Snapshotter   string `yaml:"snapshotter,omitempty"`
+	NodeImageType string `yaml:"node_image_type,omitempty"`
+	COSVersion    string `yaml:"cos_version,omitempty"`
+
+	StrictFamilyArch bool `yaml:"strict_family_arch,omitempty"`
 }
 
 type NetworkConfig struct {
 	Network string `yaml:"network,omitempty"`
 	Subnet  string `yaml:"subnet,omitempty"`
+
+	ConnectivityCheck bool `yaml:"connectivity_check,omitempty"`
+	NoExternalIP      bool `yaml:"no_external_ip,omitempty"`
 }
 
 type AdvancedConfig struct {
 	Timeout     string `yaml:"timeout,omitempty"`
-	JobName     string `yaml:"job_name,omitempty"`
-	MachineType string `yaml:"machine_type,omitempty"`
-	Preemptible bool   `yaml:"preemptible,omitempty"`
+	PullTimeout string `yaml:"pull_timeout,omitempty"`
+
+	MaxTimeoutExtension string `yaml:"max_timeout_extension,omitempty"`
+	CleanupTimeout      string `yaml:"cleanup_timeout,omitempty"`
+
+	JobName       string   `yaml:"job_name,omitempty"`
+	MachineType   string   `yaml:"machine_type,omitempty"`
+	ShieldedVM    bool     `yaml:"shielded_vm,omitempty"`
+	BuildVMImage  string   `yaml:"build_vm_image,omitempty"`
+	Preemptible   bool     `yaml:"preemptible,omitempty"`
+	Partitions    int      `yaml:"partitions,omitempty"`
+	PullArgs      []string `yaml:"pull_args,omitempty"`
+	Lockfile      string   `yaml:"lockfile,omitempty"`
+	WriteLockfile string   `yaml:"write_lockfile,omitempty"`
+	SkipIfExists  bool     `yaml:"skip_if_exists,omitempty"`
+
+	ApprovedDigests string `yaml:"approved_digests,omitempty"`
+
+	BuildVMImageVersion string `yaml:"build_vm_image_version,omitempty"`
+
+	PostPullCommand string `yaml:"post_pull_command,omitempty"`
+
+	PrePullCommands  []string `yaml:"pre_pull_commands,omitempty"`
+	PostPullCommands []string `yaml:"post_pull_commands,omitempty"`
+
+	AdditionalStartupScripts      []string `yaml:"additional_startup_scripts,omitempty"`
+	AdditionalStartupScriptsOrder string   `yaml:"additional_startup_scripts_order,omitempty"`
+
+	WriteImageRef  string `yaml:"write_image_ref,omitempty"`
+	ImageRefFormat string `yaml:"image_ref_format,omitempty"`
+	OutputType     string `yaml:"output_type,omitempty"`
+
+	NodePoolConfig       string `yaml:"node_pool_config,omitempty"`
+	NodePoolConfigFormat string `yaml:"node_pool_config_format,omitempty"`
+
+	ImageForceCreate bool `yaml:"image_force_create,omitempty"`
+
+	SmokeTest        bool   `yaml:"smoke_test,omitempty"`
+	SmokeTestTimeout string `yaml:"smoke_test_timeout,omitempty"`
+
+	AssumeNoStreaming bool `yaml:"assume_no_streaming,omitempty"`
+
+	CloudLogging bool `yaml:"cloud_logging,omitempty"`
+
+	VMLabels map[string]string `yaml:"vm_labels,omitempty"`
+
+	SSHProxyJump        string `yaml:"ssh_proxy_jump,omitempty"`
+	SSHProxyJumpKeyFile string `yaml:"ssh_proxy_jump_key_file,omitempty"`
+	SSHAddressType      string `yaml:"ssh_address_type,omitempty"`
+
+	VerifyNoLayersMissing bool `yaml:"verify_no_layers_missing,omitempty"`
+	VerifyLayerDigests    bool `yaml:"verify_layer_digests,omitempty"`
+	ParallelVerify        int  `yaml:"parallel_verify,omitempty"`
+	PullConcurrency       int  `yaml:"pull_concurrency,omitempty"`
+
+	ContainerdVersion string `yaml:"containerd_version,omitempty"`
+	GKEVersion        string `yaml:"gke_version,omitempty"`
+
+	IncludeGKESystemImages string `yaml:"include_gke_system_images,omitempty"`
+	SystemImagesManifest   string `yaml:"system_images_manifest,omitempty"`
+
+	PreinstalledImagesFile string `yaml:"preinstalled_images_file,omitempty"`
+
+	ConfigSnapshotBucket string `yaml:"config_snapshot_bucket,omitempty"`
+
+	AttestationBucket string `yaml:"attestation_bucket,omitempty"`
+	AttestationKMSKey string `yaml:"attestation_kms_key,omitempty"`
+
+	APIEndpoint string `yaml:"api_endpoint,omitempty"`
+
+	RetryBudget int `yaml:"retry_budget,omitempty"`
+
+	FromSpec           string `yaml:"from_spec,omitempty"`
+	MaxImages          int    `yaml:"max_images,omitempty"`
+	ForbidMultipleTags bool   `yaml:"forbid_multiple_tags,omitempty"`
+
+	AutoRecover bool `yaml:"auto_recover,omitempty"`
+
+	AbortOnWarning bool `yaml:"abort_on_warning,omitempty"`
+
+	SerialPort int `yaml:"serial_port,omitempty"`
+
+	MinPullThroughput float64 `yaml:"min_pull_throughput,omitempty"`
+
+	Tuning TuningConfig `yaml:"tuning,omitempty"`
 }
 
 type AuthConfig struct {
 	GCPOAuth       string `yaml:"gcp_oauth,omitempty"`
 	ServiceAccount string `yaml:"service_account,omitempty"`
+	SSHKeyFile     string `yaml:"ssh_key_file,omitempty"`
+	SSHInsecure    bool   `yaml:"ssh_insecure,omitempty"`
 	ImagePullAuth  string `yaml:"image_pull_auth,omitempty"`
+
+	CredentialProviders map[string]string `yaml:"credential_providers,omitempty"`
+
+	AttestationKeyFile string `yaml:"attestation_key_file,omitempty"`
 }
 
 type LoggingConfig struct {
-	Verbose bool `yaml:"verbose,omitempty"`
-	Quiet   bool `yaml:"quiet,omitempty"`
+	Verbose   bool   `yaml:"verbose,omitempty"`
+	Quiet     bool   `yaml:"quiet,omitempty"`
+	NoColor   bool   `yaml:"no_color,omitempty"`
+	Format    string `yaml:"format,omitempty"`
+	File      string `yaml:"file,omitempty"`
+	DebugHTTP bool   `yaml:"debug_http,omitempty"`
+	Trace     string `yaml:"trace,omitempty"`
 }
 
 // LoadFromYAML loads configuration from a YAML file
@@ -79,15 +188,21 @@ func (c *Config) LoadFromYAML(filePath string) error {
 		return fmt.Errorf("failed to read configuration file %s: %w", filePath, err)
 	}
 
+	return c.LoadFromYAMLData(data, filePath)
+}
+
+// LoadFromYAMLData applies a YAML configuration read from source (a file path or
+// a description used in error messages)
+func (c *Config) LoadFromYAMLData(data []byte, source string) error {
 	// Parse YAML
 	var yamlConfig YAMLConfig
 	if err := yaml.Unmarshal(data, &yamlConfig); err != nil {
-		return fmt.Errorf("failed to parse YAML configuration file %s: %w", filePath, err)
+		return fmt.Errorf("failed to parse YAML configuration file %s: %w", source, err)
 	}
 
 	// Apply configuration (only if not already set by command line)
-	if err := c.applyYAMLConfig(&yamlConfig, filePath); err != nil {
-		return fmt.Errorf("failed to apply configuration from %s: %w", filePath, err)
+	if err := c.applyYAMLConfig(&yamlConfig, source); err != nil {
+		return fmt.Errorf("failed to apply configuration from %s: %w", source, err)
 	}
 
 	return nil
@@ -96,16 +211,21 @@ func (c *Config) LoadFromYAML(filePath string) error {
 // applyYAMLConfig applies YAML configuration to Config struct
 // Command line parameters take precedence over config file
 func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error {
-	// Execution mode
-	if c.Mode == ModeUnspecified && yamlConfig.Execution.Mode != "" {
+	// Execution mode: checked even when -L or -R overrides it, so a typo does
+	// not go unnoticed until the flag is dropped
+	if yamlConfig.Execution.Mode != "" {
+		var mode ExecutionMode
 		switch yamlConfig.Execution.Mode {
 		case "local":
-			c.Mode = ModeLocal
+			mode = ModeLocal
 		case "remote":
-			c.Mode = ModeRemote
+			mode = ModeRemote
 		default:
 			return fmt.Errorf("invalid execution mode '%s' in %s, must be 'local' or 'remote'", yamlConfig.Execution.Mode, filePath)
 		}
+		if c.Mode == ModeUnspecified {
+			c.SetMode(mode, "'execution.mode' in "+filePath)
+		}
 	}
 
 	// Zone
@@ -118,6 +238,15 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		c.ProjectName = yamlConfig.Project.Name
 	}
 
+	if c.ExpectedProjectNumber == "" && yamlConfig.Project.ExpectedNumber != "" {
+		c.ExpectedProjectNumber = yamlConfig.Project.ExpectedNumber
+	}
+
+	// Only config files set the allowed projects, so a flag cannot widen them
+	if len(yamlConfig.Project.Allow) > 0 {
+		c.AllowedProjects = yamlConfig.Project.Allow
+	}
+
 	// Disk configuration (原来的 Cache configuration)
 	if c.DiskImageName == "" && yamlConfig.Disk.Name != "" {
 		c.DiskImageName = yamlConfig.Disk.Name
@@ -135,6 +264,26 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		c.DiskType = yamlConfig.Disk.DiskType
 	}
 
+	if c.OSType == OSLinux && yamlConfig.Disk.OSType != "" { // default value
+		c.OSType = yamlConfig.Disk.OSType
+	}
+
+	if c.Arch == ArchX86_64 && yamlConfig.Disk.Arch != "" { // default value
+		c.Arch = yamlConfig.Disk.Arch
+	}
+
+	if c.Snapshotter == SnapshotterOverlayfs && yamlConfig.Disk.Snapshotter != "" { // default value
+		c.Snapshotter = yamlConfig.Disk.Snapshotter
+	}
+
+	if c.NodeImageType == "" && yamlConfig.Disk.NodeImageType != "" { // default value
+		c.NodeImageType = yamlConfig.Disk.NodeImageType
+	}
+
+	if c.COSVersion == "" && yamlConfig.Disk.COSVersion != "" { // default value
+		c.COSVersion = yamlConfig.Disk.COSVersion
+	}
+
 	// Labels (merge with existing)
 	if len(yamlConfig.Disk.Labels) > 0 {
 		if c.DiskLabels == nil {
@@ -147,6 +296,18 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		}
 	}
 
+	if !c.StrictFamilyArch && yamlConfig.Disk.StrictFamilyArch { // default is false
+		c.StrictFamilyArch = yamlConfig.Disk.StrictFamilyArch
+	}
+
+	if len(c.FamilyAliases) == 0 && len(yamlConfig.Disk.Aliases) > 0 {
+		c.FamilyAliases = yamlConfig.Disk.Aliases
+	}
+
+	if len(c.ImageLicenses) == 0 && len(yamlConfig.Disk.Licenses) > 0 {
+		c.ImageLicenses = yamlConfig.Disk.Licenses
+	}
+
 	// Container images (append if not already set)
 	if len(c.ContainerImages) == 0 && len(yamlConfig.Images) > 0 {
 		c.ContainerImages = yamlConfig.Images
@@ -161,6 +322,14 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		c.Subnet = yamlConfig.Network.Subnet
 	}
 
+	if !c.ConnectivityCheck && yamlConfig.Network.ConnectivityCheck { // default is false
+		c.ConnectivityCheck = yamlConfig.Network.ConnectivityCheck
+	}
+
+	if !c.NoExternalIP && yamlConfig.Network.NoExternalIP { // default is false
+		c.NoExternalIP = yamlConfig.Network.NoExternalIP
+	}
+
 	// Advanced configuration
 	if c.Timeout == 20*time.Minute && yamlConfig.Advanced.Timeout != "" { // default value
 		timeout, err := time.ParseDuration(yamlConfig.Advanced.Timeout)
@@ -170,18 +339,255 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		c.Timeout = timeout
 	}
 
+	if c.PullTimeout == 0 && yamlConfig.Advanced.PullTimeout != "" { // default value
+		timeout, err := time.ParseDuration(yamlConfig.Advanced.PullTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid pull_timeout format '%s' in %s: %w", yamlConfig.Advanced.PullTimeout, filePath, err)
+		}
+		c.PullTimeout = timeout
+	}
+
+	if c.MaxTimeoutExtension == DefaultMaxTimeoutExtension && yamlConfig.Advanced.MaxTimeoutExtension != "" { // default value
+		extension, err := time.ParseDuration(yamlConfig.Advanced.MaxTimeoutExtension)
+		if err != nil {
+			return fmt.Errorf("invalid max_timeout_extension format '%s' in %s: %w", yamlConfig.Advanced.MaxTimeoutExtension, filePath, err)
+		}
+		c.MaxTimeoutExtension = extension
+	}
+
+	if c.CleanupTimeout == DefaultCleanupTimeout && yamlConfig.Advanced.CleanupTimeout != "" { // default value
+		timeout, err := time.ParseDuration(yamlConfig.Advanced.CleanupTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid cleanup_timeout format '%s' in %s: %w", yamlConfig.Advanced.CleanupTimeout, filePath, err)
+		}
+		c.CleanupTimeout = timeout
+	}
+
+	if err := yamlConfig.Advanced.Tuning.apply(&c.Tuning, filePath); err != nil {
+		return err
+	}
+
 	if c.JobName == "image-cache-build" && yamlConfig.Advanced.JobName != "" { // default value
 		c.JobName = yamlConfig.Advanced.JobName
 	}
 
-	if c.MachineType == "e2-standard-2" && yamlConfig.Advanced.MachineType != "" { // default value
+	if c.MachineType == defaultMachineType && yamlConfig.Advanced.MachineType != "" { // default value
 		c.MachineType = yamlConfig.Advanced.MachineType
 	}
 
+	if c.BuildVMImage == "" && yamlConfig.Advanced.BuildVMImage != "" { // default value
+		c.BuildVMImage = yamlConfig.Advanced.BuildVMImage
+	}
+
+	if c.BuildVMImageVersion == "" && yamlConfig.Advanced.BuildVMImageVersion != "" { // default value
+		c.BuildVMImageVersion = yamlConfig.Advanced.BuildVMImageVersion
+	}
+
+	if c.PostPullCommand == "" && yamlConfig.Advanced.PostPullCommand != "" { // default value
+		c.PostPullCommand = yamlConfig.Advanced.PostPullCommand
+		c.fileScripts = append(c.fileScripts, "advanced.post_pull_command")
+	}
+
+	if len(c.PrePullCommands) == 0 && len(yamlConfig.Advanced.PrePullCommands) > 0 {
+		c.PrePullCommands = yamlConfig.Advanced.PrePullCommands
+	}
+
+	if len(c.PostPullCommands) == 0 && len(yamlConfig.Advanced.PostPullCommands) > 0 {
+		c.PostPullCommands = yamlConfig.Advanced.PostPullCommands
+	}
+
+	if len(c.AdditionalStartupScripts) == 0 && len(yamlConfig.Advanced.AdditionalStartupScripts) > 0 {
+		c.AdditionalStartupScripts = yamlConfig.Advanced.AdditionalStartupScripts
+		c.fileScripts = append(c.fileScripts, "advanced.additional_startup_scripts")
+	}
+
+	if c.AdditionalStartupScriptsOrder == StartupScriptsBefore && yamlConfig.Advanced.AdditionalStartupScriptsOrder != "" { // default value
+		c.AdditionalStartupScriptsOrder = yamlConfig.Advanced.AdditionalStartupScriptsOrder
+	}
+
+	if !c.ShieldedVM && yamlConfig.Advanced.ShieldedVM { // default is false
+		c.ShieldedVM = yamlConfig.Advanced.ShieldedVM
+	}
+
+	if c.ContainerdVersion == "" && yamlConfig.Advanced.ContainerdVersion != "" { // default value
+		c.ContainerdVersion = yamlConfig.Advanced.ContainerdVersion
+	}
+
+	if c.GKEVersion == "" && yamlConfig.Advanced.GKEVersion != "" { // default value
+		c.GKEVersion = yamlConfig.Advanced.GKEVersion
+	}
+
+	if c.IncludeGKESystemImages == "" && yamlConfig.Advanced.IncludeGKESystemImages != "" { // default value
+		c.IncludeGKESystemImages = yamlConfig.Advanced.IncludeGKESystemImages
+	}
+
+	if c.SystemImagesManifest == "" && yamlConfig.Advanced.SystemImagesManifest != "" { // default value
+		c.SystemImagesManifest = yamlConfig.Advanced.SystemImagesManifest
+	}
+
+	if c.PreinstalledImagesFile == "" && yamlConfig.Advanced.PreinstalledImagesFile != "" { // default value
+		c.PreinstalledImagesFile = yamlConfig.Advanced.PreinstalledImagesFile
+	}
+
+	if c.ConfigSnapshotBucket == "" && yamlConfig.Advanced.ConfigSnapshotBucket != "" { // default value
+		c.ConfigSnapshotBucket = yamlConfig.Advanced.ConfigSnapshotBucket
+	}
+
+	if c.AttestationBucket == "" && yamlConfig.Advanced.AttestationBucket != "" { // default value
+		c.AttestationBucket = yamlConfig.Advanced.AttestationBucket
+	}
+
+	if c.AttestationKMSKey == "" && yamlConfig.Advanced.AttestationKMSKey != "" { // default value
+		c.AttestationKMSKey = yamlConfig.Advanced.AttestationKMSKey
+	}
+
+	if c.APIEndpoint == "" && yamlConfig.Advanced.APIEndpoint != "" { // default value
+		c.APIEndpoint = yamlConfig.Advanced.APIEndpoint
+	}
+
+	if c.RetryBudget == DefaultRetryBudget && yamlConfig.Advanced.RetryBudget != 0 { // default value
+		c.RetryBudget = yamlConfig.Advanced.RetryBudget
+	}
+
+	if c.FromSpec == "" && yamlConfig.Advanced.FromSpec != "" { // default value
+		c.FromSpec = yamlConfig.Advanced.FromSpec
+	}
+
+	if c.MaxImages == DefaultMaxImages && yamlConfig.Advanced.MaxImages != 0 { // default value
+		c.MaxImages = yamlConfig.Advanced.MaxImages
+	}
+
+	if !c.ForbidMultipleTags && yamlConfig.Advanced.ForbidMultipleTags { // default is false
+		c.ForbidMultipleTags = yamlConfig.Advanced.ForbidMultipleTags
+	}
+
+	if !c.AutoRecover && yamlConfig.Advanced.AutoRecover { // default is false
+		c.AutoRecover = yamlConfig.Advanced.AutoRecover
+	}
+
+	if !c.AbortOnWarning && yamlConfig.Advanced.AbortOnWarning { // default is false
+		c.AbortOnWarning = yamlConfig.Advanced.AbortOnWarning
+	}
+
+	if c.SerialPort == 1 && yamlConfig.Advanced.SerialPort != 0 { // default value
+		c.SerialPort = yamlConfig.Advanced.SerialPort
+	}
+
+	if c.MinPullThroughput == 0 && yamlConfig.Advanced.MinPullThroughput != 0 { // default value
+		c.MinPullThroughput = yamlConfig.Advanced.MinPullThroughput
+	}
+
 	if !c.Preemptible && yamlConfig.Advanced.Preemptible { // default is false
 		c.Preemptible = yamlConfig.Advanced.Preemptible
 	}
 
+	if c.Partitions == 1 && yamlConfig.Advanced.Partitions != 0 { // default value
+		c.Partitions = yamlConfig.Advanced.Partitions
+	}
+
+	if len(c.PullArgs) == 0 && len(yamlConfig.Advanced.PullArgs) > 0 {
+		c.PullArgs = yamlConfig.Advanced.PullArgs
+	}
+
+	if c.Lockfile == "" && yamlConfig.Advanced.Lockfile != "" {
+		c.Lockfile = yamlConfig.Advanced.Lockfile
+	}
+
+	if c.WriteLockfile == "" && yamlConfig.Advanced.WriteLockfile != "" {
+		c.WriteLockfile = yamlConfig.Advanced.WriteLockfile
+	}
+
+	if c.ApprovedDigests == "" && yamlConfig.Advanced.ApprovedDigests != "" {
+		c.ApprovedDigests = yamlConfig.Advanced.ApprovedDigests
+	}
+
+	if !c.SkipIfExists && yamlConfig.Advanced.SkipIfExists { // default is false
+		c.SkipIfExists = yamlConfig.Advanced.SkipIfExists
+	}
+
+	if c.WriteImageRef == "" && yamlConfig.Advanced.WriteImageRef != "" {
+		c.WriteImageRef = yamlConfig.Advanced.WriteImageRef
+	}
+
+	if c.ImageRefFormat == ImageRefSelfLink && yamlConfig.Advanced.ImageRefFormat != "" { // default value
+		c.ImageRefFormat = yamlConfig.Advanced.ImageRefFormat
+	}
+
+	if c.NodePoolConfig == "" && yamlConfig.Advanced.NodePoolConfig != "" {
+		c.NodePoolConfig = yamlConfig.Advanced.NodePoolConfig
+	}
+
+	if c.NodePoolConfigFormat == NodePoolConfigGcloud && yamlConfig.Advanced.NodePoolConfigFormat != "" { // default value
+		c.NodePoolConfigFormat = yamlConfig.Advanced.NodePoolConfigFormat
+	}
+
+	if c.OutputType == OutputImage && yamlConfig.Advanced.OutputType != "" { // default value
+		c.OutputType = yamlConfig.Advanced.OutputType
+	}
+
+	if !c.ImageForceCreate && yamlConfig.Advanced.ImageForceCreate { // default is false
+		c.ImageForceCreate = yamlConfig.Advanced.ImageForceCreate
+	}
+
+	if !c.SmokeTest && yamlConfig.Advanced.SmokeTest { // default is false
+		c.SmokeTest = yamlConfig.Advanced.SmokeTest
+	}
+
+	if c.SmokeTestTimeout == DefaultSmokeTestTimeout && yamlConfig.Advanced.SmokeTestTimeout != "" { // default value
+		timeout, err := time.ParseDuration(yamlConfig.Advanced.SmokeTestTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid smoke_test_timeout format '%s' in %s: %w", yamlConfig.Advanced.SmokeTestTimeout, filePath, err)
+		}
+		c.SmokeTestTimeout = timeout
+	}
+
+	if !c.AssumeNoStreaming && yamlConfig.Advanced.AssumeNoStreaming { // default is false
+		c.AssumeNoStreaming = yamlConfig.Advanced.AssumeNoStreaming
+	}
+
+	if !c.CloudLogging && yamlConfig.Advanced.CloudLogging { // default is false
+		c.CloudLogging = yamlConfig.Advanced.CloudLogging
+	}
+
+	if c.SSHProxyJump == "" && yamlConfig.Advanced.SSHProxyJump != "" {
+		c.SSHProxyJump = yamlConfig.Advanced.SSHProxyJump
+	}
+
+	if c.SSHProxyJumpKeyFile == "" && yamlConfig.Advanced.SSHProxyJumpKeyFile != "" {
+		c.SSHProxyJumpKeyFile = yamlConfig.Advanced.SSHProxyJumpKeyFile
+	}
+
+	if c.SSHAddressType == SSHAddressAuto && yamlConfig.Advanced.SSHAddressType != "" { // default value
+		c.SSHAddressType = yamlConfig.Advanced.SSHAddressType
+	}
+
+	if len(yamlConfig.Advanced.VMLabels) > 0 {
+		if c.VMLabels == nil {
+			c.VMLabels = make(map[string]string)
+		}
+		for k, v := range yamlConfig.Advanced.VMLabels {
+			if _, exists := c.VMLabels[k]; !exists { // Don't override CLI labels
+				c.VMLabels[k] = v
+			}
+		}
+	}
+
+	if !c.VerifyNoLayersMissing && yamlConfig.Advanced.VerifyNoLayersMissing { // default is false
+		c.VerifyNoLayersMissing = yamlConfig.Advanced.VerifyNoLayersMissing
+	}
+
+	if !c.VerifyLayerDigests && yamlConfig.Advanced.VerifyLayerDigests { // default is false
+		c.VerifyLayerDigests = yamlConfig.Advanced.VerifyLayerDigests
+	}
+
+	if c.ParallelVerify == 1 && yamlConfig.Advanced.ParallelVerify != 0 { // default value
+		c.ParallelVerify = yamlConfig.Advanced.ParallelVerify
+	}
+
+	if c.PullConcurrency == 0 && yamlConfig.Advanced.PullConcurrency != 0 { // default value
+		c.PullConcurrency = yamlConfig.Advanced.PullConcurrency
+	}
+
 	// Authentication
 	if c.GCPOAuth == "" && yamlConfig.Auth.GCPOAuth != "" {
 		c.GCPOAuth = yamlConfig.Auth.GCPOAuth
@@ -191,10 +597,33 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		c.ServiceAccount = yamlConfig.Auth.ServiceAccount
 	}
 
+	if c.SSHKeyFile == "" && yamlConfig.Auth.SSHKeyFile != "" {
+		c.SSHKeyFile = yamlConfig.Auth.SSHKeyFile
+	}
+
+	if c.AttestationKeyFile == "" && yamlConfig.Auth.AttestationKeyFile != "" {
+		c.AttestationKeyFile = yamlConfig.Auth.AttestationKeyFile
+	}
+
+	if !c.SSHInsecure && yamlConfig.Auth.SSHInsecure { // default is false
+		c.SSHInsecure = yamlConfig.Auth.SSHInsecure
+	}
+
 	if c.ImagePullAuth == "None" && yamlConfig.Auth.ImagePullAuth != "" { // default value
 		c.ImagePullAuth = yamlConfig.Auth.ImagePullAuth
 	}
 
+	if len(yamlConfig.Auth.CredentialProviders) > 0 {
+		if c.CredentialProviders == nil {
+			c.CredentialProviders = make(map[string]string)
+		}
+		for pattern, provider := range yamlConfig.Auth.CredentialProviders {
+			if _, exists := c.CredentialProviders[pattern]; !exists { // Don't override CLI patterns
+				c.CredentialProviders[pattern] = provider
+			}
+		}
+	}
+
 	// Logging
 	if !c.Verbose && yamlConfig.Logging.Verbose { // default is false
 		c.Verbose = yamlConfig.Logging.Verbose
@@ -204,6 +633,26 @@ func (c *Config) applyYAMLConfig(yamlConfig *YAMLConfig, filePath string) error
 		c.Quiet = yamlConfig.Logging.Quiet
 	}
 
+	if !c.NoColor && yamlConfig.Logging.NoColor { // default is false
+		c.NoColor = yamlConfig.Logging.NoColor
+	}
+
+	if c.LogFormat == LogFormatText && yamlConfig.Logging.Format != "" { // default value
+		c.LogFormat = yamlConfig.Logging.Format
+	}
+
+	if c.LogFile == "" && yamlConfig.Logging.File != "" { // default value
+		c.LogFile = yamlConfig.Logging.File
+	}
+
+	if !c.DebugHTTP && yamlConfig.Logging.DebugHTTP { // default is false
+		c.DebugHTTP = yamlConfig.Logging.DebugHTTP
+	}
+
+	if c.TraceFile == "" && yamlConfig.Logging.Trace != "" { // default value
+		c.TraceFile = yamlConfig.Logging.Trace
+	}
+
 	return nil
 }
 
@@ -238,20 +687,25 @@ func GenerateYAMLTemplate(outputPath string, templateType string) error {
 	return nil
 }
 
-// ValidateYAMLFile validates a YAML configuration file
-func ValidateYAMLFile(filePath string) error {
+// ValidateYAMLFile validates a YAML configuration file. With offline set, the
+// checks that need the network are skipped and returned.
+func ValidateYAMLFile(filePath string, offline bool) ([]string, error) {
 	// Create a temporary config to test loading
 	tempConfig := NewConfig()
+	tempConfig.Offline = offline
 	if err := tempConfig.LoadFromYAML(filePath); err != nil {
-		return err
+		return nil, err
+	}
+	if err := tempConfig.LoadSpec(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed for %s: %w", filePath, err)
 	}
 
 	// Validate the loaded configuration
 	if err := tempConfig.Validate(); err != nil {
-		return fmt.Errorf("configuration validation failed for %s: %w", filePath, err)
+		return tempConfig.SkippedChecks(), fmt.Errorf("configuration validation failed for %s: %w", filePath, err)
 	}
 
-	return nil
+	return tempConfig.SkippedChecks(), nil
 }
 
 const basicYAMLTemplate = `# GKE Image Cache Builder - Basic Configuration Template
@@ -263,6 +717,8 @@ execution:
 
 project:
   name: my-project  # Replace with your GCP project name
+  # expected_number: "123456789012"  # Refuse to build unless the name is this project
+  # allow: [my-project]              # Projects builds may target
 
 disk:
   name: web-app-cache  # Name for the disk image
@@ -288,20 +744,57 @@ images:
 # Optional advanced settings
 # advanced:
 #   timeout: 20m
+#   pull_timeout: 1h                  # Separate budget for pulling images
+#   max_timeout_extension: 4h         # Most "control extend" can add while a build runs
+#   cleanup_timeout: 5m               # Give up deleting temporary resources after this
+#   retry_budget: 100                 # Retried failures allowed across the build
+#   from_spec: cache-spec.yaml        # Also cache the images of an ImageCacheSpec document
+#   max_images: 100                   # Most container images a build caches
+#   forbid_multiple_tags: false       # Fail on several tags of one repository
+#   auto_recover: false               # Clean up disks a crashed local run left attached
+#   serial_port: 1                    # Build VM serial port for status and log (1-4)
+#   min_pull_throughput: 0            # Warn about registries slower than this (MB/s)
+#   tuning:                           # Waits for the build VM, e.g. in slow regions
+#     vm_boot_timeout: 10m            # Boot until the startup script's connectivity probes
+#     bootstrap_timeout: 15m          # Linux bootstrap script
+#     windows_setup_timeout: 45m      # Windows setup script
+#     ssh_ready_timeout: 10m          # SSH user and key provisioning
+#     status_poll_interval: 5s        # How often the script's status is read
+#     connectivity_test_timeout: 3m   # Each Network Management connectivity test
 #   job_name: image-cache-build
 #   machine_type: e2-standard-2
 #   preemptible: false
+#   lockfile: images.lock.json        # Pull exactly the digests listed here
+#   write_lockfile: images.lock.json  # Record resolved digests after the build
+#   approved_digests: approved.txt    # Only cache images whose digests are listed here
+#   write_image_ref: image-ref.txt    # Write the created image's self-link after the build
+#   image_ref_format: self-link       # Or name
+#   node_pool_config: node-pool.sh    # Write the node pool command attaching the image
+#   node_pool_config_format: gcloud   # Or terraform
+#   output_type: image                # Or disk-only / both: keep the populated cache disk
+#   image_force_create: false         # Create the image before detaching the cache disk
+#   smoke_test: false                 # Check the image resolves every image on a node VM
+#   smoke_test_timeout: 10m           # Budget of the smoke test, on top of the timeout
+#   assume_no_streaming: false        # Nodes do not use GKE Image Streaming
+#   cloud_logging: false              # Ship the build VM's log to Cloud Logging
 
 # Optional authentication
 # auth:
 #   gcp_oauth: /path/to/service-account.json
 #   service_account: default
 #   image_pull_auth: None
+#   credential_providers:             # Kubelet credential provider per registry pattern
+#     "*.dkr.ecr.*.amazonaws.com": ecr-credential-provider
 
 # Optional logging
 # logging:
 #   verbose: false
 #   quiet: false
+#   no_color: false
+#   format: text                      # Or json: one JSON object per line on stderr
+#   file: /var/log/gke-image-cache-builder.log
+#   debug_http: false                 # Log the registry and metadata requests at trace level
+#   trace: build-trace.json           # Timeline of phases, operations and pulls
 `
 
 const advancedYAMLTemplate = `# GKE Image Cache Builder - Advanced Configuration Template
@@ -318,11 +811,20 @@ disk:
   name: microservices-cache  # Disk image name
   size_gb: 50  # Disk size in GB
   family: production-cache  # Image family name
+  # family_aliases: [web-cache, team-platform]  # Secondary families, as family-alias-<n> labels
+  # strict_family_arch: true  # Fail if the family holds images of another architecture
   disk_type: pd-ssd  # Options: pd-standard, pd-ssd, pd-balanced
+  # os_type: windows  # Build for Windows Server node pools (remote mode only)
+  # architecture: arm64  # Build for Arm node pools (default: x86_64)
+  # snapshotter: overlayfs  # Must match the nodes' containerd snapshotter (overlayfs, native, stargz)
+  # node_image_type: COS  # Node image of the target node pools (COS, UBUNTU)
+  # cos_version: "113"     # Warn about images COS 113 nodes already hold
+  # licenses:  # Image licenses for governance tooling
+  #   - projects/my-governance/global/licenses/approved-cache
   labels:
     env: production
     team: platform
-    version: v1.0.0
+    version: v1-0-0
     cost-center: engineering
 
 # Container images to cache
@@ -341,6 +843,8 @@ images:
 network:
   network: production-vpc      # VPC network for build VM
   subnet: production-subnet    # Subnet for build VM
+  # connectivity_check: true   # Run Network Management connectivity tests before pulling
+  # no_external_ip: true       # No external IP on the build VM (needs Cloud NAT)
 
 # Advanced settings
 advanced:
@@ -348,10 +852,42 @@ advanced:
   job_name: production-cache-build
   machine_type: e2-standard-4  # VM machine type for remote builds
   preemptible: true  # Use preemptible instances for cost savings
+  # shielded_vm: true  # Secure Boot, vTPM and integrity monitoring on the build VM
+  # build_vm_image: projects/golden-images/global/images/family/ubuntu-2204-hardened  # Approved boot image
+  # build_vm_image_version: ubuntu-2204-hardened-v20240126  # Pin an image of that family
+  # post_pull_command: ctr content ls -q | wc -l  # Run as root after pulling, before imaging (requires --allow-hooks)
+  # pre_pull_commands:  # Hooks run as root before pulling (require --allow-hooks)
+  #   - cp /etc/ssl/internal-ca.crt /usr/local/share/ca-certificates/ && update-ca-certificates
+  # post_pull_commands:  # Hooks run as root after pulling (require --allow-hooks)
+  #   - ctr images ls -q
+  # additional_startup_scripts:  # Also run by the build VM's startup script
+  #   - install-monitoring-agent.sh
+  # additional_startup_scripts_order: before  # before or after the VM's own bootstrap steps
+  # pull_args:  # Extra ctr images pull arguments (no shell metacharacters)
+  #   - --all-platforms
+  # ssh_proxy_jump: admin@bastion.example.com:22  # Reach the build VM through a bastion
+  # ssh_proxy_jump_key_file: /path/to/bastion-key   # Bastion key (default: auth.ssh_key_file)
+  # ssh_address_type: internal  # SSH to the build VM's internal or external IP (default: auto)
+  # vm_labels:  # Build VM labels for billing export (default: disk labels)
+  #   cost-center: ml-platform
+  # verify_no_layers_missing: true  # Check every cached blob before creating the image
+  # verify_layer_digests: true      # Also rehash every blob (slower)
+  # parallel_verify: 8              # Verify up to 8 images at a time (default: 1)
+  # pull_concurrency: 2             # Pull and unpack up to 2 images at a time (default: auto)
+  # abort_on_warning: true          # Fail the build on any warning (CI gating)
+  # containerd_version: 1.7.13  # Install this containerd release on the build VM
+  # gke_version: "1.29"         # Warn when the build's containerd differs from the nodes'
+  # include_gke_system_images: "1.29"  # Add pause, kube-dns and metrics-server for GKE 1.29
+  # system_images_manifest: system-images.yaml  # Replace the built-in system image table
+  # preinstalled_images_file: preinstalled.yaml  # Replace the built-in preinstalled image table
+  # config_snapshot_bucket: my-cache-configs  # Store the effective config for --reproduce-from
+  # attestation_bucket: my-cache-provenance    # Store SLSA provenance of each image
+  # attestation_kms_key: projects/my-project/locations/global/keyRings/builds/cryptoKeys/provenance/cryptoKeyVersions/1
+  # api_endpoint: https://compute.restricted.googleapis.com  # Compute Engine API endpoint (VPC-SC)
 
 # Authentication configuration
 auth:
-  gcp_oauth: /path/to/service-account.json
+  gcp_oauth: /path/to/service-account.json  # or secret://projects/<project>/secrets/<secret>/versions/latest
   service_account: cache-builder@production-project.iam.gserviceaccount.com
   image_pull_auth: ServiceAccountToken
 
@@ -466,7 +1002,8 @@ network:
 
 # ML-optimized settings
 advanced:
-  timeout: 2h  # Long timeout for large ML images
+  timeout: 30m  # VM boot and image creation
+  pull_timeout: 2h  # Separate budget for pulling large ML images
   job_name: ml-cache-build
   machine_type: e2-standard-8  # High-performance machine for ML workloads
   preemptible: false  # Reliability over cost for production ML
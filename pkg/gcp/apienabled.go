@@ -0,0 +1,109 @@
+package gcp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// serviceNamePattern finds the API named in a disabled-API message, e.g.
+// ".../apis/api/compute.googleapis.com/overview?project=123"
+var serviceNamePattern = regexp.MustCompile(`\b([a-z0-9-]+\.googleapis\.com)\b`)
+
+// consumerProjectPattern finds the project in "... has not been used in project 123 before ..."
+var consumerProjectPattern = regexp.MustCompile(`in project ([a-z0-9-]+)`)
+
+// APINotEnabled describes a request that failed because its API is not enabled
+// on the project. The raw API error is available through Unwrap.
+type APINotEnabled struct {
+	Service string // e.g. compute.googleapis.com
+	Project string // project ID or number, empty if the error did not say
+
+	// ActivationURL is the console page enabling the API, when the error had one
+	ActivationURL string
+
+	err error
+}
+
+func (e *APINotEnabled) Error() string {
+	if e.Project == "" {
+		return fmt.Sprintf("the %s API is not enabled", e.Service)
+	}
+	return fmt.Sprintf("the %s API is not enabled in project %s", e.Service, e.Project)
+}
+
+func (e *APINotEnabled) Unwrap() error {
+	return e.err
+}
+
+// EnableCommand is the gcloud command enabling the API
+func (e *APINotEnabled) EnableCommand() string {
+	project := e.Project
+	if project == "" {
+		project = "<PROJECT>"
+	}
+	return fmt.Sprintf("gcloud services enable %s --project=%s", e.Service, project)
+}
+
+// ConsoleURL is the console page enabling the API
+func (e *APINotEnabled) ConsoleURL() string {
+	if e.ActivationURL != "" {
+		return e.ActivationURL
+	}
+	url := "https://console.cloud.google.com/apis/library/" + e.Service
+	if e.Project != "" {
+		url += "?project=" + e.Project
+	}
+	return url
+}
+
+// AsAPINotEnabled returns the disabled API a request of err's chain failed on:
+// a SERVICE_DISABLED error detail or an accessNotConfigured reason
+func AsAPINotEnabled(err error) (*APINotEnabled, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return nil, false
+	}
+
+	disabled := &APINotEnabled{err: err}
+	found := false
+	for _, detail := range apiErr.Details {
+		info, ok := detail.(map[string]interface{})
+		if !ok || info["reason"] != "SERVICE_DISABLED" {
+			continue
+		}
+		found = true
+		if metadata, ok := info["metadata"].(map[string]interface{}); ok {
+			disabled.Service, _ = metadata["service"].(string)
+			consumer, _ := metadata["consumer"].(string)
+			disabled.Project = strings.TrimPrefix(consumer, "projects/")
+			disabled.ActivationURL, _ = metadata["activationUrl"].(string)
+		}
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			found = true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Older responses only name the API and project in the message
+	if disabled.Service == "" {
+		match := serviceNamePattern.FindStringSubmatch(apiErr.Message)
+		if match == nil {
+			return nil, false
+		}
+		disabled.Service = match[1]
+	}
+	if disabled.Project == "" {
+		if match := consumerProjectPattern.FindStringSubmatch(apiErr.Message); match != nil {
+			disabled.Project = match[1]
+		}
+	}
+	return disabled, true
+}
@@ -2,28 +2,58 @@ package gcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/networkmanagement/v1"
 	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/trace"
 )
 
-// Client wraps GCP API clients (compute only, no storage)
+// Client wraps GCP API clients
 type Client struct {
 	compute     *compute.Service
 	projectName string
+	opts        []option.ClientOption
+
+	networkMgmtOnce sync.Once
+	networkMgmt     *networkmanagement.Service
+	networkMgmtErr  error
 }
 
-// NewClient creates a new GCP client
-func NewClient(projectName, credentialsPath string) (*Client, error) {
+// NewClient creates a new GCP client authenticating every service it creates
+// with credentials, typically option.WithCredentials of credentials looked up
+// once per run; nil uses Application Default Credentials. A non-empty apiEndpoint
+// replaces the Compute Engine endpoint (see ComputeEndpoint); other APIs keep
+// their defaults. Requests go through the proxy set in HTTPS_PROXY, except hosts
+// in NO_PROXY.
+func NewClient(projectName string, credentials option.ClientOption, apiEndpoint string) (*Client, error) {
 	ctx := context.Background()
 
 	var opts []option.ClientOption
-	if credentialsPath != "" {
-		opts = append(opts, option.WithCredentialsFile(credentialsPath))
+	if credentials != nil {
+		opts = append(opts, credentials)
+	}
+
+	computeOpts := opts
+	if apiEndpoint != "" {
+		endpoint, err := ComputeEndpoint(apiEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		// The other services share opts, so the endpoint goes on a copy
+		computeOpts = append(append([]option.ClientOption{}, opts...), option.WithEndpoint(endpoint))
 	}
 
-	computeService, err := compute.NewService(ctx, opts...)
+	computeService, err := compute.NewService(ctx, computeOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create compute service: %w", err)
 	}
@@ -31,15 +61,193 @@ func NewClient(projectName, credentialsPath string) (*Client, error) {
 	return &Client{
 		compute:     computeService,
 		projectName: projectName,
+		opts:        opts,
 	}, nil
 }
 
+// ComputeEndpoint returns the Compute Engine API base URL for an endpoint given as
+// an https URL, e.g. https://compute.restricted.googleapis.com for a VPC Service
+// Controls perimeter. A URL without a path gets the API's /compute/v1/ path.
+func ComputeEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("expected an https URL such as https://compute.restricted.googleapis.com, got '%s'", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/compute/v1/"
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String(), nil
+}
+
 // Compute returns the compute service
 func (c *Client) Compute() *compute.Service {
 	return c.compute
 }
 
+// NetworkManagement returns the Network Management service, creating it on first use
+func (c *Client) NetworkManagement(ctx context.Context) (*networkmanagement.Service, error) {
+	c.networkMgmtOnce.Do(func() {
+		c.networkMgmt, c.networkMgmtErr = networkmanagement.NewService(ctx, c.opts...)
+		if c.networkMgmtErr != nil {
+			c.networkMgmtErr = fmt.Errorf("failed to create network management service: %w", c.networkMgmtErr)
+		}
+	})
+	return c.networkMgmt, c.networkMgmtErr
+}
+
+// AllowedPolicyValues returns the values an org policy list constraint allows for
+// the project (e.g. "projects/golden-images" for compute.trustedImageProjects).
+// It needs orgpolicy.policy.get on the project.
+func (c *Client) AllowedPolicyValues(ctx context.Context, constraint string) ([]string, error) {
+	service, err := cloudresourcemanager.NewService(ctx, c.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager service: %w", err)
+	}
+
+	policy, err := service.Projects.GetEffectiveOrgPolicy("projects/"+c.projectName, &cloudresourcemanager.GetEffectiveOrgPolicyRequest{
+		Constraint: "constraints/" + constraint,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read org policy %s: %w", constraint, err)
+	}
+	if policy.ListPolicy == nil {
+		return nil, nil
+	}
+	return policy.ListPolicy.AllowedValues, nil
+}
+
 // ProjectName returns the project name
 func (c *Client) ProjectName() string {
 	return c.projectName
 }
+
+// ProjectNumber returns the numeric project number of a project ID
+func (c *Client) ProjectNumber(ctx context.Context, project string) (string, error) {
+	p, err := c.compute.Projects.Get(project).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to get project %s: %w", project, err)
+	}
+	return strconv.FormatUint(p.Id, 10), nil
+}
+
+// traceOperation starts the span of an operation for --trace, named after
+// what it does to which resource, e.g. "insert build-vm-1234"
+func traceOperation(ctx context.Context, op *compute.Operation, zone string) *trace.Span {
+	_, span := trace.Start(ctx, trace.CategoryGCP, op.OperationType+" "+ResourceName(op.TargetLink), "operation", op.Name, "zone", zone)
+	return span
+}
+
+// WaitForZoneOperation blocks until a zonal operation completes and returns its error, if any
+func (c *Client) WaitForZoneOperation(ctx context.Context, zone string, op *compute.Operation) (err error) {
+	span := traceOperation(ctx, op, zone)
+	defer func() { span.End(err) }()
+
+	for {
+		result, err := c.compute.ZoneOperations.Wait(c.projectName, zone, op.Name).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed waiting for operation %s: %w", op.Name, err)
+		}
+		if result.Status == "DONE" {
+			return operationError(result)
+		}
+	}
+}
+
+// WaitForGlobalOperation blocks until a global operation completes and returns its error, if any
+func (c *Client) WaitForGlobalOperation(ctx context.Context, op *compute.Operation) (err error) {
+	span := traceOperation(ctx, op, "")
+	defer func() { span.End(err) }()
+
+	for {
+		result, err := c.compute.GlobalOperations.Wait(c.projectName, op.Name).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed waiting for operation %s: %w", op.Name, err)
+		}
+		if result.Status == "DONE" {
+			return operationError(result)
+		}
+	}
+}
+
+// OperationProgress is told about an operation that is still running: its
+// latest state and how long it has been waited for
+type OperationProgress func(op *compute.Operation, elapsed time.Duration)
+
+// WaitForGlobalOperationWithProgress is WaitForGlobalOperation, calling progress
+// about every interval while the operation runs
+func (c *Client) WaitForGlobalOperationWithProgress(ctx context.Context, op *compute.Operation, interval time.Duration, progress OperationProgress) (err error) {
+	span := traceOperation(ctx, op, "")
+	defer func() { span.End(err) }()
+
+	start := time.Now()
+	lastReport := start
+	for {
+		// Each wait is cut short after interval, to report progress in between
+		waitCtx, cancel := context.WithTimeout(ctx, interval)
+		result, err := c.compute.GlobalOperations.Wait(c.projectName, op.Name).Context(waitCtx).Do()
+		cutShort := err != nil && ctx.Err() == nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		if cutShort {
+			result, err = c.compute.GlobalOperations.Get(c.projectName, op.Name).Context(ctx).Do()
+		}
+		if err != nil {
+			return fmt.Errorf("failed waiting for operation %s: %w", op.Name, err)
+		}
+		if result.Status == "DONE" {
+			return operationError(result)
+		}
+		if time.Since(lastReport) >= interval {
+			lastReport = time.Now()
+			progress(result, lastReport.Sub(start))
+		}
+	}
+}
+
+// OperationError is returned when a Compute operation completes with errors
+type OperationError struct {
+	Operation string
+	Errors    []*compute.OperationErrorErrors
+}
+
+func (e *OperationError) Error() string {
+	var messages []string
+	for _, err := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Code, err.Message))
+	}
+	return fmt.Sprintf("operation %s failed: %s", e.Operation, strings.Join(messages, "; "))
+}
+
+// HasCode reports whether any of the operation's errors has the given code
+// (e.g. RESOURCE_IN_USE_BY_ANOTHER_RESOURCE)
+func (e *OperationError) HasCode(code string) bool {
+	for _, err := range e.Errors {
+		if err.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func operationError(op *compute.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	return &OperationError{Operation: op.Name, Errors: op.Error.Errors}
+}
+
+// ResourceName returns the last segment of a resource URL
+// (e.g. .../zones/us-west1-b/machineTypes/e2-standard-2 -> e2-standard-2)
+func ResourceName(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+// RegionFromZone derives the region name from a zone (e.g. us-west1-b -> us-west1)
+func RegionFromZone(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
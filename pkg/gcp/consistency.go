@@ -0,0 +1,94 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+)
+
+// Compute Engine list and get calls are eventually consistent: an image can
+// return 404 for a few seconds after its insert operation is DONE, and a deleted
+// instance can still be listed after its delete operation is DONE. The polls
+// back off between these intervals; variables for tests.
+var (
+	consistencyInitialInterval = time.Second
+	consistencyMaxInterval     = 8 * time.Second
+)
+
+// errNotReflected is charged to the retry budget for each poll that did not
+// find the expected state yet
+var errNotReflected = errors.New("change not reflected yet")
+
+// ErrConsistencyTimeout is returned when a resource did not reach the expected
+// state before the timeout
+var ErrConsistencyTimeout = errors.New("timed out waiting for Compute Engine to reflect the change")
+
+// WaitForImageVisible polls for an image in the client's project until a get
+// returns it, which may take a moment after its creation completed. Errors other
+// than not found are returned immediately.
+func (c *Client) WaitForImageVisible(ctx context.Context, name string, timeout time.Duration) (*compute.Image, error) {
+	var image *compute.Image
+	err := poll(ctx, "get of image "+name, timeout, func() (bool, error) {
+		var err error
+		image, err = c.compute.Images.Get(c.projectName, name).Context(ctx).Do()
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %s: %w", name, err)
+	}
+	return image, nil
+}
+
+// WaitForInstanceGone polls until a deleted instance no longer appears in the
+// zone's instance list
+func (c *Client) WaitForInstanceGone(ctx context.Context, name, zone string, timeout time.Duration) error {
+	err := poll(ctx, "list of instance "+name, timeout, func() (bool, error) {
+		list, err := c.compute.Instances.List(c.projectName, zone).
+			Filter(fmt.Sprintf("name = %q", name)).Fields("items(name)").Context(ctx).Do()
+		if err != nil {
+			return false, err
+		}
+		return len(list.Items) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("instance %s is still listed: %w", name, err)
+	}
+	return nil
+}
+
+// poll calls check with exponential backoff until it reports done, returns an
+// error, ctx is cancelled or timeout elapses. Each repeated check is charged
+// to the build's retry budget as a failure of operation.
+func poll(ctx context.Context, operation string, timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	interval := consistencyInitialInterval
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return ErrConsistencyTimeout
+		}
+		if err := retry.FromContext(ctx).Retry(operation, errNotReflected); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		interval = min(interval*2, consistencyMaxInterval)
+	}
+}
@@ -0,0 +1,114 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/gcptest"
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+)
+
+// newTestClient returns a client of project p whose requests go to a fake
+// Compute Engine API, polling for consistency without waiting long
+func newTestClient(t *testing.T) (*Client, *gcptest.Server) {
+	t.Helper()
+	initialInterval, maxInterval := consistencyInitialInterval, consistencyMaxInterval
+	consistencyInitialInterval, consistencyMaxInterval = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { consistencyInitialInterval, consistencyMaxInterval = initialInterval, maxInterval })
+
+	server := gcptest.NewServer(t)
+	client, err := NewClient("p", option.WithHTTPClient(server.Client()), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// lagging answers the first polls with notYet, then with ready
+func lagging(polls int32, notYet, ready http.HandlerFunc) http.HandlerFunc {
+	var served atomic.Int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1) <= polls {
+			notYet(w, r)
+			return
+		}
+		ready(w, r)
+	}
+}
+
+func TestWaitForImageVisible(t *testing.T) {
+	c, server := newTestClient(t)
+	server.Handle(http.MethodGet, "projects/p/global/images/cache", lagging(3,
+		gcptest.Fail(http.StatusNotFound, "not found"),
+		gcptest.Respond(map[string]any{"name": "cache", "status": "READY"})))
+
+	image, err := c.WaitForImageVisible(context.Background(), "cache", time.Minute)
+	if err != nil {
+		t.Fatalf("WaitForImageVisible() error = %v", err)
+	}
+	if image.Name != "cache" || image.Status != "READY" {
+		t.Errorf("WaitForImageVisible() = %+v", image)
+	}
+	if n := len(server.Requests(http.MethodGet, "projects/p/global/images/cache")); n != 4 {
+		t.Errorf("image got %d times, want 4", n)
+	}
+}
+
+func TestWaitForImageVisibleFailsOnOtherErrors(t *testing.T) {
+	c, server := newTestClient(t)
+	server.Handle(http.MethodGet, "projects/p/global/images/cache", gcptest.Fail(http.StatusForbidden, "denied"))
+
+	if _, err := c.WaitForImageVisible(context.Background(), "cache", time.Minute); err == nil {
+		t.Fatal("WaitForImageVisible() succeeded")
+	}
+	if n := len(server.Requests(http.MethodGet, "projects/p/global/images/cache")); n != 1 {
+		t.Errorf("image got %d times, want 1", n)
+	}
+}
+
+func TestWaitForImageVisibleTimeout(t *testing.T) {
+	c, server := newTestClient(t)
+	server.Handle(http.MethodGet, "projects/p/global/images/cache", gcptest.Fail(http.StatusNotFound, "not found"))
+
+	_, err := c.WaitForImageVisible(context.Background(), "cache", 20*time.Millisecond)
+	if !errors.Is(err, ErrConsistencyTimeout) {
+		t.Errorf("WaitForImageVisible() error = %v, want %v", err, ErrConsistencyTimeout)
+	}
+}
+
+func TestWaitForInstanceGone(t *testing.T) {
+	c, server := newTestClient(t)
+	server.Handle(http.MethodGet, "projects/p/zones/z/instances", lagging(2,
+		gcptest.Respond(map[string]any{"items": []map[string]string{{"name": "builder"}}}),
+		gcptest.Respond(map[string]any{})))
+
+	if err := c.WaitForInstanceGone(context.Background(), "builder", "z", time.Minute); err != nil {
+		t.Fatalf("WaitForInstanceGone() error = %v", err)
+	}
+	if n := len(server.Requests(http.MethodGet, "projects/p/zones/z/instances")); n != 3 {
+		t.Errorf("instances listed %d times, want 3", n)
+	}
+}
+
+// TestPollChargesRetryBudget keeps listing a deleted instance: every repeated
+// poll is charged to the build's retry budget, which stops the wait once spent
+func TestPollChargesRetryBudget(t *testing.T) {
+	c, server := newTestClient(t)
+	server.Handle(http.MethodGet, "projects/p/zones/z/instances",
+		gcptest.Respond(map[string]any{"items": []map[string]string{{"name": "builder"}}}))
+
+	ctx := retry.WithBudget(context.Background(), retry.NewBudget(2))
+	err := c.WaitForInstanceGone(ctx, "builder", "z", time.Minute)
+	if err == nil || errors.Is(err, ErrConsistencyTimeout) {
+		t.Fatalf("WaitForInstanceGone() error = %v, want the budget spent", err)
+	}
+	if n := len(server.Requests(http.MethodGet, "projects/p/zones/z/instances")); n != 3 {
+		t.Errorf("instances listed %d times, want 3", n)
+	}
+}
@@ -0,0 +1,14 @@
+package gcp
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// IsNotFound reports whether a Google API request failed because the resource does not exist
+func IsNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
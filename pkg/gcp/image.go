@@ -0,0 +1,56 @@
+package gcp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// imagePathPattern matches projects/<project>/global/images/<name> and
+// projects/<project>/global/images/family/<family>. Legacy domain-scoped
+// project IDs (example.com:project) are allowed.
+var imagePathPattern = regexp.MustCompile(`^projects/((?:[a-z][-a-z0-9.]*:)?[a-z][-a-z0-9]{4,28}[a-z0-9])/global/images/(family/)?([a-z](?:[-a-z0-9]{0,61}[a-z0-9])?)$`)
+
+// imageNamePattern matches a Compute image name
+var imageNamePattern = regexp.MustCompile(`^[a-z](?:[-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// IsImageName reports whether name is a valid Compute image name
+func IsImageName(name string) bool {
+	return imageNamePattern.MatchString(name)
+}
+
+// ImagePath identifies a Compute image by name or by family in an image project
+type ImagePath struct {
+	Project string
+	Name    string // image or family name
+	Family  bool
+}
+
+// ParseImagePath parses a fully-qualified image path or self-link, e.g.
+// projects/golden-images/global/images/family/ubuntu-hardened
+func ParseImagePath(path string) (*ImagePath, error) {
+	trimmed := path
+	if i := strings.Index(trimmed, "/compute/v1/"); i >= 0 && strings.HasPrefix(trimmed, "https://") {
+		trimmed = trimmed[i+len("/compute/v1/"):]
+	}
+
+	match := imagePathPattern.FindStringSubmatch(trimmed)
+	if match == nil {
+		return nil, fmt.Errorf("expected projects/<project>/global/images/<name> or projects/<project>/global/images/family/<family>, got '%s'", path)
+	}
+	return &ImagePath{Project: match[1], Name: match[3], Family: match[2] != ""}, nil
+}
+
+// SelfLink returns the image's Compute API URL, the form other tools such as
+// gcloud and Terraform accept wherever an image is expected
+func (p *ImagePath) SelfLink() string {
+	return "https://www.googleapis.com/compute/v1/" + p.String()
+}
+
+// String returns the path in the form accepted as an instance's source image
+func (p *ImagePath) String() string {
+	if p.Family {
+		return fmt.Sprintf("projects/%s/global/images/family/%s", p.Project, p.Name)
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", p.Project, p.Name)
+}
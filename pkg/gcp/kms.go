@@ -0,0 +1,62 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// kmsKeyVersionPattern matches projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v>
+var kmsKeyVersionPattern = regexp.MustCompile(`^projects/[a-z0-9.:-]+/locations/[a-z0-9-]+/keyRings/[A-Za-z0-9_-]{1,63}/cryptoKeys/[A-Za-z0-9_-]{1,63}/cryptoKeyVersions/[1-9][0-9]*$`)
+
+// ValidateKMSKeyVersion checks the format of a Cloud KMS key version resource name
+func ValidateKMSKeyVersion(name string) error {
+	if !kmsKeyVersionPattern.MatchString(name) {
+		return fmt.Errorf("expected projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>/cryptoKeyVersions/<version>, got '%s'", name)
+	}
+	return nil
+}
+
+// castagnoli is the CRC32C table KMS uses to detect corruption in transit
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// AsymmetricSign signs a SHA-256 digest with a Cloud KMS key version. The caller
+// needs cloudkms.cryptoKeyVersions.useToSign on the key (roles/cloudkms.signer).
+func (c *Client) AsymmetricSign(ctx context.Context, keyVersion string, digest []byte) ([]byte, error) {
+	service, err := cloudkms.NewService(ctx, c.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create KMS service: %w", err)
+	}
+
+	request := &cloudkms.AsymmetricSignRequest{
+		Digest:       &cloudkms.Digest{Sha256: base64.StdEncoding.EncodeToString(digest)},
+		DigestCrc32c: int64(crc32.Checksum(digest, castagnoli)),
+	}
+	resp, err := service.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.AsymmetricSign(keyVersion, request).Context(ctx).Do()
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+			return nil, fmt.Errorf("permission denied signing with %s: grant roles/cloudkms.signer on the key: %w", keyVersion, err)
+		}
+		return nil, fmt.Errorf("failed to sign with %s: %w", keyVersion, err)
+	}
+	if !resp.VerifiedDigestCrc32c {
+		return nil, fmt.Errorf("signing with %s: the digest was corrupted in transit", keyVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature from %s: %w", keyVersion, err)
+	}
+	if int64(crc32.Checksum(signature, castagnoli)) != resp.SignatureCrc32c {
+		return nil, fmt.Errorf("signing with %s: the signature was corrupted in transit", keyVersion)
+	}
+	return signature, nil
+}
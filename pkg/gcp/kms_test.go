@@ -0,0 +1,109 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+const testKeyVersion = "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+
+// newKMSTestClient returns a client whose KMS requests go to a fake
+// asymmetricSign endpoint. It signs a digest by reversing it and answers
+// as respond changes the response, if set.
+func newKMSTestClient(t *testing.T, status int, respond func(response map[string]any)) *Client {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/"+testKeyVersion+":asymmetricSign") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": status, "message": "denied"}})
+			return
+		}
+
+		var request struct {
+			Digest       struct{ Sha256 string }
+			DigestCrc32c string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		digest, _ := base64.StdEncoding.DecodeString(request.Digest.Sha256)
+		signature := make([]byte, len(digest))
+		for i, b := range digest {
+			signature[len(digest)-1-i] = b
+		}
+		response := map[string]any{
+			"signature":            base64.StdEncoding.EncodeToString(signature),
+			"signatureCrc32c":      strconv.FormatUint(uint64(crc32.Checksum(signature, castagnoli)), 10),
+			"verifiedDigestCrc32c": request.DigestCrc32c == strconv.FormatUint(uint64(crc32.Checksum(digest, castagnoli)), 10),
+		}
+		if respond != nil {
+			respond(response)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return &Client{opts: []option.ClientOption{option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL + "/")}}
+}
+
+func TestAsymmetricSign(t *testing.T) {
+	digest := []byte{1, 2, 3, 4}
+
+	tests := []struct {
+		name    string
+		status  int
+		respond func(response map[string]any)
+		wantErr string
+	}{
+		{name: "signed", status: http.StatusOK},
+		{name: "permission denied", status: http.StatusForbidden, wantErr: "roles/cloudkms.signer"},
+		{name: "digest corrupted", status: http.StatusOK, respond: func(r map[string]any) { r["verifiedDigestCrc32c"] = false }, wantErr: "digest was corrupted"},
+		{name: "signature corrupted", status: http.StatusOK, respond: func(r map[string]any) { r["signatureCrc32c"] = "1" }, wantErr: "signature was corrupted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newKMSTestClient(t, tt.status, tt.respond)
+			signature, err := c.AsymmetricSign(context.Background(), testKeyVersion, digest)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("AsymmetricSign() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AsymmetricSign() error = %v", err)
+			}
+			if string(signature) != string([]byte{4, 3, 2, 1}) {
+				t.Errorf("AsymmetricSign() = %v, want [4 3 2 1]", signature)
+			}
+		})
+	}
+}
+
+func TestValidateKMSKeyVersion(t *testing.T) {
+	if err := ValidateKMSKeyVersion(testKeyVersion); err != nil {
+		t.Errorf("ValidateKMSKeyVersion(%q) error = %v", testKeyVersion, err)
+	}
+	for _, name := range []string{
+		"projects/p/locations/global/keyRings/r/cryptoKeys/k",
+		"projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/0",
+		"gcpkms://" + testKeyVersion,
+	} {
+		if err := ValidateKMSKeyVersion(name); err == nil {
+			t.Errorf("ValidateKMSKeyVersion(%q) succeeded", name)
+		}
+	}
+}
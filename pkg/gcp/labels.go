@@ -0,0 +1,14 @@
+package gcp
+
+import "fmt"
+
+// MaxLabels is how many labels a Compute Engine resource can carry
+const MaxLabels = 64
+
+// CheckLabelCount fails if labels, all of those of resource, are more than it can carry
+func CheckLabelCount(resource string, labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("%s would carry %d labels, but at most %d are allowed", resource, len(labels), MaxLabels)
+	}
+	return nil
+}
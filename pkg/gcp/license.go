@@ -0,0 +1,36 @@
+package gcp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// licensePathPattern matches projects/<project>/global/licenses/<name>
+var licensePathPattern = regexp.MustCompile(`^projects/((?:[a-z][-a-z0-9.]*:)?[a-z][-a-z0-9]{4,28}[a-z0-9])/global/licenses/([a-z](?:[-a-z0-9]{0,61}[a-z0-9])?)$`)
+
+// LicensePath identifies a Compute image license in the project that owns it
+type LicensePath struct {
+	Project string
+	Name    string
+}
+
+// ParseLicensePath parses a fully-qualified license path or self-link, e.g.
+// projects/my-org-governance/global/licenses/approved-base
+func ParseLicensePath(path string) (*LicensePath, error) {
+	trimmed := path
+	if i := strings.Index(trimmed, "/compute/v1/"); i >= 0 && strings.HasPrefix(trimmed, "https://") {
+		trimmed = trimmed[i+len("/compute/v1/"):]
+	}
+
+	match := licensePathPattern.FindStringSubmatch(trimmed)
+	if match == nil {
+		return nil, fmt.Errorf("expected projects/<project>/global/licenses/<name>, got '%s'", path)
+	}
+	return &LicensePath{Project: match[1], Name: match[2]}, nil
+}
+
+// String returns the path in the form accepted in an image's licenses
+func (p *LicensePath) String() string {
+	return fmt.Sprintf("projects/%s/global/licenses/%s", p.Project, p.Name)
+}
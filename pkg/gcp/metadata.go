@@ -0,0 +1,86 @@
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/httputil"
+)
+
+// metadataProbeTimeout bounds the metadata server probe; on GCP it answers well under a second
+const metadataProbeTimeout = 2 * time.Second
+
+// metadataHost is the link-local address of the GCE metadata server.
+// Using the IP avoids a DNS lookup for metadata.google.internal off-GCP.
+const metadataHost = "169.254.169.254"
+
+var metadataClient = &http.Client{
+	Timeout: metadataProbeTimeout,
+	Transport: httputil.Transport("metadata", &http.Transport{
+		// Ignore proxy settings: the metadata server is only reachable directly
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: metadataProbeTimeout,
+		}).DialContext,
+	}),
+}
+
+// QueryMetadata reads a value from the GCE metadata server of the machine this
+// process runs on. Off GCP it fails within a couple of seconds.
+func QueryMetadata(path string) (string, error) {
+	host := metadataHost
+	if override := os.Getenv("GCE_METADATA_HOST"); override != "" {
+		host = override
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	// Off-GCP this fails fast (connection refused / no route) or after the short timeout
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Metadata-Flavor") != "Google" {
+		return "", fmt.Errorf("unexpected metadata server response: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// ControllerNetworks returns the VPC networks of this machine's network
+// interfaces as projects/<project-number>/networks/<name>
+func ControllerNetworks() ([]string, error) {
+	data, err := QueryMetadata("instance/network-interfaces/?recursive=true")
+	if err != nil {
+		return nil, err
+	}
+
+	var nics []struct {
+		Network string `json:"network"`
+	}
+	if err := json.Unmarshal([]byte(data), &nics); err != nil {
+		return nil, fmt.Errorf("unexpected network interface metadata: %w", err)
+	}
+
+	networks := make([]string, 0, len(nics))
+	for _, nic := range nics {
+		networks = append(networks, nic.Network)
+	}
+	return networks, nil
+}
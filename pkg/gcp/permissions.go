@@ -0,0 +1,25 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// TestPermissions returns which of permissions the credentials hold on the
+// project. It needs the Cloud Resource Manager API, but no permission of its own.
+func (c *Client) TestPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	service, err := cloudresourcemanager.NewService(ctx, c.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager service: %w", err)
+	}
+
+	response, err := service.Projects.TestIamPermissions(c.projectName, &cloudresourcemanager.TestIamPermissionsRequest{
+		Permissions: permissions,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to test permissions on project %s: %w", c.projectName, err)
+	}
+	return response.Permissions, nil
+}
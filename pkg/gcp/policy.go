@@ -0,0 +1,113 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/log"
+)
+
+// constraintPattern finds the constraint named in an org policy violation, e.g.
+// "Constraint constraints/compute.vmExternalIpAccess violated for project ..."
+var constraintPattern = regexp.MustCompile(`constraints/([A-Za-z0-9_.]+)`)
+
+// PolicyViolation is returned when an organization policy constraint blocks a
+// Compute request. The raw API error is available through Unwrap.
+type PolicyViolation struct {
+	Constraint string // e.g. compute.vmExternalIpAccess
+	Resource   string // what was being created
+
+	// Allowed lists the values the constraint allows, when they could be read
+	Allowed []string
+
+	err error
+}
+
+func (e *PolicyViolation) Error() string {
+	return fmt.Sprintf("organization policy constraint %s does not allow %s", e.Constraint, e.Resource)
+}
+
+func (e *PolicyViolation) Unwrap() error {
+	return e.err
+}
+
+// Remediation suggests how to build within the constraint
+func (e *PolicyViolation) Remediation() string {
+	switch e.Constraint {
+	case "compute.vmExternalIpAccess":
+		return "Create the build VM without an external IP: --no-external-ip (the VM then needs Cloud NAT or Private Google Access to pull images, and SSH goes to its internal IP or through --ssh-proxy-jump)"
+	case "compute.requireShieldedVm":
+		return "Create a Shielded VM: --shielded-vm (a custom --build-vm-image must support UEFI)"
+	case trustedImageProjects:
+		remedy := "Boot the build VM from an image in an allowed project: --build-vm-image=projects/<allowed-project>/global/images/[family/]<name>"
+		if len(e.Allowed) > 0 {
+			remedy += "\n    Allowed image projects: " + strings.Join(e.Allowed, ", ")
+		}
+		return remedy
+	case "compute.restrictSharedVpcSubnetworks", "compute.restrictSharedVpcHostProjects":
+		return "Use a network and subnet the policy allows: --network=<network> --subnet=<subnet>"
+	}
+	return "Ask your organization policy administrator which values the constraint allows, or for an exception for the build project"
+}
+
+// PolicyError returns a *PolicyViolation wrapping err if err is an org policy
+// violation, and err unchanged otherwise
+func PolicyError(err error, resource string) error {
+	if err == nil {
+		return nil
+	}
+
+	var messages []string
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		messages = append(messages, apiErr.Message)
+		for _, item := range apiErr.Errors {
+			messages = append(messages, item.Message)
+		}
+	}
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		for _, item := range opErr.Errors {
+			messages = append(messages, item.Message)
+		}
+	}
+
+	for _, message := range messages {
+		if !strings.Contains(message, "violated") && !strings.Contains(message, "constraintViolation") {
+			continue
+		}
+		if match := constraintPattern.FindStringSubmatch(message); match != nil {
+			return &PolicyViolation{Constraint: match[1], Resource: resource, err: err}
+		}
+	}
+	return err
+}
+
+// trustedImageProjects is the org policy constraint restricting image projects
+const trustedImageProjects = "compute.trustedImageProjects"
+
+// ExplainPolicyError is PolicyError for a request of the client, reading the
+// image projects compute.trustedImageProjects allows into the violation, since
+// the error names the rejected project only. The raw error of a violation is
+// logged at debug level.
+func (c *Client) ExplainPolicyError(ctx context.Context, logger *log.Logger, err error, resource string) error {
+	err = PolicyError(err, resource)
+	var violation *PolicyViolation
+	if !errors.As(err, &violation) {
+		return err
+	}
+	logger.Debugf("Organization policy error: %v", violation.Unwrap())
+	if violation.Constraint == trustedImageProjects {
+		allowed, policyErr := c.AllowedPolicyValues(ctx, trustedImageProjects)
+		if policyErr != nil {
+			logger.Debugf("Cannot list allowed image projects: %v", policyErr)
+		}
+		violation.Allowed = allowed
+	}
+	return err
+}
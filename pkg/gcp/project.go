@@ -0,0 +1,45 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// ErrProjectNotFound is returned by CheckProject for a project that does not exist
+	ErrProjectNotFound = errors.New("project does not exist")
+
+	// ErrProjectAccessDenied is returned by CheckProject for a project the
+	// credentials cannot read
+	ErrProjectAccessDenied = errors.New("permission denied on project")
+)
+
+// CheckProject reads a project through the Compute Engine API and returns its
+// number. Compute Engine answers 404 for a project that does not exist and 403
+// for one the credentials have no role in, which come back as
+// ErrProjectNotFound and ErrProjectAccessDenied; a disabled API is returned as
+// APINotEnabled.
+func (c *Client) CheckProject(ctx context.Context, project string) (string, error) {
+	number, err := c.ProjectNumber(ctx, project)
+	if err == nil {
+		return number, nil
+	}
+	if _, disabled := AsAPINotEnabled(err); disabled {
+		return "", err
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusNotFound:
+			return "", fmt.Errorf("%w: %s (check --project-name for a typo)", ErrProjectNotFound, project)
+		case http.StatusForbidden:
+			return "", fmt.Errorf("%w %s: the credentials have no role in it granting compute.projects.get: %w",
+				ErrProjectAccessDenied, project, err)
+		}
+	}
+	return "", err
+}
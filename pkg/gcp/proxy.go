@@ -0,0 +1,69 @@
+package gcp
+
+import (
+	"os"
+	"strings"
+)
+
+// proxyVariables are the environment variables Go's HTTP transport, the gcloud
+// SDKs and containerd read their proxy configuration from
+var proxyVariables = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"}
+
+// metadataProxyBypass lists the metadata server's addresses, which are only
+// reachable directly from the VM
+var metadataProxyBypass = []string{"metadata.google.internal", metadataHost}
+
+// BypassProxyForMetadata adds the metadata server to NO_PROXY when an HTTP(S)
+// proxy is configured, so credential lookups and metadata probes never go
+// through a corporate proxy that cannot reach it. It must run before the first
+// HTTP request: Go reads the proxy variables once per process.
+func BypassProxyForMetadata() {
+	if firstEnv("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy") == "" {
+		return
+	}
+
+	noProxy := firstEnv("NO_PROXY", "no_proxy")
+	entries := strings.Split(noProxy, ",")
+	for _, host := range metadataProxyBypass {
+		if !containsHost(entries, host) {
+			entries = append(entries, host)
+		}
+	}
+	noProxy = strings.Trim(strings.Join(entries, ","), ",")
+
+	// Child processes such as curl prefer the lowercase variable
+	os.Setenv("NO_PROXY", noProxy)
+	if _, ok := os.LookupEnv("no_proxy"); ok {
+		os.Setenv("no_proxy", noProxy)
+	}
+}
+
+// ProxyEnv returns the proxy variables set for this process as NAME=value
+// assignments, to hand them to commands run under sudo, which drops them
+func ProxyEnv() []string {
+	var env []string
+	for _, name := range proxyVariables {
+		if value := os.Getenv(name); value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func containsHost(entries []string, host string) bool {
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == host {
+			return true
+		}
+	}
+	return false
+}
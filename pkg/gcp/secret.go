@@ -0,0 +1,64 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// SecretScheme prefixes config values that are read from Secret Manager
+const SecretScheme = "secret://"
+
+// secretVersionPattern matches projects/<project>/secrets/<secret>/versions/<version|latest>
+var secretVersionPattern = regexp.MustCompile(`^projects/[a-z0-9.:-]+/secrets/[A-Za-z0-9_-]{1,255}/versions/(?:[1-9][0-9]*|latest)$`)
+
+// IsSecretURI reports whether a config value refers to a Secret Manager secret
+func IsSecretURI(value string) bool {
+	return strings.HasPrefix(value, SecretScheme)
+}
+
+// ParseSecretURI returns the secret version resource name of a
+// secret://projects/<project>/secrets/<secret>/versions/<version> URI
+func ParseSecretURI(uri string) (string, error) {
+	name := strings.TrimPrefix(uri, SecretScheme)
+	if !IsSecretURI(uri) || !secretVersionPattern.MatchString(name) {
+		return "", fmt.Errorf("expected secret://projects/<project>/secrets/<secret>/versions/<version|latest>, got '%s'", uri)
+	}
+	return name, nil
+}
+
+// AccessSecret reads the payload of the secret version a secret:// URI refers to.
+// The caller needs secretmanager.versions.access on the secret.
+func AccessSecret(ctx context.Context, credentialsPath, uri string) ([]byte, error) {
+	name, err := ParseSecretURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []option.ClientOption
+	if credentialsPath != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsPath))
+	}
+	service, err := secretmanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret manager service: %w", err)
+	}
+
+	resp, err := service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret %s: %w", name, err)
+	}
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("secret %s has no payload", name)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret %s: %w", name, err)
+	}
+	return data, nil
+}
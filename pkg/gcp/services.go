@@ -0,0 +1,24 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/serviceusage/v1"
+)
+
+// ServiceEnabled reports whether an API, e.g. containerfilesystem.googleapis.com,
+// is enabled on the project. It needs serviceusage.services.get on the project.
+func (c *Client) ServiceEnabled(ctx context.Context, service string) (bool, error) {
+	usage, err := serviceusage.NewService(ctx, c.opts...)
+	if err != nil {
+		return false, fmt.Errorf("failed to create service usage service: %w", err)
+	}
+
+	state, err := usage.Services.Get(fmt.Sprintf("projects/%s/services/%s", c.projectName, service)).
+		Fields("state").Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the state of %s: %w", service, err)
+	}
+	return state.State == "ENABLED", nil
+}
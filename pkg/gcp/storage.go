@@ -0,0 +1,44 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// UploadObject writes data to gs://bucket/name, replacing any existing object
+func (c *Client) UploadObject(ctx context.Context, bucket, name, contentType string, data []byte) error {
+	service, err := storage.NewService(ctx, c.opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create storage service: %w", err)
+	}
+
+	object := &storage.Object{Name: name, ContentType: contentType}
+	if _, err := service.Objects.Insert(bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to upload gs://%s/%s: %w", bucket, name, err)
+	}
+	return nil
+}
+
+// DownloadObject reads gs://bucket/name
+func (c *Client) DownloadObject(ctx context.Context, bucket, name string) ([]byte, error) {
+	service, err := storage.NewService(ctx, c.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage service: %w", err)
+	}
+
+	resp, err := service.Objects.Get(bucket, name).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download gs://%s/%s: %w", bucket, name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download gs://%s/%s: %w", bucket, name, err)
+	}
+	return data, nil
+}
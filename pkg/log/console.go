@@ -2,39 +2,87 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 )
 
-// ConsoleImpl implements console-only logging (no GCS)
-type ConsoleImpl struct{}
+// ANSI escape sequences used for level prefixes
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorGray   = "\x1b[90m"
+)
+
+// ConsoleImpl writes "timestamp [LEVEL] message" lines: warnings and errors
+// to one writer, everything else to the other
+type ConsoleImpl struct {
+	out    io.Writer
+	errOut io.Writer
+	color  bool
+}
+
+// NewConsoleImpl creates a new console logger implementation writing to stdout
+// and stderr; color enables ANSI colored level prefixes
+func NewConsoleImpl(color bool) *ConsoleImpl {
+	return &ConsoleImpl{out: os.Stdout, errOut: os.Stderr, color: color}
+}
+
+// NewTextImpl creates a console-formatted logger implementation writing every
+// level to w, without colors
+func NewTextImpl(w io.Writer) *ConsoleImpl {
+	return &ConsoleImpl{out: w, errOut: w}
+}
+
+// ColorEnabled reports whether console output may be colored: not when
+// noColor is set, the NO_COLOR environment variable is set (https://no-color.org),
+// TERM is "dumb", or stdout or stderr is not a terminal
+func ColorEnabled(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout) && isTerminal(os.Stderr)
+}
 
-// NewConsoleImpl creates a new console logger implementation
-func NewConsoleImpl() *ConsoleImpl {
-	return &ConsoleImpl{}
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
 
 // Log outputs a message to the console with appropriate formatting
 func (c *ConsoleImpl) Log(level LogLevel, message string) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 
-	var prefix string
-	var output *os.File = os.Stdout
+	prefix := "[" + level.String() + "]"
+	output := c.out
+	if level == LevelWarn || level == LevelError {
+		output = c.errOut
+	}
 
+	if c.color {
+		prefix = levelColor(level) + prefix + colorReset
+	}
+	fmt.Fprintf(output, "%s %s %s\n", timestamp, prefix, message)
+}
+
+// levelColor returns the color of a level's prefix
+func levelColor(level LogLevel) string {
 	switch level {
-	case LevelInfo:
-		prefix = "[INFO]"
 	case LevelWarn:
-		prefix = "[WARN]"
-		output = os.Stderr
+		return colorYellow
 	case LevelError:
-		prefix = "[ERROR]"
-		output = os.Stderr
+		return colorRed
 	case LevelSuccess:
-		prefix = "[SUCCESS]"
-	case LevelProgress:
-		prefix = "[PROGRESS]"
+		return colorGreen
+	case LevelDebug, LevelTrace:
+		return colorGray
 	}
-
-	fmt.Fprintf(output, "%s %s %s\n", timestamp, prefix, message)
+	return colorBlue
 }
@@ -0,0 +1,32 @@
+package log
+
+import (
+	"fmt"
+	"os"
+)
+
+// FileImpl appends log messages to a file, as text or JSON lines
+type FileImpl struct {
+	LoggerImpl
+	file *os.File
+}
+
+// NewFileImpl opens path for appending, creating it if needed, and writes
+// JSON lines to it if json is set, plain console-formatted lines otherwise
+func NewFileImpl(path string, json bool) (*FileImpl, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	var impl LoggerImpl = NewTextImpl(file)
+	if json {
+		impl = NewJSONImpl(file)
+	}
+	return &FileImpl{LoggerImpl: impl, file: file}, nil
+}
+
+// Close closes the log file
+func (f *FileImpl) Close() error {
+	return f.file.Close()
+}
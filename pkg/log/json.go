@@ -0,0 +1,44 @@
+package log
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONImpl writes one JSON object per message, for log collectors:
+// {"time":"2024-01-02T15:04:05.000Z","level":"info","message":"..."}
+type JSONImpl struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// jsonEntry is one line of JSONImpl output
+type jsonEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// NewJSONImpl creates a logger implementation writing JSON lines to w
+func NewJSONImpl(w io.Writer) *JSONImpl {
+	return &JSONImpl{out: w}
+}
+
+// Log writes message as a JSON line
+func (j *JSONImpl) Log(level LogLevel, message string) {
+	data, err := json.Marshal(jsonEntry{
+		Time:    time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Level:   strings.ToLower(level.String()),
+		Message: message,
+	})
+	if err != nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.out.Write(append(data, '\n'))
+}
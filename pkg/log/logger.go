@@ -2,13 +2,18 @@ package log
 
 import (
 	"fmt"
+	"io"
+	"sync/atomic"
 )
 
-// Logger provides structured logging interface (console only, no GCS)
+// Logger provides structured logging interface. It filters messages by
+// verbosity and hands them to a LoggerImpl, which formats and writes them.
 type Logger struct {
-	verbose bool
-	quiet   bool
-	impl    LoggerImpl
+	verbose  bool
+	quiet    bool
+	trace    atomic.Bool
+	impl     LoggerImpl
+	warnings atomic.Int64
 }
 
 // LoggerImpl defines the logging implementation interface
@@ -25,17 +30,54 @@ const (
 	LevelError
 	LevelSuccess
 	LevelProgress
+	LevelDebug
+	LevelTrace
 )
 
-// NewConsoleLogger creates a console-only logger (no GCS)
-func NewConsoleLogger(verbose, quiet bool) *Logger {
+// String returns the level's name, e.g. "INFO"
+func (l LogLevel) String() string {
+	switch l {
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	case LevelSuccess:
+		return "SUCCESS"
+	case LevelProgress:
+		return "PROGRESS"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelTrace:
+		return "TRACE"
+	}
+	return fmt.Sprintf("LEVEL%d", int(l))
+}
+
+// NewLogger creates a logger that writes through impl
+func NewLogger(verbose, quiet bool, impl LoggerImpl) *Logger {
 	return &Logger{
 		verbose: verbose,
 		quiet:   quiet,
-		impl:    NewConsoleImpl(),
+		impl:    impl,
 	}
 }
 
+// NewConsoleLogger creates a console-only logger (no GCS); level prefixes are
+// colored unless noColor is set or ColorEnabled rules it out
+func NewConsoleLogger(verbose, quiet, noColor bool) *Logger {
+	return NewLogger(verbose, quiet, NewConsoleImpl(ColorEnabled(noColor)))
+}
+
+// Close closes the implementation if it holds resources such as a log file
+func (l *Logger) Close() error {
+	if closer, ok := l.impl.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // Info logs an info message
 func (l *Logger) Info(msg string) {
 	if !l.quiet {
@@ -50,6 +92,7 @@ func (l *Logger) Infof(format string, args ...interface{}) {
 
 // Warn logs a warning message
 func (l *Logger) Warn(msg string) {
+	l.warnings.Add(1)
 	l.impl.Log(LevelWarn, msg)
 }
 
@@ -58,6 +101,11 @@ func (l *Logger) Warnf(format string, args ...interface{}) {
 	l.Warn(fmt.Sprintf(format, args...))
 }
 
+// Warnings returns the number of warnings logged so far
+func (l *Logger) Warnings() int {
+	return int(l.warnings.Load())
+}
+
 // Error logs an error message
 func (l *Logger) Error(msg string) {
 	l.impl.Log(LevelError, msg)
@@ -96,7 +144,7 @@ func (l *Logger) Progressf(step, total int, format string, args ...interface{})
 // Debug logs a debug message (only in verbose mode)
 func (l *Logger) Debug(msg string) {
 	if l.verbose {
-		l.impl.Log(LevelInfo, fmt.Sprintf("[DEBUG] %s", msg))
+		l.impl.Log(LevelDebug, msg)
 	}
 }
 
@@ -104,3 +152,21 @@ func (l *Logger) Debug(msg string) {
 func (l *Logger) Debugf(format string, args ...interface{}) {
 	l.Debug(fmt.Sprintf(format, args...))
 }
+
+// SetTrace turns trace messages on or off; they are off unless a debug flag
+// such as --debug-http asks for them
+func (l *Logger) SetTrace(enabled bool) {
+	l.trace.Store(enabled)
+}
+
+// Trace logs a trace message (only when turned on with SetTrace, and not in quiet mode)
+func (l *Logger) Trace(msg string) {
+	if l.trace.Load() && !l.quiet {
+		l.impl.Log(LevelTrace, msg)
+	}
+}
+
+// Tracef logs a formatted trace message (only when turned on with SetTrace, and not in quiet mode)
+func (l *Logger) Tracef(format string, args ...interface{}) {
+	l.Trace(fmt.Sprintf(format, args...))
+}
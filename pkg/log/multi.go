@@ -0,0 +1,28 @@
+package log
+
+import (
+	"errors"
+	"io"
+)
+
+// MultiImpl hands every message to several logger implementations, e.g. the
+// console and a log file
+type MultiImpl []LoggerImpl
+
+// Log passes message to each implementation
+func (m MultiImpl) Log(level LogLevel, message string) {
+	for _, impl := range m {
+		impl.Log(level, message)
+	}
+}
+
+// Close closes the implementations that hold resources
+func (m MultiImpl) Close() error {
+	var errs []error
+	for _, impl := range m {
+		if closer, ok := impl.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
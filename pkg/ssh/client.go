@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultUser is the account created on build VMs for the builder's key
+const DefaultUser = "gke-cache-builder"
+
+const dialTimeout = 30 * time.Second
+
+// Client runs commands on a build VM over SSH
+type Client struct {
+	addr   string
+	client *gossh.Client
+
+	// bastion is the first hop when connected through a ProxyJump
+	bastion *gossh.Client
+}
+
+// NewClient connects to addr (host:port) as user, authenticating with the given key
+// and accepting only host keys the verifier trusts. A non-nil jump tunnels the
+// connection through a bastion. It makes a single attempt; use WaitForSSHReady
+// while the VM may still be booting.
+func NewClient(ctx context.Context, addr, user string, key *KeyPair, hostKeys *HostKeyVerifier, jump *ProxyJump) (*Client, error) {
+	if key == nil {
+		return nil, fmt.Errorf("no SSH key provided for %s", addr)
+	}
+	if hostKeys == nil {
+		return nil, fmt.Errorf("no host key verification configured for %s", addr)
+	}
+
+	config := &gossh.ClientConfig{
+		User:    user,
+		Auth:    []gossh.AuthMethod{gossh.PublicKeys(key.Signer())},
+		Timeout: dialTimeout,
+	}
+
+	var conn net.Conn
+	var bastion *gossh.Client
+	var err error
+	if jump != nil {
+		conn, bastion, err = jump.dial(ctx, addr)
+	} else {
+		conn, err = (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s over SSH: %w", addr, err)
+	}
+
+	// Bound the handshake, which does not observe ctx
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	sshConn, chans, reqs, err := hostKeys.handshake(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		if bastion != nil {
+			bastion.Close()
+		}
+		return nil, fmt.Errorf("failed to connect to %s over SSH: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &Client{
+		addr:    addr,
+		client:  gossh.NewClient(sshConn, chans, reqs),
+		bastion: bastion,
+	}, nil
+}
+
+// Run executes a command and returns its combined output.
+// The remote session is closed if ctx is cancelled.
+func (c *Client) Run(ctx context.Context, command string) (string, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSH session to %s: %w", c.addr, err)
+	}
+	defer session.Close()
+
+	var output bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &output
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return output.String(), fmt.Errorf("command failed on %s: %w", c.addr, err)
+		}
+		return output.String(), nil
+	case <-ctx.Done():
+		session.Close()
+		return "", ctx.Err()
+	}
+}
+
+// Close closes the SSH connection and, if any, the bastion connection
+func (c *Client) Close() error {
+	err := c.client.Close()
+	if c.bastion != nil {
+		c.bastion.Close()
+	}
+	return err
+}
+
+// Address joins a host and the SSH port
+func Address(host string) string {
+	return net.JoinHostPort(host, "22")
+}
@@ -0,0 +1,171 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrHostKeyMismatch is returned when a build VM presents a host key other than the expected one
+var ErrHostKeyMismatch = errors.New("SSH host key mismatch")
+
+// HostKeyVerifier decides whether a build VM's host key is trusted
+type HostKeyVerifier struct {
+	callback gossh.HostKeyCallback
+
+	// algorithms restricts negotiation to the key types we can verify
+	algorithms []string
+}
+
+// FixedHostKeys trusts only the given host keys (authorized_keys format),
+// typically the ones the VM published through guest attributes
+func FixedHostKeys(authorizedKeys []string) (*HostKeyVerifier, error) {
+	var keys []gossh.PublicKey
+	var algorithms []string
+
+	for _, line := range authorizedKeys {
+		key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key %q: %w", line, err)
+		}
+		keys = append(keys, key)
+		algorithms = append(algorithms, hostKeyAlgorithms(key.Type())...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys to verify against")
+	}
+
+	callback := func(hostname string, remote net.Addr, presented gossh.PublicKey) error {
+		for _, key := range keys {
+			if bytes.Equal(key.Marshal(), presented.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s presented %s %s, which the VM did not publish",
+			ErrHostKeyMismatch, hostname, presented.Type(), gossh.FingerprintSHA256(presented))
+	}
+
+	return &HostKeyVerifier{callback: callback, algorithms: algorithms}, nil
+}
+
+// TrustOnFirstUse records the first host key seen for hostAlias in a known_hosts
+// file and rejects any different key afterwards. hostAlias should identify the VM
+// itself rather than its address, since ephemeral IPs are reused across VMs.
+func TrustOnFirstUse(knownHostsPath, hostAlias string) (*HostKeyVerifier, error) {
+	// known_hosts lookups take a host:port, an alias without a port stands for port 22
+	address := hostAlias
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(hostAlias, "22")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(knownHostsPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+	f, err := os.OpenFile(knownHostsPath, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known_hosts %s: %w", knownHostsPath, err)
+	}
+	f.Close()
+
+	check, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts %s: %w", knownHostsPath, err)
+	}
+
+	var mu sync.Mutex
+	var trusted gossh.PublicKey
+
+	callback := func(hostname string, remote net.Addr, presented gossh.PublicKey) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if trusted != nil {
+			if bytes.Equal(trusted.Marshal(), presented.Marshal()) {
+				return nil
+			}
+			return fmt.Errorf("%w: %s changed its host key to %s", ErrHostKeyMismatch, hostAlias, gossh.FingerprintSHA256(presented))
+		}
+
+		err := check(address, remote, presented)
+		var keyErr *knownhosts.KeyError
+		switch {
+		case err == nil:
+		case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+			// First connection: remember the key
+			line := knownhosts.Line([]string{knownhosts.Normalize(address)}, presented)
+			if err := appendLine(knownHostsPath, line); err != nil {
+				return err
+			}
+		case errors.As(err, &keyErr):
+			return fmt.Errorf("%w: %s presented %s, known_hosts %s expects a different key",
+				ErrHostKeyMismatch, hostAlias, gossh.FingerprintSHA256(presented), knownHostsPath)
+		default:
+			return err
+		}
+
+		trusted = presented
+		return nil
+	}
+
+	return &HostKeyVerifier{callback: callback}, nil
+}
+
+// handshake runs the SSH client handshake over conn, verifying the server's
+// host key. A rejected host key is returned wrapped rather than flattened into
+// the ssh package's handshake error, so that it still matches
+// ErrHostKeyMismatch.
+func (v *HostKeyVerifier) handshake(conn net.Conn, addr string, config *gossh.ClientConfig) (gossh.Conn, <-chan gossh.NewChannel, <-chan *gossh.Request, error) {
+	var rejected error
+	config.HostKeyAlgorithms = v.algorithms
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		rejected = v.callback(hostname, remote, key)
+		return rejected
+	}
+
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, config)
+	if err != nil && rejected != nil {
+		err = fmt.Errorf("ssh: handshake failed: %w", rejected)
+	}
+	return sshConn, chans, reqs, err
+}
+
+// InsecureIgnoreHostKey accepts any host key (--ssh-insecure)
+func InsecureIgnoreHostKey() *HostKeyVerifier {
+	return &HostKeyVerifier{callback: gossh.InsecureIgnoreHostKey()}
+}
+
+// DefaultKnownHostsPath returns the known_hosts file under the tool's config directory
+func DefaultKnownHostsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "gke-image-cache-builder", "known_hosts"), nil
+}
+
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == gossh.KeyAlgoRSA {
+		return []string{gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+func appendLine(path, line string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to update known_hosts %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to update known_hosts %s: %w", path, err)
+	}
+	return nil
+}
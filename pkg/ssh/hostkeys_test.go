@@ -0,0 +1,182 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testServer is an SSH server that accepts any client key and completes the
+// handshake, counting the connections it gets
+type testServer struct {
+	addr        string
+	connections atomic.Int32
+}
+
+// newTestServer serves SSH on a loopback port with hostKey until the test ends
+func newTestServer(t *testing.T, hostKey gossh.Signer) *testServer {
+	t.Helper()
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(gossh.ConnMetadata, gossh.PublicKey) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testServer{addr: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.connections.Add(1)
+			go func() {
+				defer conn.Close()
+				sshConn, chans, reqs, err := gossh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sshConn.Close()
+				go gossh.DiscardRequests(reqs)
+				for ch := range chans {
+					ch.Reject(gossh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// newTestSigner returns a fresh ed25519 host key
+func newTestSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	return signer
+}
+
+func newTestKeyPair(t *testing.T) *KeyPair {
+	t.Helper()
+	key, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	t.Cleanup(func() { key.Cleanup() })
+	return key
+}
+
+func authorizedKey(signer gossh.Signer) string {
+	return string(gossh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func TestFixedHostKeys(t *testing.T) {
+	hostKey := newTestSigner(t)
+	server := newTestServer(t, hostKey)
+	key := newTestKeyPair(t)
+
+	tests := []struct {
+		name      string
+		published gossh.Signer
+		mismatch  bool
+	}{
+		{name: "published key", published: hostKey},
+		{name: "other key", published: newTestSigner(t), mismatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostKeys, err := FixedHostKeys([]string{authorizedKey(tt.published)})
+			if err != nil {
+				t.Fatalf("FixedHostKeys() error = %v", err)
+			}
+			client, err := NewClient(context.Background(), server.addr, "builder", key, hostKeys, nil)
+			if tt.mismatch {
+				if !errors.Is(err, ErrHostKeyMismatch) {
+					t.Fatalf("NewClient() error = %v, want %v", err, ErrHostKeyMismatch)
+				}
+				if class := ClassifyError(err); class != ClassHostKey {
+					t.Errorf("ClassifyError() = %q, want %q", class, ClassHostKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			client.Close()
+		})
+	}
+}
+
+func TestWaitForSSHReadyFailsOnHostKeyMismatch(t *testing.T) {
+	server := newTestServer(t, newTestSigner(t))
+	key := newTestKeyPair(t)
+	hostKeys, err := FixedHostKeys([]string{authorizedKey(newTestSigner(t))})
+	if err != nil {
+		t.Fatalf("FixedHostKeys() error = %v", err)
+	}
+
+	start := time.Now()
+	client, err := WaitForSSHReady(context.Background(), server.addr, "builder", key, hostKeys, nil, time.Minute)
+	if err == nil {
+		client.Close()
+		t.Fatal("WaitForSSHReady() succeeded with an unpublished host key")
+	}
+	if !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("WaitForSSHReady() error = %v, want %v", err, ErrHostKeyMismatch)
+	}
+	if elapsed := time.Since(start); elapsed >= initialBackoff {
+		t.Errorf("WaitForSSHReady() took %v, want no retry", elapsed)
+	}
+	if n := server.connections.Load(); n != 1 {
+		t.Errorf("server got %d connections, want 1", n)
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	key := newTestKeyPair(t)
+	knownHosts := filepath.Join(t.TempDir(), "config", "known_hosts")
+	first := newTestServer(t, newTestSigner(t))
+	changed := newTestServer(t, newTestSigner(t))
+
+	connect := func(addr string) error {
+		hostKeys, err := TrustOnFirstUse(knownHosts, "builder-vm")
+		if err != nil {
+			t.Fatalf("TrustOnFirstUse() error = %v", err)
+		}
+		client, err := NewClient(context.Background(), addr, "builder", key, hostKeys, nil)
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	if err := connect(first.addr); err != nil {
+		t.Fatalf("first connection error = %v", err)
+	}
+	if err := connect(first.addr); err != nil {
+		t.Fatalf("connection with the recorded key error = %v", err)
+	}
+	if err := connect(changed.addr); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("connection with a changed key error = %v, want %v", err, ErrHostKeyMismatch)
+	}
+}
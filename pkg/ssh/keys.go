@@ -0,0 +1,156 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// KeyPair is the SSH key used to log in to build VMs
+type KeyPair struct {
+	// PrivateKeyPath is where the private key lives on disk
+	PrivateKeyPath string
+
+	signer gossh.Signer
+
+	// tempDir is set for ephemeral keys and removed at cleanup
+	tempDir string
+}
+
+// GenerateKeyPair creates an ephemeral ed25519 key pair for a single build.
+// The private key is written to a fresh temporary directory readable only by
+// the current user; call Cleanup to shred it when the build finishes.
+func GenerateKeyPair() (*KeyPair, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SSH key: %w", err)
+	}
+
+	block, err := gossh.MarshalPrivateKey(privateKey, "gke-image-cache-builder")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SSH key: %w", err)
+	}
+
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH signer: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "gke-image-cache-builder-ssh-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH key directory: %w", err)
+	}
+
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write SSH key: %w", err)
+	}
+
+	return &KeyPair{
+		PrivateKeyPath: path,
+		signer:         signer,
+		tempDir:        dir,
+	}, nil
+}
+
+// LoadKeyPair reads a user-provided private key. The file is never modified or removed.
+func LoadKeyPair(path string) (*KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key %s: %w", path, err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(data)
+	if err != nil {
+		var missing *gossh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("SSH key %s is passphrase-protected; provide an unencrypted key", path)
+		}
+		return nil, fmt.Errorf("failed to parse SSH key %s: %w", path, err)
+	}
+
+	return &KeyPair{
+		PrivateKeyPath: path,
+		signer:         signer,
+	}, nil
+}
+
+// Signer returns the key used for authentication
+func (k *KeyPair) Signer() gossh.Signer {
+	return k.signer
+}
+
+// AuthorizedKey returns the public half in authorized_keys format
+func (k *KeyPair) AuthorizedKey() string {
+	return strings.TrimSpace(string(gossh.MarshalAuthorizedKey(k.signer.PublicKey())))
+}
+
+// MetadataEntry returns an ssh-keys metadata line that the guest agent
+// stops honouring after expireOn
+func (k *KeyPair) MetadataEntry(user string, expireOn time.Time) string {
+	expiry, _ := json.Marshal(struct {
+		UserName string `json:"userName"`
+		ExpireOn string `json:"expireOn"`
+	}{user, expireOn.UTC().Format(time.RFC3339)})
+
+	return fmt.Sprintf("%s:%s google-ssh %s", user, k.AuthorizedKey(), expiry)
+}
+
+// IsEphemeral reports whether the key was generated for this build
+func (k *KeyPair) IsEphemeral() bool {
+	return k.tempDir != ""
+}
+
+// Cleanup shreds an ephemeral private key and removes its directory.
+// User-provided keys are left untouched.
+func (k *KeyPair) Cleanup() error {
+	if !k.IsEphemeral() {
+		return nil
+	}
+
+	if err := shred(k.PrivateKeyPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(k.tempDir)
+}
+
+// shred overwrites a file with random data before removing it
+func shred(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	noise := make([]byte, info.Size())
+	if _, err := rand.Read(noise); err == nil {
+		_, err = f.WriteAt(noise, 0)
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to overwrite SSH key %s: %w", path, err)
+		}
+	}
+	f.Close()
+
+	return os.Remove(path)
+}
@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ProxyJump is a bastion host that connections to the build VM are tunnelled
+// through, like OpenSSH's ProxyJump. Both hops verify host keys.
+type ProxyJump struct {
+	User     string
+	Addr     string // host:port
+	Key      *KeyPair
+	HostKeys *HostKeyVerifier
+}
+
+// bastionError marks failures on the first hop so they are not blamed on the build VM
+type bastionError struct {
+	addr string
+	err  error
+}
+
+func (e *bastionError) Error() string {
+	return fmt.Sprintf("bastion %s: %v", e.addr, e.err)
+}
+
+func (e *bastionError) Unwrap() error {
+	return e.err
+}
+
+// dial connects to the bastion and opens a tunnel to addr through it.
+// The returned bastion client must be closed after the tunnel.
+func (j *ProxyJump) dial(ctx context.Context, addr string) (net.Conn, *gossh.Client, error) {
+	config := &gossh.ClientConfig{
+		User:    j.User,
+		Auth:    []gossh.AuthMethod{gossh.PublicKeys(j.Key.Signer())},
+		Timeout: dialTimeout,
+	}
+
+	conn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", j.Addr)
+	if err != nil {
+		return nil, nil, &bastionError{j.Addr, err}
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	sshConn, chans, reqs, err := j.HostKeys.handshake(conn, j.Addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, nil, &bastionError{j.Addr, err}
+	}
+	conn.SetDeadline(time.Time{})
+	bastion := gossh.NewClient(sshConn, chans, reqs)
+
+	tunnel, err := bastion.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		bastion.Close()
+		return nil, nil, fmt.Errorf("failed to reach %s through bastion %s: %w", addr, j.Addr, err)
+	}
+	return tunnel, bastion, nil
+}
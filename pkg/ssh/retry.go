@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/retry"
+)
+
+const (
+	initialBackoff = 2 * time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// ErrorClass groups SSH connection failures by likely cause
+type ErrorClass string
+
+const (
+	ClassRefused     ErrorClass = "connection refused"
+	ClassTimeout     ErrorClass = "connection timeout"
+	ClassUnreachable ErrorClass = "host unreachable"
+	ClassAuth        ErrorClass = "authentication failed"
+	ClassHostKey     ErrorClass = "host key mismatch"
+	ClassBastion     ErrorClass = "bastion connection failed"
+	ClassOther       ErrorClass = "other"
+)
+
+// ClassifyError returns the failure class of an SSH dial or handshake error
+func ClassifyError(err error) ErrorClass {
+	var netErr net.Error
+	var bastionErr *bastionError
+	var openErr *gossh.OpenChannelError
+	switch {
+	case errors.Is(err, ErrHostKeyMismatch):
+		return ClassHostKey
+	case errors.As(err, &bastionErr):
+		return ClassBastion
+	case errors.As(err, &openErr):
+		// The bastion could not open the tunnel; OpenSSH reports the connect(2) error
+		message := strings.ToLower(openErr.Message)
+		switch {
+		case strings.Contains(message, "refused"):
+			return ClassRefused
+		case strings.Contains(message, "timed out"):
+			return ClassTimeout
+		default:
+			return ClassUnreachable
+		}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ClassRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ClassUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	case strings.Contains(err.Error(), "unable to authenticate"),
+		strings.Contains(err.Error(), "no supported methods remain"):
+		return ClassAuth
+	default:
+		return ClassOther
+	}
+}
+
+// WaitForSSHReady connects to a booting VM, retrying with exponential backoff and
+// jitter until the connection succeeds, timeout elapses or ctx is cancelled.
+// Host key mismatches on either hop fail immediately. On timeout the most frequent failure
+// class is reported together with advice for fixing it. Each failure is charged to
+// the build's retry budget in ctx, which stops the retries early when spent.
+func WaitForSSHReady(ctx context.Context, addr, user string, key *KeyPair, hostKeys *HostKeyVerifier, jump *ProxyJump, timeout time.Duration) (*Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	counts := make(map[ErrorClass]int)
+	var lastErr error
+	backoff := initialBackoff
+	budget, operation := retry.FromContext(ctx), "SSH to "+addr
+
+	for attempt := 1; ; attempt++ {
+		client, err := NewClient(ctx, addr, user, key, hostKeys, jump)
+		if err == nil {
+			budget.Succeeded(operation)
+			return client, nil
+		}
+
+		class := ClassifyError(err)
+		if class == ClassHostKey {
+			return nil, err
+		}
+		counts[class]++
+		lastErr = err
+
+		charged := err
+		if class == ClassAuth {
+			charged = retry.Permission(err)
+		}
+		if err := budget.Retry(operation, charged); err != nil {
+			return nil, err
+		}
+
+		// Full jitter around the current backoff keeps parallel builds from retrying in lockstep
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil, ctx.Err()
+			}
+			return nil, readyError(addr, user, attempt, counts, lastErr)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// readyError describes the dominant failure class with targeted advice
+func readyError(addr, user string, attempts int, counts map[ErrorClass]int, lastErr error) error {
+	dominant := ClassOther
+	for class, n := range counts {
+		if n > counts[dominant] || (n == counts[dominant] && class < dominant) {
+			dominant = class
+		}
+	}
+
+	var advice string
+	switch dominant {
+	case ClassRefused:
+		advice = "sshd on the VM never accepted connections; check the VM's serial console output for boot or setup failures"
+	case ClassTimeout:
+		advice = "nothing answered on port 22; add a firewall rule allowing tcp:22 to the build VM from this machine (or from 35.235.240.0/20 when connecting through IAP)"
+	case ClassBastion:
+		advice = "the bastion itself could not be reached or rejected the login; check --ssh-proxy-jump and the bastion key, and that this machine can reach the bastion"
+	case ClassUnreachable:
+		advice = "no route to the VM; check that this machine can reach the VM's network (VPN, peering or external IP)"
+	case ClassAuth:
+		advice = fmt.Sprintf("the VM rejected the key for user %q; if OS Login is enabled (enable-oslogin=TRUE) metadata ssh-keys are ignored, so disable it for the build VM or register the key with OS Login, and check the username", user)
+	default:
+		advice = "see the last error for details"
+	}
+
+	return fmt.Errorf("SSH to %s not ready after %d attempts (mostly %s): %s; last error: %w",
+		addr, attempts, dominant, advice, lastErr)
+}
@@ -0,0 +1,311 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"github.com/0x00fafa/gke-image-cache-builder/internal/scripts"
+)
+
+const defaultFileMode os.FileMode = 0644
+
+// ProgressFunc is called as a transfer proceeds with the bytes copied so far
+type ProgressFunc func(transferred, total int64)
+
+// TransferOptions controls Upload and Download
+type TransferOptions struct {
+	// Mode is the permission of the written file. Zero means 0644 for uploads
+	// and the remote file's permission for downloads.
+	Mode os.FileMode
+
+	// Progress is optional
+	Progress ProgressFunc
+}
+
+// Upload copies a local file to remotePath on the VM, creating missing parent
+// directories. SFTP is used when the VM offers it, SCP otherwise.
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string, opts TransferOptions) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	return c.upload(ctx, f, info.Size(), remotePath, opts)
+}
+
+// UploadContent writes content to remotePath on the VM like Upload
+func (c *Client) UploadContent(ctx context.Context, content []byte, remotePath string, opts TransferOptions) error {
+	return c.upload(ctx, bytes.NewReader(content), int64(len(content)), remotePath, opts)
+}
+
+// Download copies remotePath on the VM to a local file, creating missing parent directories
+func (c *Client) Download(ctx context.Context, remotePath, localPath string, opts TransferOptions) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", localPath, err)
+	}
+
+	client, err := sftp.NewClient(c.client)
+	if err != nil {
+		// The VM has no SFTP subsystem
+		err = c.scpDownload(ctx, remotePath, localPath, opts)
+	} else {
+		defer client.Close()
+		stop := context.AfterFunc(ctx, func() { client.Close() })
+		defer stop()
+		err = sftpDownload(ctx, client, remotePath, localPath, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s from %s: %w", remotePath, c.addr, err)
+	}
+	return nil
+}
+
+func (c *Client) upload(ctx context.Context, r io.Reader, size int64, remotePath string, opts TransferOptions) error {
+	mode := opts.Mode
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	src := &progressReader{ctx: ctx, r: r, total: size, progress: opts.Progress}
+
+	client, err := sftp.NewClient(c.client)
+	if err != nil {
+		// The VM has no SFTP subsystem
+		err = c.scpUpload(ctx, src, size, remotePath, mode)
+	} else {
+		defer client.Close()
+		stop := context.AfterFunc(ctx, func() { client.Close() })
+		defer stop()
+		err = sftpUpload(client, src, remotePath, mode)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s to %s: %w", remotePath, c.addr, err)
+	}
+	return nil
+}
+
+func sftpUpload(client *sftp.Client, r io.Reader, remotePath string, mode os.FileMode) error {
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	f, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return client.Chmod(remotePath, mode)
+}
+
+func sftpDownload(ctx context.Context, client *sftp.Client, remotePath, localPath string, opts TransferOptions) error {
+	f, err := client.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return saveLocal(ctx, f, info.Size(), info.Mode().Perm(), localPath, opts)
+}
+
+// scpUpload speaks the sink side of the SCP protocol to "scp -t" on the VM
+func (c *Client) scpUpload(ctx context.Context, r io.Reader, size int64, remotePath string, mode os.FileMode) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	// chmod runs after scp exits because scp applies the umask to new files
+	command := fmt.Sprintf("mkdir -p %s && scp -qt %s && chmod %04o %s",
+		scripts.ShellQuote(path.Dir(remotePath)), scripts.ShellQuote(remotePath), mode.Perm(), scripts.ShellQuote(remotePath))
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	acks := bufio.NewReader(stdout)
+	if err := readAck(acks); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", mode.Perm(), size, path.Base(remotePath)); err != nil {
+		return err
+	}
+	if err := readAck(acks); err != nil {
+		return err
+	}
+	if n, err := io.Copy(stdin, r); err != nil {
+		return err
+	} else if n != size {
+		return fmt.Errorf("short upload: sent %d of %d bytes", n, size)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	if err := readAck(acks); err != nil {
+		return err
+	}
+	stdin.Close()
+
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// scpDownload speaks the source side of the SCP protocol to "scp -f" on the VM
+func (c *Client) scpDownload(ctx context.Context, remotePath, localPath string, opts TransferOptions) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	if err := session.Start("scp -qf " + scripts.ShellQuote(remotePath)); err != nil {
+		return err
+	}
+
+	data := bufio.NewReader(stdout)
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	header, err := data.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("scp: %s", strings.TrimSpace(stderr.String()))
+	}
+	if header[0] != 'C' {
+		return fmt.Errorf("scp: %s", strings.TrimSpace(header[1:]))
+	}
+	var perm uint32
+	var size int64
+	var name string
+	if _, err := fmt.Sscanf(header, "C%o %d %s", &perm, &size, &name); err != nil {
+		return fmt.Errorf("scp: unexpected header %q", header)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	if err := saveLocal(ctx, io.LimitReader(data, size), size, os.FileMode(perm).Perm(), localPath, opts); err != nil {
+		return err
+	}
+	if err := readAck(data); err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	stdin.Close()
+
+	return session.Wait()
+}
+
+// saveLocal writes a download to localPath, removing the partial file on failure
+func saveLocal(ctx context.Context, r io.Reader, size int64, remoteMode os.FileMode, localPath string, opts TransferOptions) error {
+	mode := opts.Mode
+	if mode == 0 {
+		mode = remoteMode
+	}
+
+	f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, &progressReader{ctx: ctx, r: r, total: size, progress: opts.Progress})
+	if err == nil && n != size {
+		err = fmt.Errorf("short download: received %d of %d bytes", n, size)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(localPath)
+		return err
+	}
+
+	// OpenFile applies the umask and leaves an existing file's mode alone
+	return os.Chmod(localPath, mode)
+}
+
+// readAck reads an SCP status byte: 0 is OK, 1 and 2 are followed by a message
+func readAck(r *bufio.Reader) error {
+	status, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if status == 0 {
+		return nil
+	}
+	message, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(message))
+}
+
+// progressReader reports progress and stops the copy once ctx is done
+type progressReader struct {
+	ctx         context.Context
+	r           io.Reader
+	transferred int64
+	total       int64
+	progress    ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	p.transferred += int64(n)
+	if n > 0 && p.progress != nil {
+		p.progress(p.transferred, p.total)
+	}
+	return n, err
+}
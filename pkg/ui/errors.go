@@ -1,8 +1,13 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/gcp"
 )
 
 // ErrorHandler provides context-aware error messages and solutions
@@ -19,356 +24,177 @@ func NewErrorHandler() *ErrorHandler {
 
 // HandleConfigError provides helpful error messages with solutions
 func (e *ErrorHandler) HandleConfigError(err error) {
-	errorMsg := err.Error()
+	if disabled, ok := gcp.AsAPINotEnabled(err); ok {
+		e.showAPINotEnabledError(err, disabled)
+		return
+	}
 
-	switch {
+	var problems config.ValidationErrors
+	if errors.As(err, &problems) && len(problems) > 1 {
+		e.showValidationErrors(problems)
+		return
+	}
+
+	// Errors of the config file itself, and of the mode flags, have no field
+	id := "error.generic"
+	switch errorMsg := err.Error(); {
 	case strings.Contains(errorMsg, "configuration file not found"):
-		e.showConfigFileNotFoundError(err)
+		id = "error.config-file-not-found"
 	case strings.Contains(errorMsg, "failed to parse YAML"):
-		e.showYAMLParseError(err)
+		id = "error.yaml-parse"
 	case strings.Contains(errorMsg, "configuration validation failed"):
-		e.showConfigValidationError(err)
+		id = "error.config-validation"
+	case len(problems) == 1:
+		if m := lookupFieldMessage(problems[0]); m != nil && m.errorID != "" {
+			id = m.errorID
+		}
 	case strings.Contains(errorMsg, "execution mode"):
-		e.showExecutionModeError()
-	case strings.Contains(errorMsg, "zone") && strings.Contains(errorMsg, "required"):
-		e.showZoneRequiredError()
-	case strings.Contains(errorMsg, "GCP VM") || strings.Contains(errorMsg, "local mode"):
-		e.showLocalModeEnvironmentError()
-	case strings.Contains(errorMsg, "project-name"):
-		e.showProjectNameError()
-	case strings.Contains(errorMsg, "disk-image-name"):
-		e.showDiskImageNameError()
-	case strings.Contains(errorMsg, "container-image"):
-		e.showContainerImageError()
-	case strings.Contains(errorMsg, "invalid machine type"):
-		e.showMachineTypeError(err)
-	case strings.Contains(errorMsg, "invalid disk type"):
-		e.showDiskTypeError(err)
-	default:
-		e.showGenericError(err)
+		id = "error.execution-mode"
 	}
+	fmt.Print(e.message(id, err))
+}
+
+// fieldMessage names the catalog messages explaining the problems of a
+// ValidationErrors field: errorID for a single problem, adviceID for one of
+// several. With err set, only the field's problems wrapping err match.
+type fieldMessage struct {
+	field    string
+	err      error
+	errorID  string
+	adviceID string
+}
+
+// fieldMessages are the fields with messages of their own, the entries with
+// an err first. A field of a list entry, e.g. images[2], is looked up as images[].
+var fieldMessages = []fieldMessage{
+	{field: "execution.mode", err: config.ErrNotOnGCP, errorID: "error.local-mode-environment", adviceID: "advice.local-mode-environment"},
+	{field: "execution.mode", errorID: "error.execution-mode", adviceID: "advice.execution-mode"},
+	{field: "execution.zone", errorID: "error.zone-required", adviceID: "advice.zone-required"},
+	{field: "project.name", err: config.ErrOtherProject, errorID: "error.local-project", adviceID: "advice.local-project"},
+	{field: "project.name", errorID: "error.project-name", adviceID: "advice.project-name"},
+	{field: "disk.name", errorID: "error.disk-image-name", adviceID: "advice.disk-image-name"},
+	{field: "images", errorID: "error.container-image", adviceID: "advice.container-image"},
+	{field: "images[]", errorID: "error.container-image", adviceID: "advice.invalid-container-image"},
+	{field: "advanced.machine_type", errorID: "error.machine-type", adviceID: "advice.machine-type"},
+	{field: "disk.disk_type", errorID: "error.disk-type", adviceID: "advice.disk-type"},
+	{field: "auth.image_pull_auth", errorID: "error.image-pull-auth", adviceID: "advice.image-pull-auth"},
+}
+
+// lookupFieldMessage returns the messages for a problem, or nil if its field has none
+func lookupFieldMessage(problem *config.ValidationError) *fieldMessage {
+	field := problem.Field
+	if i := strings.IndexByte(field, '['); i >= 0 {
+		field = field[:i] + "[]"
+	}
+	for i, m := range fieldMessages {
+		if m.field == field && (m.err == nil || errors.Is(problem.Err, m.err)) {
+			return &fieldMessages[i]
+		}
+	}
+	return nil
 }
 
-func (e *ErrorHandler) showConfigFileNotFoundError(err error) {
-	fmt.Printf(`Error: Configuration file not found
-
-%v
-
-SOLUTIONS:
-    1. Check the file path and ensure the file exists
-    2. Generate a configuration template:
-       %s --generate-config basic --output my-config.yaml
-    3. Use command line parameters instead:
-       %s -L --project-name=<PROJECT> --disk-image-name=<NAME> --container-image=<IMAGE>
-
-EXAMPLES:
-    # Generate and use a basic template
-    %s --generate-config basic --output web-app.yaml
-    %s --config web-app.yaml
-
-For configuration help: %s --help-config
-`, err, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName,
-		e.toolInfo.ExecutableName, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showYAMLParseError(err error) {
-	fmt.Printf(`Error: YAML configuration file parsing failed
-
-%v
-
-SOLUTIONS:
-    1. Check YAML syntax (indentation, colons, quotes)
-    2. Validate the configuration file:
-       %s --validate-config <CONFIG_FILE>
-    3. Generate a new template:
-       %s --generate-config basic --output new-config.yaml
-
-COMMON YAML ISSUES:
-    • Incorrect indentation (use spaces, not tabs)
-    • Missing colons after keys
-    • Unquoted special characters
-    • Inconsistent list formatting
-
-EXAMPLE VALID YAML:
-    execution:
-      mode: local
-    project:
-      name: my-project
-    images:
-      - nginx:latest
-      - redis:alpine
-
-For configuration help: %s --help-config
-`, err, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showConfigValidationError(err error) {
-	fmt.Printf(`Error: Configuration validation failed
-
-%v
-
-SOLUTIONS:
-    1. Check required fields in your configuration file
-    2. Validate configuration syntax:
-       %s --validate-config <CONFIG_FILE>
-    3. Review configuration examples:
-       %s --help-config
-    4. Generate a working template:
-       %s --generate-config basic
-
-REQUIRED CONFIGURATION:
-    execution.mode: local or remote
-    project.name: your-gcp-project
-    cache.name: your-cache-name
-    images: [list of container images]
-
-For configuration help: %s --help-config
-`, err, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName,
-		e.toolInfo.ExecutableName, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showExecutionModeError() {
-	fmt.Printf(`Error: Execution mode required
-
-SOLUTION:
-    Choose exactly one execution mode:
-    
-    LOCAL MODE (-L):  Execute on current GCP VM
-    • Cost-effective (no additional VM charges)
-    • Faster execution (no VM startup time)
-    • Requires current machine to be a GCP VM
-    
-    REMOTE MODE (-R): Create temporary GCP VM  
-    • Works from any machine
-    • Additional VM charges apply (~$0.38/build)
-    • Requires --zone parameter
-
-EXAMPLES:
-    # Local mode (on GCP VM)
-    %s -L --project-name=my-project --disk-image-name=web-cache --container-image=nginx:latest
-    
-    # Remote mode (from anywhere)
-    %s -R --zone=us-west1-b --project-name=my-project --disk-image-name=web-cache --container-image=nginx:latest
-
-Run '%s --help' for more information.
-`, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showZoneRequiredError() {
-	fmt.Printf(`Error: Zone required for remote mode (-R)
-
-SOLUTION:
-    Specify a GCP zone with --zone parameter
-    
-    Available zones: us-west1-b, us-central1-a, europe-west1-b, asia-east1-a
-    
-EXAMPLE:
-    %s -R --zone=us-west1-b --project-name=my-project --disk-image-name=my-cache --container-image=nginx:latest
-
-TIP: Use 'gcloud compute zones list' to see all available zones
-`, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showLocalModeEnvironmentError() {
-	fmt.Printf(`Error: Local mode (-L) requires execution on a GCP VM instance
-
-CURRENT ENVIRONMENT: Not a GCP VM
-
-SOLUTIONS:
-    1. Use remote mode instead:
-       %s -R --zone=us-west1-b --project-name=<PROJECT> --disk-image-name=<NAME> --container-image=<IMAGE>
-       
-    2. Run this command on a GCP VM instance
-    
-    3. Use Google Cloud Shell:
-       https://shell.cloud.google.com
-
-DETECTION: This tool detected it's not running on a GCP VM instance.
-`, e.toolInfo.ExecutableName)
+// HandleBuildError explains build failures that have a known remedy
+func (e *ErrorHandler) HandleBuildError(err error) {
+	if disabled, ok := gcp.AsAPINotEnabled(err); ok {
+		e.showAPINotEnabledError(err, disabled)
+		return
+	}
+	var violation *gcp.PolicyViolation
+	if errors.As(err, &violation) {
+		e.showPolicyViolationError(err, violation)
+		return
+	}
+	fmt.Fprint(os.Stderr, e.message("error.build-failed", err))
+}
+
+// errorData is what error messages can refer to besides the tool's names
+type errorData struct {
+	*ToolInfo
+	Err error
+
+	// Policy violations
+	Constraint  string
+	Remediation string
+
+	// Disabled APIs
+	Service       string
+	Project       string
+	EnableCommand string
+	ConsoleURL    string
+
+	// Several configuration problems
+	Problems []problemData
+}
+
+// problemData is one of several configuration problems
+type problemData struct {
+	Number int
+	Field  string
+	Err    error
+	Advice string
+}
+
+// message renders an error message from the catalog
+func (e *ErrorHandler) message(id string, err error) string {
+	return message(id, errorData{ToolInfo: e.toolInfo, Err: err})
+}
+
+func (e *ErrorHandler) showPolicyViolationError(err error, violation *gcp.PolicyViolation) {
+	fmt.Fprint(os.Stderr, message("error.policy-violation", errorData{
+		ToolInfo:    e.toolInfo,
+		Err:         err,
+		Constraint:  violation.Constraint,
+		Remediation: violation.Remediation(),
+	}))
+}
+
+func (e *ErrorHandler) showAPINotEnabledError(err error, disabled *gcp.APINotEnabled) {
+	// The API error's details follow its first line and repeat what is shown
+	summary, _, _ := strings.Cut(err.Error(), "\n")
+	fmt.Fprint(os.Stderr, message("error.api-not-enabled", errorData{
+		ToolInfo:      e.toolInfo,
+		Err:           errors.New(summary),
+		Service:       disabled.Service,
+		Project:       disabled.Project,
+		EnableCommand: disabled.EnableCommand(),
+		ConsoleURL:    disabled.ConsoleURL(),
+	}))
+}
+
+// showValidationErrors lists every configuration problem with the advice for its kind
+func (e *ErrorHandler) showValidationErrors(problems config.ValidationErrors) {
+	fmt.Print(e.validationErrorsMessage(problems))
+}
+
+// validationErrorsMessage renders the list of showValidationErrors
+func (e *ErrorHandler) validationErrorsMessage(problems config.ValidationErrors) string {
+	data := errorData{ToolInfo: e.toolInfo}
+	for i, problem := range problems {
+		data.Problems = append(data.Problems, problemData{
+			Number: i + 1,
+			Field:  problem.Field,
+			Err:    problem.Err,
+			Advice: e.problemAdvice(problem),
+		})
+	}
+	return message("error.validation-problems", data)
 }
 
-func (e *ErrorHandler) showProjectNameError() {
-	fmt.Printf(`Error: GCP project name required
-
-SOLUTION:
-    Specify your GCP project with --project-name parameter
-    
-EXAMPLES:
-    %s -L --project-name=my-gcp-project --disk-image-name=web-cache --container-image=nginx:latest
-    %s -R --zone=us-west1-b --project-name=production-project --disk-image-name=app-cache --container-image=node:16
-
-TIP: Use 'gcloud config get-value project' to see your current project
-`, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName)
+// problemAdvice is the one-line remedy for a problem, from its field
+func (e *ErrorHandler) problemAdvice(problem *config.ValidationError) string {
+	m := lookupFieldMessage(problem)
+	if m == nil {
+		return ""
+	}
+	return e.message(m.adviceID, problem.Err)
 }
 
 func (e *ErrorHandler) showCacheNameError() {
-	fmt.Printf(`Error: Cache name required
-
-SOLUTION:
-    Specify a name for your image cache disk with --cache-name parameter
-    
-    Cache name should be:
-    • Descriptive of the cached images
-    • Unique within your project
-    • Follow GCP naming conventions (lowercase, hyphens)
-    
-EXAMPLES:
-    --cache-name=web-app-cache          # For web application images
-    --cache-name=ml-models-cache        # For ML model images  
-    --cache-name=microservices-cache    # For microservices stack
-
-FULL EXAMPLE:
-    %s -L --project-name=my-project --cache-name=web-stack \
-        --container-image=nginx:1.21 \
-        --container-image=redis:6.2-alpine \
-        --container-image=postgres:13
-`, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showContainerImageError() {
-	fmt.Printf(`Error: At least one container image required
-
-SOLUTION:
-    Specify container images to cache with --container-image parameter
-    You can specify multiple images by repeating the parameter
-    
-SUPPORTED REGISTRIES:
-    • Docker Hub: nginx:latest, node:16-alpine
-    • Google Container Registry: gcr.io/my-project/app:v1.0
-    • Artifact Registry: us-docker.pkg.dev/my-project/repo/app:latest
-    
-EXAMPLES:
-    # Single image
-    --container-image=nginx:latest
-    
-    # Multiple images
-    --container-image=nginx:latest --container-image=redis:alpine --container-image=postgres:13
-    
-FULL EXAMPLE:
-    %s -L --project-name=my-project --disk-image-name=web-app-cache --container-image=nginx:latest
-`, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showDiskImageNameError() {
-	fmt.Printf(`Error: Disk image name required
-
-SOLUTION:
-    Specify a name for your disk image with --disk-image-name parameter
-    
-    Disk image name should be:
-    • Descriptive of the cached images
-    • Unique within your project
-    • Follow GCP naming conventions (lowercase, hyphens)
-    
-EXAMPLES:
-    --disk-image-name=web-app-cache          # For web application images
-    --disk-image-name=ml-models-cache        # For ML model images  
-    --disk-image-name=microservices-cache    # For microservices stack
-    --disk-image-name=team-a-cache-v1.2.0    # With version/team info
-
-FULL EXAMPLE:
-    %s -L --project-name=my-project --disk-image-name=web-app-cache --container-image=nginx:latest
-`, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showMachineTypeError(err error) {
-	fmt.Printf(`Error: Invalid machine type
-
-%v
-
-SOLUTIONS:
-    Use a supported machine type in your configuration or command line:
-    
-    SUPPORTED MACHINE TYPES:
-    • e2-standard-2, e2-standard-4, e2-standard-8, e2-standard-16
-    • e2-highmem-2, e2-highmem-4, e2-highmem-8, e2-highmem-16  
-    • e2-highcpu-2, e2-highcpu-4, e2-highcpu-8, e2-highcpu-16
-    • n1-standard-1, n1-standard-2, n1-standard-4, n1-standard-8
-    • n2-standard-2, n2-standard-4, n2-standard-8, n2-standard-16
-
-EXAMPLES:
-    # Command line
-    --machine-type=e2-standard-4
-    
-    # Configuration file
-    advanced:
-      machine_type: e2-standard-4
-
-For configuration help: %s --help-config
-`, err, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showDiskTypeError(err error) {
-	fmt.Printf(`Error: Invalid disk type
-
-%v
-
-SOLUTIONS:
-    Use a supported disk type in your configuration or command line:
-    
-    SUPPORTED DISK TYPES:
-    • pd-standard  (Standard persistent disk - cost-effective)
-    • pd-ssd       (SSD persistent disk - high performance)
-    • pd-balanced  (Balanced persistent disk - good performance/cost ratio)
-
-EXAMPLES:
-    # Command line
-    --disk-type=pd-ssd
-    
-    # Configuration file
-    cache:
-      disk_type: pd-ssd
-
-For configuration help: %s --help-config
-`, err, e.toolInfo.ExecutableName)
-}
-
-func (e *ErrorHandler) showGenericError(err error) {
-	fmt.Printf(`Error: %v
-
-QUICK HELP:
-    %s {-L|-R} --project-name=<PROJECT> --disk-image-name=<NAME> \
-        --container-image=<IMAGE>
-    
-    Required parameters:
-    • Execution mode: -L (local) or -R (remote)  
-    • --project-name: Your GCP project
-    • --disk-image-name: Name for the disk image
-    • --container-image: Images to cache (repeatable)
-    
-    Additional for remote mode:
-    • --zone: GCP zone (e.g., us-west1-b)
-    
-For detailed help: %s --help
-For examples: %s --help-examples
-`, err, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName, e.toolInfo.ExecutableName)
+	fmt.Print(e.message("error.cache-name", nil))
 }
 
 // ShowNoArgsHelp displays help when no arguments are provided
 func ShowNoArgsHelp() {
-	toolInfo := GetToolInfo()
-
-	fmt.Printf(`%s v2.0
-%s
-
-Missing required arguments. Quick start:
-
-LOCAL MODE (on GCP VM):
-    %s -L --project-name=<PROJECT> --disk-image-name=<NAME> \
-        --container-image=<IMAGE>
-
-REMOTE MODE (from anywhere):
-    %s -R --zone=<ZONE> --project-name=<PROJECT> \
-        --disk-image-name=<NAME> --container-image=<IMAGE>
-
-EXAMPLES:
-    %s -L --project-name=my-project --disk-image-name=web-cache --container-image=nginx:latest
-    %s -R --zone=us-west1-b --project-name=my-project --disk-image-name=app-cache --container-image=node:16
-
-For detailed help: %s --help
-For examples: %s --help-examples
-`, toolInfo.DisplayName, toolInfo.Purpose,
-		toolInfo.ExecutableName, toolInfo.ExecutableName,
-		toolInfo.ExecutableName, toolInfo.ExecutableName,
-		toolInfo.ExecutableName, toolInfo.ExecutableName)
+	fmt.Print(message("help.no-args", GetToolInfo()))
 }
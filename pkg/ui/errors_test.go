@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/0x00fafa/gke-image-cache-builder/pkg/config"
+)
+
+func TestLookupFieldMessage(t *testing.T) {
+	tests := []struct {
+		field  string
+		err    error
+		advice string
+	}{
+		{"execution.mode", fmt.Errorf("local mode %w", config.ErrNotOnGCP), "advice.local-mode-environment"},
+		{"execution.mode", errors.New("local mode cannot use --preemptible"), "advice.execution-mode"},
+		{"project.name", fmt.Errorf("local mode %w 'a', not 'b'", config.ErrOtherProject), "advice.local-project"},
+		{"project.name", errors.New("project-name is required"), "advice.project-name"},
+		{"images", errors.New("at least one container-image is required"), "advice.container-image"},
+		{"images[3]", errors.New("invalid image"), "advice.invalid-container-image"},
+		// A field named like another's message text is not matched by it
+		{"disk.labels.env", errors.New("disk-image-name is required"), ""},
+	}
+	for _, tt := range tests {
+		m := lookupFieldMessage(&config.ValidationError{Field: tt.field, Err: tt.err})
+		got := ""
+		if m != nil {
+			got = m.adviceID
+		}
+		if got != tt.advice {
+			t.Errorf("lookupFieldMessage(%s: %v) advice = %q, want %q", tt.field, tt.err, got, tt.advice)
+		}
+	}
+}
+
+func TestValidationErrorsMessage(t *testing.T) {
+	problems := config.ValidationErrors{
+		{Field: "disk.name", Err: errors.New("disk-image-name is required")},
+		{Field: "images[0]", Err: errors.New("invalid container image format: nginx::latest")},
+		{Field: "disk.labels.env", Err: errors.New("invalid label value")},
+	}
+	got := NewErrorHandler().validationErrorsMessage(problems)
+	for _, want := range []string{
+		"1. disk.name: disk-image-name is required",
+		Catalog("en")["advice.disk-image-name"],
+		"2. images[0]: invalid container image format: nginx::latest",
+		Catalog("en")["advice.invalid-container-image"],
+		"3. disk.labels.env: invalid label value",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message does not contain %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the help tests")
+
+// TestHelpGolden compares the help in the wide and the narrow layout with
+// testdata/help-<type>-<layout>.golden; go test -update rewrites them after
+// intended changes
+func TestHelpGolden(t *testing.T) {
+	useCatalogs(t, nil, defaultLocale)
+	layouts := []struct {
+		name  string
+		width int
+	}{
+		{"wide", 120},
+		{"narrow", 80},
+	}
+	for _, helpType := range []string{"full", "examples", "config"} {
+		for _, layout := range layouts {
+			name := "help-" + helpType + "-" + layout.name
+			t.Run(name, func(t *testing.T) {
+				got := renderHelp(helpType, "1.0.0", getDefaultToolInfo(), layout.width)
+				golden := filepath.Join("testdata", name+".golden")
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("help differs from %s; rerun with -update if the change is intended", golden)
+				}
+			})
+		}
+	}
+}
+
+// TestNarrowHelpFits checks that the narrow layout keeps its rules within the
+// width and leaves out the wide diagram
+func TestNarrowHelpFits(t *testing.T) {
+	useCatalogs(t, nil, defaultLocale)
+	wide := renderHelp("full", "1.0.0", getDefaultToolInfo(), 120)
+	narrow := renderHelp("full", "1.0.0", getDefaultToolInfo(), 60)
+	if len(narrow) >= len(wide) {
+		t.Errorf("narrow help is %d bytes, not shorter than the wide help's %d", len(narrow), len(wide))
+	}
+	for _, line := range strings.Split(narrow, "\n") {
+		if strings.Contains(line, "═") && len([]rune(strings.TrimSpace(line))) > 60 {
+			t.Errorf("rule wider than 60 columns: %q", line)
+		}
+	}
+}
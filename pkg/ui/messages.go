@@ -0,0 +1,174 @@
+package ui
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// locales holds a message catalog per locale; see locales/en.yaml for the format
+//
+//go:embed locales/*.yaml
+var locales embed.FS
+
+// defaultLocale is the locale with every message, which the others fall back to
+const defaultLocale = "en"
+
+var (
+	catalogsOnce sync.Once
+	catalogs     map[string]map[string]string
+	catalogsErr  error
+	locale       = localeFromEnv()
+)
+
+// loadCatalogs reads the embedded catalogs, keyed by locale and message ID.
+// A catalog that cannot be read is left out, and reported by CheckCatalogs.
+func loadCatalogs() map[string]map[string]string {
+	catalogsOnce.Do(func() {
+		catalogs, catalogsErr = readCatalogs(locales)
+	})
+	return catalogs
+}
+
+// readCatalogs reads the catalogs in the locales directory of fsys. It returns
+// those that are valid, and an error naming the others: a catalog must be a
+// mapping of message IDs to templates that parse.
+func readCatalogs(fsys fs.FS) (map[string]map[string]string, error) {
+	files, err := fs.ReadDir(fsys, "locales")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message catalogs: %w", err)
+	}
+	catalogs := make(map[string]map[string]string)
+	var errs []error
+	for _, file := range files {
+		messages, err := readCatalog(fsys, path.Join("locales", file.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid message catalog %s: %w", file.Name(), err))
+			continue
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".yaml")] = messages
+	}
+	return catalogs, errors.Join(errs...)
+}
+
+// readCatalog reads one catalog and checks that its templates parse
+func readCatalog(fsys fs.FS, name string) (map[string]string, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var messages map[string]string
+	if err := yaml.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+	for id, text := range messages {
+		if _, err := template.New(id).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// CheckCatalogs returns an error naming the message catalogs that are invalid
+// and were left out, or nil
+func CheckCatalogs() error {
+	loadCatalogs()
+	return catalogsErr
+}
+
+// Locales returns the locales that have a message catalog
+func Locales() []string {
+	var names []string
+	for name := range loadCatalogs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Catalog returns the message templates of a locale, keyed by message ID, or
+// nil if the locale has no catalog
+func Catalog(locale string) map[string]string {
+	return loadCatalogs()[locale]
+}
+
+// SetLocale selects the locale of user-facing messages, e.g. "ja" or "ja_JP.UTF-8".
+// Locales without a catalog select English and return an error saying so.
+func SetLocale(name string) error {
+	language := languageOf(name)
+	if _, ok := loadCatalogs()[language]; !ok {
+		locale = defaultLocale
+		return fmt.Errorf("no messages for locale '%s', using English (available: %s)", name, strings.Join(Locales(), ", "))
+	}
+	locale = language
+	return nil
+}
+
+// localeFromEnv returns the language of the POSIX locale environment, which
+// like gettext prefers LC_ALL over LC_MESSAGES over LANG
+func localeFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return languageOf(value)
+		}
+	}
+	return defaultLocale
+}
+
+// languageOf returns the language of a locale name, e.g. "ja" for "ja_JP.UTF-8";
+// the C and POSIX locales are English
+func languageOf(name string) string {
+	language := strings.ToLower(name)
+	if i := strings.IndexAny(language, "_-.@"); i >= 0 {
+		language = language[:i]
+	}
+	if language == "" || language == "c" || language == "posix" {
+		return defaultLocale
+	}
+	return language
+}
+
+// message renders a catalog message in the selected locale. A message missing
+// from the locale's catalog, or one whose translation does not render, is
+// rendered from the English catalog instead.
+func message(id string, data any) string {
+	if locale != defaultLocale {
+		if text, ok := loadCatalogs()[locale][id]; ok {
+			if rendered, err := renderMessage(id, text, data); err == nil {
+				return rendered
+			}
+		}
+	}
+	text, ok := loadCatalogs()[defaultLocale][id]
+	if !ok {
+		return id
+	}
+	rendered, err := renderMessage(id, text, data)
+	if err != nil {
+		return fmt.Sprintf("%s: %v", id, err)
+	}
+	return rendered
+}
+
+// renderMessage executes a message template
+func renderMessage(id, text string, data any) (string, error) {
+	tmpl, err := template.New(id).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
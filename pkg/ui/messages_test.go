@@ -0,0 +1,98 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testLocales is a complete English catalog and a test locale translating
+// part of it, one message with a template that does not render
+var testLocales = fstest.MapFS{
+	"locales/en.yaml": {Data: []byte(`
+greeting: "Hello, {{.Name}}"
+farewell: "Goodbye, {{.Name}}"
+count: "{{.Count}} images"
+`)},
+	"locales/xx.yaml": {Data: []byte(`
+greeting: "Hallo, {{.Name}}"
+count: "{{.Count.Missing}} Bilder"
+`)},
+}
+
+// useCatalogs selects a locale for the test, of the catalogs in fsys, or of
+// the embedded ones if fsys is nil
+func useCatalogs(t *testing.T, fsys fstest.MapFS, name string) {
+	t.Helper()
+	loadCatalogs()
+	savedCatalogs, savedLocale := catalogs, locale
+	t.Cleanup(func() { catalogs, locale = savedCatalogs, savedLocale })
+	if fsys != nil {
+		loaded, err := readCatalogs(fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalogs = loaded
+	}
+	if err := SetLocale(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageFromTestLocale(t *testing.T) {
+	useCatalogs(t, testLocales, "xx_XX.UTF-8")
+	data := struct {
+		Name  string
+		Count int
+	}{Name: "Ops", Count: 3}
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"greeting", "Hallo, Ops"},   // translated
+		{"farewell", "Goodbye, Ops"}, // missing from the test locale
+		{"count", "3 images"},        // translation does not render
+		{"unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := message(tt.id, data); got != tt.want {
+			t.Errorf("message(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestSetLocaleFallsBackToEnglish(t *testing.T) {
+	useCatalogs(t, testLocales, "en")
+	err := SetLocale("fr_FR")
+	if err == nil || !strings.Contains(err.Error(), "available: en, xx") {
+		t.Errorf("SetLocale(fr_FR) = %v, want an error listing en, xx", err)
+	}
+	if locale != defaultLocale {
+		t.Errorf("locale = %q, want %q", locale, defaultLocale)
+	}
+}
+
+func TestReadCatalogsReportsInvalidCatalogs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"locales/en.yaml": testLocales["locales/en.yaml"],
+		"locales/yy.yaml": {Data: []byte("- not a mapping\n")},
+		"locales/zz.yaml": {Data: []byte(`greeting: "Hi, {{.Name"` + "\n")},
+	}
+	catalogs, err := readCatalogs(fsys)
+	if err == nil || !strings.Contains(err.Error(), "yy.yaml") || !strings.Contains(err.Error(), "zz.yaml") {
+		t.Errorf("readCatalogs() error = %v, want one naming yy.yaml and zz.yaml", err)
+	}
+	if _, ok := catalogs["en"]; !ok || len(catalogs) != 1 {
+		t.Errorf("readCatalogs() = %v, want only the valid en catalog", catalogs)
+	}
+}
+
+func TestEmbeddedCatalogsAreValid(t *testing.T) {
+	if err := CheckCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+	if Catalog("ja")["error.zone-required"] == "" {
+		t.Error("the ja catalog does not translate error.zone-required")
+	}
+}
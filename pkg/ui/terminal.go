@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// wideHelpColumns is the terminal width from which help includes the diagram
+// and full-width rules; narrower terminals and logs get the plain layout
+const wideHelpColumns = 100
+
+// maxRuleColumns is the width of section rules in wide help
+const maxRuleColumns = 79
+
+// terminalSize returns the size of the terminal standard output writes to.
+// COLUMNS overrides the width, also when the output is not a terminal.
+func terminalSize() (width, height int, isTerminal bool) {
+	fd := int(os.Stdout.Fd())
+	if term.IsTerminal(fd) {
+		isTerminal = true
+		width, height, _ = term.GetSize(fd)
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		width = columns
+	}
+	return width, height, isTerminal
+}
+
+// helpLayout returns whether help may use the wide layout and the section rule
+// that fits an output width columns wide, 0 if unknown
+func helpLayout(width int) (wide bool, rule string) {
+	wide = width >= wideHelpColumns
+	columns := maxRuleColumns
+	if !wide {
+		columns = 40
+		if width > 0 {
+			columns = min(width, maxRuleColumns)
+		}
+	}
+	return wide, strings.Repeat("═", columns)
+}
+
+// writeHelp writes help text to standard output, through the pager when page
+// is set, the output is a terminal and the text does not fit on one screen.
+// The pager is $PAGER, or less if PAGER is unset; PAGER="" or cat turn it off.
+func writeHelp(text string, page bool) error {
+	_, height, isTerminal := terminalSize()
+	if !page || !isTerminal || strings.Count(text, "\n") < height-1 {
+		_, err := io.WriteString(os.Stdout, text)
+		return err
+	}
+
+	pager, set := os.LookupEnv("PAGER")
+	if !set {
+		pager = "less"
+	}
+	args := strings.Fields(pager)
+	if len(args) == 0 || args[0] == "cat" {
+		_, err := io.WriteString(os.Stdout, text)
+		return err
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		_, err := io.WriteString(os.Stdout, text)
+		return err
+	}
+
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Unless configured otherwise, less shows UTF-8 as is and leaves the help
+	// on screen when quitting
+	cmd.Env = os.Environ()
+	if os.Getenv("LESSCHARSET") == "" {
+		cmd.Env = append(cmd.Env, "LESSCHARSET=utf-8")
+	}
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(cmd.Env, "LESS=RX")
+	}
+	return cmd.Run()
+}